@@ -0,0 +1,264 @@
+// contentctl - импорт, экспорт и проверка файлов контента уровней (YAML/JSON).
+//
+//	contentctl validate FILE|DIR...
+//	contentctl diff [-publish] FILE|DIR...
+//	contentctl import [-dry-run] [-publish] FILE|DIR...
+//	contentctl publish [-note TEXT] [SLUG...]
+//	contentctl export [-format yaml|json] [-out DIR] [SLUG...]
+//
+// Импорт меняет черновик уровня; игроки увидят изменения после публикации
+// (import -publish, contentctl publish или редактор). publish без SLUG публикует
+// все уровни, которые еще ни разу не публиковались.
+//
+// Команды diff, import, publish и export подключаются к DATABASE_URL (как и сервер, читают .env).
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ImCtyz/duofinance/backend/config"
+	"github.com/ImCtyz/duofinance/backend/internal/content"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const usage = `usage:
+  contentctl validate FILE|DIR...
+  contentctl diff [-publish] FILE|DIR...
+  contentctl import [-dry-run] [-publish] FILE|DIR...
+  contentctl publish [-note TEXT] [SLUG...]
+  contentctl export [-format yaml|json] [-out DIR] [SLUG...]
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	ctx := context.Background()
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "validate":
+		err = runValidate(args)
+	case "diff":
+		flags := flag.NewFlagSet("diff", flag.ExitOnError)
+		publish := flags.Bool("publish", false, "also show levels that import -publish would publish")
+		flags.Parse(args)
+		err = runSync(ctx, flags.Args(), true, *publish)
+	case "import":
+		flags := flag.NewFlagSet("import", flag.ExitOnError)
+		dryRun := flags.Bool("dry-run", false, "only print changes, same as diff")
+		publish := flags.Bool("publish", false, "publish changed and never published levels")
+		flags.Parse(args)
+		err = runSync(ctx, flags.Args(), *dryRun, *publish)
+	case "publish":
+		flags := flag.NewFlagSet("publish", flag.ExitOnError)
+		note := flags.String("note", "", "revision note")
+		flags.Parse(args)
+		err = runPublish(ctx, flags.Args(), *note)
+	case "export":
+		flags := flag.NewFlagSet("export", flag.ExitOnError)
+		format := flags.String("format", string(content.FormatYAML), "output format: yaml or json")
+		out := flags.String("out", "", "directory to write <slug>.<format> files to (default stdout)")
+		flags.Parse(args)
+		err = runExport(ctx, flags.Args(), content.Format(*format), *out)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "contentctl:", err)
+		os.Exit(1)
+	}
+}
+
+func runValidate(args []string) error {
+	files, err := loadFiles(args)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, file := range files {
+		if err := content.Validate(file.File); err != nil {
+			failed++
+			fmt.Printf("%s: invalid\n", file.Path)
+			var joined interface{ Unwrap() []error }
+			if errors.As(err, &joined) {
+				for _, e := range joined.Unwrap() {
+					fmt.Printf("  %v\n", e)
+				}
+			} else {
+				fmt.Printf("  %v\n", err)
+			}
+			continue
+		}
+		fmt.Printf("%s: ok\n", file.Path)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files are invalid", failed, len(files))
+	}
+	return nil
+}
+
+// runSync - diff (dryRun) или import всех файлов; файлы проверяются до первой записи
+func runSync(ctx context.Context, args []string, dryRun, publish bool) error {
+	files, err := loadFiles(args)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := content.Validate(file.File); err != nil {
+			return fmt.Errorf("%s: %w", file.Path, err)
+		}
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	syncer := content.NewSyncer(db)
+
+	for _, file := range files {
+		var changes []content.Change
+		if dryRun {
+			changes, err = syncer.Diff(ctx, file.File, publish)
+		} else {
+			changes, err = syncer.Import(ctx, file.File, publish)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", file.Path, err)
+		}
+
+		if len(changes) == 0 {
+			fmt.Printf("%s: up to date\n", file.Path)
+			continue
+		}
+		fmt.Printf("%s: %d changes\n", file.Path, len(changes))
+		for _, change := range changes {
+			fmt.Printf("  %s\n", change)
+		}
+	}
+	return nil
+}
+
+// runPublish - публикация уровней slugs (по умолчанию — всех неопубликованных)
+func runPublish(ctx context.Context, slugs []string, note string) error {
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	syncer := content.NewSyncer(db)
+
+	if len(slugs) == 0 {
+		if slugs, err = syncer.UnpublishedSlugs(ctx); err != nil {
+			return err
+		}
+		if len(slugs) == 0 {
+			fmt.Println("no unpublished levels")
+			return nil
+		}
+	}
+	for _, slug := range slugs {
+		revision, err := syncer.Publish(ctx, slug, note)
+		if err != nil {
+			return fmt.Errorf("%s: %w", slug, err)
+		}
+		fmt.Printf("%s: published revision %d\n", slug, revision.Number)
+	}
+	return nil
+}
+
+func runExport(ctx context.Context, slugs []string, format content.Format, out string) error {
+	if format != content.FormatYAML && format != content.FormatJSON {
+		return fmt.Errorf("unsupported format %q", format)
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	syncer := content.NewSyncer(db)
+
+	if len(slugs) == 0 {
+		if slugs, err = syncer.LevelSlugs(ctx); err != nil {
+			return err
+		}
+	}
+
+	for i, slug := range slugs {
+		file, err := syncer.Export(ctx, slug)
+		if err != nil {
+			return err
+		}
+		data, err := content.Encode(file, format)
+		if err != nil {
+			return err
+		}
+
+		if out == "" {
+			if i > 0 && format == content.FormatYAML {
+				fmt.Println("---")
+			}
+			os.Stdout.Write(data)
+			continue
+		}
+
+		path := filepath.Join(out, slug+"."+string(format))
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "wrote %s\n", path)
+	}
+	return nil
+}
+
+type loadedFile struct {
+	Path string
+	File *content.File
+}
+
+func loadFiles(args []string) ([]loadedFile, error) {
+	if len(args) == 0 {
+		return nil, errors.New("no files given")
+	}
+	paths, err := content.Expand(args)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, errors.New("no content files found")
+	}
+
+	files := make([]loadedFile, 0, len(paths))
+	for _, path := range paths {
+		file, err := content.Load(path)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, loadedFile{Path: path, File: file})
+	}
+	return files, nil
+}
+
+func openDB() (*gorm.DB, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.DatabaseURL == "" {
+		return nil, errors.New("DATABASE_URL is not set")
+	}
+	return gorm.Open(postgres.Open(cfg.DatabaseURL), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Warn),
+	})
+}
@@ -0,0 +1,46 @@
+// mockoidc - локальный OIDC провайдер для разработки входа через внешние аккаунты.
+//
+//	go run ./cmd/mockoidc -addr :9090 -email dev@example.com
+//
+// и в .env бэкенда:
+//
+//	OIDC_PROVIDERS=mock
+//	OIDC_MOCK_ISSUER=http://localhost:9090
+//	OIDC_MOCK_CLIENT_ID=duofinance
+//	OIDC_MOCK_CLIENT_SECRET=secret
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/ImCtyz/duofinance/backend/internal/oidc/oidctest"
+)
+
+func main() {
+	addr := flag.String("addr", ":9090", "listen address")
+	issuer := flag.String("issuer", "http://localhost:9090", "issuer URL as seen by the backend")
+	clientID := flag.String("client-id", "duofinance", "OAuth client id")
+	clientSecret := flag.String("client-secret", "secret", "OAuth client secret")
+	subject := flag.String("sub", "mock-user-1", "subject of the signed-in user")
+	email := flag.String("email", "dev@example.com", "email of the signed-in user")
+	verified := flag.Bool("email-verified", true, "whether the email is verified")
+	name := flag.String("name", "Mock User", "display name of the signed-in user")
+	flag.Parse()
+
+	provider, err := oidctest.NewProvider(*issuer, *clientID, *clientSecret, oidctest.Identity{
+		Subject:       *subject,
+		Email:         *email,
+		EmailVerified: *verified,
+		Name:          *name,
+	})
+	if err != nil {
+		log.Fatal("Failed to create provider:", err)
+	}
+
+	log.Printf("Mock OIDC provider %s listening on %s", *issuer, *addr)
+	if err := http.ListenAndServe(*addr, provider); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -1,15 +1,24 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"os"
 	"time"
 
 	"github.com/ImCtyz/duofinance/backend/config"
 	authpkg "github.com/ImCtyz/duofinance/backend/internal/auth"
 	"github.com/ImCtyz/duofinance/backend/internal/core"
+	"github.com/ImCtyz/duofinance/backend/internal/domain"
 	"github.com/ImCtyz/duofinance/backend/internal/http"
+	"github.com/ImCtyz/duofinance/backend/internal/mail"
+	"github.com/ImCtyz/duofinance/backend/internal/migrate"
+	"github.com/ImCtyz/duofinance/backend/internal/oidc"
+	"github.com/ImCtyz/duofinance/backend/internal/ratelimit"
 	"github.com/ImCtyz/duofinance/backend/internal/repo"
+	"github.com/ImCtyz/duofinance/backend/internal/simulation"
+	migrationfiles "github.com/ImCtyz/duofinance/backend/migrations"
 	"github.com/gin-gonic/gin"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -38,27 +47,115 @@ func main() {
 	}
 	defer sqlDB.Close()
 
+	// Миграции встроены в бинарник: `server migrate ...` управляет схемой,
+	// обычный запуск сверяет ее версию с ожидаемой
+	migrations, err := migrate.Load(migrationfiles.FS)
+	if err != nil {
+		log.Fatal("Failed to load migrations:", err)
+	}
+	runner := migrate.NewRunner(sqlDB, migrations)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrateMain(context.Background(), runner, os.Args[2:])
+	}
+	if err := checkSchema(context.Background(), runner, cfg.SchemaCheck); err != nil {
+		log.Fatal("Database schema check failed: ", err)
+	}
+
 	// Создаем репозитории (пока заглушки - нужно будет реализовать)
 	userRepo := repo.NewUserRepo(db)
+	sessionRepo := repo.NewSessionRepo(db)
+	userTokenRepo := repo.NewUserTokenRepo(db)
+	recoveryCodeRepo := repo.NewRecoveryCodeRepo(db)
+	identityRepo := repo.NewIdentityRepo(db)
+	oidcStateRepo := repo.NewOIDCStateRepo(db)
 	levelRepo := repo.NewLevelRepo(db)
 	questionRepo := repo.NewQuestionRepo(db)
 	attemptRepo := repo.NewAttemptRepo(db)
 	rewardTxRepo := repo.NewRewardTxRepo(db)
 	achievementRepo := repo.NewAchievementRepo(db)
+	courseRepo := repo.NewCourseRepo(db)
+	idempotencyRepo := repo.NewIdempotencyRepo(db)
+	txManager := repo.NewTxManager(db)
+
+	// Первый администратор назначается через окружение, дальше роли меняются через /v1/admin
+	if cfg.BootstrapAdminEmail != "" {
+		ctx := context.Background()
+		admin, err := userRepo.GetByEmail(ctx, cfg.BootstrapAdminEmail)
+		if err != nil {
+			log.Printf("Bootstrap admin %s not found: %v", cfg.BootstrapAdminEmail, err)
+		} else if admin.Role != domain.RoleAdmin {
+			if err := userRepo.SetRole(ctx, admin.ID, domain.RoleAdmin); err != nil {
+				log.Fatal("Failed to grant admin role:", err)
+			}
+			log.Printf("Granted admin role to %s", admin.Email)
+		}
+	}
 
 	// Создаем сервисы (пока заглушки - нужно будет реализовать)
+	accessKeys := authpkg.NewHMACKeyRing(cfg.JWTAccessSecret)
+	if cfg.JWTKeysDir != "" {
+		accessKeys, err = authpkg.LoadKeyRing(cfg.JWTKeysDir, cfg.JWTActiveKID)
+		if err != nil {
+			log.Fatal("Failed to load JWT keys:", err)
+		}
+	}
 	jwtManager := authpkg.NewJWTManager(
-		cfg.JWTAccessSecret,
+		accessKeys,
 		cfg.JWTRefreshSecret,
 		time.Duration(cfg.JWTAccessTTLMin)*time.Minute,
 		time.Duration(cfg.JWTRefreshTTLDays)*24*time.Hour,
 	)
-	authService := core.NewAuthService(userRepo, jwtManager)
-	userService := core.NewUserService(userRepo, rewardTxRepo, attemptRepo)
-	levelService := core.NewLevelService(levelRepo, questionRepo, attemptRepo)
-	attemptService := core.NewAttemptService(attemptRepo, levelRepo, questionRepo, rewardTxRepo, userService)
-	rewardService := core.NewRewardService(rewardTxRepo)
+	var mailer mail.Mailer
+	switch cfg.MailDriver {
+	case "smtp":
+		mailer = mail.NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom)
+	case "log":
+		// В release режиме ссылки со сбросом пароля и подтверждением не должны попадать в логи
+		redact := cfg.GinMode == gin.ReleaseMode
+		if redact {
+			log.Printf("MAIL_DRIVER=log in release mode: emails are not sent and their links are redacted")
+		}
+		mailer = mail.NewLogMailer(cfg.MailLogPath, cfg.MailFrom, redact)
+	default:
+		log.Fatalf("Unknown MAIL_DRIVER %q (expected smtp or log)", cfg.MailDriver)
+	}
+	failureWindow := time.Duration(cfg.LoginFailureWindowMin) * time.Minute
+	lockoutBase := time.Duration(cfg.LoginLockoutBaseSec) * time.Second
+	lockoutMax := time.Duration(cfg.LoginLockoutMaxMin) * time.Minute
+	loginProtection := core.LoginProtection{
+		MaxAccountFailures: cfg.LoginMaxFailures,
+		FailureWindow:      failureWindow,
+		LockoutBase:        lockoutBase,
+		LockoutMax:         lockoutMax,
+	}
+	if cfg.LoginIPMaxFailures > 0 {
+		loginProtection.IPFailures = ratelimit.NewFailureTracker(cfg.LoginIPMaxFailures, lockoutBase, lockoutMax, failureWindow)
+	}
+	var oidcProviders []*oidc.Provider
+	for _, p := range cfg.OIDCProviders {
+		if p.Issuer == "" || p.ClientID == "" {
+			log.Fatalf("OIDC provider %q requires ISSUER and CLIENT_ID", p.Name)
+		}
+		oidcProviders = append(oidcProviders, oidc.NewProvider(oidc.Config{
+			Name:         p.Name,
+			Issuer:       p.Issuer,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			RedirectURL:  p.RedirectURL,
+			Scopes:       p.Scopes,
+		}))
+	}
+	authService := core.NewAuthService(userRepo, sessionRepo, userTokenRepo, recoveryCodeRepo, identityRepo, oidcStateRepo, txManager, jwtManager, mailer, cfg.AppBaseURL, loginProtection, oidcProviders)
+	simulations := simulation.NewEngine()
+	userService := core.NewUserService(userRepo, sessionRepo, rewardTxRepo, attemptRepo)
+	levelService := core.NewLevelService(levelRepo, questionRepo, attemptRepo, courseRepo)
 	achievementService := core.NewAchievementService(achievementRepo, userRepo)
+	attemptService := core.NewAttemptService(attemptRepo, levelRepo, questionRepo, rewardTxRepo, courseRepo, txManager, userService, achievementService, simulations)
+	rewardService := core.NewRewardService(rewardTxRepo)
+	contentService := core.NewContentService(levelRepo, questionRepo, simulations)
+	courseService := core.NewCourseService(courseRepo, levelRepo, attemptRepo, txManager)
+	analyticsService := core.NewAnalyticsService(attemptRepo, questionRepo, levelRepo)
+	idempotencyService := core.NewIdempotencyService(idempotencyRepo)
 
 	// Создаем структуру сервисов
 	services := http.NewServices(
@@ -68,13 +165,25 @@ func main() {
 		attemptService,
 		rewardService,
 		achievementService,
+		contentService,
+		courseService,
+		analyticsService,
+		idempotencyService,
 	)
 
 	// Создаем Gin роутер
 	router := gin.Default()
+	// IP клиента нужен для лимитов и блокировки входа: X-Forwarded-For принимается
+	// только от перечисленных прокси
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES:", err)
+	}
 
 	// Настраиваем маршруты
-	http.SetupRoutes(router, services, db)
+	http.SetupRoutes(router, services, db, http.RateLimits{
+		Auth: cfg.RateLimitAuthPerMin,
+		API:  cfg.RateLimitAPIPerMin,
+	})
 
 	// Запускаем сервер
 	log.Printf("Starting server on port %d", cfg.Port)
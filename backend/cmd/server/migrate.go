@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/ImCtyz/duofinance/backend/internal/migrate"
+)
+
+const migrateUsage = `usage:
+  server migrate up             apply all pending migrations
+  server migrate down [N]       revert the last N migrations (default 1)
+  server migrate status         show the schema version and pending migrations
+  server migrate force VERSION  set the schema version without running migrations (0 - none)
+`
+
+// errMigrateUsage - неверные аргументы подкоманды migrate
+var errMigrateUsage = errors.New("invalid arguments")
+
+// runMigrate - подкоманда migrate; схема меняется встроенными в бинарник миграциями
+func runMigrate(ctx context.Context, runner *migrate.Runner, args []string) error {
+	if len(args) == 0 {
+		return errMigrateUsage
+	}
+
+	switch cmd, args := args[0], args[1:]; cmd {
+	case "up":
+		if len(args) != 0 {
+			return errMigrateUsage
+		}
+		applied, err := runner.Up(ctx)
+		for _, m := range applied {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("no pending migrations")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			return errMigrateUsage
+		}
+		if len(args) == 1 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 1 {
+				return fmt.Errorf("down: N must be a positive number, got %q", args[0])
+			}
+			steps = n
+		}
+		reverted, err := runner.Down(ctx, steps)
+		for _, m := range reverted {
+			fmt.Printf("reverted %04d_%s\n", m.Version, m.Name)
+		}
+		return err
+	case "status":
+		if len(args) != 0 {
+			return errMigrateUsage
+		}
+		return printStatus(ctx, runner)
+	case "force":
+		if len(args) != 1 {
+			return errMigrateUsage
+		}
+		version, err := strconv.ParseUint(args[0], 10, 32)
+		if err != nil {
+			return fmt.Errorf("force: invalid version %q", args[0])
+		}
+		if err := runner.Force(ctx, uint(version)); err != nil {
+			return err
+		}
+		fmt.Printf("schema version set to %d\n", version)
+		return nil
+	default:
+		return errMigrateUsage
+	}
+}
+
+func printStatus(ctx context.Context, runner *migrate.Runner) error {
+	state, err := runner.State(ctx)
+	if err != nil {
+		return err
+	}
+	dirty := ""
+	if state.Dirty {
+		dirty = " (dirty)"
+	}
+	fmt.Printf("database version: %d%s\n", state.Version, dirty)
+	fmt.Printf("binary expects:   %d\n", runner.Latest())
+	for _, m := range runner.Migrations() {
+		mark := "pending"
+		if m.Version <= state.Version {
+			mark = "applied"
+		}
+		fmt.Printf("  %04d_%-40s %s\n", m.Version, m.Name, mark)
+	}
+	return nil
+}
+
+// migrateMain - выполнение `server migrate ...` с выходом из процесса
+func migrateMain(ctx context.Context, runner *migrate.Runner, args []string) {
+	err := runMigrate(ctx, runner, args)
+	if errors.Is(err, errMigrateUsage) {
+		fmt.Fprint(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "migrate:", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// checkSchema - сверка версии схемы при запуске сервера согласно SCHEMA_CHECK
+func checkSchema(ctx context.Context, runner *migrate.Runner, mode string) error {
+	switch mode {
+	case "off":
+		return nil
+	case "warn", "fail":
+	default:
+		return fmt.Errorf("SCHEMA_CHECK must be fail, warn or off, got %q", mode)
+	}
+
+	err := runner.Check(ctx)
+	if err != nil && mode == "warn" && errors.Is(err, migrate.ErrSchemaMismatch) {
+		log.Printf("WARNING: %v; run `server migrate up`", err)
+		return nil
+	}
+	return err
+}
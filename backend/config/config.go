@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
@@ -13,8 +14,48 @@ type Config struct {
 	GinMode           string
 	JWTAccessSecret   string
 	JWTRefreshSecret  string
-	JWTAccessTTLMin   int // minutes
-	JWTRefreshTTLDays int // days
+	JWTKeysDir        string // каталог с PEM ключами подписи access токенов; пусто — HS256 с JWTAccessSecret
+	JWTActiveKID      string // kid ключа подписи (имя PEM файла без расширения)
+	JWTAccessTTLMin   int    // minutes
+	JWTRefreshTTLDays int    // days
+
+	AppBaseURL   string // адрес фронтенда для ссылок в письмах
+	MailDriver   string // smtp|log
+	MailFrom     string
+	MailLogPath  string // файл для писем при MailDriver=log; пусто — стандартный лог
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+
+	LoginMaxFailures      int // неудачных входов в аккаунт до блокировки
+	LoginFailureWindowMin int // minutes
+	LoginLockoutBaseSec   int // seconds, удваивается с каждой следующей неудачей
+	LoginLockoutMaxMin    int // minutes
+	LoginIPMaxFailures    int // неудачных входов с одного IP до блокировки
+	RateLimitAuthPerMin   int // запросов в минуту к /v1/auth с одного IP (0 — без ограничения)
+	RateLimitAPIPerMin    int // запросов в минуту к защищенным эндпоинтам с одного IP
+	// Прокси (IP или CIDR), чьим X-Forwarded-For можно верить при определении IP клиента;
+	// пусто — заголовок игнорируется и используется адрес соединения
+	TrustedProxies []string
+
+	OIDCProviders []OIDCProvider // провайдеры из OIDC_PROVIDERS
+
+	BootstrapAdminEmail string // пользователь, получающий роль admin при запуске (если зарегистрирован)
+
+	SchemaCheck string // fail|warn|off — реакция на несовпадение версии схемы БД при запуске
+}
+
+// OIDCProvider - настройки входа через внешнего OIDC провайдера.
+// Для провайдера name читаются переменные OIDC_<NAME>_ISSUER, _CLIENT_ID,
+// _CLIENT_SECRET, _REDIRECT_URL и _SCOPES (через пробел или запятую).
+type OIDCProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
 }
 
 func Load() (*Config, error) {
@@ -24,6 +65,14 @@ func Load() (*Config, error) {
 	port, _ := strconv.Atoi(getEnv("PORT", "8080"))
 	jwtAccessTTLMin, _ := strconv.Atoi(getEnv("JWT_ACCESS_TTL_MIN", "15"))
 	jwtRefreshTTLDays, _ := strconv.Atoi(getEnv("JWT_REFRESH_TTL_DAYS", "7"))
+	smtpPort, _ := strconv.Atoi(getEnv("SMTP_PORT", "587"))
+	loginMaxFailures, _ := strconv.Atoi(getEnv("LOGIN_MAX_FAILURES", "5"))
+	loginFailureWindowMin, _ := strconv.Atoi(getEnv("LOGIN_FAILURE_WINDOW_MIN", "15"))
+	loginLockoutBaseSec, _ := strconv.Atoi(getEnv("LOGIN_LOCKOUT_BASE_SEC", "60"))
+	loginLockoutMaxMin, _ := strconv.Atoi(getEnv("LOGIN_LOCKOUT_MAX_MIN", "60"))
+	loginIPMaxFailures, _ := strconv.Atoi(getEnv("LOGIN_IP_MAX_FAILURES", "20"))
+	rateLimitAuthPerMin, _ := strconv.Atoi(getEnv("RATE_LIMIT_AUTH_PER_MIN", "30"))
+	rateLimitAPIPerMin, _ := strconv.Atoi(getEnv("RATE_LIMIT_API_PER_MIN", "300"))
 
 	return &Config{
 		DatabaseURL:       getEnv("DATABASE_URL", ""),
@@ -31,11 +80,62 @@ func Load() (*Config, error) {
 		GinMode:           getEnv("GIN_MODE", "debug"),
 		JWTAccessSecret:   getEnv("JWT_ACCESS_SECRET", "change-me-access-secret"),
 		JWTRefreshSecret:  getEnv("JWT_REFRESH_SECRET", "change-me-refresh-secret"),
+		JWTKeysDir:        getEnv("JWT_KEYS_DIR", ""),
+		JWTActiveKID:      getEnv("JWT_ACTIVE_KID", ""),
 		JWTAccessTTLMin:   jwtAccessTTLMin,
 		JWTRefreshTTLDays: jwtRefreshTTLDays,
+		AppBaseURL:        getEnv("APP_BASE_URL", "http://localhost:3000"),
+		MailDriver:        getEnv("MAIL_DRIVER", "log"),
+		MailFrom:          getEnv("MAIL_FROM", "DuoFinance <no-reply@duofinance.local>"),
+		MailLogPath:       getEnv("MAIL_LOG_PATH", ""),
+		SMTPHost:          getEnv("SMTP_HOST", ""),
+		SMTPPort:          smtpPort,
+		SMTPUsername:      getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:      getEnv("SMTP_PASSWORD", ""),
+
+		LoginMaxFailures:      loginMaxFailures,
+		LoginFailureWindowMin: loginFailureWindowMin,
+		LoginLockoutBaseSec:   loginLockoutBaseSec,
+		LoginLockoutMaxMin:    loginLockoutMaxMin,
+		LoginIPMaxFailures:    loginIPMaxFailures,
+		RateLimitAuthPerMin:   rateLimitAuthPerMin,
+		RateLimitAPIPerMin:    rateLimitAPIPerMin,
+		TrustedProxies:        splitList(getEnv("TRUSTED_PROXIES", "")),
+
+		BootstrapAdminEmail: getEnv("BOOTSTRAP_ADMIN_EMAIL", ""),
+
+		SchemaCheck: getEnv("SCHEMA_CHECK", "fail"),
+
+		OIDCProviders: loadOIDCProviders(getEnv("OIDC_PROVIDERS", ""), getEnv("APP_BASE_URL", "http://localhost:3000")),
 	}, nil
 }
 
+// splitList - непустые элементы списка через запятую или пробел
+func splitList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' })
+}
+
+// loadOIDCProviders - настройки провайдеров из списка имен через запятую
+func loadOIDCProviders(names, appBaseURL string) []OIDCProvider {
+	var providers []OIDCProvider
+	for _, name := range strings.Split(names, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		providers = append(providers, OIDCProvider{
+			Name:         name,
+			Issuer:       getEnv(prefix+"ISSUER", ""),
+			ClientID:     getEnv(prefix+"CLIENT_ID", ""),
+			ClientSecret: getEnv(prefix+"CLIENT_SECRET", ""),
+			RedirectURL:  getEnv(prefix+"REDIRECT_URL", strings.TrimRight(appBaseURL, "/")+"/oauth/"+name+"/callback"),
+			Scopes:       splitList(getEnv(prefix+"SCOPES", "")),
+		})
+	}
+	return providers
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
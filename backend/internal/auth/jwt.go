@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 var (
@@ -12,85 +13,126 @@ var (
 	ErrExpiredToken = errors.New("token has expired")
 )
 
+// Назначение токенов (claim sub)
+const (
+	SubjectAccess     = "access_token"
+	SubjectRefresh    = "refresh_token"
+	SubjectMFAPending = "mfa_pending" // пароль проверен, ожидается второй фактор
+)
+
+// MFATokenTTL - время на ввод второго фактора после проверки пароля
+const MFATokenTTL = 5 * time.Minute
+
 // JWTClaims - структура для JWT токена
 type JWTClaims struct {
-	UserID   uint   `json:"user_id"`
-	Email    string `json:"email"`
-	Username string `json:"username"`
+	UserID    uint   `json:"user_id"`
+	Email     string `json:"email"`
+	Username  string `json:"username"`
+	Role      string `json:"role,omitempty"`
+	SessionID string `json:"sid,omitempty"` // идентификатор цепочки refresh токенов (сессии)
 	jwt.RegisteredClaims
 }
 
-// JWTManager - менеджер для работы с JWT токенами
+// TokenPair - выданная пара токенов с метаданными refresh токена
+type TokenPair struct {
+	AccessToken      string
+	RefreshToken     string
+	SessionID        string
+	RefreshJTI       string
+	IssuedAt         time.Time
+	RefreshExpiresAt time.Time
+}
+
+// JWTManager - менеджер для работы с JWT токенами.
+// Access токены подписываются ключами из accessKeys и могут проверяться другими сервисами
+// по JWKS; refresh токены проверяет только этот сервис, поэтому они подписываются HS256.
 type JWTManager struct {
-	accessSecret  string
+	accessKeys    *KeyRing
 	refreshSecret string
 	accessTTL     time.Duration
 	refreshTTL    time.Duration
 }
 
 // NewJWTManager - создание нового JWT менеджера
-func NewJWTManager(accessSecret, refreshSecret string, accessTTL, refreshTTL time.Duration) *JWTManager {
+func NewJWTManager(accessKeys *KeyRing, refreshSecret string, accessTTL, refreshTTL time.Duration) *JWTManager {
 	return &JWTManager{
-		accessSecret:  accessSecret,
+		accessKeys:    accessKeys,
 		refreshSecret: refreshSecret,
 		accessTTL:     accessTTL,
 		refreshTTL:    refreshTTL,
 	}
 }
 
-// GenerateTokens - генерация access и refresh токенов
-func (j *JWTManager) GenerateTokens(userID uint, email, username string) (accessToken, refreshToken string, err error) {
+// JWKS - публичные ключи проверки access токенов
+func (j *JWTManager) JWKS() *JWKSet {
+	return j.accessKeys.JWKS()
+}
+
+// GenerateTokens - генерация access и refresh токенов для сессии sessionID.
+// Каждый токен получает уникальный jti, по которому refresh токен отслеживается на сервере.
+// Роль попадает только в access токен: при обновлении она берется из БД заново.
+func (j *JWTManager) GenerateTokens(userID uint, email, username, role, sessionID string) (*TokenPair, error) {
+	now := time.Now()
+
 	// Access token
 	accessClaims := JWTClaims{
-		UserID:   userID,
-		Email:    email,
-		Username: username,
+		UserID:    userID,
+		Email:     email,
+		Username:  username,
+		Role:      role,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(j.accessTTL)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
+			ID:        uuid.NewString(),
+			ExpiresAt: jwt.NewNumericDate(now.Add(j.accessTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
 			Issuer:    "duofinance",
-			Subject:   "access_token",
+			Subject:   SubjectAccess,
 		},
 	}
 
-	accessTokenObj := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims)
-	accessToken, err = accessTokenObj.SignedString([]byte(j.accessSecret))
+	accessToken, err := j.accessKeys.Sign(accessClaims)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 
 	// Refresh token
+	refreshJTI := uuid.NewString()
+	refreshExpiresAt := now.Add(j.refreshTTL)
 	refreshClaims := JWTClaims{
-		UserID:   userID,
-		Email:    email,
-		Username: username,
+		UserID:    userID,
+		Email:     email,
+		Username:  username,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(j.refreshTTL)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
+			ID:        refreshJTI,
+			ExpiresAt: jwt.NewNumericDate(refreshExpiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
 			Issuer:    "duofinance",
-			Subject:   "refresh_token",
+			Subject:   SubjectRefresh,
 		},
 	}
 
 	refreshTokenObj := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims)
-	refreshToken, err = refreshTokenObj.SignedString([]byte(j.refreshSecret))
+	refreshToken, err := refreshTokenObj.SignedString([]byte(j.refreshSecret))
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 
-	return accessToken, refreshToken, nil
+	return &TokenPair{
+		AccessToken:      accessToken,
+		RefreshToken:     refreshToken,
+		SessionID:        sessionID,
+		RefreshJTI:       refreshJTI,
+		IssuedAt:         now,
+		RefreshExpiresAt: refreshExpiresAt,
+	}, nil
 }
 
 // ValidateAccessToken - валидация access токена
 func (j *JWTManager) ValidateAccessToken(tokenString string) (*JWTClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, ErrInvalidToken
-		}
-		return []byte(j.accessSecret), nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, j.accessKeys.Keyfunc)
 
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenExpired) {
@@ -100,7 +142,7 @@ func (j *JWTManager) ValidateAccessToken(tokenString string) (*JWTClaims, error)
 	}
 
 	if claims, ok := token.Claims.(*JWTClaims); ok && token.Valid {
-		if claims.Subject != "access_token" {
+		if claims.Subject != SubjectAccess {
 			return nil, ErrInvalidToken
 		}
 		return claims, nil
@@ -109,8 +151,40 @@ func (j *JWTManager) ValidateAccessToken(tokenString string) (*JWTClaims, error)
 	return nil, ErrInvalidToken
 }
 
+// GenerateMFAToken - короткоживущий токен, подтверждающий проверку пароля.
+// Обменивается на пару access/refresh после ввода второго фактора.
+func (j *JWTManager) GenerateMFAToken(userID uint, email, username string) (string, error) {
+	now := time.Now()
+	claims := JWTClaims{
+		UserID:   userID,
+		Email:    email,
+		Username: username,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			ExpiresAt: jwt.NewNumericDate(now.Add(MFATokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			Issuer:    "duofinance",
+			Subject:   SubjectMFAPending,
+		},
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(j.refreshSecret))
+}
+
 // ValidateRefreshToken - валидация refresh токена
 func (j *JWTManager) ValidateRefreshToken(tokenString string) (*JWTClaims, error) {
+	return j.validateHMACToken(tokenString, SubjectRefresh)
+}
+
+// ValidateMFAToken - валидация токена ожидания второго фактора
+func (j *JWTManager) ValidateMFAToken(tokenString string) (*JWTClaims, error) {
+	return j.validateHMACToken(tokenString, SubjectMFAPending)
+}
+
+// validateHMACToken - проверка токена, подписанного секретом refresh токенов.
+// Subject не позволяет использовать токен одного назначения вместо другого.
+func (j *JWTManager) validateHMACToken(tokenString, subject string) (*JWTClaims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, ErrInvalidToken
@@ -126,7 +200,7 @@ func (j *JWTManager) ValidateRefreshToken(tokenString string) (*JWTClaims, error
 	}
 
 	if claims, ok := token.Claims.(*JWTClaims); ok && token.Valid {
-		if claims.Subject != "refresh_token" {
+		if claims.Subject != subject {
 			return nil, ErrInvalidToken
 		}
 		return claims, nil
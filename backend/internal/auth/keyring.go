@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrNoSigningKey = errors.New("no signing key configured")
+	ErrUnknownKey   = errors.New("unknown key id")
+)
+
+// Key - ключ подписи или проверки токенов
+type Key struct {
+	ID      string
+	Method  jwt.SigningMethod
+	private interface{} // nil для ключей, оставленных только для проверки
+	public  interface{}
+}
+
+// KeyRing - набор ключей: один активный для подписи и несколько для проверки.
+// Позволяет ротировать ключи без разлогинивания пользователей: новый ключ
+// становится активным, старый остается в наборе до истечения выданных им токенов.
+type KeyRing struct {
+	active *Key
+	keys   map[string]*Key
+}
+
+// NewHMACKeyRing - набор из единственного HS256 секрета (режим совместимости).
+// Токены подписываются без заголовка kid.
+func NewHMACKeyRing(secret string) *KeyRing {
+	key := &Key{
+		Method:  jwt.SigningMethodHS256,
+		private: []byte(secret),
+		public:  []byte(secret),
+	}
+	return &KeyRing{active: key, keys: map[string]*Key{"": key}}
+}
+
+// LoadKeyRing - загрузка ключей из PEM файлов каталога dir.
+// kid ключа — имя файла без расширения. Приватные ключи (RSA или Ed25519)
+// пригодны для подписи и проверки, публичные — только для проверки.
+// activeKID выбирает ключ подписи; если не задан, а приватный ключ единственный, используется он.
+func LoadKeyRing(dir, activeKID string) (*KeyRing, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	ring := &KeyRing{keys: make(map[string]*Key)}
+	var privateKIDs []string
+	for _, path := range paths {
+		kid := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		key, err := loadPEMKey(path, kid)
+		if err != nil {
+			return nil, fmt.Errorf("load key %s: %w", path, err)
+		}
+		ring.keys[kid] = key
+		if key.private != nil {
+			privateKIDs = append(privateKIDs, kid)
+		}
+	}
+
+	if activeKID == "" && len(privateKIDs) == 1 {
+		activeKID = privateKIDs[0]
+	}
+	active, ok := ring.keys[activeKID]
+	if !ok || active.private == nil {
+		return nil, fmt.Errorf("%w: active key %q not found among private keys in %s", ErrNoSigningKey, activeKID, dir)
+	}
+	ring.active = active
+
+	return ring, nil
+}
+
+// Sign - подпись claims активным ключом
+func (r *KeyRing) Sign(claims jwt.Claims) (string, error) {
+	if r.active == nil {
+		return "", ErrNoSigningKey
+	}
+	token := jwt.NewWithClaims(r.active.Method, claims)
+	if r.active.ID != "" {
+		token.Header["kid"] = r.active.ID
+	}
+	return token.SignedString(r.active.private)
+}
+
+// Keyfunc - выбор ключа проверки по заголовку kid
+func (r *KeyRing) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := r.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, ErrInvalidToken
+	}
+	return key.public, nil
+}
+
+// JWK - публичный ключ в формате RFC 7517
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKSet - набор публичных ключей
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS - публичные ключи для проверки токенов сторонними сервисами.
+// Симметричные ключи не публикуются.
+func (r *KeyRing) JWKS() *JWKSet {
+	set := &JWKSet{Keys: []JWK{}}
+
+	kids := make([]string, 0, len(r.keys))
+	for kid := range r.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	for _, kid := range kids {
+		key := r.keys[kid]
+		switch pub := key.public.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "RSA",
+				Kid: kid,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "OKP",
+				Kid: kid,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+
+	return set
+}
+
+// loadPEMKey - разбор приватного или публичного ключа из PEM файла
+func loadPEMKey(path, kid string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &Key{ID: kid, Method: jwt.SigningMethodRS256, private: k, public: &k.PublicKey}, nil
+	case *rsa.PublicKey:
+		return &Key{ID: kid, Method: jwt.SigningMethodRS256, public: k}, nil
+	case ed25519.PrivateKey:
+		return &Key{ID: kid, Method: jwt.SigningMethodEdDSA, private: k, public: k.Public()}, nil
+	case ed25519.PublicKey:
+		return &Key{ID: kid, Method: jwt.SigningMethodEdDSA, public: k}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+}
@@ -0,0 +1,102 @@
+package auth_test
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ImCtyz/duofinance/backend/internal/auth"
+)
+
+// Секрет из RFC 6238, приложение B (SHA1): ASCII "12345678901234567890" в base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// Коды приложения B — восьмизначные; шестизначный код — их последние шесть цифр
+func TestValidateTOTPVectors(t *testing.T) {
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},          // 94287082
+		{1111111109, "081804"},  // 07081804
+		{1111111111, "050471"},  // 14050471
+		{1234567890, "005924"},  // 89005924
+		{2000000000, "279037"},  // 69279037
+		{20000000000, "353130"}, // 65353130
+	}
+	for _, v := range vectors {
+		step, ok := auth.ValidateTOTP(rfcSecret, v.code, time.Unix(v.unix, 0))
+		if !ok || step != v.unix/30 {
+			t.Errorf("T=%d code %s: step %d, ok %v; want step %d", v.unix, v.code, step, ok, v.unix/30)
+		}
+	}
+}
+
+// Код шага 1 (T=30..59) принимается на соседних шагах 0 и 2 и отклоняется дальше
+func TestValidateTOTPSkew(t *testing.T) {
+	cases := []struct {
+		unix int64
+		ok   bool
+	}{
+		{0, true},   // шаг 0: на шаг раньше
+		{29, true},  // последняя секунда шага 0
+		{30, true},  // сам шаг 1
+		{89, true},  // последняя секунда шага 2
+		{90, false}, // шаг 3: на два шага позже
+		{120, false},
+	}
+	for _, tc := range cases {
+		step, ok := auth.ValidateTOTP(rfcSecret, "287082", time.Unix(tc.unix, 0))
+		if ok != tc.ok {
+			t.Errorf("T=%d: ok = %v, want %v", tc.unix, ok, tc.ok)
+		}
+		// Возвращается шаг кода, а не текущий: по нему сервер запрещает повтор
+		if ok && step != 1 {
+			t.Errorf("T=%d: step = %d, want 1", tc.unix, step)
+		}
+	}
+}
+
+func TestValidateTOTPRejectsMalformedInput(t *testing.T) {
+	now := time.Unix(59, 0)
+	for _, code := range []string{"", "28708", "2870820", "94287082", "abcdef"} {
+		if _, ok := auth.ValidateTOTP(rfcSecret, code, now); ok {
+			t.Errorf("code %q accepted", code)
+		}
+	}
+	if _, ok := auth.ValidateTOTP(rfcSecret, " 287082 ", now); !ok {
+		t.Error("code with surrounding spaces rejected")
+	}
+	if _, ok := auth.ValidateTOTP(strings.ToLower(rfcSecret), "287082", now); !ok {
+		t.Error("lowercase secret rejected")
+	}
+	if _, ok := auth.ValidateTOTP("not base32!", "287082", now); ok {
+		t.Error("code accepted for an invalid secret")
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	first, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 20 байт в base32 без выравнивания — 32 символа
+	if len(first) != 32 || first == second {
+		t.Errorf("secrets %q and %q", first, second)
+	}
+
+	uri, err := url.Parse(auth.TOTPURI("DuoFinance", "alice@example.com", first))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := uri.Query()
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || query.Get("secret") != first ||
+		query.Get("digits") != "6" || query.Get("period") != "30" {
+		t.Errorf("TOTPURI = %s", uri)
+	}
+}
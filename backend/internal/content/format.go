@@ -0,0 +1,231 @@
+// Package content - декларативный формат уровней (YAML/JSON) и его синхронизация
+// с таблицами levels, level_steps, questions и choices по стабильным slug.
+package content
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ImCtyz/duofinance/backend/internal/domain"
+	"github.com/goccy/go-yaml"
+)
+
+// FormatVersion - текущая версия формата файла
+const FormatVersion = 1
+
+// Format - сериализация файла контента
+type Format string
+
+const (
+	FormatYAML Format = "yaml"
+	FormatJSON Format = "json"
+)
+
+// File - один уровень со всеми шагами, вопросами и вариантами ответов
+type File struct {
+	Version int   `json:"version" yaml:"version"`
+	Level   Level `json:"level" yaml:"level"`
+}
+
+// Level - уровень; шаги идут в порядке прохождения
+type Level struct {
+	Slug         string `json:"slug" yaml:"slug"`
+	Title        string `json:"title" yaml:"title"`
+	Topic        string `json:"topic,omitempty" yaml:"topic,omitempty"`
+	Difficulty   string `json:"difficulty,omitempty" yaml:"difficulty,omitempty"`
+	RewardPoints int    `json:"reward_points" yaml:"reward_points"`
+	IsActive     *bool  `json:"is_active,omitempty" yaml:"is_active,omitempty"` // по умолчанию true
+	// Правила подсчета результата; по умолчанию domain.DefaultScoringPolicy
+	Scoring *domain.ScoringPolicy `json:"scoring,omitempty" yaml:"scoring,omitempty"`
+	Steps   []Step                `json:"steps" yaml:"steps"`
+}
+
+// Step - шаг уровня. Question задается только для шагов типа question,
+// Payload — произвольный JSON для текстовых шагов и симуляций.
+type Step struct {
+	Slug     string                 `json:"slug" yaml:"slug"`
+	Type     string                 `json:"type" yaml:"type"`
+	Title    string                 `json:"title,omitempty" yaml:"title,omitempty"`
+	Payload  map[string]interface{} `json:"payload,omitempty" yaml:"payload,omitempty"`
+	Question *Question              `json:"question,omitempty" yaml:"question,omitempty"`
+}
+
+// Question - вопрос; slug по умолчанию <slug уровня>.<slug шага>
+type Question struct {
+	Slug        string `json:"slug,omitempty" yaml:"slug,omitempty"`
+	Prompt      string `json:"prompt" yaml:"prompt"`
+	Explanation string `json:"explanation,omitempty" yaml:"explanation,omitempty"`
+	Kind        string `json:"kind,omitempty" yaml:"kind,omitempty"` // choice|numeric|ordering|matching|cloze
+	// Spec — правильный ответ для видов, кроме choice (domain.NumericSpec и т.п.)
+	Spec map[string]interface{} `json:"spec,omitempty" yaml:"spec,omitempty"`
+	// Template — шаблон со случайными значениями для numeric и choice без вариантов
+	Template    *domain.QuestionTemplate `json:"template,omitempty" yaml:"template,omitempty"`
+	MultiSelect bool                     `json:"multi_select,omitempty" yaml:"multi_select,omitempty"`
+	Grading     string                   `json:"grading,omitempty" yaml:"grading,omitempty"`         // exact|partial|at_least
+	MinCorrect  int                      `json:"min_correct,omitempty" yaml:"min_correct,omitempty"` // для at_least
+	Choices     []Choice                 `json:"choices,omitempty" yaml:"choices,omitempty"`
+}
+
+// Choice - вариант ответа; slug по умолчанию — номер варианта, начиная с 1
+type Choice struct {
+	Slug    string `json:"slug,omitempty" yaml:"slug,omitempty"`
+	Text    string `json:"text" yaml:"text"`
+	Correct bool   `json:"correct,omitempty" yaml:"correct,omitempty"`
+}
+
+// FormatFromPath - формат по расширению файла
+func FormatFromPath(path string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML, nil
+	case ".json":
+		return FormatJSON, nil
+	}
+	return "", fmt.Errorf("%s: unsupported file extension (want .yaml, .yml or .json)", path)
+}
+
+// Decode - разбор файла; неизвестные поля считаются ошибкой, пропущенные slug
+// вопросов и вариантов заполняются значениями по умолчанию
+func Decode(data []byte, format Format) (*File, error) {
+	var f File
+	switch format {
+	case FormatYAML:
+		if err := yaml.UnmarshalWithOptions(data, &f, yaml.DisallowUnknownField()); err != nil {
+			return nil, err
+		}
+	case FormatJSON:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&f); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+
+	f.applyDefaults()
+	return &f, nil
+}
+
+// Encode - сериализация файла; slug, совпадающие со значениями по умолчанию, опускаются
+func Encode(f *File, format Format) ([]byte, error) {
+	out := f.withoutDefaults()
+	switch format {
+	case FormatYAML:
+		return yaml.MarshalWithOptions(out, yaml.Indent(2), yaml.IndentSequence(true), yaml.UseLiteralStyleIfMultiline(true))
+	case FormatJSON:
+		data, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	}
+	return nil, fmt.Errorf("unsupported format %q", format)
+}
+
+// Load - чтение файла с диска
+func Load(path string) (*File, error) {
+	format, err := FormatFromPath(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := Decode(data, format)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return f, nil
+}
+
+// Expand - список файлов контента: каталоги раскрываются в *.yaml, *.yml и *.json
+// (без рекурсии), результат отсортирован
+func Expand(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			if _, err := FormatFromPath(entry.Name()); err == nil {
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Active - признак активности уровня с учетом значения по умолчанию
+func (l *Level) Active() bool {
+	return l.IsActive == nil || *l.IsActive
+}
+
+func defaultQuestionSlug(levelSlug, stepSlug string) string {
+	return levelSlug + "." + stepSlug
+}
+
+func defaultChoiceSlug(index int) string {
+	return strconv.Itoa(index + 1)
+}
+
+func (f *File) applyDefaults() {
+	for i := range f.Level.Steps {
+		step := &f.Level.Steps[i]
+		if step.Question == nil {
+			continue
+		}
+		if step.Question.Slug == "" {
+			step.Question.Slug = defaultQuestionSlug(f.Level.Slug, step.Slug)
+		}
+		for j := range step.Question.Choices {
+			if step.Question.Choices[j].Slug == "" {
+				step.Question.Choices[j].Slug = defaultChoiceSlug(j)
+			}
+		}
+	}
+}
+
+func (f *File) withoutDefaults() *File {
+	out := *f
+	out.Level.Steps = make([]Step, len(f.Level.Steps))
+	for i, step := range f.Level.Steps {
+		if step.Question != nil {
+			question := *step.Question
+			if question.Slug == defaultQuestionSlug(f.Level.Slug, step.Slug) {
+				question.Slug = ""
+			}
+			question.Choices = make([]Choice, len(step.Question.Choices))
+			for j, choice := range step.Question.Choices {
+				if choice.Slug == defaultChoiceSlug(j) {
+					choice.Slug = ""
+				}
+				question.Choices[j] = choice
+			}
+			step.Question = &question
+		}
+		out.Level.Steps[i] = step
+	}
+	return &out
+}
@@ -0,0 +1,640 @@
+package content
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ImCtyz/duofinance/backend/internal/domain"
+	"github.com/ImCtyz/duofinance/backend/internal/repo"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+var ErrLevelNotFound = errors.New("level not found")
+
+// Op - вид изменения
+type Op string
+
+const (
+	OpCreate  Op = "create"
+	OpUpdate  Op = "update"
+	OpDelete  Op = "delete"
+	OpRestore Op = "restore"
+	OpPublish Op = "publish"
+)
+
+// Change - одно изменение, которое нужно внести в БД, чтобы она совпала с файлом
+type Change struct {
+	Op     Op
+	Kind   string   // level|step|question|choice
+	Slug   string   // для шагов и вариантов — с префиксом родителя
+	Fields []string // измененные колонки (для update)
+}
+
+func (c Change) String() string {
+	switch c.Op {
+	case OpCreate:
+		return fmt.Sprintf("+ %s %s", c.Kind, c.Slug)
+	case OpDelete:
+		return fmt.Sprintf("- %s %s", c.Kind, c.Slug)
+	case OpRestore:
+		return fmt.Sprintf("* %s %s (restore)", c.Kind, c.Slug)
+	case OpPublish:
+		return fmt.Sprintf("^ %s %s (publish)", c.Kind, c.Slug)
+	}
+	return fmt.Sprintf("~ %s %s (%s)", c.Kind, c.Slug, strings.Join(c.Fields, ", "))
+}
+
+// Syncer - импорт и экспорт файлов контента. Записи сопоставляются по slug:
+// повторный импорт того же файла ничего не меняет. Шаги и варианты ответов,
+// которых нет в файле, мягко удаляются; вопросы не удаляются никогда,
+// так как на них могут ссылаться другие уровни.
+//
+// Импорт пишет рабочие (черновые) таблицы. Игроки видят только опубликованную
+// ревизию, поэтому без publish изменения остаются черновиком до публикации.
+type Syncer struct {
+	db *gorm.DB
+}
+
+// NewSyncer - создание синхронизатора
+func NewSyncer(db *gorm.DB) *Syncer {
+	return &Syncer{db: db}
+}
+
+// Diff - изменения, которые внесет Import, без записи в БД
+func (s *Syncer) Diff(ctx context.Context, f *File, publish bool) ([]Change, error) {
+	if err := Validate(f); err != nil {
+		return nil, err
+	}
+	return s.sync(s.db.WithContext(ctx), f, false, publish)
+}
+
+// Import - привести БД в соответствие с файлом в одной транзакции. При publish
+// уровень публикуется новой ревизией, если он изменился или еще не публиковался
+func (s *Syncer) Import(ctx context.Context, f *File, publish bool) ([]Change, error) {
+	if err := Validate(f); err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		changes, err = s.sync(tx, f, true, publish)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// Export - текущее состояние уровня в виде файла
+func (s *Syncer) Export(ctx context.Context, slug string) (*File, error) {
+	var level domain.Level
+	err := s.db.WithContext(ctx).
+		Preload("Steps", func(db *gorm.DB) *gorm.DB { return db.Order("\"order\" ASC") }).
+		Preload("Steps.Question").
+		Preload("Steps.Question.Choices", func(db *gorm.DB) *gorm.DB { return db.Order("\"order\" ASC, id ASC") }).
+		Where("slug = ?", slug).
+		First(&level).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrLevelNotFound, slug)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	isActive := level.IsActive
+	f := &File{
+		Version: FormatVersion,
+		Level: Level{
+			Slug:         level.Slug,
+			Title:        level.Title,
+			Topic:        level.Topic,
+			Difficulty:   level.Difficulty,
+			RewardPoints: level.RewardPoints,
+			IsActive:     &isActive,
+			Scoring:      level.ScoringPolicy,
+			Steps:        make([]Step, 0, len(level.Steps)),
+		},
+	}
+
+	for _, ls := range level.Steps {
+		step := Step{
+			Slug:  ls.Slug,
+			Type:  ls.Type,
+			Title: ls.Title,
+		}
+		if canonicalPayload(ls.Payload) != "" {
+			if err := json.Unmarshal(ls.Payload, &step.Payload); err != nil {
+				return nil, fmt.Errorf("step %s: payload is not a JSON object: %w", ls.Slug, err)
+			}
+		}
+		if ls.Question != nil {
+			question := &Question{
+				Slug:        ls.Question.Slug,
+				Prompt:      ls.Question.Prompt,
+				Explanation: ls.Question.Explanation,
+				MultiSelect: ls.Question.MultiSelect,
+				Template:    ls.Question.Template,
+				Grading:     string(ls.Question.GradingMode),
+				MinCorrect:  ls.Question.MinCorrect,
+			}
+			if kind := ls.Question.QuestionKind(); kind != domain.KindChoice {
+				question.Kind = string(kind)
+			}
+			if canonicalPayload(ls.Question.Spec) != "" {
+				if err := json.Unmarshal(ls.Question.Spec, &question.Spec); err != nil {
+					return nil, fmt.Errorf("step %s: question spec is not a JSON object: %w", ls.Slug, err)
+				}
+			}
+			for _, c := range ls.Question.Choices {
+				question.Choices = append(question.Choices, Choice{
+					Slug:    c.Slug,
+					Text:    c.Text,
+					Correct: c.IsCorrect,
+				})
+			}
+			step.Question = question
+		}
+		f.Level.Steps = append(f.Level.Steps, step)
+	}
+	return f, nil
+}
+
+// Publish - опубликовать текущее состояние рабочих таблиц уровня новой ревизией
+func (s *Syncer) Publish(ctx context.Context, slug, note string) (*domain.LevelRevision, error) {
+	var level domain.Level
+	err := s.db.WithContext(ctx).Where("slug = ?", slug).First(&level).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrLevelNotFound, slug)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return publishLevel(s.db.WithContext(ctx), level.ID, note)
+}
+
+// UnpublishedSlugs - slug неудаленных уровней, которые еще ни разу не публиковались
+func (s *Syncer) UnpublishedSlugs(ctx context.Context) ([]string, error) {
+	var slugs []string
+	err := s.db.WithContext(ctx).Model(&domain.Level{}).
+		Where("published_revision_id IS NULL").
+		Order("id ASC").
+		Pluck("slug", &slugs).Error
+	return slugs, err
+}
+
+// publishLevel - ревизия со снимком уровня из рабочих таблиц (как при публикации
+// из редактора, но без автора)
+func publishLevel(tx *gorm.DB, levelID uint, note string) (*domain.LevelRevision, error) {
+	levels := repo.NewLevelRepo(tx)
+	level, err := levels.GetWithSteps(tx.Statement.Context, levelID)
+	if err != nil {
+		return nil, err
+	}
+	if len(level.Steps) == 0 {
+		return nil, fmt.Errorf("level %s has no steps to publish", level.Slug)
+	}
+	level.PublishedRevisionID = nil
+	revision, err := domain.NewLevelRevision(level)
+	if err != nil {
+		return nil, err
+	}
+	revision.Note = note
+	if err := levels.PublishRevision(tx.Statement.Context, revision); err != nil {
+		return nil, err
+	}
+	return revision, nil
+}
+
+// LevelSlugs - slug всех неудаленных уровней
+func (s *Syncer) LevelSlugs(ctx context.Context) ([]string, error) {
+	var slugs []string
+	err := s.db.WithContext(ctx).Model(&domain.Level{}).
+		Order("id ASC").
+		Pluck("slug", &slugs).Error
+	return slugs, err
+}
+
+// sync - сравнение файла с БД; при apply изменения записываются через tx,
+// при publish измененный или неопубликованный уровень публикуется
+func (s *Syncer) sync(tx *gorm.DB, f *File, apply, publish bool) ([]Change, error) {
+	var changes []Change
+
+	level, err := findBySlug[domain.Level](tx.Where("slug = ?", f.Level.Slug))
+	if err != nil {
+		return nil, err
+	}
+
+	desired := map[string]interface{}{
+		"title":          f.Level.Title,
+		"topic":          f.Level.Topic,
+		"difficulty":     f.Level.Difficulty,
+		"reward_points":  f.Level.RewardPoints,
+		"is_active":      f.Level.Active(),
+		"scoring_policy": policyJSON(f.Level.Scoring),
+	}
+
+	var levelID uint
+	if level == nil {
+		changes = append(changes, Change{Op: OpCreate, Kind: "level", Slug: f.Level.Slug})
+		if apply {
+			created := domain.Level{
+				Slug:          f.Level.Slug,
+				Title:         f.Level.Title,
+				Topic:         f.Level.Topic,
+				Difficulty:    f.Level.Difficulty,
+				RewardPoints:  f.Level.RewardPoints,
+				IsActive:      f.Level.Active(),
+				ScoringPolicy: f.Level.Scoring,
+			}
+			if err := tx.Omit("Steps").Create(&created).Error; err != nil {
+				return nil, err
+			}
+			// Нулевое значение при вставке заменяется значением по умолчанию колонки (true)
+			if !created.IsActive {
+				if err := tx.Model(&created).Update("is_active", false).Error; err != nil {
+					return nil, err
+				}
+			}
+			levelID = created.ID
+		}
+	} else {
+		levelID = level.ID
+		current := map[string]interface{}{
+			"title":          level.Title,
+			"topic":          level.Topic,
+			"difficulty":     level.Difficulty,
+			"reward_points":  level.RewardPoints,
+			"is_active":      level.IsActive,
+			"scoring_policy": policyJSON(level.ScoringPolicy),
+		}
+		if err := s.update(tx, &changes, apply, &domain.Level{}, level.ID, level.DeletedAt.Valid, "level", f.Level.Slug, current, desired); err != nil {
+			return nil, err
+		}
+	}
+
+	stepChanges, err := s.syncSteps(tx, f, levelID, apply)
+	if err != nil {
+		return nil, err
+	}
+	changes = append(changes, stepChanges...)
+
+	if publish && (len(changes) > 0 || level == nil || level.PublishedRevisionID == nil) {
+		changes = append(changes, Change{Op: OpPublish, Kind: "level", Slug: f.Level.Slug})
+		if apply {
+			if _, err := publishLevel(tx, levelID, "content import"); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return changes, nil
+}
+
+func (s *Syncer) syncSteps(tx *gorm.DB, f *File, levelID uint, apply bool) ([]Change, error) {
+	var changes []Change
+
+	var existing []domain.LevelStep
+	if levelID != 0 {
+		if err := tx.Where("level_id = ?", levelID).Order("\"order\" ASC").Find(&existing).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	wanted := make(map[string]bool, len(f.Level.Steps))
+	for _, step := range f.Level.Steps {
+		wanted[step.Slug] = true
+	}
+	bySlug := make(map[string]*domain.LevelStep, len(existing))
+	for i := range existing {
+		step := &existing[i]
+		if wanted[step.Slug] {
+			bySlug[step.Slug] = step
+			continue
+		}
+		changes = append(changes, Change{Op: OpDelete, Kind: "step", Slug: f.Level.Slug + "/" + step.Slug})
+		if apply {
+			if err := tx.Delete(&domain.LevelStep{}, step.ID).Error; err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Порядок уникален среди живых шагов, поэтому оставшиеся шаги сначала
+	// переносятся на отрицательные позиции, затем получают итоговые
+	if apply && len(bySlug) > 0 {
+		err := tx.Model(&domain.LevelStep{}).
+			Where("level_id = ?", levelID).
+			UpdateColumn("order", gorm.Expr("-\"order\"")).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i, step := range f.Level.Steps {
+		path := f.Level.Slug + "/" + step.Slug
+
+		var questionID *uint
+		if step.Question != nil {
+			id, questionChanges, err := s.syncQuestion(tx, step.Question, apply)
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, questionChanges...)
+			questionID = &id
+		}
+
+		payload, err := payloadJSON(step.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("step %s: %w", path, err)
+		}
+
+		current, ok := bySlug[step.Slug]
+		if !ok {
+			changes = append(changes, Change{Op: OpCreate, Kind: "step", Slug: path})
+			if apply {
+				created := domain.LevelStep{
+					LevelID:    levelID,
+					Order:      i + 1,
+					Slug:       step.Slug,
+					Type:       step.Type,
+					Title:      step.Title,
+					Payload:    payload,
+					QuestionID: questionID,
+				}
+				if err := tx.Omit("Question").Create(&created).Error; err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		var fields []string
+		if current.Order != i+1 {
+			fields = append(fields, "order")
+		}
+		if current.Type != step.Type {
+			fields = append(fields, "type")
+		}
+		if current.Title != step.Title {
+			fields = append(fields, "title")
+		}
+		if canonicalPayload(current.Payload) != canonicalPayload(payload) {
+			fields = append(fields, "payload")
+		}
+		if !sameID(current.QuestionID, questionID) {
+			fields = append(fields, "question_id")
+		}
+		if len(fields) > 0 {
+			changes = append(changes, Change{Op: OpUpdate, Kind: "step", Slug: path, Fields: fields})
+		}
+
+		if apply {
+			// order записывается всегда: выше шаги были перенесены на отрицательные позиции
+			err := tx.Model(&domain.LevelStep{}).Where("id = ?", current.ID).Updates(map[string]interface{}{
+				"order":       i + 1,
+				"type":        step.Type,
+				"title":       step.Title,
+				"payload":     payload,
+				"question_id": questionID,
+			}).Error
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return changes, nil
+}
+
+// syncQuestion - создание или обновление вопроса с вариантами; возвращает id вопроса
+// (0 для еще не созданного вопроса без apply)
+func (s *Syncer) syncQuestion(tx *gorm.DB, q *Question, apply bool) (uint, []Change, error) {
+	var changes []Change
+
+	question, err := findBySlug[domain.Question](tx.Where("slug = ?", q.Slug))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	spec, err := payloadJSON(q.Spec)
+	if err != nil {
+		return 0, nil, err
+	}
+	kind := domain.QuestionKind(q.Kind)
+	if kind == "" {
+		kind = domain.KindChoice
+	}
+
+	var questionID uint
+	if question == nil {
+		changes = append(changes, Change{Op: OpCreate, Kind: "question", Slug: q.Slug})
+		if apply {
+			created := domain.Question{
+				Slug:        q.Slug,
+				Prompt:      q.Prompt,
+				Explanation: q.Explanation,
+				Kind:        kind,
+				Spec:        spec,
+				Template:    q.Template,
+				MultiSelect: q.MultiSelect,
+				GradingMode: domain.GradingMode(q.Grading),
+				MinCorrect:  q.MinCorrect,
+			}
+			if err := tx.Omit("Choices").Create(&created).Error; err != nil {
+				return 0, nil, err
+			}
+			questionID = created.ID
+		}
+	} else {
+		questionID = question.ID
+		current := map[string]interface{}{
+			"prompt":       question.Prompt,
+			"explanation":  question.Explanation,
+			"kind":         string(question.QuestionKind()),
+			"spec":         specJSON(question.Spec),
+			"template":     templateJSON(question.Template),
+			"multi_select": question.MultiSelect,
+			"grading_mode": string(question.GradingMode),
+			"min_correct":  question.MinCorrect,
+		}
+		desired := map[string]interface{}{
+			"prompt":       q.Prompt,
+			"explanation":  q.Explanation,
+			"kind":         string(kind),
+			"spec":         specJSON(spec),
+			"template":     templateJSON(q.Template),
+			"multi_select": q.MultiSelect,
+			"grading_mode": q.Grading,
+			"min_correct":  q.MinCorrect,
+		}
+		if err := s.update(tx, &changes, apply, &domain.Question{}, question.ID, question.DeletedAt.Valid, "question", q.Slug, current, desired); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	var existing []domain.Choice
+	if questionID != 0 {
+		if err := tx.Where("question_id = ?", questionID).Find(&existing).Error; err != nil {
+			return 0, nil, err
+		}
+	}
+
+	wanted := make(map[string]bool, len(q.Choices))
+	for _, choice := range q.Choices {
+		wanted[choice.Slug] = true
+	}
+	bySlug := make(map[string]*domain.Choice, len(existing))
+	for i := range existing {
+		choice := &existing[i]
+		if wanted[choice.Slug] {
+			bySlug[choice.Slug] = choice
+			continue
+		}
+		changes = append(changes, Change{Op: OpDelete, Kind: "choice", Slug: q.Slug + "/" + choice.Slug})
+		if apply {
+			if err := tx.Delete(&domain.Choice{}, choice.ID).Error; err != nil {
+				return 0, nil, err
+			}
+		}
+	}
+
+	for i, choice := range q.Choices {
+		path := q.Slug + "/" + choice.Slug
+		current, ok := bySlug[choice.Slug]
+		if !ok {
+			changes = append(changes, Change{Op: OpCreate, Kind: "choice", Slug: path})
+			if apply {
+				created := domain.Choice{
+					QuestionID: questionID,
+					Slug:       choice.Slug,
+					Text:       choice.Text,
+					IsCorrect:  choice.Correct,
+					Order:      i + 1,
+				}
+				if err := tx.Create(&created).Error; err != nil {
+					return 0, nil, err
+				}
+			}
+			continue
+		}
+
+		err := s.update(tx, &changes, apply, &domain.Choice{}, current.ID, false, "choice", path,
+			map[string]interface{}{"text": current.Text, "is_correct": current.IsCorrect, "order": current.Order},
+			map[string]interface{}{"text": choice.Text, "is_correct": choice.Correct, "order": i + 1},
+		)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+
+	return questionID, changes, nil
+}
+
+// update - запись отличающихся колонок и восстановление мягко удаленной записи
+func (s *Syncer) update(tx *gorm.DB, changes *[]Change, apply bool, model interface{}, id uint, deleted bool, kind, slug string, current, desired map[string]interface{}) error {
+	if deleted {
+		*changes = append(*changes, Change{Op: OpRestore, Kind: kind, Slug: slug})
+		if apply {
+			if err := tx.Unscoped().Model(model).Where("id = ?", id).Update("deleted_at", nil).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	values := make(map[string]interface{})
+	var fields []string
+	for column, value := range desired {
+		if current[column] != value {
+			values[column] = value
+			fields = append(fields, column)
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	sort.Strings(fields)
+
+	*changes = append(*changes, Change{Op: OpUpdate, Kind: kind, Slug: slug, Fields: fields})
+	if apply {
+		return tx.Model(model).Where("id = ?", id).Updates(values).Error
+	}
+	return nil
+}
+
+// findBySlug - живая запись по условию, иначе последняя мягко удаленная (для восстановления)
+func findBySlug[T any](query *gorm.DB) (*T, error) {
+	var records []T
+	err := query.Session(&gorm.Session{}).Unscoped().
+		Order("deleted_at IS NOT NULL, id DESC").
+		Limit(1).
+		Find(&records).Error
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+	return &records[0], nil
+}
+
+// policyJSON - политика подсчета как значение колонки jsonb (nil — политика по умолчанию);
+// строка удобна и для сравнения в update
+func policyJSON(policy *domain.ScoringPolicy) interface{} {
+	if policy == nil {
+		return nil
+	}
+	data, _ := json.Marshal(policy)
+	return string(data)
+}
+
+func templateJSON(template *domain.QuestionTemplate) interface{} {
+	if template == nil {
+		return nil
+	}
+	data, _ := json.Marshal(template)
+	return string(data)
+}
+
+// specJSON - Spec вопроса для сравнения и записи: канонический JSON или nil
+func specJSON(spec datatypes.JSON) interface{} {
+	if canonical := canonicalPayload(spec); canonical != "" {
+		return canonical
+	}
+	return nil
+}
+
+func payloadJSON(payload map[string]interface{}) (datatypes.JSON, error) {
+	if len(payload) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return datatypes.JSON(data), nil
+}
+
+// canonicalPayload - JSON с отсортированными ключами; null и {} считаются пустыми
+func canonicalPayload(raw []byte) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return string(raw)
+	}
+	if m, ok := value.(map[string]interface{}); value == nil || ok && len(m) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+func sameID(a, b *uint) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
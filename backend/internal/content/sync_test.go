@@ -0,0 +1,217 @@
+package content_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/ImCtyz/duofinance/backend/internal/content"
+	"github.com/ImCtyz/duofinance/backend/internal/domain"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openDB - одноразовая база SQLite со схемой контента (AutoMigrate по моделям,
+// как в internal/e2e)
+func openDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := filepath.Join(t.TempDir(), "content.db") + "?_pragma=foreign_keys(1)"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("database handle: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	err = db.AutoMigrate(
+		&domain.User{}, &domain.Course{}, &domain.Unit{}, &domain.Level{},
+		&domain.LevelPrerequisite{}, &domain.LevelRevision{}, &domain.Question{},
+		&domain.Choice{}, &domain.LevelStep{},
+	)
+	if err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+func load(t *testing.T) *content.File {
+	t.Helper()
+	f, err := content.Load(filepath.Join("testdata", "budgeting.yaml"))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	return f
+}
+
+func importFile(t *testing.T, syncer *content.Syncer, f *content.File, publish bool) []content.Change {
+	t.Helper()
+	changes, err := syncer.Import(context.Background(), f, publish)
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	return changes
+}
+
+func ops(changes []content.Change) map[string]content.Op {
+	out := make(map[string]content.Op, len(changes))
+	for _, c := range changes {
+		if c.Op == content.OpPublish {
+			continue
+		}
+		out[c.Kind+" "+c.Slug] = c.Op
+	}
+	return out
+}
+
+func TestImportExportRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	db := openDB(t)
+	syncer := content.NewSyncer(db)
+
+	changes := importFile(t, syncer, load(t), true)
+	got := ops(changes)
+	for key, op := range map[string]content.Op{
+		"level budgeting-basics":             content.OpCreate,
+		"question budgeting-basics.needs":    content.OpCreate,
+		"question budgeting.surplus":         content.OpCreate,
+		"choice budgeting-basics.needs/rent": content.OpCreate,
+	} {
+		if got[key] != op {
+			t.Errorf("change %q = %q, want %q (changes: %v)", key, got[key], op, changes)
+		}
+	}
+	if got["level budgeting-basics"] != content.OpCreate || changes[len(changes)-1].Op != content.OpPublish {
+		t.Errorf("first import must create and publish the level: %v", changes)
+	}
+
+	// Повторный импорт того же файла ничего не меняет и не публикует новую ревизию
+	if changes := importFile(t, syncer, load(t), true); len(changes) != 0 {
+		t.Errorf("second import changes = %v, want none", changes)
+	}
+	var revisions int64
+	if err := db.Model(&domain.LevelRevision{}).Count(&revisions).Error; err != nil {
+		t.Fatal(err)
+	}
+	if revisions != 1 {
+		t.Errorf("revisions = %d, want 1", revisions)
+	}
+
+	// Экспорт, сериализованный в любом формате и прочитанный обратно, совпадает с БД
+	exported, err := syncer.Export(ctx, "budgeting-basics")
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	for _, format := range []content.Format{content.FormatYAML, content.FormatJSON} {
+		data, err := content.Encode(exported, format)
+		if err != nil {
+			t.Fatalf("encode %s: %v", format, err)
+		}
+		decoded, err := content.Decode(data, format)
+		if err != nil {
+			t.Fatalf("decode %s: %v\n%s", format, err, data)
+		}
+		changes, err := syncer.Diff(ctx, decoded, true)
+		if err != nil {
+			t.Fatalf("diff %s: %v", format, err)
+		}
+		if len(changes) != 0 {
+			t.Errorf("diff of exported %s = %v, want none\n%s", format, changes, data)
+		}
+	}
+
+	if _, err := syncer.Export(ctx, "missing"); !errors.Is(err, content.ErrLevelNotFound) {
+		t.Errorf("export of unknown level error = %v, want ErrLevelNotFound", err)
+	}
+}
+
+func TestImportUpsertsBySlug(t *testing.T) {
+	ctx := context.Background()
+	db := openDB(t)
+	syncer := content.NewSyncer(db)
+	importFile(t, syncer, load(t), false)
+
+	var level domain.Level
+	if err := db.Where("slug = ?", "budgeting-basics").First(&level).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// Правка заголовка и удаление шага обновляют существующие записи, а не создают новые
+	edited := load(t)
+	edited.Level.Title = "Budgeting 101"
+	edited.Level.Steps = edited.Level.Steps[:2]
+	diff, err := syncer.Diff(ctx, edited, false)
+	if err != nil {
+		t.Fatalf("diff: %v", err)
+	}
+	changes := importFile(t, syncer, edited, false)
+	if len(diff) != len(changes) {
+		t.Errorf("diff = %v, import = %v; want the same changes", diff, changes)
+	}
+	got := ops(changes)
+	if got["level budgeting-basics"] != content.OpUpdate || got["step budgeting-basics/surplus"] != content.OpDelete {
+		t.Errorf("changes = %v, want a level update and a step delete", changes)
+	}
+
+	var levels int64
+	if err := db.Model(&domain.Level{}).Where("slug = ?", "budgeting-basics").Count(&levels).Error; err != nil {
+		t.Fatal(err)
+	}
+	if levels != 1 {
+		t.Errorf("levels with slug = %d, want 1", levels)
+	}
+	var updated domain.Level
+	if err := db.First(&updated, level.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if updated.Title != "Budgeting 101" {
+		t.Errorf("title = %q, want %q", updated.Title, "Budgeting 101")
+	}
+
+	// Вернувшийся шаг создается заново, вопрос переиспользуется по slug
+	changes = importFile(t, syncer, load(t), false)
+	got = ops(changes)
+	if got["step budgeting-basics/surplus"] != content.OpCreate || got["question budgeting.surplus"] != "" {
+		t.Errorf("changes = %v, want only the surplus step created", changes)
+	}
+	if changes := importFile(t, syncer, load(t), false); len(changes) != 0 {
+		t.Errorf("repeated import changes = %v, want none", changes)
+	}
+	var steps int64
+	if err := db.Model(&domain.LevelStep{}).Where("level_id = ?", level.ID).Count(&steps).Error; err != nil {
+		t.Fatal(err)
+	}
+	if steps != 3 {
+		t.Errorf("live steps = %d, want 3", steps)
+	}
+	var questions int64
+	if err := db.Model(&domain.Question{}).Count(&questions).Error; err != nil {
+		t.Fatal(err)
+	}
+	if questions != 2 {
+		t.Errorf("questions = %d, want 2", questions)
+	}
+}
+
+func TestImportRejectsInvalidFile(t *testing.T) {
+	db := openDB(t)
+	syncer := content.NewSyncer(db)
+
+	f := load(t)
+	f.Level.Steps[1].Question.Choices[0].Correct = false
+	f.Level.Steps[1].Question.Choices[1].Correct = false
+	if _, err := syncer.Import(context.Background(), f, true); err == nil {
+		t.Fatal("import of a question without correct choices succeeded")
+	}
+	var levels int64
+	if err := db.Model(&domain.Level{}).Count(&levels).Error; err != nil {
+		t.Fatal(err)
+	}
+	if levels != 0 {
+		t.Errorf("levels = %d, want 0 after a rejected import", levels)
+	}
+}
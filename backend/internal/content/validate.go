@@ -0,0 +1,213 @@
+package content
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ImCtyz/duofinance/backend/internal/domain"
+	"github.com/ImCtyz/duofinance/backend/internal/simulation"
+)
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// Validate - проверка файла по тем же правилам, что и в редакторе контента.
+// Возвращает все найденные ошибки сразу (errors.Join).
+func Validate(f *File) error {
+	var errs []error
+	fail := func(path, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", path, fmt.Sprintf(format, args...)))
+	}
+	simulations := simulation.NewEngine()
+
+	if f.Version != FormatVersion {
+		fail("version", "unsupported version %d (want %d)", f.Version, FormatVersion)
+	}
+
+	level := f.Level
+	checkSlug(fail, "level.slug", level.Slug)
+	if strings.TrimSpace(level.Title) == "" {
+		fail("level.title", "is required")
+	}
+	switch level.Difficulty {
+	case "", domain.DifficultyEasy, domain.DifficultyMedium, domain.DifficultyHard:
+	default:
+		fail("level.difficulty", "must be one of easy, medium, hard")
+	}
+	if level.RewardPoints < 0 {
+		fail("level.reward_points", "must not be negative")
+	}
+	if level.Scoring != nil {
+		if err := level.Scoring.Validate(); err != nil {
+			fail("level.scoring", "%v", err)
+		}
+	}
+	if len(level.Steps) == 0 {
+		fail("level.steps", "at least one step is required")
+	}
+
+	steps := make(map[string]bool, len(level.Steps))
+	questions := make(map[string]bool)
+	for i, step := range level.Steps {
+		path := fmt.Sprintf("level.steps[%d]", i)
+		checkSlug(fail, path+".slug", step.Slug)
+		if steps[step.Slug] {
+			fail(path+".slug", "duplicate step slug %q", step.Slug)
+		}
+		steps[step.Slug] = true
+
+		switch step.Type {
+		case domain.StepTypeQuestion:
+			if step.Question == nil {
+				fail(path+".question", "is required for question steps")
+				continue
+			}
+			if questions[step.Question.Slug] {
+				fail(path+".question.slug", "duplicate question slug %q", step.Question.Slug)
+			}
+			questions[step.Question.Slug] = true
+			validateQuestion(fail, path+".question", step.Question)
+		case domain.StepTypeText, domain.StepTypeSimulation:
+			if step.Question != nil {
+				fail(path+".question", "is only allowed for question steps")
+			}
+			if step.Type == domain.StepTypeSimulation {
+				payload, err := payloadJSON(step.Payload)
+				if err == nil {
+					_, err = simulations.ParseSpec(payload)
+				}
+				if err != nil {
+					fail(path+".payload", "%v", err)
+				}
+			}
+		default:
+			fail(path+".type", "must be one of question, text, simulation")
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func validateQuestion(fail func(path, format string, args ...interface{}), path string, question *Question) {
+	checkSlug(fail, path+".slug", question.Slug)
+	if strings.TrimSpace(question.Prompt) == "" {
+		fail(path+".prompt", "is required")
+	}
+	if question.Template != nil {
+		validateQuestionTemplate(fail, path, question)
+		return
+	}
+	if kind := domain.QuestionKind(question.Kind); kind != "" && kind != domain.KindChoice {
+		validateQuestionSpec(fail, path, question)
+		return
+	}
+	if len(question.Spec) > 0 {
+		fail(path+".spec", "is not allowed for choice questions")
+	}
+	if len(question.Choices) < 2 {
+		fail(path+".choices", "at least two choices are required")
+	}
+
+	correct := 0
+	slugs := make(map[string]bool, len(question.Choices))
+	for i, choice := range question.Choices {
+		choicePath := fmt.Sprintf("%s.choices[%d]", path, i)
+		checkSlug(fail, choicePath+".slug", choice.Slug)
+		if slugs[choice.Slug] {
+			fail(choicePath+".slug", "duplicate choice slug %q", choice.Slug)
+		}
+		slugs[choice.Slug] = true
+		if strings.TrimSpace(choice.Text) == "" {
+			fail(choicePath+".text", "is required")
+		}
+		if choice.Correct {
+			correct++
+		}
+	}
+
+	if len(question.Choices) > 0 && correct == 0 {
+		fail(path+".choices", "at least one choice must be correct")
+	}
+	if !question.MultiSelect && correct > 1 {
+		fail(path+".choices", "single-select question must have exactly one correct choice")
+	}
+
+	switch mode := domain.GradingMode(question.Grading); mode {
+	case domain.GradingDefault, domain.GradingExact:
+	case domain.GradingPartial, domain.GradingAtLeast:
+		if !question.MultiSelect {
+			fail(path+".grading", "partial and at_least grading require multi_select")
+		}
+		if mode == domain.GradingAtLeast && (question.MinCorrect < 1 || question.MinCorrect > correct) {
+			fail(path+".min_correct", "must be between 1 and the number of correct choices")
+		}
+	default:
+		fail(path+".grading", "must be one of exact, partial, at_least")
+	}
+	if question.MinCorrect != 0 && question.Grading != string(domain.GradingAtLeast) {
+		fail(path+".min_correct", "is only allowed with at_least grading")
+	}
+}
+
+// validateQuestionSpec - проверка вопроса без вариантов по правилам domain.Question.ValidateSpec
+func validateQuestionSpec(fail func(path, format string, args ...interface{}), path string, question *Question) {
+	spec, err := payloadJSON(question.Spec)
+	if err != nil {
+		fail(path+".spec", "%v", err)
+		return
+	}
+	q := domain.Question{
+		Kind:        domain.QuestionKind(question.Kind),
+		Spec:        spec,
+		MultiSelect: question.MultiSelect,
+		GradingMode: domain.GradingMode(question.Grading),
+	}
+	if len(question.Choices) > 0 {
+		fail(path+".choices", "are not allowed for %s questions", question.Kind)
+	}
+	if question.MinCorrect != 0 {
+		fail(path+".min_correct", "is only allowed with at_least grading")
+	}
+	if err := q.ValidateSpec(); err != nil {
+		fail(path+".spec", "%v", err)
+	}
+}
+
+// validateQuestionTemplate - проверка шаблонного вопроса по правилам domain.Question.ValidateTemplate
+func validateQuestionTemplate(fail func(path, format string, args ...interface{}), path string, question *Question) {
+	spec, err := payloadJSON(question.Spec)
+	if err != nil {
+		fail(path+".spec", "%v", err)
+		return
+	}
+	if len(question.Choices) > 0 {
+		fail(path+".choices", "are generated for template questions")
+	}
+	if question.MinCorrect != 0 {
+		fail(path+".min_correct", "is only allowed with at_least grading")
+	}
+	q := domain.Question{
+		Prompt:      question.Prompt,
+		Explanation: question.Explanation,
+		Kind:        domain.QuestionKind(question.Kind),
+		Spec:        spec,
+		Template:    question.Template,
+		MultiSelect: question.MultiSelect,
+		GradingMode: domain.GradingMode(question.Grading),
+	}
+	if err := q.ValidateTemplate(); err != nil {
+		fail(path+".template", "%v", err)
+	}
+}
+
+func checkSlug(fail func(path, format string, args ...interface{}), path, slug string) {
+	switch {
+	case slug == "":
+		fail(path, "is required")
+	case len(slug) > 255:
+		fail(path, "must be at most 255 characters")
+	case !slugPattern.MatchString(slug):
+		fail(path, "must contain only lowercase letters, digits, '.', '-' and '_'")
+	}
+}
@@ -0,0 +1,297 @@
+package core_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ImCtyz/duofinance/backend/internal/core"
+	"github.com/ImCtyz/duofinance/backend/internal/domain"
+	"github.com/ImCtyz/duofinance/backend/internal/repo/memory"
+	"github.com/ImCtyz/duofinance/backend/internal/simulation"
+)
+
+type attemptFixture struct {
+	store     *memory.Store
+	attempts  core.AttemptService
+	content   core.ContentService
+	courses   core.CourseService
+	userID    uint
+	level     *domain.Level
+	questions []*domain.Question
+}
+
+func newAttemptFixture(t *testing.T) *attemptFixture {
+	t.Helper()
+	ctx := context.Background()
+	store := memory.NewStore()
+	users := memory.NewUserRepo(store)
+	levels := memory.NewLevelRepo(store)
+	questions := memory.NewQuestionRepo(store)
+	attempts := memory.NewAttemptRepo(store)
+	rewards := memory.NewRewardTxRepo(store)
+	courses := memory.NewCourseRepo(store)
+	tx := memory.NewTxManager(store)
+	simulations := simulation.NewEngine()
+
+	f := &attemptFixture{
+		store:   store,
+		content: core.NewContentService(levels, questions, simulations),
+		courses: core.NewCourseService(courses, levels, attempts, tx),
+		attempts: core.NewAttemptService(
+			attempts, levels, questions, rewards, courses, tx,
+			core.NewUserService(users, memory.NewSessionRepo(store), rewards, attempts),
+			core.NewAchievementService(memory.NewAchievementRepo(store), users),
+			simulations,
+		),
+	}
+	must(t, store.SeedAchievement(&domain.Achievement{Code: "first_steps", Name: "First steps"}))
+
+	user, err := newAuthService(store, &outbox{}, defaultProtection()).Register(ctx, "player@example.com", "player", "password123")
+	must(t, err)
+	f.userID = user.ID
+	f.level = f.publishLevel(t, "Budget basics", "What share of income should go to savings?", "Which habit helps to save?")
+	return f
+}
+
+// publishLevel - опубликованный уровень с вопросами prompts; верный вариант ответа идет первым
+func (f *attemptFixture) publishLevel(t *testing.T, title string, prompts ...string) *domain.Level {
+	t.Helper()
+	ctx := context.Background()
+	level := &domain.Level{Title: title, Topic: "budget", Difficulty: "easy", RewardPoints: 10, IsActive: true}
+	must(t, f.content.CreateLevel(ctx, level))
+	for _, prompt := range prompts {
+		question := &domain.Question{
+			Prompt: prompt,
+			Kind:   domain.KindChoice,
+			Choices: []domain.Choice{
+				{Text: "right", Order: 1, IsCorrect: true},
+				{Text: "wrong", Order: 2},
+			},
+		}
+		must(t, f.content.CreateQuestion(ctx, question))
+		must(t, f.content.CreateStep(ctx, &domain.LevelStep{LevelID: level.ID, Type: domain.StepTypeQuestion, QuestionID: &question.ID}))
+		f.questions = append(f.questions, question)
+	}
+	_, err := f.content.PublishLevel(ctx, level.ID, f.userID, "initial")
+	must(t, err)
+	return level
+}
+
+// answer - ответ на вопрос question: верный или неверный вариант
+func (f *attemptFixture) answer(t *testing.T, attemptID uint, question *domain.Question, correct bool) *core.AnswerResult {
+	t.Helper()
+	choice := question.Choices[0].ID
+	if !correct {
+		choice = question.Choices[1].ID
+	}
+	result, err := f.attempts.AnswerQuestion(context.Background(), f.userID, attemptID, question.ID, domain.Answer{ChoiceIDs: []uint{choice}})
+	must(t, err)
+	return result
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestStartAttempt(t *testing.T) {
+	ctx := context.Background()
+	f := newAttemptFixture(t)
+
+	attempt, err := f.attempts.StartAttempt(ctx, f.userID, f.level.ID)
+	must(t, err)
+	if attempt.Status != domain.AttemptInProgress || attempt.LevelRevisionID == nil {
+		t.Fatalf("started attempt = %+v", attempt)
+	}
+
+	// Повторный старт продолжает активную попытку
+	again, err := f.attempts.StartAttempt(ctx, f.userID, f.level.ID)
+	must(t, err)
+	if again.ID != attempt.ID {
+		t.Fatalf("second StartAttempt = %d, want active attempt %d", again.ID, attempt.ID)
+	}
+
+	if _, err := f.attempts.StartAttempt(ctx, f.userID, 999); !errors.Is(err, core.ErrLevelNotFound) {
+		t.Fatalf("StartAttempt on a missing level = %v, want ErrLevelNotFound", err)
+	}
+
+	// Черновик без публикации игрокам недоступен
+	draft := &domain.Level{Title: "Draft", IsActive: true}
+	must(t, f.content.CreateLevel(ctx, draft))
+	if _, err := f.attempts.StartAttempt(ctx, f.userID, draft.ID); !errors.Is(err, core.ErrLevelNotFound) {
+		t.Fatalf("StartAttempt on an unpublished level = %v", err)
+	}
+
+	archived := f.publishLevel(t, "Archived", "Is this level still open?")
+	archived.IsActive = false
+	must(t, f.content.UpdateLevel(ctx, archived))
+	if _, err := f.attempts.StartAttempt(ctx, f.userID, archived.ID); !errors.Is(err, core.ErrLevelInactive) {
+		t.Fatalf("StartAttempt on an inactive level = %v, want ErrLevelInactive", err)
+	}
+}
+
+func TestStartAttemptRequiresPrerequisites(t *testing.T) {
+	ctx := context.Background()
+	f := newAttemptFixture(t)
+	advanced := f.publishLevel(t, "Investing", "What is diversification?")
+	_, err := f.courses.SetPrerequisites(ctx, advanced.ID, []*domain.LevelPrerequisite{{RequiredLevelID: f.level.ID, MinScore: 70}})
+	must(t, err)
+
+	if _, err := f.attempts.StartAttempt(ctx, f.userID, advanced.ID); !errors.Is(err, core.ErrLevelLocked) {
+		t.Fatalf("StartAttempt before the prerequisite was passed = %v, want ErrLevelLocked", err)
+	}
+
+	attempt, err := f.attempts.StartAttempt(ctx, f.userID, f.level.ID)
+	must(t, err)
+	f.answer(t, attempt.ID, f.questions[0], true)
+	f.answer(t, attempt.ID, f.questions[1], true)
+	_, err = f.attempts.CompleteAttempt(ctx, f.userID, attempt.ID)
+	must(t, err)
+
+	if _, err := f.attempts.StartAttempt(ctx, f.userID, advanced.ID); err != nil {
+		t.Fatalf("StartAttempt after passing the prerequisite = %v", err)
+	}
+}
+
+func TestAnswerQuestion(t *testing.T) {
+	ctx := context.Background()
+	f := newAttemptFixture(t)
+	attempt, err := f.attempts.StartAttempt(ctx, f.userID, f.level.ID)
+	must(t, err)
+
+	next, err := f.attempts.GetNextQuestion(ctx, f.userID, attempt.ID)
+	must(t, err)
+	if next.ID != f.questions[0].ID {
+		t.Fatalf("GetNextQuestion = %d, want %d", next.ID, f.questions[0].ID)
+	}
+
+	if result := f.answer(t, attempt.ID, f.questions[0], false); result.Correct || result.Score != 0 {
+		t.Fatalf("wrong answer graded %+v", result)
+	}
+	if result := f.answer(t, attempt.ID, f.questions[1], true); !result.Correct || result.Score != 1 {
+		t.Fatalf("right answer graded %+v", result)
+	}
+
+	// Вопрос другого уровня и чужая попытка
+	outside := &domain.Question{Prompt: "Outside", Kind: domain.KindChoice, Choices: []domain.Choice{{Text: "x", IsCorrect: true}, {Text: "y"}}}
+	must(t, f.content.CreateQuestion(ctx, outside))
+	_, err = f.attempts.AnswerQuestion(ctx, f.userID, attempt.ID, outside.ID, domain.Answer{ChoiceIDs: []uint{outside.Choices[0].ID}})
+	var validation *core.ValidationError
+	if !errors.As(err, &validation) {
+		t.Fatalf("answer to a question outside the level = %v", err)
+	}
+	_, err = f.attempts.AnswerQuestion(ctx, f.userID+1, attempt.ID, f.questions[0].ID, domain.Answer{})
+	if !errors.Is(err, core.ErrAttemptForbidden) {
+		t.Fatalf("answer in another user's attempt = %v", err)
+	}
+	_, err = f.attempts.AnswerQuestion(ctx, f.userID, 999, f.questions[0].ID, domain.Answer{})
+	if !errors.Is(err, core.ErrAttemptNotFound) {
+		t.Fatalf("answer in a missing attempt = %v", err)
+	}
+}
+
+func TestCompleteAttempt(t *testing.T) {
+	ctx := context.Background()
+	f := newAttemptFixture(t)
+	attempt, err := f.attempts.StartAttempt(ctx, f.userID, f.level.ID)
+	must(t, err)
+	f.answer(t, attempt.ID, f.questions[0], true)
+	f.answer(t, attempt.ID, f.questions[1], true)
+
+	result, err := f.attempts.CompleteAttempt(ctx, f.userID, attempt.ID)
+	must(t, err)
+	if !result.Passed || result.Score < 100 || result.CorrectAnswers != 2 || result.TotalQuestions != 2 {
+		t.Fatalf("CompleteAttempt = %+v", result)
+	}
+	if result.Reward == nil || result.Reward.Diamonds <= 0 {
+		t.Fatalf("passed attempt without a reward: %+v", result.Reward)
+	}
+	awarded, err := memory.NewAchievementRepo(f.store).GetByUserID(ctx, f.userID)
+	must(t, err)
+	if len(awarded) != 1 || awarded[0].Code != "first_steps" {
+		t.Fatalf("awarded achievements = %+v", awarded)
+	}
+
+	balance, err := memory.NewRewardTxRepo(f.store).GetBalance(ctx, f.userID)
+	must(t, err)
+	if balance != result.Reward.Diamonds {
+		t.Fatalf("balance = %d, want %d", balance, result.Reward.Diamonds)
+	}
+	profile, err := memory.NewUserRepo(f.store).GetProfile(ctx, f.userID)
+	must(t, err)
+	if profile.Streak != 1 {
+		t.Fatalf("streak = %d, want 1", profile.Streak)
+	}
+
+	// Завершенную попытку нельзя ни завершить повторно, ни продолжить
+	if _, err := f.attempts.CompleteAttempt(ctx, f.userID, attempt.ID); !errors.Is(err, core.ErrAttemptCompleted) {
+		t.Fatalf("second CompleteAttempt = %v", err)
+	}
+	_, err = f.attempts.AnswerQuestion(ctx, f.userID, attempt.ID, f.questions[0].ID, domain.Answer{ChoiceIDs: []uint{f.questions[0].Choices[0].ID}})
+	if !errors.Is(err, core.ErrAttemptCompleted) {
+		t.Fatalf("answer after completion = %v", err)
+	}
+}
+
+func TestCompleteFailedAttempt(t *testing.T) {
+	ctx := context.Background()
+	f := newAttemptFixture(t)
+	attempt, err := f.attempts.StartAttempt(ctx, f.userID, f.level.ID)
+	must(t, err)
+	// Вклад вопроса падает до нуля только после четырех ошибок подряд
+	for i := 0; i < 4; i++ {
+		f.answer(t, attempt.ID, f.questions[0], false)
+		f.answer(t, attempt.ID, f.questions[1], false)
+	}
+
+	result, err := f.attempts.CompleteAttempt(ctx, f.userID, attempt.ID)
+	must(t, err)
+	if result.Passed || result.Score != 0 || result.CorrectAnswers != 0 || len(result.WrongQuestions) != 2 {
+		t.Fatalf("CompleteAttempt = %+v", result)
+	}
+	awarded, err := memory.NewAchievementRepo(f.store).GetByUserID(ctx, f.userID)
+	must(t, err)
+	if len(awarded) != 0 {
+		t.Fatalf("achievements for a failed attempt: %+v", awarded)
+	}
+}
+
+// Ход симуляции возможен только после предыдущих шагов уровня
+func TestAdvanceSimulationRequiresCurrentStep(t *testing.T) {
+	ctx := context.Background()
+	f := newAttemptFixture(t)
+	level := &domain.Level{Title: "Savings plan", IsActive: true}
+	must(t, f.content.CreateLevel(ctx, level))
+	question := &domain.Question{Prompt: "Ready?", Kind: domain.KindChoice, Choices: []domain.Choice{
+		{Text: "yes", Order: 1, IsCorrect: true},
+		{Text: "no", Order: 2},
+	}}
+	must(t, f.content.CreateQuestion(ctx, question))
+	must(t, f.content.CreateStep(ctx, &domain.LevelStep{LevelID: level.ID, Type: domain.StepTypeQuestion, QuestionID: &question.ID}))
+	simulationStep := &domain.LevelStep{LevelID: level.ID, Type: domain.StepTypeSimulation, Payload: []byte(`{"simulator":"compound_interest",
+		"config":{"principal":1000,"annual_rate":5,"years":1},"objectives":[{"metric":"balance","op":"gte","value":1000}]}`)}
+	must(t, f.content.CreateStep(ctx, simulationStep))
+	_, err := f.content.PublishLevel(ctx, level.ID, f.userID, "initial")
+	must(t, err)
+
+	attempt, err := f.attempts.StartAttempt(ctx, f.userID, level.ID)
+	must(t, err)
+	decision := []byte(`{"contribution":0}`)
+	if _, err := f.attempts.AdvanceSimulation(ctx, f.userID, attempt.ID, simulationStep.ID, decision); !errors.Is(err, core.ErrStepNotCurrent) {
+		t.Fatalf("simulation turn before the question = %v", err)
+	}
+
+	_, err = f.attempts.AnswerQuestion(ctx, f.userID, attempt.ID, question.ID, domain.Answer{ChoiceIDs: []uint{question.Choices[0].ID}})
+	must(t, err)
+	result, err := f.attempts.AdvanceSimulation(ctx, f.userID, attempt.ID, simulationStep.ID, decision)
+	must(t, err)
+	if result.Outcome == nil || !result.Outcome.Passed {
+		t.Fatalf("AdvanceSimulation = %+v", result)
+	}
+	if _, err := f.attempts.AdvanceSimulation(ctx, f.userID, attempt.ID, simulationStep.ID, decision); !errors.Is(err, simulation.ErrFinished) {
+		t.Fatalf("turn in a finished simulation = %v", err)
+	}
+}
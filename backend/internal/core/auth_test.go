@@ -0,0 +1,306 @@
+package core_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ImCtyz/duofinance/backend/internal/auth"
+	"github.com/ImCtyz/duofinance/backend/internal/core"
+	"github.com/ImCtyz/duofinance/backend/internal/mail"
+	"github.com/ImCtyz/duofinance/backend/internal/ratelimit"
+	"github.com/ImCtyz/duofinance/backend/internal/repo/memory"
+)
+
+// Сервисы core на репозиториях в памяти: проверяют бизнес-логику без Postgres
+
+// outbox - почта, отправленная сервисом
+type outbox struct {
+	mu       sync.Mutex
+	messages []mail.Message
+}
+
+func (o *outbox) Send(ctx context.Context, msg mail.Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.messages = append(o.messages, msg)
+	return nil
+}
+
+func (o *outbox) sent() []mail.Message {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]mail.Message(nil), o.messages...)
+}
+
+func newAuthService(store *memory.Store, mailer mail.Mailer, protection core.LoginProtection) core.AuthService {
+	jwtManager := auth.NewJWTManager(auth.NewHMACKeyRing("secret"), "refresh-secret", 15*time.Minute, 24*time.Hour)
+	return core.NewAuthService(
+		memory.NewUserRepo(store),
+		memory.NewSessionRepo(store),
+		memory.NewUserTokenRepo(store),
+		nil, nil, nil,
+		memory.NewTxManager(store),
+		jwtManager,
+		mailer,
+		"https://app.example.com",
+		protection,
+		nil,
+	)
+}
+
+func defaultProtection() core.LoginProtection {
+	return core.LoginProtection{
+		MaxAccountFailures: 3,
+		FailureWindow:      time.Hour,
+		LockoutBase:        time.Minute,
+		LockoutMax:         time.Hour,
+	}
+}
+
+func TestRegister(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	mailer := &outbox{}
+	svc := newAuthService(store, mailer, defaultProtection())
+
+	user, err := svc.Register(ctx, "alice@example.com", "alice", "password123")
+	if err != nil {
+		t.Fatalf("Register = %v", err)
+	}
+	if user.ID == 0 || user.PasswordHash == "password123" {
+		t.Fatalf("registered user = %+v", user)
+	}
+
+	// Вместе с пользователем создается профиль
+	if _, err := memory.NewUserRepo(store).GetProfile(ctx, user.ID); err != nil {
+		t.Fatalf("profile of the registered user: %v", err)
+	}
+
+	sent := mailer.sent()
+	if len(sent) != 1 || sent[0].To != "alice@example.com" || !strings.Contains(sent[0].Body, "/verify-email?token=") {
+		t.Fatalf("verification email = %+v", sent)
+	}
+
+	if _, err := svc.Register(ctx, "alice@example.com", "other", "password123"); err == nil {
+		t.Fatal("duplicate email accepted")
+	}
+	if _, err := svc.Register(ctx, "other@example.com", "alice", "password123"); err == nil {
+		t.Fatal("duplicate username accepted")
+	}
+}
+
+func TestLogin(t *testing.T) {
+	ctx := context.Background()
+	svc := newAuthService(memory.NewStore(), &outbox{}, defaultProtection())
+	if _, err := svc.Register(ctx, "alice@example.com", "alice", "password123"); err != nil {
+		t.Fatalf("Register = %v", err)
+	}
+	client := core.ClientInfo{UserAgent: "test", IP: "10.0.0.1"}
+
+	result, err := svc.Login(ctx, "alice@example.com", "password123", client)
+	if err != nil {
+		t.Fatalf("Login = %v", err)
+	}
+	if result.AccessToken == "" || result.RefreshToken == "" || result.MFARequired {
+		t.Fatalf("Login result = %+v", result)
+	}
+	claims, err := svc.ValidateToken(ctx, result.AccessToken)
+	if err != nil || claims.UserID != result.User.ID {
+		t.Fatalf("ValidateToken = %+v, %v", claims, err)
+	}
+	sessions, err := svc.GetSessions(ctx, result.User.ID)
+	if err != nil || len(sessions) != 1 {
+		t.Fatalf("GetSessions = %d sessions, %v", len(sessions), err)
+	}
+
+	if _, err := svc.Login(ctx, "nobody@example.com", "password123", client); !errors.Is(err, core.ErrInvalidCredentials) {
+		t.Fatalf("Login with unknown email = %v", err)
+	}
+
+	// Неудача номер MaxAccountFailures блокирует аккаунт даже для верного пароля
+	for i := 0; i < 2; i++ {
+		if _, err := svc.Login(ctx, "alice@example.com", "wrong", client); !errors.Is(err, core.ErrInvalidCredentials) {
+			t.Fatalf("failure %d: Login = %v", i+1, err)
+		}
+	}
+	if _, err := svc.Login(ctx, "alice@example.com", "wrong", client); !errors.Is(err, core.ErrAccountLocked) {
+		t.Fatalf("failure 3: Login = %v", err)
+	}
+	_, err = svc.Login(ctx, "alice@example.com", "password123", client)
+	var locked *core.LockedError
+	if !errors.As(err, &locked) || !errors.Is(err, core.ErrAccountLocked) {
+		t.Fatalf("Login of a locked account = %v", err)
+	}
+}
+
+func TestLoginBlocksIP(t *testing.T) {
+	ctx := context.Background()
+	protection := defaultProtection()
+	protection.IPFailures = ratelimit.NewFailureTracker(2, time.Minute, time.Hour, time.Hour)
+	svc := newAuthService(memory.NewStore(), &outbox{}, protection)
+	if _, err := svc.Register(ctx, "alice@example.com", "alice", "password123"); err != nil {
+		t.Fatalf("Register = %v", err)
+	}
+
+	attacker := core.ClientInfo{IP: "10.0.0.66"}
+	for i := 0; i < 2; i++ {
+		if _, err := svc.Login(ctx, "nobody@example.com", "guess", attacker); !errors.Is(err, core.ErrInvalidCredentials) {
+			t.Fatalf("failure %d: Login = %v", i+1, err)
+		}
+	}
+	if _, err := svc.Login(ctx, "alice@example.com", "password123", attacker); !errors.Is(err, core.ErrTooManyAttempts) {
+		t.Fatalf("Login from a blocked IP = %v", err)
+	}
+	if _, err := svc.Login(ctx, "alice@example.com", "password123", core.ClientInfo{IP: "10.0.0.1"}); err != nil {
+		t.Fatalf("Login from another IP = %v", err)
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	ctx := context.Background()
+	svc := newAuthService(memory.NewStore(), &outbox{}, defaultProtection())
+	if _, err := svc.Register(ctx, "alice@example.com", "alice", "password123"); err != nil {
+		t.Fatalf("Register = %v", err)
+	}
+	client := core.ClientInfo{UserAgent: "test", IP: "10.0.0.1"}
+	login, err := svc.Login(ctx, "alice@example.com", "password123", client)
+	if err != nil {
+		t.Fatalf("Login = %v", err)
+	}
+
+	access, refresh, err := svc.RefreshToken(ctx, login.RefreshToken, client)
+	if err != nil {
+		t.Fatalf("RefreshToken = %v", err)
+	}
+	if access == "" || refresh == "" || refresh == login.RefreshToken {
+		t.Fatal("RefreshToken did not rotate the token pair")
+	}
+	if _, err := svc.ValidateToken(ctx, access); err != nil {
+		t.Fatalf("ValidateToken of the refreshed token = %v", err)
+	}
+
+	// Повторное использование ротированного токена отзывает всю цепочку
+	if _, _, err := svc.RefreshToken(ctx, login.RefreshToken, client); !errors.Is(err, core.ErrRefreshTokenReused) {
+		t.Fatalf("reused RefreshToken = %v", err)
+	}
+	if _, _, err := svc.RefreshToken(ctx, refresh, client); !errors.Is(err, core.ErrSessionRevoked) {
+		t.Fatalf("RefreshToken after reuse = %v", err)
+	}
+	if _, err := svc.ValidateToken(ctx, access); err == nil {
+		t.Fatal("access token of a revoked session still valid")
+	}
+
+	if _, _, err := svc.RefreshToken(ctx, "garbage", client); err == nil {
+		t.Fatal("malformed refresh token accepted")
+	}
+}
+
+// Адрес, заблокированный за неудачные входы, не может подбирать коды второго фактора
+func TestVerifyMFABlocksIP(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	protection := defaultProtection()
+	protection.IPFailures = ratelimit.NewFailureTracker(2, time.Minute, time.Hour, time.Hour)
+	svc := newAuthService(store, &outbox{}, protection)
+	user, err := svc.Register(ctx, "alice@example.com", "alice", "password123")
+	if err != nil {
+		t.Fatalf("Register = %v", err)
+	}
+	now := time.Now()
+	user.TOTPSecret = "JBSWY3DPEHPK3PXP"
+	user.TOTPEnabledAt = &now
+	if err := memory.NewUserRepo(store).Update(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	attacker := core.ClientInfo{IP: "10.0.0.66"}
+	login, err := svc.Login(ctx, "alice@example.com", "password123", attacker)
+	if err != nil || !login.MFARequired {
+		t.Fatalf("Login = %+v, %v", login, err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := svc.Login(ctx, "nobody@example.com", "guess", attacker); !errors.Is(err, core.ErrInvalidCredentials) {
+			t.Fatalf("failure %d: Login = %v", i+1, err)
+		}
+	}
+
+	_, err = svc.VerifyMFA(ctx, login.MFAToken, "000000", attacker)
+	var locked *core.LockedError
+	if !errors.As(err, &locked) || !errors.Is(err, core.ErrTooManyAttempts) {
+		t.Fatalf("VerifyMFA from a blocked IP = %v", err)
+	}
+}
+
+// Каждая неудача после истечения блокировки удваивает ее, но не дольше LockoutMax
+func TestLoginLockoutDoubles(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	svc := newAuthService(store, &outbox{}, defaultProtection())
+	user, err := svc.Register(ctx, "alice@example.com", "alice", "password123")
+	if err != nil {
+		t.Fatalf("Register = %v", err)
+	}
+	users := memory.NewUserRepo(store)
+	client := core.ClientInfo{IP: "10.0.0.1"}
+
+	for i := 0; i < 2; i++ {
+		if _, err := svc.Login(ctx, "alice@example.com", "wrong", client); !errors.Is(err, core.ErrInvalidCredentials) {
+			t.Fatalf("failure %d: Login = %v", i+1, err)
+		}
+	}
+	// LockoutBase - минута, LockoutMax - час: 1, 2, 4, ..., 32 минуты и дальше час
+	for _, want := range []time.Duration{1, 2, 4, 8, 16, 32, 60, 60} {
+		start := time.Now()
+		_, err := svc.Login(ctx, "alice@example.com", "wrong", client)
+		var locked *core.LockedError
+		if !errors.As(err, &locked) || !errors.Is(err, core.ErrAccountLocked) {
+			t.Fatalf("Login = %v, want lockout of %v min", err, want)
+		}
+		if got := locked.Until.Sub(start); got < want*time.Minute || got > want*time.Minute+time.Second {
+			t.Fatalf("lockout lasts %v, want %v min", got, want)
+		}
+
+		// Блокировка истекла, а счетчик неудач остался
+		current, err := users.GetByID(ctx, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		past := time.Now().Add(-time.Second)
+		current.LockedUntil = &past
+		if err := users.Update(ctx, current); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Успешный вход сбрасывает счетчик
+	if _, err := svc.Login(ctx, "alice@example.com", "password123", client); err != nil {
+		t.Fatalf("Login after the lockout = %v", err)
+	}
+	if _, err := svc.Login(ctx, "alice@example.com", "wrong", client); !errors.Is(err, core.ErrInvalidCredentials) {
+		t.Fatalf("first failure after a successful login = %v", err)
+	}
+}
+
+func TestValidateTokenRequiresSession(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	svc := newAuthService(store, &outbox{}, defaultProtection())
+	user, err := svc.Register(ctx, "alice@example.com", "alice", "password123")
+	if err != nil {
+		t.Fatalf("Register = %v", err)
+	}
+
+	// Токен с верной подписью, но без sid: его нельзя отозвать вместе с сессией
+	jwtManager := auth.NewJWTManager(auth.NewHMACKeyRing("secret"), "refresh-secret", 15*time.Minute, 24*time.Hour)
+	pair, err := jwtManager.GenerateTokens(user.ID, user.Email, user.Username, string(user.Role), "")
+	if err != nil {
+		t.Fatalf("GenerateTokens = %v", err)
+	}
+	if _, err := svc.ValidateToken(ctx, pair.AccessToken); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Fatalf("ValidateToken without sid = %v, want ErrInvalidToken", err)
+	}
+}
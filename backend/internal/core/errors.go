@@ -0,0 +1,78 @@
+package core
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrSessionRevoked     = errors.New("session has been revoked")
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+	ErrSessionNotFound    = errors.New("session not found")
+	ErrInvalidUserToken   = errors.New("invalid or expired token")
+	ErrEmailVerified      = errors.New("email is already verified")
+	ErrAccountLocked      = errors.New("account is temporarily locked")
+	ErrTooManyAttempts    = errors.New("too many failed login attempts")
+	ErrInvalidMFACode     = errors.New("invalid two-factor code")
+	ErrMFAAlreadyEnabled  = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnabled      = errors.New("two-factor authentication is not enabled")
+	ErrUnknownProvider    = errors.New("unknown identity provider")
+	ErrInvalidOIDCState   = errors.New("invalid or expired oidc state")
+	ErrOIDCFailed         = errors.New("oidc authentication failed")
+	ErrOIDCEmailRequired  = errors.New("identity provider did not return an email")
+	ErrAccountExists      = errors.New("account with this email already exists")
+	ErrIdentityLinked     = errors.New("identity is already linked")
+	ErrIdentityNotFound   = errors.New("identity not found")
+	ErrLastLoginMethod    = errors.New("cannot remove the last login method")
+	ErrInvalidRole        = errors.New("invalid role")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrOwnRole            = errors.New("cannot change own role")
+	ErrLevelNotFound      = errors.New("level not found")
+	ErrLevelInactive      = errors.New("level is not active")
+	ErrLevelLocked        = errors.New("previous level not completed")
+	ErrStepNotFound       = errors.New("level step not found")
+	ErrQuestionNotFound   = errors.New("question not found")
+	ErrChoiceNotFound     = errors.New("choice not found")
+	ErrStepOrderTaken     = errors.New("step order is already taken")
+	ErrQuestionInUse      = errors.New("question is used by level steps")
+	ErrRevisionNotFound   = errors.New("level revision not found")
+	ErrCourseNotFound     = errors.New("course not found")
+	ErrUnitNotFound       = errors.New("unit not found")
+	ErrPrerequisiteCycle  = errors.New("prerequisites would form a cycle")
+	ErrStepNotCurrent     = errors.New("step is not the current step of the attempt")
+	ErrAttemptNotFound    = errors.New("attempt not found")
+	ErrAttemptForbidden   = errors.New("attempt belongs to another user")
+	ErrAttemptCompleted   = errors.New("attempt is already completed")
+	ErrNoMoreQuestions    = errors.New("no more questions")
+	ErrIdempotencyInUse   = errors.New("request with this idempotency key is still in progress")
+	ErrIdempotencyReused  = errors.New("idempotency key was used with a different request")
+)
+
+// LockedError - вход временно запрещен (ErrAccountLocked или ErrTooManyAttempts)
+type LockedError struct {
+	Reason error
+	Until  time.Time
+}
+
+func (e *LockedError) Error() string {
+	return e.Reason.Error()
+}
+
+func (e *LockedError) Unwrap() error {
+	return e.Reason
+}
+
+// ValidationError - недопустимые данные от клиента
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+func invalid(field, message string) error {
+	return &ValidationError{Field: field, Message: message}
+}
@@ -2,13 +2,28 @@ package core
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/ImCtyz/duofinance/backend/internal/auth"
 	"github.com/ImCtyz/duofinance/backend/internal/domain"
+	"github.com/ImCtyz/duofinance/backend/internal/mail"
+	"github.com/ImCtyz/duofinance/backend/internal/oidc"
+	"github.com/ImCtyz/duofinance/backend/internal/ratelimit"
 	"github.com/ImCtyz/duofinance/backend/internal/repo"
+	"github.com/ImCtyz/duofinance/backend/internal/simulation"
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/datatypes"
 	"gorm.io/gorm"
@@ -16,13 +31,58 @@ import (
 
 // Заглушки для сервисов - нужно будет реализовать
 
+// Время жизни токенов из писем
+const (
+	passwordResetTTL = time.Hour
+	emailVerifyTTL   = 48 * time.Hour
+)
+
+// Время на вход через OIDC провайдера
+const oidcStateTTL = 10 * time.Minute
+
+// Время на отправку письма в фоне
+const mailSendTimeout = 30 * time.Second
+
+// Параметры TOTP
+const (
+	totpIssuer        = "DuoFinance"
+	recoveryCodeCount = 10
+)
+
 type authService struct {
-	userRepo   repo.UserRepo
-	jwtManager *auth.JWTManager
+	userRepo      repo.UserRepo
+	sessionRepo   repo.SessionRepo
+	userTokenRepo repo.UserTokenRepo
+	recoveryRepo  repo.RecoveryCodeRepo
+	identityRepo  repo.IdentityRepo
+	oidcStateRepo repo.OIDCStateRepo
+	txManager     repo.TxManager
+	jwtManager    *auth.JWTManager
+	mailer        mail.Mailer
+	appBaseURL    string
+	protection    LoginProtection
+	oidcProviders map[string]*oidc.Provider
 }
 
-func NewAuthService(userRepo repo.UserRepo, jwtManager *auth.JWTManager) AuthService {
-	return &authService{userRepo: userRepo, jwtManager: jwtManager}
+func NewAuthService(userRepo repo.UserRepo, sessionRepo repo.SessionRepo, userTokenRepo repo.UserTokenRepo, recoveryRepo repo.RecoveryCodeRepo, identityRepo repo.IdentityRepo, oidcStateRepo repo.OIDCStateRepo, txManager repo.TxManager, jwtManager *auth.JWTManager, mailer mail.Mailer, appBaseURL string, protection LoginProtection, oidcProviders []*oidc.Provider) AuthService {
+	providers := make(map[string]*oidc.Provider, len(oidcProviders))
+	for _, p := range oidcProviders {
+		providers[p.Name()] = p
+	}
+	return &authService{
+		userRepo:      userRepo,
+		sessionRepo:   sessionRepo,
+		userTokenRepo: userTokenRepo,
+		recoveryRepo:  recoveryRepo,
+		identityRepo:  identityRepo,
+		oidcStateRepo: oidcStateRepo,
+		txManager:     txManager,
+		jwtManager:    jwtManager,
+		mailer:        mailer,
+		appBaseURL:    strings.TrimRight(appBaseURL, "/"),
+		protection:    protection,
+		oidcProviders: providers,
+	}
 }
 
 func (s *authService) Register(ctx context.Context, email, username, password string) (*domain.User, error) {
@@ -55,357 +115,2005 @@ func (s *authService) Register(ctx context.Context, email, username, password st
 		Email:        email,
 		Username:     username,
 		PasswordHash: string(hashedPassword),
+		Role:         domain.RoleLearner,
 	}
 
-	// Сохраняем в БД
-	err = s.userRepo.Create(ctx, user)
+	// Пользователь и профиль сохраняются вместе: без профиля аккаунт не создается
+	err = s.createUser(ctx, user)
 	if err != nil {
 		return nil, err
 	}
 
-	// Создаем профиль пользователя
-	profile := &domain.Profile{
-		UserID: user.ID,
-		Streak: 0,
-		Stats:  nil, // Пустая статистика
-		Meta:   nil, // Пустые метаданные
-	}
-
-	err = s.userRepo.UpdateProfile(ctx, profile)
-	if err != nil {
-		// Если профиль не создался, это не критично для регистрации
-		// Просто логируем ошибку
+	// Письмо для подтверждения email; пользователь может запросить его повторно
+	if err := s.SendEmailVerification(ctx, user.ID); err != nil {
+		log.Printf("failed to send verification email to user %d: %v", user.ID, err)
 	}
 
 	return user, nil
 }
 
-func (s *authService) Login(ctx context.Context, email, password string) (accessToken, refreshToken string, user *domain.User, err error) {
+func (s *authService) Login(ctx context.Context, email, password string, client ClientInfo) (*LoginResult, error) {
+	// Слишком много неудач с этого адреса — не тратим время на bcrypt
+	if err := s.checkIPBlocked(client.IP); err != nil {
+		return nil, err
+	}
+
 	// Получаем пользователя по email
-	user, err = s.userRepo.GetByEmail(ctx, email)
+	user, err := s.userRepo.GetByEmail(ctx, email)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return "", "", nil, errors.New("invalid email or password")
+			s.recordIPFailure(client.IP)
+			return nil, ErrInvalidCredentials
 		}
-		return "", "", nil, err
+		return nil, err
+	}
+
+	if err := checkLocked(user); err != nil {
+		return nil, err
 	}
 
 	// Проверяем пароль
 	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
 	if err != nil {
-		return "", "", nil, errors.New("invalid email or password")
+		s.recordIPFailure(client.IP)
+		if err := s.recordAccountFailure(ctx, user.ID); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidCredentials
+	}
+
+	// Счетчик неудач не сбрасывается до проверки второго фактора,
+	// иначе верный пароль открывал бы неограниченный перебор кодов
+	if user.TOTPEnabledAt != nil {
+		mfaToken, err := s.jwtManager.GenerateMFAToken(user.ID, user.Email, user.Username)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{User: user, MFARequired: true, MFAToken: mfaToken}, nil
+	}
+
+	return s.completeLogin(ctx, user, client)
+}
+
+func (s *authService) VerifyMFA(ctx context.Context, mfaToken, code string, client ClientInfo) (*LoginResult, error) {
+	claims, err := s.jwtManager.ValidateMFAToken(mfaToken)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.GetByID(ctx, claims.UserID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, auth.ErrInvalidToken
+		}
+		return nil, err
+	}
+	// 2FA отключили после выдачи токена
+	if user.TOTPEnabledAt == nil {
+		return nil, auth.ErrInvalidToken
+	}
+
+	if err := checkLocked(user); err != nil {
+		return nil, err
+	}
+
+	// Адрес, заблокированный за перебор, не может подбирать и коды второго фактора
+	if err := s.checkIPBlocked(client.IP); err != nil {
+		return nil, err
+	}
+
+	if err := s.verifySecondFactor(ctx, user, code); err != nil {
+		if errors.Is(err, ErrInvalidMFACode) {
+			s.recordIPFailure(client.IP)
+			if err := s.recordAccountFailure(ctx, user.ID); err != nil {
+				return nil, err
+			}
+		}
+		return nil, err
+	}
+
+	return s.completeLogin(ctx, user, client)
+}
+
+// completeLogin - сброс счетчика неудач и выдача токенов новой сессии
+func (s *authService) completeLogin(ctx context.Context, user *domain.User, client ClientInfo) (*LoginResult, error) {
+	if user.FailedLoginAttempts > 0 || user.LockedUntil != nil {
+		if err := s.userRepo.ResetFailedLogins(ctx, user.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	// Каждый вход открывает новую цепочку refresh токенов
+	pair, err := s.jwtManager.GenerateTokens(user.ID, user.Email, user.Username, string(user.Role), uuid.NewString())
+	if err != nil {
+		return nil, err
 	}
 
-	// Генерируем токены
-	accessToken, refreshToken, err = s.jwtManager.GenerateTokens(user.ID, user.Email, user.Username)
+	err = s.sessionRepo.Create(ctx, newSession(user.ID, pair, client))
 	if err != nil {
-		return "", "", nil, err
+		return nil, err
 	}
 
-	return accessToken, refreshToken, user, nil
+	return &LoginResult{
+		AccessToken:  pair.AccessToken,
+		RefreshToken: pair.RefreshToken,
+		User:         user,
+	}, nil
 }
 
-func (s *authService) RefreshToken(ctx context.Context, refreshToken string) (newAccessToken, newRefreshToken string, err error) {
+func (s *authService) RefreshToken(ctx context.Context, refreshToken string, client ClientInfo) (newAccessToken, newRefreshToken string, err error) {
 	// Валидируем refresh токен
 	claims, err := s.jwtManager.ValidateRefreshToken(refreshToken)
 	if err != nil {
 		return "", "", err
 	}
 
+	// Токены без jti выданы до появления серверных сессий — не принимаем их
+	if claims.ID == "" || claims.SessionID == "" {
+		return "", "", auth.ErrInvalidToken
+	}
+
+	session, err := s.sessionRepo.GetByJTI(ctx, claims.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", "", auth.ErrInvalidToken
+		}
+		return "", "", err
+	}
+
+	if session.RevokedAt != nil {
+		// Повторное использование уже ротированного токена — признак утечки,
+		// отзываем всю цепочку
+		if session.ReplacedBy != "" {
+			if _, err := s.sessionRepo.RevokeFamily(ctx, session.UserID, session.FamilyID); err != nil {
+				return "", "", err
+			}
+			return "", "", ErrRefreshTokenReused
+		}
+		return "", "", ErrSessionRevoked
+	}
+
 	// Получаем пользователя
-	user, err := s.userRepo.GetByID(ctx, claims.UserID)
+	user, err := s.userRepo.GetByID(ctx, session.UserID)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Генерируем новые токены в той же цепочке
+	pair, err := s.jwtManager.GenerateTokens(user.ID, user.Email, user.Username, string(user.Role), session.FamilyID)
 	if err != nil {
 		return "", "", err
 	}
 
-	// Генерируем новые токены
-	newAccessToken, newRefreshToken, err = s.jwtManager.GenerateTokens(user.ID, user.Email, user.Username)
+	err = s.sessionRepo.Rotate(ctx, session.JTI, newSession(user.ID, pair, client))
 	if err != nil {
+		if errors.Is(err, repo.ErrSessionRevoked) {
+			// Токен успели ротировать конкурентным запросом
+			if _, err := s.sessionRepo.RevokeFamily(ctx, session.UserID, session.FamilyID); err != nil {
+				return "", "", err
+			}
+			return "", "", ErrRefreshTokenReused
+		}
 		return "", "", err
 	}
 
-	return newAccessToken, newRefreshToken, nil
+	return pair.AccessToken, pair.RefreshToken, nil
 }
 
 func (s *authService) GetCurrentUser(ctx context.Context, userID uint) (*domain.User, error) {
 	return s.userRepo.GetByID(ctx, userID)
 }
 
-func (s *authService) ValidateToken(ctx context.Context, token string) (userID uint, err error) {
+func (s *authService) ValidateToken(ctx context.Context, token string) (*auth.JWTClaims, error) {
 	claims, err := s.jwtManager.ValidateAccessToken(token)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	return claims.UserID, nil
-}
 
-func (s *authService) Logout(ctx context.Context, userID uint) error {
-	// В простой реализации JWT logout не требует действий на сервере
-	// В более сложной системе можно добавить blacklist токенов
-	return nil
+	// Access токен живет не дольше своей сессии. Токен без sid нельзя отозвать,
+	// поэтому он не принимается
+	if claims.SessionID == "" {
+		return nil, auth.ErrInvalidToken
+	}
+	active, err := s.sessionRepo.IsFamilyActive(ctx, claims.UserID, claims.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if !active {
+		return nil, ErrSessionRevoked
+	}
+
+	return claims, nil
 }
 
-type userService struct {
-	userRepo     repo.UserRepo
-	rewardTxRepo repo.RewardTxRepo
-	attemptRepo  repo.AttemptRepo
+func (s *authService) GetJWKS(ctx context.Context) *auth.JWKSet {
+	return s.jwtManager.JWKS()
 }
 
-func NewUserService(userRepo repo.UserRepo, rewardTxRepo repo.RewardTxRepo, attemptRepo repo.AttemptRepo) UserService {
-	return &userService{userRepo: userRepo, rewardTxRepo: rewardTxRepo, attemptRepo: attemptRepo}
+func (s *authService) Logout(ctx context.Context, userID uint, sessionID string) error {
+	if sessionID == "" {
+		return s.sessionRepo.RevokeAllByUser(ctx, userID)
+	}
+	_, err := s.sessionRepo.RevokeFamily(ctx, userID, sessionID)
+	return err
 }
 
-func (s *userService) GetProfile(ctx context.Context, userID uint) (*domain.Profile, error) {
-	return s.userRepo.GetProfile(ctx, userID)
+func (s *authService) GetSessions(ctx context.Context, userID uint) ([]*domain.Session, error) {
+	return s.sessionRepo.GetActiveByUser(ctx, userID)
 }
 
-func (s *userService) UpdateProfile(ctx context.Context, userID uint, updates map[string]interface{}) error {
-	profile, err := s.userRepo.GetProfile(ctx, userID)
+func (s *authService) RevokeSession(ctx context.Context, userID uint, sessionID string) error {
+	revoked, err := s.sessionRepo.RevokeFamily(ctx, userID, sessionID)
 	if err != nil {
 		return err
 	}
+	if revoked == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
 
-	// Обновляем поля профиля
-	if streak, ok := updates["streak"].(int); ok {
-		profile.Streak = streak
+func (s *authService) RevokeOtherSessions(ctx context.Context, userID uint, currentSessionID string) error {
+	return s.sessionRepo.RevokeOthers(ctx, userID, currentSessionID)
+}
+
+func (s *authService) RequestPasswordReset(ctx context.Context, email string) error {
+	user, err := s.userRepo.GetByEmail(ctx, email)
+	if err != nil {
+		// Не раскрываем, зарегистрирован ли email
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
 	}
-	if stats, ok := updates["stats"].(map[string]interface{}); ok {
-		statsJSON, _ := json.Marshal(stats)
-		profile.Stats = datatypes.JSON(statsJSON)
+
+	// Действует только последняя ссылка
+	if err := s.userTokenRepo.InvalidateByUser(ctx, user.ID, domain.TokenPasswordReset); err != nil {
+		return err
 	}
-	if meta, ok := updates["meta"].(map[string]interface{}); ok {
-		metaJSON, _ := json.Marshal(meta)
-		profile.Meta = datatypes.JSON(metaJSON)
+	token, err := s.issueUserToken(ctx, user.ID, domain.TokenPasswordReset, passwordResetTTL)
+	if err != nil {
+		return err
 	}
 
-	return s.userRepo.UpdateProfile(ctx, profile)
+	// Письмо уходит в фоне: время ответа и ошибки SMTP не должны выдавать,
+	// что адрес зарегистрирован
+	s.sendInBackground(mail.Message{
+		To:      user.Email,
+		Subject: "Сброс пароля DuoFinance",
+		Body: "Здравствуйте, " + user.Username + "!\n\n" +
+			"Чтобы задать новый пароль, перейдите по ссылке (действует 1 час):\n" +
+			s.appBaseURL + "/reset-password?token=" + token + "\n\n" +
+			"Если вы не запрашивали сброс пароля, просто проигнорируйте это письмо.",
+	})
+	return nil
 }
 
-func (s *userService) GetDiamondsBalance(ctx context.Context, userID uint) (int64, error) {
-	return s.rewardTxRepo.GetBalance(ctx, userID)
+// sendInBackground - отправка письма без ожидания; ошибка только записывается в лог
+func (s *authService) sendInBackground(msg mail.Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mailSendTimeout)
+		defer cancel()
+		if err := s.mailer.Send(ctx, msg); err != nil {
+			log.Printf("failed to send %q to %s: %v", msg.Subject, msg.To, err)
+		}
+	}()
 }
 
-func (s *userService) GetUserStats(ctx context.Context, userID uint) (*UserStats, error) {
-	// Получаем профиль
-	profile, err := s.userRepo.GetProfile(ctx, userID)
+func (s *authService) ResetPassword(ctx context.Context, token, newPassword string) error {
+	userToken, err := s.userTokenRepo.Consume(ctx, domain.TokenPasswordReset, hashToken(token))
 	if err != nil {
-		return nil, err
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidUserToken
+		}
+		return err
 	}
 
-	// Получаем баланс алмазов
-	balance, err := s.rewardTxRepo.GetBalance(ctx, userID)
+	user, err := s.userRepo.GetByID(ctx, userToken.UserID)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	// Получаем все попытки пользователя
-	attempts, err := s.attemptRepo.GetByUserID(ctx, userID)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	user.PasswordHash = string(hashedPassword)
+	user.FailedLoginAttempts = 0
+	user.LastFailedLoginAt = nil
+	user.LockedUntil = nil
+	// Ссылка пришла на этот email — значит, владение подтверждено
+	if user.EmailVerifiedAt == nil {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	}
+	if err := s.userRepo.Update(ctx, user); err != nil {
+		return err
 	}
 
-	// Подсчитываем статистику
-	totalAttempts := len(attempts)
-	completedLevels := 0
-	totalScore := 0.0
-	completedLevelIDs := make(map[uint]bool)
-	completedAttempts := 0
+	// После смены пароля все существующие сессии недействительны
+	return s.sessionRepo.RevokeAllByUser(ctx, user.ID)
+}
 
-	// Проходим по всем попыткам
-	for _, attempt := range attempts {
-		if attempt.Status == "completed" && attempt.ResultScore >= 70 {
-			// Считаем уникальные завершенные уровни
-			if !completedLevelIDs[attempt.LevelID] {
-				completedLevels++
-				completedLevelIDs[attempt.LevelID] = true
-			}
-			totalScore += float64(attempt.ResultScore)
-			completedAttempts++
+func (s *authService) VerifyEmail(ctx context.Context, token string) error {
+	userToken, err := s.userTokenRepo.Consume(ctx, domain.TokenEmailVerify, hashToken(token))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidUserToken
 		}
+		return err
 	}
 
-	// Вычисляем средний балл
-	averageScore := 0.0
-	if completedAttempts > 0 {
-		averageScore = totalScore / float64(completedAttempts)
+	user, err := s.userRepo.GetByID(ctx, userToken.UserID)
+	if err != nil {
+		return err
+	}
+	if user.EmailVerifiedAt != nil {
+		return nil
 	}
 
-	return &UserStats{
-		TotalAttempts:     totalAttempts,
-		CompletedLevels:   completedLevels,
-		TotalDiamonds:     balance,
-		CurrentStreak:     profile.Streak,
-		AverageScore:      averageScore,
-		AchievementsCount: 0,
-	}, nil
+	now := time.Now()
+	user.EmailVerifiedAt = &now
+	return s.userRepo.Update(ctx, user)
 }
 
-func (s *userService) UpdateStreak(ctx context.Context, userID uint) error {
-	profile, err := s.userRepo.GetProfile(ctx, userID)
+func (s *authService) SendEmailVerification(ctx context.Context, userID uint) error {
+	user, err := s.userRepo.GetByID(ctx, userID)
 	if err != nil {
 		return err
 	}
+	if user.EmailVerifiedAt != nil {
+		return ErrEmailVerified
+	}
 
-	var meta map[string]interface{}
-	if profile.Meta != nil && len(profile.Meta) > 0 {
-		_ = json.Unmarshal(profile.Meta, &meta)
+	if err := s.userTokenRepo.InvalidateByUser(ctx, user.ID, domain.TokenEmailVerify); err != nil {
+		return err
 	}
-	if meta == nil {
-		meta = make(map[string]interface{})
+	token, err := s.issueUserToken(ctx, user.ID, domain.TokenEmailVerify, emailVerifyTTL)
+	if err != nil {
+		return err
 	}
 
-	// Определяем таймзону пользователя. Ожидаем IANA name в meta["timezone"].
-	// Если не задано или некорректно — используем UTC.
-	tzName, _ := meta["timezone"].(string)
-	loc := time.UTC
-	if tzName != "" {
-		if l, err := time.LoadLocation(tzName); err == nil {
-			loc = l
-		}
+	return s.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Подтвердите email в DuoFinance",
+		Body: "Здравствуйте, " + user.Username + "!\n\n" +
+			"Подтвердите адрес электронной почты, перейдя по ссылке (действует 48 часов):\n" +
+			s.appBaseURL + "/verify-email?token=" + token,
+	})
+}
+
+// issueUserToken - создание одноразового токена; в БД сохраняется только его хеш
+func (s *authService) issueUserToken(ctx context.Context, userID uint, purpose domain.UserTokenPurpose, ttl time.Duration) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	err := s.userTokenRepo.Create(ctx, &domain.UserToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return "", err
 	}
+	return token, nil
+}
 
-	now := time.Now().In(loc)
-	today := now.Format("2006-01-02")
-	last, _ := meta["streak_last_date"].(string)
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
 
-	if last == today {
+// recordAccountFailure - учет неудачного входа в аккаунт и блокировка с экспоненциальным ростом.
+// Возвращает LockedError, если аккаунт заблокирован этой неудачей
+func (s *authService) recordAccountFailure(ctx context.Context, userID uint) error {
+	if s.protection.MaxAccountFailures <= 0 {
 		return nil
 	}
 
-	// Если последняя дата — вчера в выбранной таймзоне, инкрементируем,
-	// иначе считаем пропуск и начинаем с 1.
-	newStreak := 1
-	if last != "" {
-		// Вычисляем "вчера" в пользовательской таймзоне
-		yesterday := now.Add(-24 * time.Hour).Format("2006-01-02")
-		if last == yesterday {
-			newStreak = profile.Streak + 1
-		} else {
-			newStreak = 1
-		}
+	failures, err := s.userRepo.IncrementFailedLogins(ctx, userID, time.Now().Add(-s.protection.FailureWindow))
+	if err != nil {
+		return err
 	}
 
-	meta["streak_last_date"] = today
-	metaJSON, _ := json.Marshal(meta)
-	profile.Meta = datatypes.JSON(metaJSON)
-	profile.Streak = newStreak
-	return s.userRepo.UpdateProfile(ctx, profile)
+	delay := ratelimit.Backoff(failures, s.protection.MaxAccountFailures, s.protection.LockoutBase, s.protection.LockoutMax)
+	if delay == 0 {
+		return nil
+	}
+	until := time.Now().Add(delay)
+	if err := s.userRepo.LockUntil(ctx, userID, until); err != nil {
+		return err
+	}
+	return &LockedError{Reason: ErrAccountLocked, Until: until}
 }
 
-type levelService struct {
-	levelRepo    repo.LevelRepo
-	questionRepo repo.QuestionRepo
-	attemptRepo  repo.AttemptRepo
+// checkLocked - LockedError, если вход в аккаунт временно заблокирован
+func checkLocked(user *domain.User) error {
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		return &LockedError{Reason: ErrAccountLocked, Until: *user.LockedUntil}
+	}
+	return nil
 }
 
-func NewLevelService(levelRepo repo.LevelRepo, questionRepo repo.QuestionRepo, attemptRepo repo.AttemptRepo) LevelService {
-	return &levelService{levelRepo: levelRepo, questionRepo: questionRepo, attemptRepo: attemptRepo}
+// checkIPBlocked - LockedError, если адрес ip заблокирован за неудачные входы
+func (s *authService) checkIPBlocked(ip string) error {
+	if s.protection.IPFailures != nil && ip != "" {
+		if until, blocked := s.protection.IPFailures.BlockedUntil(ip); blocked {
+			return &LockedError{Reason: ErrTooManyAttempts, Until: until}
+		}
+	}
+	return nil
 }
 
-func (s *levelService) GetLevels(ctx context.Context) ([]*domain.Level, error) {
-	levels, err := s.levelRepo.GetAll(ctx)
-	if err != nil {
-		return nil, err
+func (s *authService) recordIPFailure(ip string) {
+	if s.protection.IPFailures != nil && ip != "" {
+		s.protection.IPFailures.Fail(ip)
 	}
-	return levels, nil
 }
 
-func (s *levelService) GetLevel(ctx context.Context, id uint) (*domain.Level, error) {
-	// С деталями шагов, если доступны
-	level, err := s.levelRepo.GetWithSteps(ctx, id)
+func (s *authService) EnrollTOTP(ctx context.Context, userID uint) (*TOTPEnrollment, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
-	return level, nil
-}
+	if user.TOTPEnabledAt != nil {
+		return nil, ErrMFAAlreadyEnabled
+	}
 
-func (s *levelService) GetLevelsByDifficulty(ctx context.Context, difficulty string) ([]*domain.Level, error) {
-	levels, err := s.levelRepo.GetByDifficulty(ctx, difficulty)
+	secret, err := auth.GenerateTOTPSecret()
 	if err != nil {
 		return nil, err
 	}
-	return levels, nil
-}
 
-func (s *levelService) GetLevelsByTopic(ctx context.Context, topic string) ([]*domain.Level, error) {
-	levels, err := s.levelRepo.GetByTopic(ctx, topic)
-	if err != nil {
+	// Повторный вызов до подтверждения заменяет секрет и коды восстановления
+	user.TOTPSecret = secret
+	user.TOTPLastStep = 0
+	if err := s.userRepo.Update(ctx, user); err != nil {
 		return nil, err
 	}
-	return levels, nil
-}
 
-func (s *levelService) IsLevelAvailable(ctx context.Context, levelID, userID uint) (bool, error) {
-	level, err := s.levelRepo.GetByID(ctx, levelID)
-	if err != nil {
-		return false, err
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, hashToken(normalizeRecoveryCode(code)))
 	}
-	if !level.IsActive {
-		return false, nil
+	if err := s.recoveryRepo.Replace(ctx, user.ID, hashes); err != nil {
+		return nil, err
 	}
 
-	// Получаем активные уровни по порядку
-	levels, err := s.levelRepo.GetAll(ctx)
-	if err != nil {
-		return false, err
-	}
+	return &TOTPEnrollment{
+		Secret:        secret,
+		URI:           auth.TOTPURI(totpIssuer, user.Email, secret),
+		RecoveryCodes: codes,
+	}, nil
+}
 
-	// Оставляем только активные
-	var activeLevels []*domain.Level
-	for _, l := range levels {
-		if l.IsActive {
-			activeLevels = append(activeLevels, l)
-		}
+func (s *authService) ConfirmTOTP(ctx context.Context, userID uint, code string) error {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return err
 	}
-
-	// Найдем позицию текущего уровня
-	idx := -1
-	for i, l := range activeLevels {
-		if l.ID == levelID {
-			idx = i
-			break
-		}
+	if user.TOTPEnabledAt != nil {
+		return ErrMFAAlreadyEnabled
 	}
-	if idx == -1 {
-		return false, errors.New("level not found")
+	if user.TOTPSecret == "" {
+		return ErrMFANotEnabled
 	}
 
-	// Первый уровень всегда доступен
-	if idx == 0 {
-		return true, nil
+	// Подтверждение только кодом из приложения: так проверяется, что секрет сохранен
+	step, ok := auth.ValidateTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return ErrInvalidMFACode
 	}
 
-	// Требуется завершение предыдущего активного уровня
-	prev := activeLevels[idx-1]
-	attempts, err := s.attemptRepo.GetByUserID(ctx, userID)
+	now := time.Now()
+	user.TOTPEnabledAt = &now
+	user.TOTPLastStep = step
+	return s.userRepo.Update(ctx, user)
+}
+
+func (s *authService) DisableTOTP(ctx context.Context, userID uint, code string) error {
+	user, err := s.userRepo.GetByID(ctx, userID)
 	if err != nil {
-		return false, err
+		return err
+	}
+	if user.TOTPEnabledAt == nil {
+		return ErrMFANotEnabled
+	}
+	// Неверные коды считаются так же, как при входе: иначе украденный access токен
+	// позволял бы перебирать код без ограничений
+	if err := checkLocked(user); err != nil {
+		return err
 	}
 
-	for _, a := range attempts {
-		if a.LevelID == prev.ID && a.Status == domain.AttemptStatus("completed") && a.ResultScore >= 70 {
-			return true, nil
+	// Использованный код восстановления и отключение 2FA фиксируются вместе
+	err = s.txManager.WithinTx(ctx, func(ctx context.Context) error {
+		if err := s.verifySecondFactor(ctx, user, code); err != nil {
+			return err
+		}
+		user.TOTPSecret = ""
+		user.TOTPEnabledAt = nil
+		user.TOTPLastStep = 0
+		if err := s.userRepo.Update(ctx, user); err != nil {
+			return err
+		}
+		return s.recoveryRepo.DeleteByUser(ctx, user.ID)
+	})
+	if errors.Is(err, ErrInvalidMFACode) {
+		if err := s.recordAccountFailure(ctx, user.ID); err != nil {
+			return err
 		}
 	}
-
-	return false, nil
+	return err
 }
 
-type attemptService struct {
-	attemptRepo  repo.AttemptRepo
-	levelRepo    repo.LevelRepo
-	questionRepo repo.QuestionRepo
+// verifySecondFactor - проверка кода TOTP или кода восстановления (одноразовые)
+func (s *authService) verifySecondFactor(ctx context.Context, user *domain.User, code string) error {
+	if step, ok := auth.ValidateTOTP(user.TOTPSecret, code, time.Now()); ok {
+		accepted, err := s.userRepo.AcceptTOTPStep(ctx, user.ID, step)
+		if err != nil {
+			return err
+		}
+		if !accepted {
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+
+	normalized := normalizeRecoveryCode(code)
+	if normalized == "" {
+		return ErrInvalidMFACode
+	}
+	err := s.recoveryRepo.Consume(ctx, user.ID, hashToken(normalized))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidMFACode
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *authService) GetOIDCProviders(ctx context.Context) []string {
+	names := make([]string, 0, len(s.oidcProviders))
+	for name := range s.oidcProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *authService) StartOIDCLogin(ctx context.Context, provider string) (string, error) {
+	return s.startOIDC(ctx, provider, nil)
+}
+
+func (s *authService) CompleteOIDCLogin(ctx context.Context, provider, code, state string, client ClientInfo) (*LoginResult, error) {
+	claims, err := s.completeOIDC(ctx, provider, code, state, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var user *domain.User
+	identity, err := s.identityRepo.GetByProviderSubject(ctx, provider, claims.Subject)
+	switch {
+	case err == nil:
+		user, err = s.userRepo.GetByID(ctx, identity.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.identityRepo.TouchLogin(ctx, identity.ID); err != nil {
+			return nil, err
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		user, err = s.userForIdentity(ctx, claims)
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		err = s.identityRepo.Create(ctx, &domain.UserIdentity{
+			UserID:      user.ID,
+			Provider:    provider,
+			Subject:     claims.Subject,
+			Email:       claims.Email,
+			LastLoginAt: &now,
+		})
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	// Второй фактор требуется при любом способе входа
+	if user.TOTPEnabledAt != nil {
+		mfaToken, err := s.jwtManager.GenerateMFAToken(user.ID, user.Email, user.Username)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{User: user, MFARequired: true, MFAToken: mfaToken}, nil
+	}
+
+	return s.completeLogin(ctx, user, client)
+}
+
+func (s *authService) StartOIDCLink(ctx context.Context, userID uint, provider string) (string, error) {
+	return s.startOIDC(ctx, provider, &userID)
+}
+
+func (s *authService) CompleteOIDCLink(ctx context.Context, userID uint, provider, code, state string) (*domain.UserIdentity, error) {
+	claims, err := s.completeOIDC(ctx, provider, code, state, &userID)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := s.identityRepo.GetByProviderSubject(ctx, provider, claims.Subject)
+	if err == nil {
+		if identity.UserID == userID {
+			return identity, nil
+		}
+		return nil, ErrIdentityLinked
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	// К аккаунту привязывается не больше одного внешнего аккаунта каждого провайдера
+	identities, err := s.identityRepo.GetByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, existing := range identities {
+		if existing.Provider == provider {
+			return nil, ErrIdentityLinked
+		}
+	}
+
+	identity = &domain.UserIdentity{
+		UserID:   userID,
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	}
+	if err := s.identityRepo.Create(ctx, identity); err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+func (s *authService) GetIdentities(ctx context.Context, userID uint) ([]*domain.UserIdentity, error) {
+	return s.identityRepo.GetByUser(ctx, userID)
+}
+
+func (s *authService) UnlinkIdentity(ctx context.Context, userID uint, provider string) error {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	// Пользователь, созданный через провайдера, не имеет пароля
+	if user.PasswordHash == "" {
+		identities, err := s.identityRepo.GetByUser(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if len(identities) == 1 && identities[0].Provider == provider {
+			return ErrLastLoginMethod
+		}
+	}
+
+	err = s.identityRepo.Delete(ctx, userID, provider)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrIdentityNotFound
+	}
+	return err
+}
+
+// startOIDC - сохранение state, nonce и PKCE verifier и адрес страницы входа провайдера.
+// userID задан при привязке провайдера к существующему аккаунту
+func (s *authService) startOIDC(ctx context.Context, provider string, userID *uint) (string, error) {
+	p, ok := s.oidcProviders[provider]
+	if !ok {
+		return "", ErrUnknownProvider
+	}
+
+	values := make([]string, 3)
+	for i := range values {
+		value, err := oidc.RandomString()
+		if err != nil {
+			return "", err
+		}
+		values[i] = value
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	err := s.oidcStateRepo.Create(ctx, &domain.OIDCState{
+		State:        state,
+		Provider:     provider,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		UserID:       userID,
+		ExpiresAt:    time.Now().Add(oidcStateTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := p.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrOIDCFailed, err)
+	}
+	return authURL, nil
+}
+
+// completeOIDC - проверка state и обмен кода на сведения о пользователе провайдера
+func (s *authService) completeOIDC(ctx context.Context, provider, code, state string, userID *uint) (*oidc.Claims, error) {
+	p, ok := s.oidcProviders[provider]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+
+	record, err := s.oidcStateRepo.Consume(ctx, state)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidOIDCState
+		}
+		return nil, err
+	}
+	// state входа нельзя использовать для привязки и наоборот
+	if record.Provider != provider || (record.UserID == nil) != (userID == nil) ||
+		(userID != nil && *record.UserID != *userID) {
+		return nil, ErrInvalidOIDCState
+	}
+
+	claims, err := p.Exchange(ctx, code, record.CodeVerifier, record.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCFailed, err)
+	}
+	return claims, nil
+}
+
+// userForIdentity - пользователь для нового внешнего аккаунта. Существующий аккаунт
+// привязывается автоматически, только если email подтвержден и провайдером, и у нас:
+// иначе владелец пароля мог бы заранее занять чужой email.
+func (s *authService) userForIdentity(ctx context.Context, claims *oidc.Claims) (*domain.User, error) {
+	if claims.Email == "" {
+		return nil, ErrOIDCEmailRequired
+	}
+
+	existing, err := s.userRepo.GetByEmail(ctx, claims.Email)
+	if err == nil {
+		if claims.EmailVerified && existing.EmailVerifiedAt != nil {
+			return existing, nil
+		}
+		return nil, ErrAccountExists
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	username, err := s.availableUsername(ctx, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	// Пароль не задан: войти можно только через провайдера или после сброса пароля
+	user := &domain.User{
+		Email:    claims.Email,
+		Username: username,
+		Role:     domain.RoleLearner,
+	}
+	if claims.EmailVerified {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	}
+	if err := s.createUser(ctx, user); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// createUser - новый пользователь вместе с пустым профилем в одной транзакции
+func (s *authService) createUser(ctx context.Context, user *domain.User) error {
+	return s.txManager.WithinTx(ctx, func(ctx context.Context) error {
+		if err := s.userRepo.Create(ctx, user); err != nil {
+			return err
+		}
+		return s.userRepo.UpdateProfile(ctx, &domain.Profile{UserID: user.ID})
+	})
+}
+
+// availableUsername - свободное имя пользователя на основе preferred_username или email
+func (s *authService) availableUsername(ctx context.Context, claims *oidc.Claims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base = strings.SplitN(claims.Email, "@", 2)[0]
+	}
+
+	var b strings.Builder
+	for _, r := range strings.ToLower(base) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' || r == '.' || r == '-' {
+			b.WriteRune(r)
+		}
+	}
+	base = truncate(b.String(), 40)
+	for len(base) < 3 {
+		base += "_"
+	}
+
+	candidate := base
+	for i := 0; i < 5; i++ {
+		_, err := s.userRepo.GetByUsername(ctx, candidate)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+
+		suffix := make([]byte, 2)
+		if _, err := rand.Read(suffix); err != nil {
+			return "", err
+		}
+		candidate = fmt.Sprintf("%s%d", base, int(suffix[0])<<8|int(suffix[1]))
+	}
+	return "", errors.New("failed to pick a free username")
+}
+
+// newRecoveryCode - код восстановления вида "abcde-fghij"
+func newRecoveryCode() (string, error) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyz123456789" // 32 символа без похожих i, l, o, 0
+	raw := make([]byte, 10)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	code := make([]byte, 0, 11)
+	for i, b := range raw {
+		if i == 5 {
+			code = append(code, '-')
+		}
+		code = append(code, alphabet[int(b)%len(alphabet)])
+	}
+	return string(code), nil
+}
+
+// normalizeRecoveryCode - код без регистра, пробелов и дефисов
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// newSession - запись о выданном refresh токене
+func newSession(userID uint, pair *auth.TokenPair, client ClientInfo) *domain.Session {
+	return &domain.Session{
+		UserID:     userID,
+		JTI:        pair.RefreshJTI,
+		FamilyID:   pair.SessionID,
+		Device:     describeDevice(client.UserAgent),
+		UserAgent:  truncate(client.UserAgent, 512),
+		IP:         client.IP,
+		IssuedAt:   pair.IssuedAt,
+		ExpiresAt:  pair.RefreshExpiresAt,
+		LastUsedAt: &pair.IssuedAt,
+	}
+}
+
+// describeDevice - краткое описание клиента по User-Agent, например "Chrome on Windows"
+func describeDevice(userAgent string) string {
+	if userAgent == "" {
+		return "Unknown device"
+	}
+
+	browser := "Unknown browser"
+	switch {
+	case strings.Contains(userAgent, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(userAgent, "OPR/"):
+		browser = "Opera"
+	case strings.Contains(userAgent, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(userAgent, "Chrome/"):
+		browser = "Chrome"
+	case strings.Contains(userAgent, "Safari/"):
+		browser = "Safari"
+	case strings.Contains(userAgent, "okhttp"), strings.Contains(userAgent, "Dart/"), strings.Contains(userAgent, "CFNetwork"):
+		browser = "Mobile app"
+	}
+
+	platform := ""
+	switch {
+	case strings.Contains(userAgent, "Android"):
+		platform = "Android"
+	case strings.Contains(userAgent, "iPhone"), strings.Contains(userAgent, "iPad"), strings.Contains(userAgent, "iOS"):
+		platform = "iOS"
+	case strings.Contains(userAgent, "Windows"):
+		platform = "Windows"
+	case strings.Contains(userAgent, "Mac OS X"), strings.Contains(userAgent, "Macintosh"):
+		platform = "macOS"
+	case strings.Contains(userAgent, "Linux"):
+		platform = "Linux"
+	}
+
+	if platform == "" {
+		return browser
+	}
+	return browser + " on " + platform
+}
+
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return s[:max]
+}
+
+type userService struct {
+	userRepo     repo.UserRepo
+	sessionRepo  repo.SessionRepo
+	rewardTxRepo repo.RewardTxRepo
+	attemptRepo  repo.AttemptRepo
+}
+
+func NewUserService(userRepo repo.UserRepo, sessionRepo repo.SessionRepo, rewardTxRepo repo.RewardTxRepo, attemptRepo repo.AttemptRepo) UserService {
+	return &userService{userRepo: userRepo, sessionRepo: sessionRepo, rewardTxRepo: rewardTxRepo, attemptRepo: attemptRepo}
+}
+
+func (s *userService) ListUsers(ctx context.Context, page, pageSize int) (*UserPage, error) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	users, total, err := s.userRepo.List(ctx, (page-1)*pageSize, pageSize)
+	if err != nil {
+		return nil, err
+	}
+	return &UserPage{Users: users, Total: total, Page: page, PageSize: pageSize}, nil
+}
+
+func (s *userService) SetRole(ctx context.Context, actorID, userID uint, role domain.UserRole) error {
+	if !role.Valid() {
+		return ErrInvalidRole
+	}
+	// Администратор не может снять права с самого себя и оставить систему без администраторов
+	if actorID == userID {
+		return ErrOwnRole
+	}
+
+	err := s.userRepo.SetRole(ctx, userID, role)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+
+	return s.sessionRepo.RevokeAllByUser(ctx, userID)
+}
+
+func (s *userService) GetProfile(ctx context.Context, userID uint) (*domain.Profile, error) {
+	return s.userRepo.GetProfile(ctx, userID)
+}
+
+func (s *userService) UpdateProfile(ctx context.Context, userID uint, updates map[string]interface{}) error {
+	profile, err := s.userRepo.GetProfile(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	// Обновляем поля профиля
+	if streak, ok := updates["streak"].(int); ok {
+		profile.Streak = streak
+	}
+	if stats, ok := updates["stats"].(map[string]interface{}); ok {
+		statsJSON, _ := json.Marshal(stats)
+		profile.Stats = datatypes.JSON(statsJSON)
+	}
+	if meta, ok := updates["meta"].(map[string]interface{}); ok {
+		metaJSON, _ := json.Marshal(meta)
+		profile.Meta = datatypes.JSON(metaJSON)
+	}
+
+	return s.userRepo.UpdateProfile(ctx, profile)
+}
+
+func (s *userService) GetDiamondsBalance(ctx context.Context, userID uint) (int64, error) {
+	return s.rewardTxRepo.GetBalance(ctx, userID)
+}
+
+func (s *userService) GetUserStats(ctx context.Context, userID uint) (*UserStats, error) {
+	// Получаем профиль
+	profile, err := s.userRepo.GetProfile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Получаем баланс алмазов
+	balance, err := s.rewardTxRepo.GetBalance(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Получаем все попытки пользователя
+	attempts, err := s.attemptRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Подсчитываем статистику
+	totalAttempts := len(attempts)
+	completedLevels := 0
+	totalScore := 0.0
+	completedLevelIDs := make(map[uint]bool)
+	completedAttempts := 0
+
+	// Проходим по всем попыткам
+	for _, attempt := range attempts {
+		if attempt.Status == "completed" && attempt.Passed {
+			// Считаем уникальные завершенные уровни
+			if !completedLevelIDs[attempt.LevelID] {
+				completedLevels++
+				completedLevelIDs[attempt.LevelID] = true
+			}
+			totalScore += float64(attempt.ResultScore)
+			completedAttempts++
+		}
+	}
+
+	// Вычисляем средний балл
+	averageScore := 0.0
+	if completedAttempts > 0 {
+		averageScore = totalScore / float64(completedAttempts)
+	}
+
+	return &UserStats{
+		TotalAttempts:     totalAttempts,
+		CompletedLevels:   completedLevels,
+		TotalDiamonds:     balance,
+		CurrentStreak:     profile.Streak,
+		AverageScore:      averageScore,
+		AchievementsCount: 0,
+	}, nil
+}
+
+func (s *userService) UpdateStreak(ctx context.Context, userID uint) error {
+	profile, err := s.userRepo.GetProfile(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !profile.TouchStreak(time.Now()) {
+		return nil
+	}
+	return s.userRepo.UpdateProfile(ctx, profile)
+}
+
+type levelService struct {
+	levelRepo    repo.LevelRepo
+	questionRepo repo.QuestionRepo
+	attemptRepo  repo.AttemptRepo
+	unlock       *unlockEngine
+}
+
+func NewLevelService(levelRepo repo.LevelRepo, questionRepo repo.QuestionRepo, attemptRepo repo.AttemptRepo, courseRepo repo.CourseRepo) LevelService {
+	return &levelService{
+		levelRepo:    levelRepo,
+		questionRepo: questionRepo,
+		attemptRepo:  attemptRepo,
+		unlock:       newUnlockEngine(levelRepo, courseRepo, attemptRepo),
+	}
+}
+
+func (s *levelService) GetLevels(ctx context.Context) ([]*domain.Level, error) {
+	return s.publishedLevels(ctx, func(*domain.Level) bool { return true })
+}
+
+func (s *levelService) GetLevel(ctx context.Context, id uint) (*domain.Level, error) {
+	level, err := s.levelRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return publishedLevel(ctx, s.levelRepo, level)
+}
+
+func (s *levelService) GetLevelsByDifficulty(ctx context.Context, difficulty string) ([]*domain.Level, error) {
+	return s.publishedLevels(ctx, func(level *domain.Level) bool { return level.Difficulty == difficulty })
+}
+
+func (s *levelService) GetLevelsByTopic(ctx context.Context, topic string) ([]*domain.Level, error) {
+	return s.publishedLevels(ctx, func(level *domain.Level) bool { return level.Topic == topic })
+}
+
+// publishedLevels - активные опубликованные уровни в опубликованной версии, отобранные
+// по match; фильтр применяется к снимку, а не к черновику
+func (s *levelService) publishedLevels(ctx context.Context, match func(*domain.Level) bool) ([]*domain.Level, error) {
+	levels, err := s.levelRepo.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var result []*domain.Level
+	for _, level := range levels {
+		if level.PublishedRevisionID == nil {
+			continue
+		}
+		published, err := publishedLevel(ctx, s.levelRepo, level)
+		if err != nil {
+			return nil, err
+		}
+		if match(published) {
+			result = append(result, published)
+		}
+	}
+	return result, nil
+}
+
+func (s *levelService) IsLevelAvailable(ctx context.Context, levelID, userID uint) (bool, error) {
+	level, err := s.levelRepo.GetByID(ctx, levelID)
+	if err != nil {
+		return false, err
+	}
+	return s.unlock.isAvailable(ctx, userID, level)
+}
+
+// unlockEngine - единые правила открытия уровней для карты курса, списка уровней
+// и старта попытки. Уровень пройден, если есть попытка, прошедшая по политике
+// подсчета уровня; открыт — если он активен и каждая его зависимость пройдена
+// не ниже MinScore. Зависимости от удаленных и неактивных уровней не учитываются,
+// так как выполнить их невозможно.
+type unlockEngine struct {
+	levelRepo   repo.LevelRepo
+	courseRepo  repo.CourseRepo
+	attemptRepo repo.AttemptRepo
+}
+
+func newUnlockEngine(levelRepo repo.LevelRepo, courseRepo repo.CourseRepo, attemptRepo repo.AttemptRepo) *unlockEngine {
+	return &unlockEngine{levelRepo: levelRepo, courseRepo: courseRepo, attemptRepo: attemptRepo}
+}
+
+// progress - состояние уровней levels для пользователя, по ID уровня
+func (e *unlockEngine) progress(ctx context.Context, userID uint, levels []*domain.Level) (map[uint]*LevelProgress, error) {
+	best, err := e.attemptRepo.GetBestScores(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	passedIDs, err := e.attemptRepo.GetPassedLevelIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	passed := make(map[uint]bool, len(passedIDs))
+	for _, id := range passedIDs {
+		passed[id] = true
+	}
+
+	ids := make([]uint, 0, len(levels))
+	for _, level := range levels {
+		ids = append(ids, level.ID)
+	}
+	edges, err := e.courseRepo.GetPrerequisites(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	required := make(map[uint][]*domain.LevelPrerequisite, len(levels))
+	for _, edge := range edges {
+		required[edge.LevelID] = append(required[edge.LevelID], edge)
+	}
+
+	// Выполнимые зависимости — только от активных опубликованных неудаленных уровней
+	active, err := e.levelRepo.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reachable := make(map[uint]bool, len(active))
+	for _, level := range active {
+		reachable[level.ID] = level.PublishedRevisionID != nil
+	}
+
+	result := make(map[uint]*LevelProgress, len(levels))
+	for _, level := range levels {
+		p := &LevelProgress{Level: level, State: domain.LevelLocked, Prerequisites: required[level.ID]}
+		if score, ok := best[level.ID]; ok {
+			p.BestScore = &score
+		}
+
+		switch {
+		case !level.IsActive, level.PublishedRevisionID == nil:
+		case passed[level.ID]:
+			p.State = domain.LevelCompleted
+		case prerequisitesMet(p.Prerequisites, best, reachable):
+			p.State = domain.LevelAvailable
+		}
+		result[level.ID] = p
+	}
+	return result, nil
+}
+
+// isAvailable - можно ли пользователю начать уровень (открыт или уже пройден)
+func (e *unlockEngine) isAvailable(ctx context.Context, userID uint, level *domain.Level) (bool, error) {
+	progress, err := e.progress(ctx, userID, []*domain.Level{level})
+	if err != nil {
+		return false, err
+	}
+	return progress[level.ID].State != domain.LevelLocked, nil
+}
+
+func prerequisitesMet(prerequisites []*domain.LevelPrerequisite, best map[uint]int, reachable map[uint]bool) bool {
+	for _, prerequisite := range prerequisites {
+		if !reachable[prerequisite.RequiredLevelID] {
+			continue
+		}
+		score, ok := best[prerequisite.RequiredLevelID]
+		if !ok || score < prerequisite.MinScore {
+			return false
+		}
+	}
+	return true
+}
+
+type contentService struct {
+	levelRepo    repo.LevelRepo
+	questionRepo repo.QuestionRepo
+	simulations  *simulation.Engine
+}
+
+func NewContentService(levelRepo repo.LevelRepo, questionRepo repo.QuestionRepo, simulations *simulation.Engine) ContentService {
+	return &contentService{levelRepo: levelRepo, questionRepo: questionRepo, simulations: simulations}
+}
+
+func (s *contentService) ListLevels(ctx context.Context, includeDeleted bool) ([]*domain.Level, error) {
+	return s.levelRepo.ListAll(ctx, includeDeleted)
+}
+
+func (s *contentService) GetLevel(ctx context.Context, id uint, includeDeleted bool) (*domain.Level, error) {
+	level, err := s.levelRepo.GetForEdit(ctx, id, includeDeleted)
+	if err != nil {
+		return nil, notFound(err, ErrLevelNotFound)
+	}
+	return level, nil
+}
+
+func (s *contentService) CreateLevel(ctx context.Context, level *domain.Level) error {
+	if err := validateLevel(level); err != nil {
+		return err
+	}
+	level.ID = 0
+	level.Steps = nil
+	return s.levelRepo.Create(ctx, level)
+}
+
+func (s *contentService) UpdateLevel(ctx context.Context, level *domain.Level) error {
+	if err := validateLevel(level); err != nil {
+		return err
+	}
+	existing, err := s.levelRepo.GetByID(ctx, level.ID)
+	if err != nil {
+		return notFound(err, ErrLevelNotFound)
+	}
+	level.CreatedAt = existing.CreatedAt
+	level.Slug = existing.Slug
+	level.PublishedRevisionID = existing.PublishedRevisionID
+	// Место в курсе меняется через CourseService.PlaceLevel
+	level.UnitID = existing.UnitID
+	level.UnitOrder = existing.UnitOrder
+	level.Steps = nil
+	return s.levelRepo.Update(ctx, level)
+}
+
+func (s *contentService) DeleteLevel(ctx context.Context, id uint) error {
+	return notFound(s.levelRepo.Delete(ctx, id), ErrLevelNotFound)
+}
+
+func (s *contentService) RestoreLevel(ctx context.Context, id uint) error {
+	return notFound(s.levelRepo.Restore(ctx, id), ErrLevelNotFound)
+}
+
+func (s *contentService) ListSteps(ctx context.Context, levelID uint, includeDeleted bool) ([]*domain.LevelStep, error) {
+	if _, err := s.levelRepo.GetByID(ctx, levelID); err != nil {
+		return nil, notFound(err, ErrLevelNotFound)
+	}
+	return s.levelRepo.GetSteps(ctx, levelID, includeDeleted)
+}
+
+func (s *contentService) CreateStep(ctx context.Context, step *domain.LevelStep) error {
+	if _, err := s.levelRepo.GetByID(ctx, step.LevelID); err != nil {
+		return notFound(err, ErrLevelNotFound)
+	}
+
+	if step.Order == 0 {
+		max, err := s.levelRepo.MaxStepOrder(ctx, step.LevelID)
+		if err != nil {
+			return err
+		}
+		step.Order = max + 1
+	}
+
+	step.ID = 0
+	if err := s.validateStep(ctx, step); err != nil {
+		return err
+	}
+	return s.levelRepo.CreateStep(ctx, step)
+}
+
+func (s *contentService) UpdateStep(ctx context.Context, step *domain.LevelStep) error {
+	existing, err := s.levelRepo.GetStep(ctx, step.ID)
+	if err != nil {
+		return notFound(err, ErrStepNotFound)
+	}
+	// Перенос шага в другой уровень не поддерживается
+	step.LevelID = existing.LevelID
+	step.CreatedAt = existing.CreatedAt
+	step.Slug = existing.Slug
+	if step.Order == 0 {
+		step.Order = existing.Order
+	}
+
+	if err := s.validateStep(ctx, step); err != nil {
+		return err
+	}
+	return s.levelRepo.UpdateStep(ctx, step)
+}
+
+func (s *contentService) DeleteStep(ctx context.Context, id uint) error {
+	return notFound(s.levelRepo.DeleteStep(ctx, id), ErrStepNotFound)
+}
+
+func (s *contentService) ReorderSteps(ctx context.Context, levelID uint, stepIDs []uint) ([]*domain.LevelStep, error) {
+	steps, err := s.ListSteps(ctx, levelID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// Новый порядок должен содержать каждый неудаленный шаг уровня ровно один раз
+	if len(stepIDs) != len(steps) {
+		return nil, invalid("step_ids", "must list every step of the level exactly once")
+	}
+	current := make(map[uint]bool, len(steps))
+	for _, step := range steps {
+		current[step.ID] = true
+	}
+	seen := make(map[uint]bool, len(stepIDs))
+	for _, id := range stepIDs {
+		if !current[id] || seen[id] {
+			return nil, invalid("step_ids", "must list every step of the level exactly once")
+		}
+		seen[id] = true
+	}
+
+	if err := s.levelRepo.ReorderSteps(ctx, levelID, stepIDs); err != nil {
+		return nil, err
+	}
+	return s.levelRepo.GetSteps(ctx, levelID, false)
+}
+
+func (s *contentService) GetQuestion(ctx context.Context, id uint) (*domain.Question, error) {
+	question, err := s.questionRepo.GetWithChoices(ctx, id)
+	if err != nil {
+		return nil, notFound(err, ErrQuestionNotFound)
+	}
+	return question, nil
+}
+
+func (s *contentService) CreateQuestion(ctx context.Context, question *domain.Question) error {
+	if err := validateQuestion(question, question.Choices); err != nil {
+		return err
+	}
+	question.ID = 0
+	for i, choice := range question.Choices {
+		choice.ID = 0
+		if choice.Order == 0 {
+			choice.Order = i + 1
+		}
+	}
+	return s.questionRepo.Create(ctx, question)
+}
+
+func (s *contentService) UpdateQuestion(ctx context.Context, question *domain.Question) error {
+	existing, err := s.questionRepo.GetWithChoices(ctx, question.ID)
+	if err != nil {
+		return notFound(err, ErrQuestionNotFound)
+	}
+	// Смена multi_select должна оставаться согласованной с текущими вариантами;
+	// у не-choice и шаблонных вопросов варианты удаляются вместе с обновлением
+	choices := existing.Choices
+	if !question.StoresChoices() {
+		choices = nil
+	}
+	if err := validateQuestion(question, choices); err != nil {
+		return err
+	}
+	question.CreatedAt = existing.CreatedAt
+	question.Slug = existing.Slug
+	question.Choices = nil
+	return s.questionRepo.Update(ctx, question)
+}
+
+func (s *contentService) DeleteQuestion(ctx context.Context, id uint) error {
+	if _, err := s.questionRepo.GetByID(ctx, id); err != nil {
+		return notFound(err, ErrQuestionNotFound)
+	}
+	used, err := s.questionRepo.IsReferenced(ctx, id)
+	if err != nil {
+		return err
+	}
+	if used {
+		return ErrQuestionInUse
+	}
+	return notFound(s.questionRepo.Delete(ctx, id), ErrQuestionNotFound)
+}
+
+func (s *contentService) CreateChoice(ctx context.Context, choice *domain.Choice) error {
+	question, err := s.questionRepo.GetWithChoices(ctx, choice.QuestionID)
+	if err != nil {
+		return notFound(err, ErrQuestionNotFound)
+	}
+
+	choice.ID = 0
+	if choice.Order == 0 {
+		choice.Order = len(question.Choices) + 1
+	}
+	if err := validateQuestion(question, append(question.Choices, *choice)); err != nil {
+		return err
+	}
+	return s.questionRepo.CreateChoice(ctx, choice)
+}
+
+func (s *contentService) UpdateChoice(ctx context.Context, choice *domain.Choice) error {
+	existing, err := s.questionRepo.GetChoice(ctx, choice.ID)
+	if err != nil {
+		return notFound(err, ErrChoiceNotFound)
+	}
+	question, err := s.questionRepo.GetWithChoices(ctx, existing.QuestionID)
+	if err != nil {
+		return notFound(err, ErrQuestionNotFound)
+	}
+
+	choice.QuestionID = existing.QuestionID
+	choice.CreatedAt = existing.CreatedAt
+	choice.Slug = existing.Slug
+	if choice.Order == 0 {
+		choice.Order = existing.Order
+	}
+
+	choices := make([]domain.Choice, 0, len(question.Choices))
+	for _, c := range question.Choices {
+		if c.ID == choice.ID {
+			c = *choice
+		}
+		choices = append(choices, c)
+	}
+	if err := validateQuestion(question, choices); err != nil {
+		return err
+	}
+	return s.questionRepo.UpdateChoice(ctx, choice)
+}
+
+func (s *contentService) DeleteChoice(ctx context.Context, id uint) error {
+	existing, err := s.questionRepo.GetChoice(ctx, id)
+	if err != nil {
+		return notFound(err, ErrChoiceNotFound)
+	}
+	question, err := s.questionRepo.GetWithChoices(ctx, existing.QuestionID)
+	if err != nil {
+		return notFound(err, ErrQuestionNotFound)
+	}
+
+	choices := make([]domain.Choice, 0, len(question.Choices))
+	for _, c := range question.Choices {
+		if c.ID != id {
+			choices = append(choices, c)
+		}
+	}
+	if err := validateQuestion(question, choices); err != nil {
+		return err
+	}
+	return notFound(s.questionRepo.DeleteChoice(ctx, id), ErrChoiceNotFound)
+}
+
+func (s *contentService) PublishLevel(ctx context.Context, levelID, actorID uint, note string) (*domain.LevelRevision, error) {
+	level, err := s.levelRepo.GetWithSteps(ctx, levelID)
+	if err != nil {
+		return nil, notFound(err, ErrLevelNotFound)
+	}
+
+	// Публикуемый снимок должен быть проходимым: правила те же, что и при редактировании
+	if len(level.Steps) == 0 {
+		return nil, invalid("steps", "level has no steps to publish")
+	}
+	for _, step := range level.Steps {
+		if step.Type == domain.StepTypeSimulation {
+			if _, err := s.simulations.ParseSpec(step.Payload); err != nil {
+				return nil, invalid("steps", fmt.Sprintf("step %d: %v", step.Order, err))
+			}
+		}
+		if step.Type != domain.StepTypeQuestion {
+			continue
+		}
+		if step.Question == nil {
+			return nil, invalid("steps", fmt.Sprintf("step %d references a missing question", step.Order))
+		}
+		if err := validateQuestion(step.Question, step.Question.Choices); err != nil {
+			return nil, err
+		}
+	}
+
+	level.PublishedRevisionID = nil
+	revision, err := domain.NewLevelRevision(level)
+	if err != nil {
+		return nil, err
+	}
+	revision.PublishedBy = &actorID
+	revision.Note = strings.TrimSpace(note)
+
+	if err := s.levelRepo.PublishRevision(ctx, revision); err != nil {
+		return nil, notFound(err, ErrLevelNotFound)
+	}
+	return revision, nil
+}
+
+func (s *contentService) ListRevisions(ctx context.Context, levelID uint) ([]*domain.LevelRevision, error) {
+	if _, err := s.levelRepo.GetByID(ctx, levelID); err != nil {
+		return nil, notFound(err, ErrLevelNotFound)
+	}
+	return s.levelRepo.ListRevisions(ctx, levelID)
+}
+
+func (s *contentService) GetRevision(ctx context.Context, levelID uint, number int) (*domain.LevelRevision, error) {
+	revision, err := s.levelRepo.GetRevision(ctx, levelID, number)
+	if err != nil {
+		return nil, notFound(err, ErrRevisionNotFound)
+	}
+	return revision, nil
+}
+
+func (s *contentService) RollbackLevel(ctx context.Context, levelID uint, number int, actorID uint) (*domain.LevelRevision, error) {
+	previous, err := s.GetRevision(ctx, levelID, number)
+	if err != nil {
+		return nil, err
+	}
+
+	// История не переписывается: снимок старой ревизии публикуется под новым номером.
+	// Черновик не меняется.
+	revision := &domain.LevelRevision{
+		LevelID:        levelID,
+		Snapshot:       previous.Snapshot,
+		PublishedBy:    &actorID,
+		RolledBackFrom: &previous.Number,
+		Note:           fmt.Sprintf("rollback to revision %d", previous.Number),
+	}
+	if err := s.levelRepo.PublishRevision(ctx, revision); err != nil {
+		return nil, notFound(err, ErrLevelNotFound)
+	}
+	return revision, nil
+}
+
+// validateStep - тип шага, уникальность порядка и ссылка на вопрос
+func (s *contentService) validateStep(ctx context.Context, step *domain.LevelStep) error {
+	if step.Order < 1 {
+		return invalid("order", "must be positive")
+	}
+
+	switch step.Type {
+	case domain.StepTypeQuestion:
+		if step.QuestionID == nil {
+			return invalid("question_id", "is required for question steps")
+		}
+		if _, err := s.questionRepo.GetByID(ctx, *step.QuestionID); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return invalid("question_id", "question does not exist")
+			}
+			return err
+		}
+	case domain.StepTypeText, domain.StepTypeSimulation:
+		if step.QuestionID != nil {
+			return invalid("question_id", "is only allowed for question steps")
+		}
+		if step.Type == domain.StepTypeSimulation {
+			if _, err := s.simulations.ParseSpec(step.Payload); err != nil {
+				return invalid("payload", err.Error())
+			}
+		}
+	default:
+		return invalid("type", "must be one of question, text, simulation")
+	}
+
+	taken, err := s.levelRepo.IsStepOrderTaken(ctx, step.LevelID, step.Order, step.ID)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrStepOrderTaken
+	}
+	return nil
+}
+
+func validateLevel(level *domain.Level) error {
+	level.Title = strings.TrimSpace(level.Title)
+	if level.Title == "" {
+		return invalid("title", "is required")
+	}
+	switch level.Difficulty {
+	case "", domain.DifficultyEasy, domain.DifficultyMedium, domain.DifficultyHard:
+	default:
+		return invalid("difficulty", "must be one of easy, medium, hard")
+	}
+	if level.RewardPoints < 0 {
+		return invalid("reward_points", "must not be negative")
+	}
+	if level.ScoringPolicy != nil {
+		if err := level.ScoringPolicy.Validate(); err != nil {
+			return invalid("scoring_policy", err.Error())
+		}
+	}
+	return nil
+}
+
+// validateQuestion - вопрос и его итоговый набор вариантов: минимум два варианта,
+// хотя бы один верный, и ровно один верный для вопроса с одиночным выбором;
+// частичные режимы оценки — только для множественного выбора
+func validateQuestion(question *domain.Question, choices []domain.Choice) error {
+	if strings.TrimSpace(question.Prompt) == "" {
+		return invalid("prompt", "is required")
+	}
+	if question.Kind == "" {
+		question.Kind = domain.KindChoice
+	}
+	if !question.Kind.Valid() {
+		return invalid("kind", "must be one of choice, numeric, ordering, matching, cloze")
+	}
+	if question.Template != nil {
+		// Варианты и значение ответа шаблонного вопроса строятся при показе в попытке
+		if len(choices) > 0 {
+			return invalid("choices", "are generated for template questions")
+		}
+		if err := question.ValidateTemplate(); err != nil {
+			return invalid("template", err.Error())
+		}
+		question.MinCorrect = 0
+		return nil
+	}
+	if question.Kind != domain.KindChoice {
+		if len(choices) > 0 {
+			return invalid("choices", "are not allowed for "+string(question.Kind)+" questions")
+		}
+		if err := question.ValidateSpec(); err != nil {
+			return invalid("spec", err.Error())
+		}
+		question.MinCorrect = 0
+		return nil
+	}
+	if err := question.ValidateSpec(); err != nil {
+		return invalid("spec", err.Error())
+	}
+	if len(choices) < 2 {
+		return invalid("choices", "at least two choices are required")
+	}
+
+	correct := 0
+	for _, choice := range choices {
+		if strings.TrimSpace(choice.Text) == "" {
+			return invalid("choices", "choice text is required")
+		}
+		if choice.IsCorrect {
+			correct++
+		}
+	}
+	if correct == 0 {
+		return invalid("choices", "at least one choice must be correct")
+	}
+	if !question.MultiSelect && correct > 1 {
+		return invalid("choices", "single-select question must have exactly one correct choice")
+	}
+
+	switch question.GradingMode {
+	case domain.GradingDefault, domain.GradingExact:
+	case domain.GradingPartial, domain.GradingAtLeast:
+		if !question.MultiSelect {
+			return invalid("grading_mode", "partial and at_least grading require a multi-select question")
+		}
+	default:
+		return invalid("grading_mode", "must be one of exact, partial, at_least")
+	}
+	if question.GradingMode == domain.GradingAtLeast {
+		if question.MinCorrect < 1 || question.MinCorrect > correct {
+			return invalid("min_correct", "must be between 1 and the number of correct choices")
+		}
+	} else {
+		question.MinCorrect = 0
+	}
+	return nil
+}
+
+// notFound - замена gorm.ErrRecordNotFound на доменную ошибку
+func notFound(err, target error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return target
+	}
+	return err
+}
+
+type courseService struct {
+	courseRepo repo.CourseRepo
+	levelRepo  repo.LevelRepo
+	txManager  repo.TxManager
+	unlock     *unlockEngine
+}
+
+func NewCourseService(courseRepo repo.CourseRepo, levelRepo repo.LevelRepo, attemptRepo repo.AttemptRepo, txManager repo.TxManager) CourseService {
+	return &courseService{
+		courseRepo: courseRepo,
+		levelRepo:  levelRepo,
+		txManager:  txManager,
+		unlock:     newUnlockEngine(levelRepo, courseRepo, attemptRepo),
+	}
+}
+
+func (s *courseService) ListCourses(ctx context.Context, includeInactive bool) ([]*domain.Course, error) {
+	return s.courseRepo.ListCourses(ctx, includeInactive)
+}
+
+func (s *courseService) GetCourseMap(ctx context.Context, courseID, userID uint) (*CourseMap, error) {
+	course, err := s.courseRepo.GetCourse(ctx, courseID)
+	if err != nil {
+		return nil, notFound(err, ErrCourseNotFound)
+	}
+	if !course.IsActive {
+		return nil, ErrCourseNotFound
+	}
+
+	units, err := s.courseRepo.GetUnits(ctx, courseID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Неактивные и неопубликованные уровни игрокам не показываются; остальные —
+	// в опубликованной версии
+	var levels []*domain.Level
+	for _, unit := range units {
+		for i := range unit.Levels {
+			level := &unit.Levels[i]
+			if !level.IsActive || level.PublishedRevisionID == nil {
+				continue
+			}
+			published, err := publishedLevel(ctx, s.levelRepo, level)
+			if err != nil {
+				return nil, err
+			}
+			levels = append(levels, published)
+		}
+	}
+	progress, err := s.unlock.progress(ctx, userID, levels)
+	if err != nil {
+		return nil, err
+	}
+
+	courseMap := &CourseMap{Course: course, Units: make([]*UnitMap, 0, len(units))}
+	for _, unit := range units {
+		unitMap := &UnitMap{Unit: unit, Levels: []*LevelProgress{}}
+		for _, level := range unit.Levels {
+			if p, ok := progress[level.ID]; ok {
+				unitMap.Levels = append(unitMap.Levels, p)
+			}
+		}
+		courseMap.Units = append(courseMap.Units, unitMap)
+	}
+	return courseMap, nil
+}
+
+func (s *courseService) GetCourse(ctx context.Context, id uint) (*domain.Course, error) {
+	course, err := s.courseRepo.GetCourse(ctx, id)
+	if err != nil {
+		return nil, notFound(err, ErrCourseNotFound)
+	}
+	return course, nil
+}
+
+func (s *courseService) CreateCourse(ctx context.Context, course *domain.Course) error {
+	if err := s.validateCourse(ctx, course); err != nil {
+		return err
+	}
+	course.ID = 0
+	course.Units = nil
+	return s.courseRepo.CreateCourse(ctx, course)
+}
+
+func (s *courseService) UpdateCourse(ctx context.Context, course *domain.Course) error {
+	existing, err := s.courseRepo.GetCourse(ctx, course.ID)
+	if err != nil {
+		return notFound(err, ErrCourseNotFound)
+	}
+	if err := s.validateCourse(ctx, course); err != nil {
+		return err
+	}
+	course.CreatedAt = existing.CreatedAt
+	course.Units = nil
+	return s.courseRepo.UpdateCourse(ctx, course)
+}
+
+func (s *courseService) ListUnits(ctx context.Context, courseID uint) ([]*domain.Unit, error) {
+	if _, err := s.courseRepo.GetCourse(ctx, courseID); err != nil {
+		return nil, notFound(err, ErrCourseNotFound)
+	}
+	return s.courseRepo.GetUnits(ctx, courseID)
+}
+
+func (s *courseService) CreateUnit(ctx context.Context, unit *domain.Unit) error {
+	if _, err := s.courseRepo.GetCourse(ctx, unit.CourseID); err != nil {
+		return notFound(err, ErrCourseNotFound)
+	}
+	unit.Title = strings.TrimSpace(unit.Title)
+	if unit.Title == "" {
+		return invalid("title", "is required")
+	}
+	unit.ID = 0
+	unit.Levels = nil
+	return s.courseRepo.CreateUnit(ctx, unit)
+}
+
+func (s *courseService) UpdateUnit(ctx context.Context, unit *domain.Unit) error {
+	existing, err := s.courseRepo.GetUnit(ctx, unit.ID)
+	if err != nil {
+		return notFound(err, ErrUnitNotFound)
+	}
+	unit.Title = strings.TrimSpace(unit.Title)
+	if unit.Title == "" {
+		return invalid("title", "is required")
+	}
+	unit.CourseID = existing.CourseID
+	unit.CreatedAt = existing.CreatedAt
+	unit.Levels = nil
+	return s.courseRepo.UpdateUnit(ctx, unit)
+}
+
+func (s *courseService) PlaceLevel(ctx context.Context, levelID uint, unitID *uint, order int) error {
+	if unitID != nil {
+		if _, err := s.courseRepo.GetUnit(ctx, *unitID); err != nil {
+			return notFound(err, ErrUnitNotFound)
+		}
+	}
+	if order < 0 {
+		return invalid("order", "must not be negative")
+	}
+	return notFound(s.courseRepo.PlaceLevel(ctx, levelID, unitID, order), ErrLevelNotFound)
+}
+
+func (s *courseService) GetPrerequisites(ctx context.Context, levelID uint) ([]*domain.LevelPrerequisite, error) {
+	if _, err := s.levelRepo.GetByID(ctx, levelID); err != nil {
+		return nil, notFound(err, ErrLevelNotFound)
+	}
+	return s.courseRepo.GetPrerequisites(ctx, []uint{levelID})
+}
+
+func (s *courseService) SetPrerequisites(ctx context.Context, levelID uint, prerequisites []*domain.LevelPrerequisite) ([]*domain.LevelPrerequisite, error) {
+	if _, err := s.levelRepo.GetByID(ctx, levelID); err != nil {
+		return nil, notFound(err, ErrLevelNotFound)
+	}
+
+	seen := make(map[uint]bool, len(prerequisites))
+	for _, prerequisite := range prerequisites {
+		switch {
+		case prerequisite.RequiredLevelID == levelID:
+			return nil, invalid("prerequisites", "level cannot require itself")
+		case seen[prerequisite.RequiredLevelID]:
+			return nil, invalid("prerequisites", "duplicate required level")
+		case prerequisite.MinScore < 0 || prerequisite.MinScore > 100:
+			return nil, invalid("prerequisites", "min_score must be between 0 and 100")
+		}
+		seen[prerequisite.RequiredLevelID] = true
+
+		if _, err := s.levelRepo.GetByID(ctx, prerequisite.RequiredLevelID); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, invalid("prerequisites", fmt.Sprintf("required level %d not found", prerequisite.RequiredLevelID))
+			}
+			return nil, err
+		}
+	}
+
+	// Проверка на цикл и замена идут под блокировкой графа: иначе две параллельные
+	// правки могли бы пройти проверку по одному снимку и вместе замкнуть цикл
+	err := s.txManager.WithinTx(ctx, func(ctx context.Context) error {
+		if err := s.courseRepo.LockPrerequisites(ctx); err != nil {
+			return err
+		}
+		edges, err := s.courseRepo.ListPrerequisites(ctx)
+		if err != nil {
+			return err
+		}
+		if createsCycle(edges, levelID, prerequisites) {
+			return ErrPrerequisiteCycle
+		}
+		return s.courseRepo.ReplacePrerequisites(ctx, levelID, prerequisites)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.courseRepo.GetPrerequisites(ctx, []uint{levelID})
+}
+
+func (s *courseService) validateCourse(ctx context.Context, course *domain.Course) error {
+	course.Title = strings.TrimSpace(course.Title)
+	if course.Title == "" {
+		return invalid("title", "is required")
+	}
+	if !slugPattern.MatchString(course.Slug) || len(course.Slug) > 255 {
+		return invalid("slug", "must contain only lowercase letters, digits, '.', '-' and '_'")
+	}
+
+	courses, err := s.courseRepo.ListCourses(ctx, true)
+	if err != nil {
+		return err
+	}
+	for _, other := range courses {
+		if other.Slug == course.Slug && other.ID != course.ID {
+			return invalid("slug", "is already taken")
+		}
+	}
+	return nil
+}
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// createsCycle - появится ли цикл, если зависимости levelID заменить на prerequisites.
+// Цикл возникает, когда из какого-либо нового требуемого уровня по существующим
+// ребрам (уровень -> требуемый уровень) достижим сам levelID.
+func createsCycle(edges []*domain.LevelPrerequisite, levelID uint, prerequisites []*domain.LevelPrerequisite) bool {
+	graph := make(map[uint][]uint)
+	for _, edge := range edges {
+		if edge.LevelID != levelID {
+			graph[edge.LevelID] = append(graph[edge.LevelID], edge.RequiredLevelID)
+		}
+	}
+
+	visited := make(map[uint]bool)
+	stack := make([]uint, 0, len(prerequisites))
+	for _, prerequisite := range prerequisites {
+		stack = append(stack, prerequisite.RequiredLevelID)
+	}
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if id == levelID {
+			return true
+		}
+		if visited[id] {
+			continue
+		}
+		visited[id] = true
+		stack = append(stack, graph[id]...)
+	}
+	return false
+}
+
+type attemptService struct {
+	attemptRepo  repo.AttemptRepo
+	levelRepo    repo.LevelRepo
+	questionRepo repo.QuestionRepo
 	rewardTxRepo repo.RewardTxRepo
+	txManager    repo.TxManager
 	userService  UserService
+	achievements AchievementService
+	unlock       *unlockEngine
+	simulations  *simulation.Engine
 }
 
-func NewAttemptService(attemptRepo repo.AttemptRepo, levelRepo repo.LevelRepo, questionRepo repo.QuestionRepo, rewardTxRepo repo.RewardTxRepo, userService UserService) AttemptService {
+func NewAttemptService(attemptRepo repo.AttemptRepo, levelRepo repo.LevelRepo, questionRepo repo.QuestionRepo, rewardTxRepo repo.RewardTxRepo, courseRepo repo.CourseRepo, txManager repo.TxManager, userService UserService, achievements AchievementService, simulations *simulation.Engine) AttemptService {
 	return &attemptService{
 		attemptRepo:  attemptRepo,
 		levelRepo:    levelRepo,
 		questionRepo: questionRepo,
 		rewardTxRepo: rewardTxRepo,
+		txManager:    txManager,
 		userService:  userService,
+		achievements: achievements,
+		unlock:       newUnlockEngine(levelRepo, courseRepo, attemptRepo),
+		simulations:  simulations,
 	}
 }
 
@@ -414,49 +2122,25 @@ func (s *attemptService) StartAttempt(ctx context.Context, userID, levelID uint)
 	level, err := s.levelRepo.GetByID(ctx, levelID)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("level not found")
+			return nil, ErrLevelNotFound
 		}
 		return nil, err
 	}
 	if !level.IsActive {
-		return nil, errors.New("level is not active")
+		return nil, ErrLevelInactive
+	}
+	// Попытка возможна только на опубликованной версии уровня
+	if level.PublishedRevisionID == nil {
+		return nil, ErrLevelNotFound
 	}
 
-	// Доп. проверка: заблокирован ли уровень (нельзя начать, пока не завершен предыдущий)
-	// Определяем предыдущий активный уровень
-	levels, err := s.levelRepo.GetAll(ctx)
-	if err == nil {
-		var activeLevels []*domain.Level
-		for _, l := range levels {
-			if l.IsActive {
-				activeLevels = append(activeLevels, l)
-			}
-		}
-		// Найдем позицию текущего уровня среди активных
-		idx := -1
-		for i, l := range activeLevels {
-			if l.ID == levelID {
-				idx = i
-				break
-			}
-		}
-		if idx > 0 {
-			prev := activeLevels[idx-1]
-			// Проверяем завершение предыдущего уровня
-			userAttempts, err2 := s.attemptRepo.GetByUserID(ctx, userID)
-			if err2 == nil {
-				prevCompleted := false
-				for _, a := range userAttempts {
-					if a.LevelID == prev.ID && a.Status == domain.AttemptStatus("completed") && a.ResultScore >= 70 {
-						prevCompleted = true
-						break
-					}
-				}
-				if !prevCompleted {
-					return nil, errors.New("previous level not completed")
-				}
-			}
-		}
+	// Уровень заблокирован, пока не пройдены его зависимости
+	available, err := s.unlock.isAvailable(ctx, userID, level)
+	if err != nil {
+		return nil, err
+	}
+	if !available {
+		return nil, ErrLevelLocked
 	}
 
 	// Проверяем, нет ли уже активной попытки для этого уровня
@@ -465,32 +2149,31 @@ func (s *attemptService) StartAttempt(ctx context.Context, userID, levelID uint)
 		return nil, err
 	}
 	if existingAttempt != nil {
-		// Санитарная проверка на "застрявшие" попытки: если нет больше вопросов, но статус in_progress — отменяем и создаем новую
-		if existingAttempt.Status == domain.AttemptInProgress {
-			if _, err := s.GetNextQuestion(ctx, existingAttempt.ID); err != nil {
-				if err.Error() == "no more questions" {
-					// отменяем попытку и продолжаем создание новой
-					_ = s.CancelAttempt(ctx, existingAttempt.ID, userID)
-				} else {
-					// при других ошибках возвращаем существующую, чтобы не терять прогресс
-					return existingAttempt, nil
-				}
-			} else {
-				// есть следующий вопрос — возвращаем текущую активную попытку
-				return existingAttempt, nil
-			}
-		} else {
+		if existingAttempt.Status != domain.AttemptInProgress {
 			return existingAttempt, nil // уже завершена/failed — вернем
 		}
+		// Санитарная проверка на "застрявшие" попытки: все шаги пройдены, но статус
+		// in_progress — отменяем и создаем новую. При ошибках проверки возвращаем
+		// существующую, чтобы не терять прогресс
+		finished, err := s.stepsFinished(ctx, existingAttempt.ID)
+		if err != nil || !finished {
+			return existingAttempt, nil
+		}
+		if err := s.CancelAttempt(ctx, existingAttempt.ID, userID); err != nil {
+			return nil, fmt.Errorf("cancel stuck attempt %d: %w", existingAttempt.ID, err)
+		}
 	}
 
 	// Создаем новую попытку
+	// Попытка закрепляется за опубликованной ревизией: правки черновика ее не затронут
 	attempt := &domain.Attempt{
-		UserID:      userID,
-		LevelID:     levelID,
-		Status:      "in_progress",
-		ResultScore: 0,
-		StartedAt:   time.Now(),
+		UserID:          userID,
+		LevelID:         levelID,
+		Status:          "in_progress",
+		ResultScore:     0,
+		StartedAt:       time.Now(),
+		LevelRevisionID: level.PublishedRevisionID,
+		Seed:            newAttemptSeed(),
 	}
 
 	err = s.attemptRepo.Create(ctx, attempt)
@@ -501,140 +2184,514 @@ func (s *attemptService) StartAttempt(ctx context.Context, userID, levelID uint)
 	return attempt, nil
 }
 
-func (s *attemptService) GetNextQuestion(ctx context.Context, attemptID uint) (*domain.Question, error) {
+func (s *attemptService) GetNextQuestion(ctx context.Context, userID, attemptID uint) (*domain.Question, error) {
+	// Получаем попытку
+	attempt, err := s.ownedAttempt(ctx, userID, attemptID)
+	if err != nil {
+		return nil, err
+	}
+
+	if attempt.Status != "in_progress" {
+		return nil, ErrAttemptCompleted
+	}
+
+	// Получаем уровень с шагами
+	level, err := s.attemptLevel(ctx, attempt)
+	if err != nil {
+		return nil, err
+	}
+
+	// Получаем уже отвеченные шаги
+	answeredSteps, err := s.attemptRepo.GetSteps(ctx, attemptID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Создаем карту отвеченных шагов
+	answeredMap := make(map[uint]bool)
+	for _, step := range answeredSteps {
+		if step.LevelStepID != 0 {
+			answeredMap[step.LevelStepID] = true
+		}
+	}
+
+	// Находим первый неотвеченный вопрос
+	for _, step := range level.Steps {
+		if step.Type == "question" && !answeredMap[step.ID] && step.Question != nil {
+			// Шаблонный вопрос показывается со значениями этой попытки
+			question, _, err := step.Question.Instantiate(attempt.QuestionSeed(step.Question.ID))
+			if err != nil {
+				return nil, err
+			}
+			return question, s.markServed(ctx, attempt, step.ID)
+		}
+	}
+
+	return nil, ErrNoMoreQuestions
+}
+
+func (s *attemptService) AnswerQuestion(ctx context.Context, userID, attemptID, questionID uint, answer domain.Answer) (*AnswerResult, error) {
 	// Получаем попытку
+	attempt, err := s.ownedAttempt(ctx, userID, attemptID)
+	if err != nil {
+		return nil, err
+	}
+
+	if attempt.Status != "in_progress" {
+		return nil, ErrAttemptCompleted
+	}
+
+	// Получаем вопрос с правильными ответами из той версии уровня, на которой идет попытка
+	level, err := s.attemptLevel(ctx, attempt)
+	if err != nil {
+		return nil, err
+	}
+	levelStep, question := levelQuestion(level, questionID)
+	if question == nil {
+		return nil, invalid("question_id", "question is not part of the attempt's level")
+	}
+	return s.recordAnswer(ctx, attempt, level, levelStep, answer)
+}
+
+// recordAnswer - оценка ответа на вопрос шага levelStep и запись шага попытки
+func (s *attemptService) recordAnswer(ctx context.Context, attempt *domain.Attempt, level *domain.Level, levelStep *domain.LevelStep, answer domain.Answer) (*AnswerResult, error) {
+	questionID := levelStep.Question.ID
+	question, instance, err := levelStep.Question.Instantiate(attempt.QuestionSeed(questionID))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAnswerChoices(question, answer); err != nil {
+		return nil, err
+	}
+
+	// Оцениваем ответ в режиме вопроса (по умолчанию — по политике уровня)
+	mode := question.Grading(level.Scoring())
+	grade := question.Grade(answer, mode)
+
+	// Создаем или обновляем шаг попытки
+	responseData := map[string]interface{}{
+		"question_id": questionID,
+		"choice_ids":  answer.ChoiceIDs,
+		"answered_at": time.Now(),
+	}
+	if answer.Number != nil {
+		responseData["number"] = *answer.Number
+	}
+	if len(answer.Order) > 0 {
+		responseData["order"] = answer.Order
+	}
+	if len(answer.Matches) > 0 {
+		responseData["matches"] = answer.Matches
+	}
+	if len(answer.Blanks) > 0 {
+		responseData["blanks"] = answer.Blanks
+	}
+	if instance != nil {
+		// Зерно и значения позволяют восстановить вопрос таким, каким его видел игрок
+		responseData["seed"] = instance.Seed
+		responseData["values"] = instance.Values
+	}
+	responseJSON, _ := json.Marshal(responseData)
+
+	attemptStep := &domain.AttemptStep{
+		AttemptID:   attempt.ID,
+		LevelStepID: levelStep.ID,
+		QuestionID:  &questionID,
+		StepOrder:   len(attempt.Steps) + 1,
+		Response:    datatypes.JSON(responseJSON),
+		Correct:     grade.Correct,
+		Score:       grade.Score,
+	}
+
+	err = s.recordStep(ctx, attempt, attemptStep)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AnswerResult{
+		Correct:     grade.Correct,
+		Score:       grade.Score,
+		Mode:        mode,
+		Explanation: question.Explanation,
+		Choices:     grade.Choices,
+		Parts:       grade.Parts,
+	}, nil
+}
+
+func (s *attemptService) CurrentStep(ctx context.Context, userID, attemptID uint) (*StepEnvelope, error) {
+	attempt, level, err := s.flowContext(ctx, userID, attemptID)
+	if err != nil {
+		return nil, err
+	}
+	envelope, err := s.currentStep(attempt, level)
+	if err != nil || envelope == nil {
+		return nil, err
+	}
+	return envelope, s.markServed(ctx, attempt, envelope.Step.ID)
+}
+
+func (s *attemptService) AdvanceStep(ctx context.Context, userID, attemptID, stepID uint, input StepInput) (*StepAdvance, error) {
+	attempt, level, err := s.flowContext(ctx, userID, attemptID)
+	if err != nil {
+		return nil, err
+	}
+	if attempt.Status != "in_progress" {
+		return nil, ErrAttemptCompleted
+	}
+
+	// Шаги проходятся строго по порядку: продвинуть можно только текущий
+	current, err := s.currentStep(attempt, level)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil || current.Step.ID != stepID {
+		return nil, ErrStepNotCurrent
+	}
+
+	result := &StepAdvance{}
+	step := current.Step
+	switch step.Type {
+	case domain.StepTypeQuestion:
+		result.Answer, err = s.recordAnswer(ctx, attempt, level, step, input.Answer)
+	case domain.StepTypeSimulation:
+		result.Simulation, err = s.recordTurn(ctx, attempt, step, lastSimulationTurns(attempt.Steps)[step.ID], input.Decision)
+	default:
+		err = s.recordRead(ctx, attempt, step)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Следующий шаг считается по обновленной попытке
+	attempt, err = s.attemptRepo.GetByID(ctx, attemptID)
+	if err != nil {
+		return nil, err
+	}
+	result.Next, err = s.currentStep(attempt, level)
+	if err != nil {
+		return nil, err
+	}
+	if result.Next != nil {
+		if err := s.markServed(ctx, attempt, result.Next.Step.ID); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// stepsFinished - пройдены ли все шаги попытки (тексты, вопросы и симуляции).
+// В отличие от CurrentStep показ шага не отмечается
+func (s *attemptService) stepsFinished(ctx context.Context, attemptID uint) (bool, error) {
 	attempt, err := s.attemptRepo.GetByID(ctx, attemptID)
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("attempt not found")
+		return false, err
+	}
+	level, err := s.attemptLevel(ctx, attempt)
+	if err != nil {
+		return false, err
+	}
+	current, err := s.currentStep(attempt, level)
+	if err != nil {
+		return false, err
+	}
+	return current == nil, nil
+}
+
+// flowContext - попытка и уровень в той версии, на которой она начата
+func (s *attemptService) flowContext(ctx context.Context, userID, attemptID uint) (*domain.Attempt, *domain.Level, error) {
+	attempt, err := s.ownedAttempt(ctx, userID, attemptID)
+	if err != nil {
+		return nil, nil, err
+	}
+	level, err := s.attemptLevel(ctx, attempt)
+	if err != nil {
+		return nil, nil, err
+	}
+	return attempt, level, nil
+}
+
+// currentStep - первый непройденный шаг уровня. Текст пройден после прочтения, вопрос —
+// после любого ответа, симуляция — после последнего хода
+func (s *attemptService) currentStep(attempt *domain.Attempt, level *domain.Level) (*StepEnvelope, error) {
+	recorded := make(map[uint]bool)
+	for _, step := range attempt.Steps {
+		recorded[step.LevelStepID] = true
+	}
+	turns := lastSimulationTurns(attempt.Steps)
+
+	steps := make([]*domain.LevelStep, 0, len(level.Steps))
+	for i := range level.Steps {
+		steps = append(steps, &level.Steps[i])
+	}
+	sort.SliceStable(steps, func(i, j int) bool { return steps[i].Order < steps[j].Order })
+
+	for i, step := range steps {
+		envelope := &StepEnvelope{Step: step, Index: i + 1, Total: len(steps)}
+		switch step.Type {
+		case domain.StepTypeQuestion:
+			if recorded[step.ID] || step.Question == nil {
+				continue
+			}
+			question, _, err := step.Question.Instantiate(attempt.QuestionSeed(step.Question.ID))
+			if err != nil {
+				return nil, err
+			}
+			envelope.Question = question
+		case domain.StepTypeSimulation:
+			result, _, err := s.simulationResult(step, turns[step.ID])
+			if err != nil {
+				return nil, err
+			}
+			if result.State.Done {
+				continue
+			}
+			envelope.Simulation = result
+		default:
+			if recorded[step.ID] {
+				continue
+			}
+		}
+		return envelope, nil
+	}
+	return nil, nil
+}
+
+// recordRead - отметка о прочтении текстового шага
+func (s *attemptService) recordRead(ctx context.Context, attempt *domain.Attempt, step *domain.LevelStep) error {
+	responseJSON, _ := json.Marshal(map[string]interface{}{
+		"read":        true,
+		"answered_at": time.Now(),
+	})
+	return s.recordStep(ctx, attempt, &domain.AttemptStep{
+		AttemptID:   attempt.ID,
+		LevelStepID: step.ID,
+		StepOrder:   len(attempt.Steps) + 1,
+		Response:    datatypes.JSON(responseJSON),
+	})
+}
+
+// recordStep - запись шага попытки со временем показа и ответа; отметка о показанном
+// шаге сбрасывается, чтобы следующий показ (в том числе следующий ход симуляции) отсчитывался заново
+func (s *attemptService) recordStep(ctx context.Context, attempt *domain.Attempt, step *domain.AttemptStep) error {
+	servedAt, answeredAt := stepTiming(attempt, step.LevelStepID)
+	step.ServedAt = &servedAt
+	step.AnsweredAt = &answeredAt
+	step.DurationMs = answeredAt.Sub(servedAt).Milliseconds()
+	if err := s.attemptRepo.AddStep(ctx, step); err != nil {
+		return err
+	}
+	if attempt.ServedStepID == nil {
+		return nil
+	}
+	attempt.ServedStepID, attempt.ServedAt = nil, nil
+	return s.attemptRepo.SetServed(ctx, attempt.ID, nil, nil)
+}
+
+// markServed - отметка о показе шага; повторный показ того же шага время не сдвигает
+func (s *attemptService) markServed(ctx context.Context, attempt *domain.Attempt, levelStepID uint) error {
+	if attempt.ServedStepID != nil && *attempt.ServedStepID == levelStepID {
+		return nil
+	}
+	now := time.Now()
+	attempt.ServedStepID, attempt.ServedAt = &levelStepID, &now
+	return s.attemptRepo.SetServed(ctx, attempt.ID, &levelStepID, &now)
+}
+
+// stepTiming - время показа и ответа на шаг. Если показ шага не был отмечен (клиент
+// отвечает, не запрашивая шаг), временем показа считается последний записанный шаг
+// попытки или ее начало
+func stepTiming(attempt *domain.Attempt, levelStepID uint) (servedAt, answeredAt time.Time) {
+	answeredAt = time.Now()
+	if attempt.ServedStepID != nil && *attempt.ServedStepID == levelStepID && attempt.ServedAt != nil {
+		servedAt = *attempt.ServedAt
+	} else {
+		servedAt = attempt.StartedAt
+		for _, step := range attempt.Steps {
+			if step.CreatedAt.After(servedAt) {
+				servedAt = step.CreatedAt
+			}
+		}
+	}
+	if servedAt.After(answeredAt) {
+		servedAt = answeredAt
+	}
+	return servedAt, answeredAt
+}
+
+func (s *attemptService) ListSimulations(ctx context.Context, userID, attemptID uint) ([]*SimulationResult, error) {
+	_, level, turns, err := s.simulationContext(ctx, userID, attemptID)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*SimulationResult
+	for i := range level.Steps {
+		step := &level.Steps[i]
+		if step.Type != domain.StepTypeSimulation {
+			continue
+		}
+		result, _, err := s.simulationResult(step, turns[step.ID])
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (s *attemptService) GetSimulation(ctx context.Context, userID, attemptID, stepID uint) (*SimulationResult, error) {
+	_, level, turns, err := s.simulationContext(ctx, userID, attemptID)
+	if err != nil {
+		return nil, err
+	}
+	step := simulationStep(level, stepID)
+	if step == nil {
+		return nil, ErrStepNotFound
+	}
+	result, _, err := s.simulationResult(step, turns[stepID])
+	return result, err
+}
+
+func (s *attemptService) AdvanceSimulation(ctx context.Context, userID, attemptID, stepID uint, decision json.RawMessage) (*SimulationResult, error) {
+	attempt, level, turns, err := s.simulationContext(ctx, userID, attemptID)
+	if err != nil {
+		return nil, err
+	}
+	if attempt.Status != "in_progress" {
+		return nil, ErrAttemptCompleted
+	}
+	step := simulationStep(level, stepID)
+	if step == nil {
+		return nil, ErrStepNotFound
+	}
+	// Как и в AdvanceStep, ходить можно только в текущем шаге попытки;
+	// ход в завершенной симуляции отклоняет сам симулятор (simulation.ErrFinished)
+	last, _, err := s.simulationResult(step, turns[stepID])
+	if err != nil {
+		return nil, err
+	}
+	if !last.State.Done {
+		current, err := s.currentStep(attempt, level)
+		if err != nil {
+			return nil, err
+		}
+		if current == nil || current.Step.ID != stepID {
+			return nil, ErrStepNotCurrent
 		}
-		return nil, err
-	}
-
-	if attempt.Status != "in_progress" {
-		return nil, errors.New("attempt is not in progress")
 	}
+	return s.recordTurn(ctx, attempt, step, turns[stepID], decision)
+}
 
-	// Получаем уровень с шагами
-	level, err := s.levelRepo.GetWithSteps(ctx, attempt.LevelID)
+// recordTurn - ход симуляции шага step после хода last и запись шага попытки
+func (s *attemptService) recordTurn(ctx context.Context, attempt *domain.Attempt, step *domain.LevelStep, last *domain.AttemptStep, decision json.RawMessage) (*SimulationResult, error) {
+	current, spec, err := s.simulationResult(step, last)
 	if err != nil {
 		return nil, err
 	}
 
-	// Получаем уже отвеченные шаги
-	answeredSteps, err := s.attemptRepo.GetSteps(ctx, attemptID)
+	state, err := s.simulations.Advance(spec, current.State, decision)
 	if err != nil {
 		return nil, err
 	}
+	result := &SimulationResult{StepID: step.ID, Title: step.Title, Simulator: spec.Simulator, State: state}
 
-	// Создаем карту отвеченных шагов
-	answeredMap := make(map[uint]bool)
-	for _, step := range answeredSteps {
-		if step.LevelStepID != 0 {
-			answeredMap[step.LevelStepID] = true
-		}
+	// Каждый ход сохраняется отдельным шагом попытки; последний содержит текущее состояние,
+	// а после завершения — оценку по целям
+	responseJSON, _ := json.Marshal(map[string]interface{}{
+		"decision":    decision,
+		"state":       state,
+		"answered_at": time.Now(),
+	})
+	turn := &domain.AttemptStep{
+		AttemptID:   attempt.ID,
+		LevelStepID: step.ID,
+		StepOrder:   len(attempt.Steps) + 1,
+		Response:    datatypes.JSON(responseJSON),
 	}
-
-	// Находим первый неотвеченный вопрос
-	for _, step := range level.Steps {
-		if step.Type == "question" && !answeredMap[step.ID] {
-			if step.QuestionID != nil {
-				// Получаем вопрос с вариантами ответов
-				question, err := s.questionRepo.GetWithChoices(ctx, *step.QuestionID)
-				if err != nil {
-					return nil, err
-				}
-				return question, nil
-			}
-		}
+	if state.Done {
+		outcome := spec.Evaluate(state)
+		result.Outcome = &outcome
+		turn.Correct = outcome.Passed
+		turn.Score = outcome.Score
 	}
-
-	return nil, errors.New("no more questions")
+	if err := s.recordStep(ctx, attempt, turn); err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
-func (s *attemptService) AnswerQuestion(ctx context.Context, attemptID, questionID uint, choiceIDs []uint) (bool, string, error) {
-	// Получаем попытку
-	attempt, err := s.attemptRepo.GetByID(ctx, attemptID)
+// simulationContext - попытка, ее уровень и последний ход по каждому шагу-симуляции
+func (s *attemptService) simulationContext(ctx context.Context, userID, attemptID uint) (*domain.Attempt, *domain.Level, map[uint]*domain.AttemptStep, error) {
+	attempt, err := s.ownedAttempt(ctx, userID, attemptID)
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return false, "", errors.New("attempt not found")
-		}
-		return false, "", err
+		return nil, nil, nil, err
 	}
-
-	if attempt.Status != "in_progress" {
-		return false, "", errors.New("attempt is not in progress")
+	level, err := s.attemptLevel(ctx, attempt)
+	if err != nil {
+		return nil, nil, nil, err
 	}
+	return attempt, level, lastSimulationTurns(attempt.Steps), nil
+}
 
-	// Получаем вопрос с правильными ответами
-	question, err := s.questionRepo.GetWithChoices(ctx, questionID)
+// simulationResult - текущее состояние шага по последнему ходу (без ходов — начальное)
+func (s *attemptService) simulationResult(step *domain.LevelStep, last *domain.AttemptStep) (*SimulationResult, *simulation.Spec, error) {
+	spec, err := s.simulations.ParseSpec(step.Payload)
 	if err != nil {
-		return false, "", err
+		return nil, nil, err
 	}
-
-	// Получаем правильные варианты ответов
-	var correctChoiceIDs []uint
-	for _, choice := range question.Choices {
-		if choice.IsCorrect {
-			correctChoiceIDs = append(correctChoiceIDs, choice.ID)
-		}
+	result := &SimulationResult{StepID: step.ID, Title: step.Title, Simulator: spec.Simulator}
+	if last == nil {
+		result.State, err = s.simulations.Start(spec)
+		return result, spec, err
 	}
 
-	// Проверяем правильность ответа
-	isCorrect := compareChoiceIDs(choiceIDs, correctChoiceIDs)
-
-	// Находим LevelStepID для этого вопроса
-	level, err := s.levelRepo.GetWithSteps(ctx, attempt.LevelID)
-	if err != nil {
-		return false, "", err
+	var response struct {
+		State *simulation.State `json:"state"`
 	}
-
-	var levelStepID uint
-	for _, step := range level.Steps {
-		if step.Type == "question" && step.QuestionID != nil && *step.QuestionID == questionID {
-			levelStepID = step.ID
-			break
-		}
+	if err := json.Unmarshal(last.Response, &response); err != nil || response.State == nil {
+		return nil, nil, fmt.Errorf("simulation step %d has a corrupted state", step.ID)
 	}
-
-	// Создаем или обновляем шаг попытки
-	responseData := map[string]interface{}{
-		"question_id": questionID,
-		"choice_ids":  choiceIDs,
-		"answered_at": time.Now(),
+	result.State = response.State
+	if result.State.Done {
+		outcome := spec.Evaluate(result.State)
+		result.Outcome = &outcome
 	}
-	responseJSON, _ := json.Marshal(responseData)
+	return result, spec, nil
+}
 
-	attemptStep := &domain.AttemptStep{
-		AttemptID:   attemptID,
-		LevelStepID: levelStepID,
-		QuestionID:  &questionID,
-		StepOrder:   len(attempt.Steps) + 1,
-		Response:    datatypes.JSON(responseJSON),
-		Correct:     isCorrect,
-		DurationMs:  0, // Можно добавить подсчет времени
+// lastSimulationTurns - последний ход по каждому шагу-симуляции (шаги попытки без вопроса)
+func lastSimulationTurns(steps []domain.AttemptStep) map[uint]*domain.AttemptStep {
+	turns := make(map[uint]*domain.AttemptStep)
+	for i := range steps {
+		step := &steps[i]
+		if step.QuestionID != nil {
+			continue
+		}
+		if last, ok := turns[step.LevelStepID]; !ok || step.StepOrder > last.StepOrder {
+			turns[step.LevelStepID] = step
+		}
 	}
+	return turns
+}
 
-	err = s.attemptRepo.AddStep(ctx, attemptStep)
-	if err != nil {
-		return false, "", err
+func simulationStep(level *domain.Level, stepID uint) *domain.LevelStep {
+	for i := range level.Steps {
+		if level.Steps[i].ID == stepID && level.Steps[i].Type == domain.StepTypeSimulation {
+			return &level.Steps[i]
+		}
 	}
-
-	return isCorrect, question.Explanation, nil
+	return nil
 }
 
-func (s *attemptService) CompleteAttempt(ctx context.Context, attemptID uint) (*AttemptResult, error) {
+func (s *attemptService) CompleteAttempt(ctx context.Context, userID, attemptID uint) (*AttemptResult, error) {
 	// Получаем попытку
-	attempt, err := s.attemptRepo.GetByID(ctx, attemptID)
+	attempt, err := s.ownedAttempt(ctx, userID, attemptID)
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("attempt not found")
-		}
 		return nil, err
 	}
 
 	if attempt.Status != "in_progress" {
-		return nil, errors.New("attempt is already completed")
+		return nil, ErrAttemptCompleted
 	}
 
 	// Получаем все шаги попытки
@@ -662,18 +2719,19 @@ func (s *attemptService) CompleteAttempt(ctx context.Context, attemptID uint) (*
 		}
 	}
 
-	// Общее число вопросов берем из структуры уровня
+	// Общее число вопросов берем из структуры уровня; каждая симуляция считается
+	// отдельным заданием с вкладом, равным доле выполненных целей
+	// Ошибку загрузки возвращаем до транзакции: завершение без уровня посчитало бы
+	// результат по умолчанию и закрыло попытку без возможности повтора
+	lvl, err := s.attemptLevel(ctx, attempt)
+	if err != nil {
+		return nil, err
+	}
 	totalQuestions := 0
-	lvl, _ := s.levelRepo.GetWithSteps(ctx, attempt.LevelID)
-	if lvl != nil {
-		for _, st := range lvl.Steps {
-			if st.Type == "question" && st.QuestionID != nil {
-				totalQuestions++
-			}
+	for _, st := range lvl.Steps {
+		if st.Type == "question" && st.QuestionID != nil || st.Type == domain.StepTypeSimulation {
+			totalQuestions++
 		}
-	} else {
-		// fallback: количество уникальных вопросов из шагов попытки
-		totalQuestions = len(lastByQuestion)
 	}
 
 	correctAnswers := 0
@@ -687,10 +2745,11 @@ func (s *attemptService) CompleteAttempt(ctx context.Context, attemptID uint) (*
 		}
 	}
 
-	// Для расчета точности в стиле Duolingo: каждый вопрос дает вклад от 0 до 1
-	// в зависимости от количества ошибок до первого правильного ответа.
-	// Фактор: 1.0 без ошибок; 0.7 при 1 ошибке; 0.4 при 2 ошибках; 0.1 при 3; 0 при >=4.
-	// Можно настроить формулой: max(0, 1 - 0.3*m), и затем ограничить минимумом 0.1 для m=3.
+	// Правила подсчета — из той версии уровня, на которой шла попытка
+	policy := lvl.Scoring()
+
+	// Точность в стиле Duolingo: каждый вопрос дает вклад от 0 до 1 в зависимости
+	// от количества ошибок до первого правильного ответа (кривая штрафов политики)
 	contributionSum := 0.0
 
 	// Собираем все шаги по вопросу для подсчета количества ошибок до первого правильного
@@ -704,9 +2763,10 @@ func (s *attemptService) CompleteAttempt(ctx context.Context, attemptID uint) (*
 	}
 
 	for qid, qSteps := range stepsByQuestion {
-		// Сортировка уже по step_order ASC у нас в выборке, но на всякий случай
-		// порядок сохранен, так как GetSteps делает Order("step_order ASC").
-		mistakes := 0
+		_, question := levelQuestion(lvl, qid)
+
+		// Порядок сохранен, так как GetSteps делает Order("step_order ASC")
+		mistakes := 0.0
 		firstCorrectFound := false
 		var lastStep *domain.AttemptStep
 		for _, st := range qSteps {
@@ -715,30 +2775,19 @@ func (s *attemptService) CompleteAttempt(ctx context.Context, attemptID uint) (*
 				firstCorrectFound = true
 				break
 			}
-			mistakes++
+			mistakes += 1 - st.Score // частично засчитанный ответ — дробная ошибка
 		}
 
 		if !firstCorrectFound {
 			// Нет правильного ответа — добавляем в список ошибок
-			question, err := s.questionRepo.GetWithChoices(ctx, qid)
+			var err error
+			if question == nil {
+				question, err = s.questionRepo.GetWithChoices(ctx, qid)
+			}
+			if err == nil {
+				question, _, err = question.Instantiate(attempt.QuestionSeed(qid))
+			}
 			if err == nil && lastStep != nil {
-				var responseData map[string]interface{}
-				json.Unmarshal(lastStep.Response, &responseData)
-
-				var yourChoiceIDs []uint
-				if raw, ok := responseData["choice_ids"]; ok {
-					switch v := raw.(type) {
-					case []interface{}:
-						for _, id := range v {
-							if idFloat, ok := id.(float64); ok {
-								yourChoiceIDs = append(yourChoiceIDs, uint(idFloat))
-							}
-						}
-					case []uint:
-						yourChoiceIDs = v
-					}
-				}
-
 				var correctChoiceIDs []uint
 				for _, choice := range question.Choices {
 					if choice.IsCorrect {
@@ -746,25 +2795,37 @@ func (s *attemptService) CompleteAttempt(ctx context.Context, attemptID uint) (*
 					}
 				}
 
-				wrongQuestions = append(wrongQuestions, &WrongQuestion{
+				yourAnswer := responseAnswer(lastStep)
+				wrongQuestion := &WrongQuestion{
 					QuestionID:       qid,
 					Prompt:           question.Prompt,
-					YourChoiceIDs:    yourChoiceIDs,
+					Kind:             question.QuestionKind(),
+					YourChoiceIDs:    yourAnswer.ChoiceIDs,
 					CorrectChoiceIDs: correctChoiceIDs,
+					YourAnswer:       yourAnswer,
 					Explanation:      question.Explanation,
-				})
+				}
+				if question.QuestionKind() != domain.KindChoice {
+					wrongQuestion.Solution = question.Spec
+				}
+				wrongQuestions = append(wrongQuestions, wrongQuestion)
 			}
 		}
 
-		// Рассчитываем вклад вопроса в точность
-		factor := 1.0 - 0.3*float64(mistakes)
-		if mistakes == 3 {
-			factor = 0.1
+		// Вклад вопроса в точность
+		contributionSum += policy.Factor(mistakes)
+	}
+
+	// Незавершенная симуляция дает нулевой вклад
+	turns := lastSimulationTurns(attempt.Steps)
+	for _, st := range lvl.Steps {
+		if st.Type != domain.StepTypeSimulation || turns[st.ID] == nil {
+			continue
 		}
-		if factor < 0 {
-			factor = 0
+		contributionSum += turns[st.ID].Score
+		if turns[st.ID].Correct {
+			correctAnswers++
 		}
-		contributionSum += factor
 	}
 
 	// Вычисляем итоговый балл (точность) с учетом числа ошибок
@@ -775,36 +2836,56 @@ func (s *attemptService) CompleteAttempt(ctx context.Context, attemptID uint) (*
 		score = int(normalized + 0.5) // округление
 	}
 
-	// Обновляем попытку
+	// Проходной балл проверяется по точности, бонус за скорость добавляется сверху
 	now := time.Now()
+	passed := policy.Passed(score)
+	timeBonus := policy.Bonus(score, now.Sub(attempt.StartedAt))
+	score += timeBonus
+	if score > 100 {
+		score = 100
+	}
+
+	// Обновляем попытку
 	attempt.Status = "completed"
 	attempt.ResultScore = score
+	attempt.Passed = passed
 	attempt.CompletedAt = &now
 
-	err = s.attemptRepo.Update(ctx, attempt)
-	if err != nil {
-		return nil, err
-	}
-
-	// Начисляем награду
-	level, err := s.levelRepo.GetByID(ctx, attempt.LevelID)
-	if err == nil && score >= 70 { // Минимум 70% для получения награды
-		rewardAmount := int64(level.RewardPoints)
-		err = s.rewardTxRepo.Create(ctx, &domain.RewardTx{
+	// Награда — по ревизии, на которой шла попытка
+	var reward *domain.RewardTx
+	if passed && lvl.RewardPoints > 0 {
+		reward = &domain.RewardTx{
 			UserID:    attempt.UserID,
-			Amount:    rewardAmount,
+			Amount:    int64(lvl.RewardPoints),
 			Type:      "earn",
 			Reason:    "Level completion reward",
 			AttemptID: &attempt.ID,
-		})
-		if err != nil {
-			// Логируем ошибку, но не прерываем выполнение
 		}
 	}
 
-	// Обновляем streak (огоньки) — не более одного раза в сутки
-	if s.userService != nil {
-		_ = s.userService.UpdateStreak(ctx, attempt.UserID)
+	// Итог, награда, streak и достижения сохраняются вместе; конкурентное завершение не пройдет
+	err = s.txManager.WithinTx(ctx, func(ctx context.Context) error {
+		if err := s.attemptRepo.Complete(ctx, attempt); err != nil {
+			return err
+		}
+		if reward != nil {
+			if err := s.rewardTxRepo.Create(ctx, reward); err != nil {
+				return err
+			}
+		}
+		// Streak (огоньки) — не более одного раза в сутки
+		if s.userService != nil {
+			if err := s.userService.UpdateStreak(ctx, attempt.UserID); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+		}
+		return s.awardAchievements(ctx, attempt)
+	})
+	if err != nil {
+		if errors.Is(err, repo.ErrAttemptNotInProgress) {
+			return nil, ErrAttemptCompleted
+		}
+		return nil, err
 	}
 
 	result := &AttemptResult{
@@ -813,11 +2894,16 @@ func (s *attemptService) CompleteAttempt(ctx context.Context, attemptID uint) (*
 		TotalQuestions: totalQuestions,
 		CorrectAnswers: correctAnswers,
 		WrongQuestions: wrongQuestions,
-		Reward: &RewardInfo{
-			Diamonds: int64(level.RewardPoints),
-			TxID:     0, // Можно добавить ID транзакции
-			Reason:   "Level completion reward",
-		},
+		Passed:         passed,
+		TimeBonus:      timeBonus,
+		Policy:         policy,
+	}
+	if reward != nil {
+		result.Reward = &RewardInfo{
+			Diamonds: reward.Amount,
+			TxID:     reward.ID,
+			Reason:   reward.Reason,
+		}
 	}
 
 	return result, nil
@@ -831,37 +2917,136 @@ func (s *attemptService) GetUserAttempts(ctx context.Context, userID uint) ([]*d
 	return s.attemptRepo.GetByUserID(ctx, userID)
 }
 
-// Вспомогательная функция для сравнения массивов ID
-func compareChoiceIDs(userChoices, correctChoices []uint) bool {
-	if len(userChoices) != len(correctChoices) {
-		return false
+// awardAchievements - достижения за завершенную попытку; вызывается в транзакции
+// завершения, поэтому попытка не бывает завершена без положенных достижений
+func (s *attemptService) awardAchievements(ctx context.Context, attempt *domain.Attempt) error {
+	if s.achievements == nil {
+		return nil
+	}
+	if attempt.Passed {
+		data := map[string]interface{}{"level_id": attempt.LevelID, "score": attempt.ResultScore}
+		if err := s.achievements.CheckAndAwardAchievements(ctx, attempt.UserID, "level_completed", data); err != nil {
+			return err
+		}
+	}
+	if s.userService == nil {
+		return nil
+	}
+	profile, err := s.userService.GetProfile(ctx, attempt.UserID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	data := map[string]interface{}{"streak": profile.Streak}
+	return s.achievements.CheckAndAwardAchievements(ctx, attempt.UserID, "streak_updated", data)
+}
+
+// ownedAttempt - попытка attemptID, если она принадлежит пользователю userID
+func (s *attemptService) ownedAttempt(ctx context.Context, userID, attemptID uint) (*domain.Attempt, error) {
+	attempt, err := s.attemptRepo.GetByID(ctx, attemptID)
+	if err != nil {
+		return nil, notFound(err, ErrAttemptNotFound)
+	}
+	if attempt.UserID != userID {
+		return nil, ErrAttemptForbidden
 	}
+	return attempt, nil
+}
 
-	// Создаем карты для быстрого поиска
-	userMap := make(map[uint]bool)
-	for _, id := range userChoices {
-		userMap[id] = true
+// attemptLevel - уровень с шагами, вопросами и вариантами в той версии, на которой
+// начата попытка. Новые попытки всегда закреплены за ревизией; рабочие таблицы читают
+// только попытки, начатые до появления ревизий
+func (s *attemptService) attemptLevel(ctx context.Context, attempt *domain.Attempt) (*domain.Level, error) {
+	if attempt.LevelRevisionID == nil {
+		return s.levelRepo.GetWithSteps(ctx, attempt.LevelID)
 	}
+	return revisionLevel(ctx, s.levelRepo, *attempt.LevelRevisionID)
+}
 
-	correctMap := make(map[uint]bool)
-	for _, id := range correctChoices {
-		correctMap[id] = true
+// revisionLevel - уровень из снимка опубликованной ревизии
+func revisionLevel(ctx context.Context, levelRepo repo.LevelRepo, revisionID uint) (*domain.Level, error) {
+	revision, err := levelRepo.GetRevisionByID(ctx, revisionID)
+	if err != nil {
+		return nil, err
 	}
+	return revision.Level()
+}
 
-	// Проверяем, что все элементы совпадают
-	for id := range userMap {
-		if !correctMap[id] {
-			return false
+// publishedLevel - уровень в опубликованной версии. Черновик, ни разу не публиковавшийся,
+// игрокам не виден (ErrLevelNotFound). Активность и место в курсе — оперативные поля,
+// они не версионируются и берутся из level
+func publishedLevel(ctx context.Context, levelRepo repo.LevelRepo, level *domain.Level) (*domain.Level, error) {
+	if level.PublishedRevisionID == nil {
+		return nil, ErrLevelNotFound
+	}
+	published, err := revisionLevel(ctx, levelRepo, *level.PublishedRevisionID)
+	if err != nil {
+		return nil, err
+	}
+	published.IsActive = level.IsActive
+	published.UnitID = level.UnitID
+	published.UnitOrder = level.UnitOrder
+	published.PublishedRevisionID = level.PublishedRevisionID
+	return published, nil
+}
+
+// levelQuestion - шаг уровня с вопросом questionID и сам вопрос (nil, если его нет в уровне)
+func levelQuestion(level *domain.Level, questionID uint) (*domain.LevelStep, *domain.Question) {
+	for i := range level.Steps {
+		step := &level.Steps[i]
+		if step.Type == domain.StepTypeQuestion && step.Question != nil && step.Question.ID == questionID {
+			return step, step.Question
 		}
 	}
+	return nil, nil
+}
 
-	for id := range correctMap {
-		if !userMap[id] {
-			return false
+// checkAnswerChoices - выбранные варианты должны принадлежать вопросу и не повторяться
+func checkAnswerChoices(question *domain.Question, answer domain.Answer) error {
+	if len(answer.ChoiceIDs) == 0 {
+		return nil
+	}
+	if question.QuestionKind() != domain.KindChoice {
+		return invalid("choice_ids", "question has no choices")
+	}
+	choices := make(map[uint]bool, len(question.Choices))
+	for _, choice := range question.Choices {
+		choices[choice.ID] = true
+	}
+	selected := make(map[uint]bool, len(answer.ChoiceIDs))
+	for _, id := range answer.ChoiceIDs {
+		if !choices[id] {
+			return invalid("choice_ids", fmt.Sprintf("choice %d does not belong to question %d", id, question.ID))
+		}
+		if selected[id] {
+			return invalid("choice_ids", fmt.Sprintf("choice %d is selected more than once", id))
 		}
+		selected[id] = true
+	}
+	if !question.MultiSelect && len(answer.ChoiceIDs) > 1 {
+		return invalid("choice_ids", "question accepts a single choice")
+	}
+	return nil
+}
+
+// newAttemptSeed - случайное зерно для шаблонных вопросов попытки
+func newAttemptSeed() int64 {
+	var raw [8]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return time.Now().UnixNano()
 	}
+	return int64(binary.LittleEndian.Uint64(raw[:]) >> 1)
+}
 
-	return true
+// responseAnswer - ответ игрока, сохраненный в шаге попытки
+func responseAnswer(step *domain.AttemptStep) domain.Answer {
+	var answer domain.Answer
+	if err := json.Unmarshal(step.Response, &answer); err != nil {
+		return domain.Answer{}
+	}
+	return answer
 }
 
 type rewardService struct {
@@ -927,13 +3112,10 @@ func (s *rewardService) HasEnoughDiamonds(ctx context.Context, userID uint, amou
 
 // CancelAttempt устанавливает статус попытки как failed и проставляет CompletedAt
 func (s *attemptService) CancelAttempt(ctx context.Context, attemptID uint, userID uint) error {
-	attempt, err := s.attemptRepo.GetByID(ctx, attemptID)
+	attempt, err := s.ownedAttempt(ctx, userID, attemptID)
 	if err != nil {
 		return err
 	}
-	if attempt.UserID != userID {
-		return errors.New("forbidden")
-	}
 	if attempt.Status != domain.AttemptInProgress {
 		return nil
 	}
@@ -972,7 +3154,7 @@ func (s *achievementService) CheckAndAwardAchievements(ctx context.Context, user
 		// Проверяем, есть ли уже у пользователя это достижение
 		hasAchievement, err := s.achievementRepo.HasAchievement(ctx, userID, achievement.ID)
 		if err != nil {
-			continue
+			return err
 		}
 		if hasAchievement {
 			continue
@@ -1003,10 +3185,9 @@ func (s *achievementService) CheckAndAwardAchievements(ctx context.Context, user
 		}
 
 		if shouldAward {
-			err = s.achievementRepo.AwardToUser(ctx, userID, achievement.ID)
-			if err != nil {
-				// Логируем ошибку, но продолжаем
-				continue
+			// Ошибка возвращается: в транзакции вызывающего она откатит всю операцию
+			if err := s.achievementRepo.AwardToUser(ctx, userID, achievement.ID); err != nil {
+				return err
 			}
 		}
 	}
@@ -1040,3 +3221,251 @@ func (s *achievementService) GetAchievementProgress(ctx context.Context, userID,
 		IsCompleted: hasAchievement,
 	}, nil
 }
+
+// idempotencyKeyTTL - сколько хранится ответ на запрос с Idempotency-Key
+const idempotencyKeyTTL = 24 * time.Hour
+
+type idempotencyService struct {
+	idempotencyRepo repo.IdempotencyRepo
+}
+
+func NewIdempotencyService(idempotencyRepo repo.IdempotencyRepo) IdempotencyService {
+	return &idempotencyService{idempotencyRepo: idempotencyRepo}
+}
+
+func (s *idempotencyService) Begin(ctx context.Context, userID uint, key, fingerprint string) (*domain.IdempotencyKey, error) {
+	if key == "" || len(key) > 255 {
+		return nil, invalid("Idempotency-Key", "must be between 1 and 255 characters")
+	}
+	record, created, err := s.idempotencyRepo.Reserve(ctx, &domain.IdempotencyKey{
+		UserID:      userID,
+		Key:         key,
+		Fingerprint: fingerprint,
+		ExpiresAt:   time.Now().Add(idempotencyKeyTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		return record, nil
+	}
+	if record.Fingerprint != fingerprint {
+		return nil, ErrIdempotencyReused
+	}
+	if record.CompletedAt == nil {
+		return nil, ErrIdempotencyInUse
+	}
+	return record, nil
+}
+
+func (s *idempotencyService) Finish(ctx context.Context, id uint, statusCode int, response []byte) error {
+	return s.idempotencyRepo.Complete(ctx, id, statusCode, response)
+}
+
+func (s *idempotencyService) Release(ctx context.Context, id uint) error {
+	return s.idempotencyRepo.Delete(ctx, id)
+}
+
+// Пороги для признаков проблемных вопросов
+const (
+	analyticsMinSample         = 10   // меньше ответов — флаги и дискриминация не считаются
+	analyticsGroupShare        = 0.27 // доля верхней и нижней группы для индекса дискриминации
+	analyticsTooHard           = 0.3
+	analyticsTooEasy           = 0.95
+	analyticsLowDiscrimination = 0.2
+)
+
+type analyticsService struct {
+	attemptRepo  repo.AttemptRepo
+	questionRepo repo.QuestionRepo
+	levelRepo    repo.LevelRepo
+}
+
+func NewAnalyticsService(attemptRepo repo.AttemptRepo, questionRepo repo.QuestionRepo, levelRepo repo.LevelRepo) AnalyticsService {
+	return &analyticsService{attemptRepo: attemptRepo, questionRepo: questionRepo, levelRepo: levelRepo}
+}
+
+func (s *analyticsService) QuestionAnalytics(ctx context.Context, questionID uint) (*QuestionAnalytics, error) {
+	question, err := s.questionRepo.GetWithChoices(ctx, questionID)
+	if err != nil {
+		return nil, notFound(err, ErrQuestionNotFound)
+	}
+	answers, err := s.attemptRepo.GetQuestionAnswers(ctx, []uint{questionID})
+	if err != nil {
+		return nil, err
+	}
+	return questionAnalytics(question, answers), nil
+}
+
+func (s *analyticsService) LevelAnalytics(ctx context.Context, levelID uint) ([]*QuestionAnalytics, error) {
+	level, err := s.levelRepo.GetForEdit(ctx, levelID, false)
+	if err != nil {
+		return nil, notFound(err, ErrLevelNotFound)
+	}
+	var ids []uint
+	for _, step := range level.Steps {
+		if step.Type == domain.StepTypeQuestion && step.QuestionID != nil {
+			ids = append(ids, *step.QuestionID)
+		}
+	}
+	if len(ids) == 0 {
+		return []*QuestionAnalytics{}, nil
+	}
+
+	questions, err := s.questionRepo.GetByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	answers, err := s.attemptRepo.GetQuestionAnswers(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	byQuestion := make(map[uint][]*repo.QuestionAnswer, len(ids))
+	for _, answer := range answers {
+		byQuestion[answer.QuestionID] = append(byQuestion[answer.QuestionID], answer)
+	}
+	questionByID := make(map[uint]*domain.Question, len(questions))
+	for _, question := range questions {
+		questionByID[question.ID] = question
+	}
+
+	result := make([]*QuestionAnalytics, 0, len(ids))
+	for _, id := range ids {
+		if question, ok := questionByID[id]; ok {
+			result = append(result, questionAnalytics(question, byQuestion[id]))
+		}
+	}
+	return result, nil
+}
+
+// questionAnalytics - сводка по ответам на вопрос; answers отсортированы по попыткам и порядку шагов
+func questionAnalytics(question *domain.Question, answers []*repo.QuestionAnswer) *QuestionAnalytics {
+	stats := &QuestionAnalytics{
+		QuestionID: question.ID,
+		Prompt:     question.Prompt,
+		Kind:       question.QuestionKind(),
+		Answers:    len(answers),
+		Flags:      []string{},
+	}
+
+	// Первый ответ в каждой попытке
+	var first []*repo.QuestionAnswer
+	seen := make(map[uint]bool)
+	var totalDuration int64
+	for _, answer := range answers {
+		totalDuration += answer.DurationMs
+		if !seen[answer.AttemptID] {
+			seen[answer.AttemptID] = true
+			first = append(first, answer)
+		}
+	}
+	stats.Attempts = len(first)
+	if len(answers) > 0 {
+		stats.AverageDurationMs = totalDuration / int64(len(answers))
+	}
+
+	correct := 0
+	var score float64
+	for _, answer := range first {
+		if answer.Correct {
+			correct++
+		}
+		score += answer.Score
+	}
+	if len(first) > 0 {
+		stats.FirstTryCorrectRate = float64(correct) / float64(len(first))
+		stats.AverageScore = score / float64(len(first))
+	}
+
+	// Распределение вариантов имеет смысл только для постоянных вариантов
+	if question.QuestionKind() == domain.KindChoice && question.Template == nil {
+		picks := make(map[uint]int, len(question.Choices))
+		for _, answer := range first {
+			for _, id := range responseAnswer(&domain.AttemptStep{Response: answer.Response}).ChoiceIDs {
+				picks[id]++
+			}
+		}
+		for _, choice := range question.Choices {
+			stat := ChoiceStat{ChoiceID: choice.ID, Text: choice.Text, IsCorrect: choice.IsCorrect, Picks: picks[choice.ID]}
+			if len(first) > 0 {
+				stat.Rate = float64(stat.Picks) / float64(len(first))
+			}
+			stats.Choices = append(stats.Choices, stat)
+		}
+	}
+
+	stats.Discrimination = discriminationIndex(first)
+
+	if len(first) < analyticsMinSample {
+		return stats
+	}
+	if stats.FirstTryCorrectRate < analyticsTooHard {
+		stats.Flags = append(stats.Flags, FlagTooHard)
+	}
+	if stats.FirstTryCorrectRate > analyticsTooEasy {
+		stats.Flags = append(stats.Flags, FlagTooEasy)
+	}
+	if d := stats.Discrimination; d != nil {
+		switch {
+		case *d < 0:
+			stats.Flags = append(stats.Flags, FlagNegativeDiscrimination)
+		case *d < analyticsLowDiscrimination:
+			stats.Flags = append(stats.Flags, FlagLowDiscrimination)
+		}
+	}
+	maxCorrectPicks := 0
+	for _, choice := range stats.Choices {
+		if choice.IsCorrect && choice.Picks > maxCorrectPicks {
+			maxCorrectPicks = choice.Picks
+		}
+	}
+	unused, popular := false, false
+	for _, choice := range stats.Choices {
+		if choice.IsCorrect {
+			continue
+		}
+		if choice.Picks == 0 {
+			unused = true
+		}
+		if choice.Picks > maxCorrectPicks {
+			popular = true
+		}
+	}
+	if popular {
+		stats.Flags = append(stats.Flags, FlagPopularDistractor)
+	}
+	if unused {
+		stats.Flags = append(stats.Flags, FlagUnusedDistractor)
+	}
+	return stats
+}
+
+// discriminationIndex - разница доли верных ответов у верхних и нижних 27% завершенных попыток
+func discriminationIndex(first []*repo.QuestionAnswer) *float64 {
+	var completed []*repo.QuestionAnswer
+	for _, answer := range first {
+		if answer.AttemptStatus == domain.AttemptCompleted {
+			completed = append(completed, answer)
+		}
+	}
+	if len(completed) < analyticsMinSample {
+		return nil
+	}
+	sort.SliceStable(completed, func(i, j int) bool { return completed[i].AttemptScore > completed[j].AttemptScore })
+
+	group := int(float64(len(completed))*analyticsGroupShare + 0.5)
+	if group < 1 {
+		group = 1
+	}
+	rate := func(answers []*repo.QuestionAnswer) float64 {
+		correct := 0
+		for _, answer := range answers {
+			if answer.Correct {
+				correct++
+			}
+		}
+		return float64(correct) / float64(len(answers))
+	}
+	index := rate(completed[:group]) - rate(completed[len(completed)-group:])
+	return &index
+}
@@ -2,8 +2,14 @@ package core
 
 import (
 	"context"
+	"encoding/json"
+	"time"
 
+	"github.com/ImCtyz/duofinance/backend/internal/auth"
 	"github.com/ImCtyz/duofinance/backend/internal/domain"
+	"github.com/ImCtyz/duofinance/backend/internal/ratelimit"
+	"github.com/ImCtyz/duofinance/backend/internal/simulation"
+	"gorm.io/datatypes"
 )
 
 // AuthService - интерфейс для аутентификации и авторизации
@@ -11,20 +17,80 @@ type AuthService interface {
 	// Регистрация нового пользователя
 	Register(ctx context.Context, email, username, password string) (*domain.User, error)
 
-	// Вход в систему
-	Login(ctx context.Context, email, password string) (accessToken, refreshToken string, user *domain.User, err error)
+	// Вход в систему (создает новую сессию для клиента client).
+	// Если у пользователя включена 2FA, вместо токенов возвращается MFAToken
+	Login(ctx context.Context, email, password string, client ClientInfo) (*LoginResult, error)
 
-	// Обновление токена доступа
-	RefreshToken(ctx context.Context, refreshToken string) (newAccessToken, newRefreshToken string, err error)
+	// Второй шаг входа: обмен MFAToken и кода TOTP (или кода восстановления) на токены
+	VerifyMFA(ctx context.Context, mfaToken, code string, client ClientInfo) (*LoginResult, error)
+
+	// Обновление токена доступа с ротацией refresh токена
+	RefreshToken(ctx context.Context, refreshToken string, client ClientInfo) (newAccessToken, newRefreshToken string, err error)
 
 	// Получение текущего пользователя
 	GetCurrentUser(ctx context.Context, userID uint) (*domain.User, error)
 
 	// Валидация токена
-	ValidateToken(ctx context.Context, token string) (userID uint, err error)
+	ValidateToken(ctx context.Context, token string) (*auth.JWTClaims, error)
+
+	// Публичные ключи проверки access токенов (JWKS)
+	GetJWKS(ctx context.Context) *auth.JWKSet
+
+	// Выход из системы (отзыв сессии sessionID; пустой sessionID отзывает все сессии)
+	Logout(ctx context.Context, userID uint, sessionID string) error
+
+	// Получить активные сессии пользователя
+	GetSessions(ctx context.Context, userID uint) ([]*domain.Session, error)
+
+	// Отозвать сессию пользователя
+	RevokeSession(ctx context.Context, userID uint, sessionID string) error
+
+	// Отозвать все сессии пользователя, кроме текущей
+	RevokeOtherSessions(ctx context.Context, userID uint, currentSessionID string) error
+
+	// Отправить письмо со ссылкой для сброса пароля (для неизвестного email ничего не делает)
+	RequestPasswordReset(ctx context.Context, email string) error
+
+	// Установить новый пароль по токену из письма
+	ResetPassword(ctx context.Context, token, newPassword string) error
+
+	// Подтвердить email по токену из письма
+	VerifyEmail(ctx context.Context, token string) error
+
+	// Отправить письмо для подтверждения email
+	SendEmailVerification(ctx context.Context, userID uint) error
+
+	// Начать подключение TOTP: новый секрет и коды восстановления (2FA включается после ConfirmTOTP)
+	EnrollTOTP(ctx context.Context, userID uint) (*TOTPEnrollment, error)
+
+	// Подтвердить подключение TOTP первым кодом из приложения
+	ConfirmTOTP(ctx context.Context, userID uint, code string) error
+
+	// Отключить 2FA (требуется код TOTP или код восстановления)
+	DisableTOTP(ctx context.Context, userID uint, code string) error
 
-	// Выход из системы (инвалидация токенов)
-	Logout(ctx context.Context, userID uint) error
+	// Имена настроенных OIDC провайдеров
+	GetOIDCProviders(ctx context.Context) []string
+
+	// Начать вход через OIDC провайдера: адрес страницы входа провайдера
+	StartOIDCLogin(ctx context.Context, provider string) (authURL string, err error)
+
+	// Завершить вход через OIDC провайдера по коду и state из redirect.
+	// Новый внешний аккаунт привязывается к пользователю с тем же подтвержденным email
+	// либо создает нового пользователя
+	CompleteOIDCLogin(ctx context.Context, provider, code, state string, client ClientInfo) (*LoginResult, error)
+
+	// Начать привязку OIDC провайдера к аккаунту пользователя
+	StartOIDCLink(ctx context.Context, userID uint, provider string) (authURL string, err error)
+
+	// Завершить привязку OIDC провайдера
+	CompleteOIDCLink(ctx context.Context, userID uint, provider, code, state string) (*domain.UserIdentity, error)
+
+	// Получить привязанные внешние аккаунты
+	GetIdentities(ctx context.Context, userID uint) ([]*domain.UserIdentity, error)
+
+	// Отвязать провайдера (нельзя удалить последний способ входа)
+	UnlinkIdentity(ctx context.Context, userID uint, provider string) error
 }
 
 // LevelService - интерфейс для работы с уровнями/уроками
@@ -45,19 +111,163 @@ type LevelService interface {
 	IsLevelAvailable(ctx context.Context, levelID, userID uint) (bool, error)
 }
 
-// AttemptService - интерфейс для работы с попытками прохождения
+// ContentService - интерфейс для редактирования контента (уровни, шаги, вопросы)
+type ContentService interface {
+	// Получить все уровни, включая неактивные (и удаленные при includeDeleted)
+	ListLevels(ctx context.Context, includeDeleted bool) ([]*domain.Level, error)
+
+	// Получить уровень с неудаленными шагами
+	GetLevel(ctx context.Context, id uint, includeDeleted bool) (*domain.Level, error)
+
+	// Создать уровень
+	CreateLevel(ctx context.Context, level *domain.Level) error
+
+	// Обновить уровень
+	UpdateLevel(ctx context.Context, level *domain.Level) error
+
+	// Мягко удалить уровень
+	DeleteLevel(ctx context.Context, id uint) error
+
+	// Восстановить удаленный уровень
+	RestoreLevel(ctx context.Context, id uint) error
+
+	// Получить шаги уровня (удаленные — при includeDeleted)
+	ListSteps(ctx context.Context, levelID uint, includeDeleted bool) ([]*domain.LevelStep, error)
+
+	// Создать шаг; Order 0 — в конец уровня
+	CreateStep(ctx context.Context, step *domain.LevelStep) error
+
+	// Обновить шаг
+	UpdateStep(ctx context.Context, step *domain.LevelStep) error
+
+	// Мягко удалить шаг
+	DeleteStep(ctx context.Context, id uint) error
+
+	// Задать новый порядок всех шагов уровня
+	ReorderSteps(ctx context.Context, levelID uint, stepIDs []uint) ([]*domain.LevelStep, error)
+
+	// Получить вопрос с вариантами ответов
+	GetQuestion(ctx context.Context, id uint) (*domain.Question, error)
+
+	// Создать вопрос вместе с вариантами ответов
+	CreateQuestion(ctx context.Context, question *domain.Question) error
+
+	// Обновить вопрос (без вариантов ответов)
+	UpdateQuestion(ctx context.Context, question *domain.Question) error
+
+	// Мягко удалить вопрос, не используемый шагами
+	DeleteQuestion(ctx context.Context, id uint) error
+
+	// Добавить вариант ответа
+	CreateChoice(ctx context.Context, choice *domain.Choice) error
+
+	// Обновить вариант ответа
+	UpdateChoice(ctx context.Context, choice *domain.Choice) error
+
+	// Удалить вариант ответа
+	DeleteChoice(ctx context.Context, id uint) error
+
+	// Опубликовать текущий черновик уровня как новую ревизию
+	PublishLevel(ctx context.Context, levelID, actorID uint, note string) (*domain.LevelRevision, error)
+
+	// История опубликованных ревизий уровня, новые первыми
+	ListRevisions(ctx context.Context, levelID uint) ([]*domain.LevelRevision, error)
+
+	// Получить ревизию уровня со снимком
+	GetRevision(ctx context.Context, levelID uint, number int) (*domain.LevelRevision, error)
+
+	// Повторно опубликовать снимок ревизии number как новую ревизию
+	RollbackLevel(ctx context.Context, levelID uint, number int, actorID uint) (*domain.LevelRevision, error)
+}
+
+// IdempotencyService - повтор запросов с заголовком Idempotency-Key
+type IdempotencyService interface {
+	// Занять ключ для запроса с отпечатком fingerprint. Завершенный запрос возвращается
+	// с CompletedAt (ответ нужно повторить); ErrIdempotencyInUse, если запрос с этим
+	// ключом еще выполняется; ErrIdempotencyReused, если ключ занят другим запросом
+	Begin(ctx context.Context, userID uint, key, fingerprint string) (*domain.IdempotencyKey, error)
+
+	// Сохранить ответ для повторов
+	Finish(ctx context.Context, id uint, statusCode int, response []byte) error
+
+	// Освободить ключ (запрос не выполнен и может быть повторен)
+	Release(ctx context.Context, id uint) error
+}
+
+// AnalyticsService - статистика ответов на вопросы для редакторов
+type AnalyticsService interface {
+	// Статистика по вопросу
+	QuestionAnalytics(ctx context.Context, questionID uint) (*QuestionAnalytics, error)
+
+	// Статистика по всем вопросам уровня в порядке шагов
+	LevelAnalytics(ctx context.Context, levelID uint) ([]*QuestionAnalytics, error)
+}
+
+// CourseService - интерфейс для работы с курсами и открытием уровней
+type CourseService interface {
+	// Получить курсы (неактивные — при includeInactive)
+	ListCourses(ctx context.Context, includeInactive bool) ([]*domain.Course, error)
+
+	// Карта активного курса: юниты и активные уровни с состоянием для пользователя
+	GetCourseMap(ctx context.Context, courseID, userID uint) (*CourseMap, error)
+
+	// Получить курс
+	GetCourse(ctx context.Context, id uint) (*domain.Course, error)
+
+	// Создать курс
+	CreateCourse(ctx context.Context, course *domain.Course) error
+
+	// Обновить курс
+	UpdateCourse(ctx context.Context, course *domain.Course) error
+
+	// Получить юниты курса вместе со всеми уровнями
+	ListUnits(ctx context.Context, courseID uint) ([]*domain.Unit, error)
+
+	// Создать юнит
+	CreateUnit(ctx context.Context, unit *domain.Unit) error
+
+	// Обновить юнит
+	UpdateUnit(ctx context.Context, unit *domain.Unit) error
+
+	// Поместить уровень в юнит (unitID nil — убрать из курса)
+	PlaceLevel(ctx context.Context, levelID uint, unitID *uint, order int) error
+
+	// Получить зависимости уровня
+	GetPrerequisites(ctx context.Context, levelID uint) ([]*domain.LevelPrerequisite, error)
+
+	// Заменить зависимости уровня; ErrPrerequisiteCycle, если граф перестанет быть ацикличным
+	SetPrerequisites(ctx context.Context, levelID uint, prerequisites []*domain.LevelPrerequisite) ([]*domain.LevelPrerequisite, error)
+}
+
+// AttemptService - интерфейс для работы с попытками прохождения.
+// Методы с userID работают только с попытками этого пользователя (иначе ErrAttemptForbidden)
 type AttemptService interface {
 	// Начать новую попытку прохождения уровня
 	StartAttempt(ctx context.Context, userID, levelID uint) (*domain.Attempt, error)
 
 	// Получить следующий вопрос в попытке
-	GetNextQuestion(ctx context.Context, attemptID uint) (*domain.Question, error)
+	GetNextQuestion(ctx context.Context, userID, attemptID uint) (*domain.Question, error)
 
 	// Ответить на вопрос
-	AnswerQuestion(ctx context.Context, attemptID, questionID uint, choiceIDs []uint) (bool, string, error)
+	AnswerQuestion(ctx context.Context, userID, attemptID, questionID uint, answer domain.Answer) (*AnswerResult, error)
+
+	// Текущий шаг попытки (текст, вопрос или симуляция); nil — все шаги пройдены
+	CurrentStep(ctx context.Context, userID, attemptID uint) (*StepEnvelope, error)
+
+	// Прохождение текущего шага: прочтение текста, ответ на вопрос или ход симуляции
+	AdvanceStep(ctx context.Context, userID, attemptID, stepID uint, input StepInput) (*StepAdvance, error)
+
+	// Текущее состояние всех шагов-симуляций попытки
+	ListSimulations(ctx context.Context, userID, attemptID uint) ([]*SimulationResult, error)
+
+	// Текущее состояние шага-симуляции
+	GetSimulation(ctx context.Context, userID, attemptID, stepID uint) (*SimulationResult, error)
+
+	// Ход симуляции с решением игрока
+	AdvanceSimulation(ctx context.Context, userID, attemptID, stepID uint, decision json.RawMessage) (*SimulationResult, error)
 
 	// Завершить попытку и получить результаты
-	CompleteAttempt(ctx context.Context, attemptID uint) (*AttemptResult, error)
+	CompleteAttempt(ctx context.Context, userID, attemptID uint) (*AttemptResult, error)
 
 	// Отменить (прервать) активную попытку
 	CancelAttempt(ctx context.Context, attemptID uint, userID uint) error
@@ -85,6 +295,14 @@ type UserService interface {
 
 	// Обновить streak пользователя
 	UpdateStreak(ctx context.Context, userID uint) error
+
+	// Получить страницу пользователей (для администратора); номер и размер
+	// страницы в ответе — фактические, после приведения к допустимым значениям
+	ListUsers(ctx context.Context, page, pageSize int) (*UserPage, error)
+
+	// Изменить роль пользователя; сессии пользователя отзываются, чтобы
+	// выданные access токены со старой ролью перестали действовать
+	SetRole(ctx context.Context, actorID, userID uint, role domain.UserRole) error
 }
 
 // RewardService - интерфейс для работы с наградами
@@ -117,6 +335,78 @@ type AchievementService interface {
 	GetAchievementProgress(ctx context.Context, userID, achievementID uint) (*AchievementProgress, error)
 }
 
+// ClientInfo - сведения о клиенте, которому выдаются токены
+type ClientInfo struct {
+	UserAgent string
+	IP        string
+}
+
+// LoginResult - результат входа
+type LoginResult struct {
+	AccessToken  string
+	RefreshToken string
+	User         *domain.User
+	MFARequired  bool   // пароль верен, но токены выдаются только после второго фактора
+	MFAToken     string // передается в VerifyMFA вместе с кодом
+}
+
+// TOTPEnrollment - данные для подключения приложения-аутентификатора
+type TOTPEnrollment struct {
+	Secret        string   `json:"secret"`
+	URI           string   `json:"otpauth_uri"`
+	RecoveryCodes []string `json:"recovery_codes"` // показываются один раз
+}
+
+// LoginProtection - параметры защиты входа от перебора паролей
+type LoginProtection struct {
+	MaxAccountFailures int           // неудачных попыток подряд до блокировки аккаунта
+	FailureWindow      time.Duration // неудачи старше окна не учитываются
+	LockoutBase        time.Duration // первая блокировка; каждая следующая неудача удваивает ее
+	LockoutMax         time.Duration
+	IPFailures         *ratelimit.FailureTracker // неудачные входы по IP адресу
+}
+
+// AnswerResult - оценка ответа на вопрос
+type AnswerResult struct {
+	Correct     bool
+	Score       float64 // доля зачета от 0 до 1
+	Mode        domain.GradingMode
+	Explanation string
+	Choices     []domain.ChoiceGrade
+	Parts       []domain.PartGrade // разбор для ordering, matching и cloze
+}
+
+// StepEnvelope - шаг попытки для показа игроку; заполнено поле, соответствующее типу шага
+type StepEnvelope struct {
+	Step       *domain.LevelStep
+	Index      int // номер шага среди шагов уровня, начиная с 1
+	Total      int
+	Question   *domain.Question // экземпляр вопроса для этой попытки
+	Simulation *SimulationResult
+}
+
+// StepInput - данные для прохождения шага: Answer для вопроса, Decision для симуляции
+type StepInput struct {
+	Answer   domain.Answer
+	Decision json.RawMessage
+}
+
+// StepAdvance - результат прохождения шага и следующий шаг (nil — все шаги пройдены)
+type StepAdvance struct {
+	Answer     *AnswerResult
+	Simulation *SimulationResult
+	Next       *StepEnvelope
+}
+
+// SimulationResult - состояние шага-симуляции; Outcome заполняется после последнего хода
+type SimulationResult struct {
+	StepID    uint
+	Title     string
+	Simulator string
+	State     *simulation.State
+	Outcome   *simulation.Outcome
+}
+
 // AttemptResult - результат завершения попытки
 type AttemptResult struct {
 	Attempt         *domain.Attempt       `json:"attempt"`
@@ -124,17 +414,23 @@ type AttemptResult struct {
 	TotalQuestions  int                   `json:"total_questions"`
 	CorrectAnswers  int                   `json:"correct_answers"`
 	WrongQuestions  []*WrongQuestion      `json:"wrong_questions"`
+	Passed          bool                  `json:"passed"`
+	TimeBonus       int                   `json:"time_bonus"` // уже включен в Score
+	Policy          domain.ScoringPolicy  `json:"policy"`     // политика, по которой посчитан результат
 	Reward          *RewardInfo           `json:"reward"`
 	NewAchievements []*domain.Achievement `json:"new_achievements,omitempty"`
 }
 
 // WrongQuestion - информация о неправильно отвеченном вопросе
 type WrongQuestion struct {
-	QuestionID       uint   `json:"question_id"`
-	Prompt           string `json:"prompt"`
-	YourChoiceIDs    []uint `json:"your_choice_ids"`
-	CorrectChoiceIDs []uint `json:"correct_choice_ids"`
-	Explanation      string `json:"explanation"`
+	QuestionID       uint                `json:"question_id"`
+	Prompt           string              `json:"prompt"`
+	Kind             domain.QuestionKind `json:"kind"`
+	YourChoiceIDs    []uint              `json:"your_choice_ids"`
+	CorrectChoiceIDs []uint              `json:"correct_choice_ids"`
+	YourAnswer       domain.Answer       `json:"your_answer"`
+	Solution         datatypes.JSON      `json:"solution,omitempty"` // Spec вопроса для видов, кроме choice
+	Explanation      string              `json:"explanation"`
 }
 
 // RewardInfo - информация о награде
@@ -144,6 +440,63 @@ type RewardInfo struct {
 	Reason   string `json:"reason"`
 }
 
+// QuestionAnalytics - статистика ответов на вопрос. Доли и распределение вариантов
+// считаются по первому ответу в каждой попытке, время — по всем ответам
+type QuestionAnalytics struct {
+	QuestionID          uint
+	Prompt              string
+	Kind                domain.QuestionKind
+	Attempts            int // попыток с ответом на вопрос
+	Answers             int // всех ответов, включая повторные
+	FirstTryCorrectRate float64
+	AverageScore        float64 // средняя доля зачета первого ответа
+	AverageDurationMs   int64
+	Choices             []ChoiceStat // для choice-вопросов без шаблона
+	// Индекс дискриминации: разница доли верных первых ответов в верхних и нижних 27%
+	// завершенных попыток по итоговому баллу; nil — мало данных
+	Discrimination *float64
+	Flags          []string // признаки проблемного вопроса
+}
+
+// ChoiceStat - сколько раз вариант был выбран в первом ответе
+type ChoiceStat struct {
+	ChoiceID  uint
+	Text      string
+	IsCorrect bool
+	Picks     int
+	Rate      float64 // доля первых ответов, в которых выбран вариант
+}
+
+// Признаки проблемного вопроса (QuestionAnalytics.Flags)
+const (
+	FlagTooHard                = "too_hard"                // почти никто не отвечает верно с первого раза
+	FlagTooEasy                = "too_easy"                // почти все отвечают верно
+	FlagLowDiscrimination      = "low_discrimination"      // сильные и слабые игроки отвечают одинаково
+	FlagNegativeDiscrimination = "negative_discrimination" // слабые отвечают лучше сильных — возможна ошибка в ключе
+	FlagPopularDistractor      = "popular_distractor"      // неверный вариант выбирают чаще верного
+	FlagUnusedDistractor       = "unused_distractor"       // неверный вариант никто не выбирает
+)
+
+// CourseMap - карта курса для пользователя
+type CourseMap struct {
+	Course *domain.Course
+	Units  []*UnitMap
+}
+
+// UnitMap - юнит на карте курса
+type UnitMap struct {
+	Unit   *domain.Unit
+	Levels []*LevelProgress
+}
+
+// LevelProgress - уровень и его состояние для пользователя
+type LevelProgress struct {
+	Level         *domain.Level
+	State         domain.LevelState
+	BestScore     *int // лучший результат завершенных попыток; nil, если их нет
+	Prerequisites []*domain.LevelPrerequisite
+}
+
 // UserStats - статистика пользователя
 type UserStats struct {
 	TotalAttempts     int     `json:"total_attempts"`
@@ -154,6 +507,14 @@ type UserStats struct {
 	AchievementsCount int     `json:"achievements_count"`
 }
 
+// UserPage - страница списка пользователей
+type UserPage struct {
+	Users    []*domain.User
+	Total    int64
+	Page     int
+	PageSize int
+}
+
 // AchievementProgress - прогресс по достижению
 type AchievementProgress struct {
 	Achievement *domain.Achievement `json:"achievement"`
@@ -0,0 +1,313 @@
+package domain
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expression — разобранная арифметическая формула шаблона вопроса: числа, переменные,
+// + - * / % ^, скобки и функции round(x, n), floor, ceil, abs, sqrt, pow, min, max
+type Expression struct {
+	source string
+	root   exprNode
+}
+
+type exprNode interface {
+	eval(vars map[string]float64) (float64, error)
+}
+
+type (
+	numberNode float64
+	varNode    string
+	unaryNode  struct{ operand exprNode }
+	binaryNode struct {
+		op          byte
+		left, right exprNode
+	}
+	callNode struct {
+		name string
+		args []exprNode
+	}
+)
+
+// exprFuncs — допустимые функции и число их аргументов
+var exprFuncs = map[string]struct {
+	arity int
+	fn    func(args []float64) float64
+}{
+	"round": {2, func(a []float64) float64 { p := math.Pow(10, math.Round(a[1])); return math.Round(a[0]*p) / p }},
+	"floor": {1, func(a []float64) float64 { return math.Floor(a[0]) }},
+	"ceil":  {1, func(a []float64) float64 { return math.Ceil(a[0]) }},
+	"abs":   {1, func(a []float64) float64 { return math.Abs(a[0]) }},
+	"sqrt":  {1, func(a []float64) float64 { return math.Sqrt(a[0]) }},
+	"pow":   {2, func(a []float64) float64 { return math.Pow(a[0], a[1]) }},
+	"min":   {2, func(a []float64) float64 { return math.Min(a[0], a[1]) }},
+	"max":   {2, func(a []float64) float64 { return math.Max(a[0], a[1]) }},
+}
+
+// ParseExpression — разбор формулы
+func ParseExpression(source string) (*Expression, error) {
+	p := &exprParser{src: source}
+	p.next()
+	root, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok != "" {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.tok, p.start+1)
+	}
+	return &Expression{source: source, root: root}, nil
+}
+
+// Eval — значение формулы; результат должен быть конечным числом
+func (e *Expression) Eval(vars map[string]float64) (float64, error) {
+	value, err := e.root.eval(vars)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("%q is not a finite number", e.source)
+	}
+	return value, nil
+}
+
+// Vars — имена переменных, на которые ссылается формула, по алфавиту
+func (e *Expression) Vars() []string {
+	seen := make(map[string]bool)
+	var walk func(node exprNode)
+	walk = func(node exprNode) {
+		switch n := node.(type) {
+		case varNode:
+			seen[string(n)] = true
+		case unaryNode:
+			walk(n.operand)
+		case binaryNode:
+			walk(n.left)
+			walk(n.right)
+		case callNode:
+			for _, arg := range n.args {
+				walk(arg)
+			}
+		}
+	}
+	walk(e.root)
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (n numberNode) eval(map[string]float64) (float64, error) { return float64(n), nil }
+
+func (n varNode) eval(vars map[string]float64) (float64, error) {
+	value, ok := vars[string(n)]
+	if !ok {
+		return 0, fmt.Errorf("unknown variable %q", string(n))
+	}
+	return value, nil
+}
+
+func (n unaryNode) eval(vars map[string]float64) (float64, error) {
+	value, err := n.operand.eval(vars)
+	return -value, err
+}
+
+func (n binaryNode) eval(vars map[string]float64) (float64, error) {
+	left, err := n.left.eval(vars)
+	if err != nil {
+		return 0, err
+	}
+	right, err := n.right.eval(vars)
+	if err != nil {
+		return 0, err
+	}
+	switch n.op {
+	case '+':
+		return left + right, nil
+	case '-':
+		return left - right, nil
+	case '*':
+		return left * right, nil
+	case '/':
+		if right == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return left / right, nil
+	case '%':
+		if right == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return math.Mod(left, right), nil
+	default:
+		return math.Pow(left, right), nil
+	}
+}
+
+func (n callNode) eval(vars map[string]float64) (float64, error) {
+	args := make([]float64, len(n.args))
+	for i, arg := range n.args {
+		value, err := arg.eval(vars)
+		if err != nil {
+			return 0, err
+		}
+		args[i] = value
+	}
+	return exprFuncs[n.name].fn(args), nil
+}
+
+// exprParser — разбор с рекурсивным спуском:
+// sum = product {("+"|"-") product}; product = unary {("*"|"/"|"%") unary};
+// unary = "-" unary | power; power = primary ["^" unary]
+type exprParser struct {
+	src   string
+	pos   int
+	start int
+	tok   string
+}
+
+func (p *exprParser) next() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+	p.start = p.pos
+	if p.pos >= len(p.src) {
+		p.tok = ""
+		return
+	}
+	c := rune(p.src[p.pos])
+	switch {
+	case unicode.IsDigit(c) || c == '.':
+		for p.pos < len(p.src) && (unicode.IsDigit(rune(p.src[p.pos])) || p.src[p.pos] == '.') {
+			p.pos++
+		}
+	case unicode.IsLetter(c) || c == '_':
+		for p.pos < len(p.src) && (unicode.IsLetter(rune(p.src[p.pos])) || unicode.IsDigit(rune(p.src[p.pos])) || p.src[p.pos] == '_') {
+			p.pos++
+		}
+	default:
+		p.pos++
+	}
+	p.tok = p.src[p.start:p.pos]
+}
+
+func (p *exprParser) parseSum() (exprNode, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok == "+" || p.tok == "-" {
+		op := p.tok[0]
+		p.next()
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseProduct() (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok == "*" || p.tok == "/" || p.tok == "%" {
+		op := p.tok[0]
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.tok == "-" {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryNode{operand: operand}, nil
+	}
+	base, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok == "^" {
+		p.next()
+		exponent, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return binaryNode{op: '^', left: base, right: exponent}, nil
+	}
+	return base, nil
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	tok, start := p.tok, p.start
+	switch {
+	case tok == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case tok == "(":
+		p.next()
+		node, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok != ")" {
+			return nil, fmt.Errorf("missing ) at position %d", p.start+1)
+		}
+		p.next()
+		return node, nil
+	case unicode.IsDigit(rune(tok[0])) || tok[0] == '.':
+		value, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d", tok, start+1)
+		}
+		p.next()
+		return numberNode(value), nil
+	case unicode.IsLetter(rune(tok[0])) || tok[0] == '_':
+		p.next()
+		if p.tok != "(" {
+			return varNode(tok), nil
+		}
+		fn, ok := exprFuncs[strings.ToLower(tok)]
+		if !ok {
+			return nil, fmt.Errorf("unknown function %q", tok)
+		}
+		p.next()
+		var args []exprNode
+		for p.tok != ")" {
+			if len(args) > 0 {
+				if p.tok != "," {
+					return nil, fmt.Errorf("expected , or ) at position %d", p.start+1)
+				}
+				p.next()
+			}
+			arg, err := p.parseSum()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+		}
+		p.next()
+		if len(args) != fn.arity {
+			return nil, fmt.Errorf("%s expects %d arguments, got %d", tok, fn.arity, len(args))
+		}
+		return callNode{name: strings.ToLower(tok), args: args}, nil
+	default:
+		return nil, fmt.Errorf("unexpected %q at position %d", tok, start+1)
+	}
+}
@@ -0,0 +1,107 @@
+package domain_test
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ImCtyz/duofinance/backend/internal/domain"
+)
+
+func TestExpressionEval(t *testing.T) {
+	vars := map[string]float64{"price": 1500, "rate": 0.2, "n": 3}
+	cases := []struct {
+		formula string
+		want    float64
+	}{
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"10 - 4 - 3", 3},
+		{"12 / 4 / 3", 1},
+		{"7 % 4", 3},
+		{"-2 ^ 2", -4},
+		{"2 ^ 3 ^ 2", 512},
+		{"2 ^ -1", 0.5},
+		{"--3", 3},
+		{".5 + 1.25", 1.75},
+		{"price * rate", 300},
+		{"price * (1 + rate) ^ n", 2592},
+		{"round(10 / 3, 2)", 3.33},
+		{"ROUND(2.5, 0)", 3},
+		{"floor(2.7) + ceil(2.1)", 5},
+		{"abs(-4) + sqrt(9)", 7},
+		{"pow(2, 10)", 1024},
+		{"min(price, 100) + max(n, 5)", 105},
+	}
+	for _, tc := range cases {
+		expr, err := domain.ParseExpression(tc.formula)
+		if err != nil {
+			t.Errorf("%s: %v", tc.formula, err)
+			continue
+		}
+		got, err := expr.Eval(vars)
+		if err != nil {
+			t.Errorf("%s: %v", tc.formula, err)
+			continue
+		}
+		if math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("%s = %v, want %v", tc.formula, got, tc.want)
+		}
+	}
+}
+
+func TestParseExpressionErrors(t *testing.T) {
+	cases := map[string]string{
+		"":             "unexpected end",
+		"1 +":          "unexpected end",
+		"(1 + 2":       "missing )",
+		"1 2":          "unexpected \"2\"",
+		"1..2":         "invalid number",
+		"foo(1)":       "unknown function",
+		"round(1)":     "expects 2 arguments",
+		"min(1 2)":     "expected , or )",
+		"round(1, 2":   "expected , or )",
+		"1 + $":        "unexpected \"$\"",
+		"abs(1, 2, 3)": "expects 1 arguments",
+	}
+	for formula, want := range cases {
+		_, err := domain.ParseExpression(formula)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got error %v, want %q", formula, err, want)
+		}
+	}
+}
+
+func TestExpressionEvalErrors(t *testing.T) {
+	cases := map[string]string{
+		"1 / 0":        "division by zero",
+		"5 % (2 - 2)":  "division by zero",
+		"sqrt(-1)":     "not a finite number",
+		"pow(10, 400)": "not a finite number",
+		"missing + 1":  "unknown variable",
+		"x / (x - x)":  "division by zero",
+	}
+	for formula, want := range cases {
+		expr, err := domain.ParseExpression(formula)
+		if err != nil {
+			t.Errorf("%q: %v", formula, err)
+			continue
+		}
+		_, err = expr.Eval(map[string]float64{"x": 2})
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got error %v, want %q", formula, err, want)
+		}
+	}
+}
+
+func TestExpressionVars(t *testing.T) {
+	expr, err := domain.ParseExpression("round(price * rate, n) + price - abs(answer)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"answer", "n", "price", "rate"}
+	if got := expr.Vars(); !reflect.DeepEqual(got, want) {
+		t.Errorf("vars = %v, want %v", got, want)
+	}
+}
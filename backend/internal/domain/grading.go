@@ -0,0 +1,441 @@
+package domain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+)
+
+// Answer — ответ игрока; заполняется поле, соответствующее виду вопроса
+type Answer struct {
+	ChoiceIDs []uint            `json:"choice_ids,omitempty"` // choice
+	Number    *float64          `json:"number,omitempty"`     // numeric
+	Order     []string          `json:"order,omitempty"`      // ordering: ID элементов по порядку
+	Matches   map[string]string `json:"matches,omitempty"`    // matching: ID пары -> выбранная правая часть
+	Blanks    map[string]string `json:"blanks,omitempty"`     // cloze: ID пропуска -> текст
+}
+
+// Grade — оценка одного ответа на вопрос
+type Grade struct {
+	Score   float64 // доля зачета от 0 до 1
+	Correct bool    // ответ засчитан полностью
+	Choices []ChoiceGrade
+	Parts   []PartGrade // разбор для ordering, matching и cloze
+}
+
+// ChoiceGrade — разбор ответа по варианту
+type ChoiceGrade struct {
+	ChoiceID uint
+	Selected bool
+	Correct  bool
+}
+
+// PartGrade — разбор ответа по элементу, паре или пропуску
+type PartGrade struct {
+	ID      string
+	Correct bool
+}
+
+// NumericSpec — числовой ответ: верен, если отличается от Value не больше чем на
+// Tolerance или на RelativeTolerance·|Value|
+type NumericSpec struct {
+	Value             float64 `json:"value"`
+	Tolerance         float64 `json:"tolerance,omitempty"`
+	RelativeTolerance float64 `json:"relative_tolerance,omitempty"`
+	Unit              string  `json:"unit,omitempty"`
+}
+
+// OrderingSpec — элементы в правильном порядке
+type OrderingSpec struct {
+	Items []SpecItem `json:"items"`
+}
+
+// MatchingSpec — правильные пары; игрок видит левые части и перемешанные правые
+// и для каждой пары выбирает правую часть
+type MatchingSpec struct {
+	Pairs []MatchPair `json:"pairs"`
+}
+
+// ClozeSpec — текст с пропусками вида {{id}} и допустимые ответы для каждого пропуска
+type ClozeSpec struct {
+	Text   string       `json:"text"`
+	Blanks []ClozeBlank `json:"blanks"`
+}
+
+// SpecItem — элемент упорядочивания
+type SpecItem struct {
+	ID   string `json:"id"`
+	Text string `json:"text"`
+}
+
+// MatchPair — пара для сопоставления
+type MatchPair struct {
+	ID    string `json:"id"`
+	Left  string `json:"left"`
+	Right string `json:"right"`
+}
+
+// ClozeBlank — пропуск в тексте
+type ClozeBlank struct {
+	ID            string   `json:"id"`
+	Answers       []string `json:"answers"`
+	CaseSensitive bool     `json:"case_sensitive,omitempty"`
+}
+
+// ClozePattern — разметка пропуска в ClozeSpec.Text
+var ClozePattern = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_-]+)\s*\}\}`)
+
+// GradingMode — действующий режим оценки вопроса по политике уровня
+func (q *Question) Grading(policy ScoringPolicy) GradingMode {
+	if q.GradingMode != GradingDefault {
+		return q.GradingMode
+	}
+	if q.multiPart() && policy.PartialCredit {
+		return GradingPartial
+	}
+	return GradingExact
+}
+
+// multiPart — ответ состоит из нескольких частей, поэтому возможен частичный зачет
+func (q *Question) multiPart() bool {
+	switch q.QuestionKind() {
+	case KindChoice:
+		return q.MultiSelect
+	case KindOrdering, KindMatching, KindCloze:
+		return true
+	}
+	return false
+}
+
+// QuestionKind — вид вопроса с учетом значения по умолчанию
+func (q *Question) QuestionKind() QuestionKind {
+	if q.Kind == "" {
+		return KindChoice
+	}
+	return q.Kind
+}
+
+// StoresChoices — варианты ответа хранятся в Choices (у шаблонных вопросов они генерируются)
+func (q *Question) StoresChoices() bool {
+	return q.QuestionKind() == KindChoice && q.Template == nil
+}
+
+// DecodeSpec — разбор Spec вопроса в структуру вида вопроса
+func DecodeSpec[T any](q *Question) (*T, error) {
+	var spec T
+	if len(q.Spec) == 0 {
+		return nil, fmt.Errorf("spec is required for %s questions", q.QuestionKind())
+	}
+	if err := json.Unmarshal(q.Spec, &spec); err != nil {
+		return nil, fmt.Errorf("invalid %s spec: %w", q.QuestionKind(), err)
+	}
+	return &spec, nil
+}
+
+// ValidateSpec — проверка Spec и вариантов ответа с учетом вида вопроса
+// (правила для вариантов choice-вопроса проверяются отдельно)
+func (q *Question) ValidateSpec() error {
+	kind := q.QuestionKind()
+	if !kind.Valid() {
+		return errors.New("kind must be one of choice, numeric, ordering, matching, cloze")
+	}
+	if kind == KindChoice {
+		if len(q.Spec) > 0 && string(q.Spec) != "null" {
+			return errors.New("spec is not allowed for choice questions")
+		}
+		return nil
+	}
+	if len(q.Choices) > 0 {
+		return fmt.Errorf("choices are not allowed for %s questions", kind)
+	}
+	if q.MultiSelect {
+		return fmt.Errorf("multi_select is not allowed for %s questions", kind)
+	}
+	switch q.GradingMode {
+	case GradingDefault, GradingExact:
+	case GradingPartial:
+		if kind == KindNumeric {
+			return errors.New("partial grading is not available for numeric questions")
+		}
+	default:
+		return fmt.Errorf("grading mode %q is not available for %s questions", q.GradingMode, kind)
+	}
+
+	switch kind {
+	case KindNumeric:
+		spec, err := DecodeSpec[NumericSpec](q)
+		if err != nil {
+			return err
+		}
+		if spec.Tolerance < 0 || spec.RelativeTolerance < 0 {
+			return errors.New("tolerances must not be negative")
+		}
+	case KindOrdering:
+		spec, err := DecodeSpec[OrderingSpec](q)
+		if err != nil {
+			return err
+		}
+		if len(spec.Items) < 2 {
+			return errors.New("at least two items are required")
+		}
+		ids := make([]string, 0, len(spec.Items))
+		for _, item := range spec.Items {
+			if strings.TrimSpace(item.Text) == "" {
+				return errors.New("item text is required")
+			}
+			ids = append(ids, item.ID)
+		}
+		return checkSpecIDs("item", ids)
+	case KindMatching:
+		spec, err := DecodeSpec[MatchingSpec](q)
+		if err != nil {
+			return err
+		}
+		if len(spec.Pairs) < 2 {
+			return errors.New("at least two pairs are required")
+		}
+		ids := make([]string, 0, len(spec.Pairs))
+		rights := make(map[string]bool, len(spec.Pairs))
+		for _, pair := range spec.Pairs {
+			if strings.TrimSpace(pair.Left) == "" || strings.TrimSpace(pair.Right) == "" {
+				return errors.New("pair left and right are required")
+			}
+			// Правая часть — это ответ игрока, поэтому она должна быть однозначной
+			right := strings.TrimSpace(pair.Right)
+			if rights[right] {
+				return fmt.Errorf("duplicate pair right %q", right)
+			}
+			rights[right] = true
+			ids = append(ids, pair.ID)
+		}
+		return checkSpecIDs("pair", ids)
+	case KindCloze:
+		spec, err := DecodeSpec[ClozeSpec](q)
+		if err != nil {
+			return err
+		}
+		if len(spec.Blanks) == 0 {
+			return errors.New("at least one blank is required")
+		}
+		ids := make([]string, 0, len(spec.Blanks))
+		for _, blank := range spec.Blanks {
+			if len(blank.Answers) == 0 {
+				return fmt.Errorf("blank %q needs at least one answer", blank.ID)
+			}
+			ids = append(ids, blank.ID)
+		}
+		if err := checkSpecIDs("blank", ids); err != nil {
+			return err
+		}
+		inText := make(map[string]bool)
+		for _, match := range ClozePattern.FindAllStringSubmatch(spec.Text, -1) {
+			inText[match[1]] = true
+		}
+		for _, id := range ids {
+			if !inText[id] {
+				return fmt.Errorf("blank %q is not marked in text as {{%s}}", id, id)
+			}
+		}
+		if len(inText) != len(ids) {
+			return errors.New("text marks blanks that are not defined")
+		}
+	}
+	return nil
+}
+
+func checkSpecIDs(kind string, ids []string) error {
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if strings.TrimSpace(id) == "" {
+			return fmt.Errorf("%s id is required", kind)
+		}
+		if seen[id] {
+			return fmt.Errorf("duplicate %s id %q", kind, id)
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+// Grade — оценка ответа answer в режиме mode
+func (q *Question) Grade(answer Answer, mode GradingMode) Grade {
+	var grade Grade
+	switch q.QuestionKind() {
+	case KindNumeric:
+		grade = q.gradeNumeric(answer)
+	case KindOrdering:
+		grade = q.gradeOrdering(answer)
+	case KindMatching:
+		grade = q.gradeMatching(answer)
+	case KindCloze:
+		grade = q.gradeCloze(answer)
+	default:
+		return q.gradeChoices(answer.ChoiceIDs, mode)
+	}
+
+	// Частичный зачет — только в режиме partial; иначе засчитывается лишь полный ответ
+	if mode != GradingPartial && grade.Score < 1 {
+		grade.Score = 0
+	}
+	grade.Correct = grade.Score >= 1
+	return grade
+}
+
+func (q *Question) gradeChoices(choiceIDs []uint, mode GradingMode) Grade {
+	selected := make(map[uint]bool, len(choiceIDs))
+	for _, id := range choiceIDs {
+		selected[id] = true
+	}
+
+	var grade Grade
+	total, hits, wrong := 0, 0, 0
+	known := make(map[uint]bool, len(q.Choices))
+	for _, choice := range q.Choices {
+		known[choice.ID] = true
+		grade.Choices = append(grade.Choices, ChoiceGrade{
+			ChoiceID: choice.ID,
+			Selected: selected[choice.ID],
+			Correct:  choice.IsCorrect,
+		})
+		if choice.IsCorrect {
+			total++
+			if selected[choice.ID] {
+				hits++
+			}
+		} else if selected[choice.ID] {
+			wrong++
+		}
+	}
+	// Варианты не из этого вопроса считаются неверными
+	for id := range selected {
+		if !known[id] {
+			wrong++
+		}
+	}
+
+	switch mode {
+	case GradingPartial:
+		if total > 0 && hits > wrong {
+			grade.Score = float64(hits-wrong) / float64(total)
+		}
+	case GradingAtLeast:
+		need := q.MinCorrect
+		if need <= 0 || need > total {
+			need = total
+		}
+		if wrong == 0 && need > 0 {
+			if hits >= need {
+				grade.Score = 1
+			} else {
+				grade.Score = float64(hits) / float64(need)
+			}
+		}
+	default:
+		if wrong == 0 && hits == total && total > 0 {
+			grade.Score = 1
+		}
+	}
+	grade.Correct = grade.Score >= 1
+	return grade
+}
+
+func (q *Question) gradeNumeric(answer Answer) Grade {
+	spec, err := DecodeSpec[NumericSpec](q)
+	if err != nil || answer.Number == nil {
+		return Grade{}
+	}
+	diff := math.Abs(*answer.Number - spec.Value)
+	allowed := math.Max(spec.Tolerance, spec.RelativeTolerance*math.Abs(spec.Value))
+	if diff <= allowed+1e-9 {
+		return Grade{Score: 1}
+	}
+	return Grade{}
+}
+
+// gradeOrdering — доля элементов, стоящих на своих местах
+func (q *Question) gradeOrdering(answer Answer) Grade {
+	spec, err := DecodeSpec[OrderingSpec](q)
+	if err != nil || len(spec.Items) == 0 {
+		return Grade{}
+	}
+	var grade Grade
+	hits := 0
+	for i, item := range spec.Items {
+		correct := i < len(answer.Order) && answer.Order[i] == item.ID
+		if correct {
+			hits++
+		}
+		grade.Parts = append(grade.Parts, PartGrade{ID: item.ID, Correct: correct})
+	}
+	if len(answer.Order) == len(spec.Items) {
+		grade.Score = float64(hits) / float64(len(spec.Items))
+	} else {
+		// Лишние или пропущенные элементы — ответ неполный
+		grade.Score = float64(hits) / float64(len(spec.Items)+absInt(len(answer.Order)-len(spec.Items)))
+	}
+	return grade
+}
+
+// gradeMatching — доля верно сопоставленных пар
+func (q *Question) gradeMatching(answer Answer) Grade {
+	spec, err := DecodeSpec[MatchingSpec](q)
+	if err != nil || len(spec.Pairs) == 0 {
+		return Grade{}
+	}
+	var grade Grade
+	hits := 0
+	for _, pair := range spec.Pairs {
+		correct := strings.TrimSpace(answer.Matches[pair.ID]) == strings.TrimSpace(pair.Right)
+		if correct {
+			hits++
+		}
+		grade.Parts = append(grade.Parts, PartGrade{ID: pair.ID, Correct: correct})
+	}
+	grade.Score = float64(hits) / float64(len(spec.Pairs))
+	return grade
+}
+
+// gradeCloze — доля верно заполненных пропусков; пробелы нормализуются,
+// регистр не учитывается, если пропуск не CaseSensitive
+func (q *Question) gradeCloze(answer Answer) Grade {
+	spec, err := DecodeSpec[ClozeSpec](q)
+	if err != nil || len(spec.Blanks) == 0 {
+		return Grade{}
+	}
+	var grade Grade
+	hits := 0
+	for _, blank := range spec.Blanks {
+		given := normalizeBlank(answer.Blanks[blank.ID], blank.CaseSensitive)
+		correct := false
+		for _, accepted := range blank.Answers {
+			if given != "" && given == normalizeBlank(accepted, blank.CaseSensitive) {
+				correct = true
+				break
+			}
+		}
+		if correct {
+			hits++
+		}
+		grade.Parts = append(grade.Parts, PartGrade{ID: blank.ID, Correct: correct})
+	}
+	grade.Score = float64(hits) / float64(len(spec.Blanks))
+	return grade
+}
+
+func normalizeBlank(value string, caseSensitive bool) string {
+	value = strings.Join(strings.Fields(value), " ")
+	if !caseSensitive {
+		value = strings.ToLower(value)
+	}
+	return value
+}
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
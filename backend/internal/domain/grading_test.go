@@ -0,0 +1,166 @@
+package domain_test
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/ImCtyz/duofinance/backend/internal/domain"
+)
+
+type gradeCase struct {
+	name       string
+	answer     domain.Answer
+	mode       domain.GradingMode
+	minCorrect int
+	score      float64
+	correct    bool
+}
+
+func checkGrades(t *testing.T, q *domain.Question, cases []gradeCase) {
+	t.Helper()
+	for _, tc := range cases {
+		q.MinCorrect = tc.minCorrect
+		grade := q.Grade(tc.answer, tc.mode)
+		if math.Abs(grade.Score-tc.score) > 1e-9 || grade.Correct != tc.correct {
+			t.Errorf("%s: score %v, correct %v; want %v, %v", tc.name, grade.Score, grade.Correct, tc.score, tc.correct)
+		}
+	}
+}
+
+func TestGradeChoices(t *testing.T) {
+	q := &domain.Question{
+		MultiSelect: true,
+		Choices: []domain.Choice{
+			{Model: domain.Model{ID: 1}, IsCorrect: true},
+			{Model: domain.Model{ID: 2}, IsCorrect: true},
+			{Model: domain.Model{ID: 3}, IsCorrect: true},
+			{Model: domain.Model{ID: 4}},
+		},
+	}
+	ids := func(ids ...uint) domain.Answer { return domain.Answer{ChoiceIDs: ids} }
+	checkGrades(t, q, []gradeCase{
+		{name: "exact: all correct", answer: ids(1, 2, 3), mode: domain.GradingExact, score: 1, correct: true},
+		{name: "exact: missing one", answer: ids(1, 2), mode: domain.GradingExact},
+		{name: "exact: extra wrong", answer: ids(1, 2, 3, 4), mode: domain.GradingExact},
+		{name: "exact: unknown id", answer: ids(1, 2, 3, 99), mode: domain.GradingExact},
+		{name: "exact: nothing selected", answer: ids(), mode: domain.GradingExact},
+		{name: "default is exact", answer: ids(1, 2), mode: domain.GradingDefault},
+
+		{name: "partial: two of three", answer: ids(1, 2), mode: domain.GradingPartial, score: 2.0 / 3},
+		{name: "partial: wrong cancels a hit", answer: ids(1, 2, 4), mode: domain.GradingPartial, score: 1.0 / 3},
+		{name: "partial: unknown id is wrong", answer: ids(1, 99), mode: domain.GradingPartial},
+		{name: "partial: duplicates count once", answer: ids(1, 1, 1), mode: domain.GradingPartial, score: 1.0 / 3},
+		{name: "partial: only wrong", answer: ids(4), mode: domain.GradingPartial},
+
+		{name: "at least: enough", answer: ids(1, 2), mode: domain.GradingAtLeast, minCorrect: 2, score: 1, correct: true},
+		{name: "at least: more than enough", answer: ids(1, 2, 3), mode: domain.GradingAtLeast, minCorrect: 2, score: 1, correct: true},
+		{name: "at least: short", answer: ids(1), mode: domain.GradingAtLeast, minCorrect: 2, score: 0.5},
+		{name: "at least: any wrong", answer: ids(1, 2, 4), mode: domain.GradingAtLeast, minCorrect: 2},
+		{name: "at least: unknown id", answer: ids(1, 2, 99), mode: domain.GradingAtLeast, minCorrect: 2},
+		{name: "at least: zero means all", answer: ids(1, 2), mode: domain.GradingAtLeast, score: 2.0 / 3},
+		{name: "at least: negative means all", answer: ids(1), mode: domain.GradingAtLeast, minCorrect: -1, score: 1.0 / 3},
+		{name: "at least: above total means all", answer: ids(1, 2, 3), mode: domain.GradingAtLeast, minCorrect: 5, score: 1, correct: true},
+		{name: "at least: above total, short", answer: ids(1, 2), mode: domain.GradingAtLeast, minCorrect: 5, score: 2.0 / 3},
+	})
+
+	grade := q.Grade(ids(2, 4), domain.GradingExact)
+	want := []domain.ChoiceGrade{
+		{ChoiceID: 1, Correct: true},
+		{ChoiceID: 2, Selected: true, Correct: true},
+		{ChoiceID: 3, Correct: true},
+		{ChoiceID: 4, Selected: true},
+	}
+	if !reflect.DeepEqual(grade.Choices, want) {
+		t.Errorf("choices = %+v, want %+v", grade.Choices, want)
+	}
+
+	// Без верных вариантов зачет невозможен ни в одном режиме
+	q.Choices = []domain.Choice{{Model: domain.Model{ID: 1}}}
+	checkGrades(t, q, []gradeCase{
+		{name: "no correct choices: exact", answer: ids(), mode: domain.GradingExact},
+		{name: "no correct choices: partial", answer: ids(), mode: domain.GradingPartial},
+		{name: "no correct choices: at least", answer: ids(), mode: domain.GradingAtLeast, minCorrect: 1},
+	})
+}
+
+func TestGradeOrdering(t *testing.T) {
+	q := &domain.Question{
+		Kind: domain.KindOrdering,
+		Spec: []byte(`{"items":[{"id":"a","text":"A"},{"id":"b","text":"B"},{"id":"c","text":"C"},{"id":"d","text":"D"}]}`),
+	}
+	order := func(ids ...string) domain.Answer { return domain.Answer{Order: ids} }
+	checkGrades(t, q, []gradeCase{
+		{name: "in order", answer: order("a", "b", "c", "d"), mode: domain.GradingPartial, score: 1, correct: true},
+		{name: "swapped pair", answer: order("b", "a", "c", "d"), mode: domain.GradingPartial, score: 0.5},
+		{name: "missing item", answer: order("a", "b", "c"), mode: domain.GradingPartial, score: 3.0 / 5},
+		{name: "extra item", answer: order("a", "b", "c", "d", "e"), mode: domain.GradingPartial, score: 4.0 / 5},
+		{name: "repeated item", answer: order("a", "a", "c", "d"), mode: domain.GradingPartial, score: 0.75},
+		{name: "unknown ids", answer: order("x", "y", "z", "w"), mode: domain.GradingPartial},
+		{name: "empty", answer: order(), mode: domain.GradingPartial},
+		{name: "exact: in order", answer: order("a", "b", "c", "d"), mode: domain.GradingExact, score: 1, correct: true},
+		{name: "exact: swapped pair", answer: order("b", "a", "c", "d"), mode: domain.GradingExact},
+		{name: "exact: extra item", answer: order("a", "b", "c", "d", "e"), mode: domain.GradingExact},
+	})
+
+	grade := q.Grade(order("b", "a", "c"), domain.GradingPartial)
+	want := []domain.PartGrade{{ID: "a"}, {ID: "b"}, {ID: "c", Correct: true}, {ID: "d"}}
+	if !reflect.DeepEqual(grade.Parts, want) {
+		t.Errorf("parts = %+v, want %+v", grade.Parts, want)
+	}
+}
+
+func TestGradeMatching(t *testing.T) {
+	q := &domain.Question{
+		Kind: domain.KindMatching,
+		Spec: []byte(`{"pairs":[{"id":"p1","left":"Salary","right":"Income"},{"id":"p2","left":"Rent","right":"Expense"},{"id":"p3","left":"Deposit","right":"Savings"}]}`),
+	}
+	matches := func(m map[string]string) domain.Answer { return domain.Answer{Matches: m} }
+	all := map[string]string{"p1": "Income", "p2": "Expense", "p3": "Savings"}
+	checkGrades(t, q, []gradeCase{
+		{name: "all pairs", answer: matches(all), mode: domain.GradingPartial, score: 1, correct: true},
+		{name: "surrounding spaces", answer: matches(map[string]string{"p1": " Income ", "p2": "Expense\t", "p3": "Savings"}), mode: domain.GradingPartial, score: 1, correct: true},
+		{name: "swapped rights", answer: matches(map[string]string{"p1": "Expense", "p2": "Income", "p3": "Savings"}), mode: domain.GradingPartial, score: 1.0 / 3},
+		{name: "missing pair", answer: matches(map[string]string{"p1": "Income", "p2": "Expense"}), mode: domain.GradingPartial, score: 2.0 / 3},
+		{name: "extra pair ignored", answer: matches(map[string]string{"p1": "Income", "p2": "Expense", "p3": "Savings", "p9": "Debt"}), mode: domain.GradingPartial, score: 1, correct: true},
+		{name: "case matters", answer: matches(map[string]string{"p1": "income", "p2": "Expense", "p3": "Savings"}), mode: domain.GradingPartial, score: 2.0 / 3},
+		{name: "empty", answer: matches(nil), mode: domain.GradingPartial},
+		{name: "exact: missing pair", answer: matches(map[string]string{"p1": "Income", "p2": "Expense"}), mode: domain.GradingExact},
+	})
+}
+
+func TestGradeCloze(t *testing.T) {
+	q := &domain.Question{
+		Kind: domain.KindCloze,
+		Spec: []byte(`{"text":"{{b1}} grows faster than simple interest; compare loans by {{b2}}.",
+			"blanks":[{"id":"b1","answers":["compound interest","compounding"]},{"id":"b2","answers":["APR"],"case_sensitive":true}]}`),
+	}
+	blanks := func(m map[string]string) domain.Answer { return domain.Answer{Blanks: m} }
+	checkGrades(t, q, []gradeCase{
+		{name: "exact text", answer: blanks(map[string]string{"b1": "compound interest", "b2": "APR"}), mode: domain.GradingPartial, score: 1, correct: true},
+		{name: "case and spaces normalized", answer: blanks(map[string]string{"b1": "  Compound \t INTEREST ", "b2": " APR "}), mode: domain.GradingPartial, score: 1, correct: true},
+		{name: "alternative answer", answer: blanks(map[string]string{"b1": "Compounding", "b2": "APR"}), mode: domain.GradingPartial, score: 1, correct: true},
+		{name: "case-sensitive blank", answer: blanks(map[string]string{"b1": "compounding", "b2": "apr"}), mode: domain.GradingPartial, score: 0.5},
+		{name: "inner words must match", answer: blanks(map[string]string{"b1": "compound interest rate", "b2": "APR"}), mode: domain.GradingPartial, score: 0.5},
+		{name: "blank left empty", answer: blanks(map[string]string{"b1": "   ", "b2": "APR"}), mode: domain.GradingPartial, score: 0.5},
+		{name: "missing blank", answer: blanks(map[string]string{"b1": "compounding"}), mode: domain.GradingPartial, score: 0.5},
+		{name: "extra blank ignored", answer: blanks(map[string]string{"b1": "compounding", "b2": "APR", "b3": "x"}), mode: domain.GradingPartial, score: 1, correct: true},
+		{name: "exact: one blank wrong", answer: blanks(map[string]string{"b1": "compounding", "b2": "apr"}), mode: domain.GradingExact},
+	})
+
+	grade := q.Grade(blanks(map[string]string{"b1": "COMPOUNDING", "b2": "apr"}), domain.GradingPartial)
+	want := []domain.PartGrade{{ID: "b1", Correct: true}, {ID: "b2"}}
+	if !reflect.DeepEqual(grade.Parts, want) {
+		t.Errorf("parts = %+v, want %+v", grade.Parts, want)
+	}
+}
+
+// Ответ вида вопроса, не совпадающий со Spec, не засчитывается
+func TestGradeCorruptedSpec(t *testing.T) {
+	for _, kind := range []domain.QuestionKind{domain.KindOrdering, domain.KindMatching, domain.KindCloze} {
+		q := &domain.Question{Kind: kind, Spec: []byte(`{`)}
+		if grade := q.Grade(domain.Answer{}, domain.GradingPartial); grade.Score != 0 || grade.Correct {
+			t.Errorf("%s: corrupted spec graded %+v", kind, grade)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package domain
 
 import (
+	"encoding/json"
+	"fmt"
 	"time"
 
 	"gorm.io/datatypes"
@@ -18,10 +20,24 @@ type Model struct {
 // User — аккаунт игрока.
 type User struct {
 	Model
-	Email        string  `gorm:"size:255;uniqueIndex;not null"`
-	Username     string  `gorm:"size:255;uniqueIndex;not null"`
-	PasswordHash string  `gorm:"size:255;not null"`
-	Profile      Profile `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Email           string   `gorm:"size:255;uniqueIndex;not null"`
+	Username        string   `gorm:"size:255;uniqueIndex;not null"`
+	PasswordHash    string   `gorm:"size:255;not null"`
+	Role            UserRole `gorm:"size:20;index;not null;default:'learner'"`
+	EmailVerifiedAt *time.Time
+
+	// Защита от перебора паролей
+	FailedLoginAttempts int `gorm:"not null;default:0"`
+	LastFailedLoginAt   *time.Time
+	LockedUntil         *time.Time
+
+	// Двухфакторная аутентификация (TOTP). Секрет сохраняется при регистрации
+	// приложения, TOTPEnabledAt — после подтверждения первым кодом
+	TOTPSecret    string     `gorm:"column:totp_secret;size:64"`
+	TOTPEnabledAt *time.Time `gorm:"column:totp_enabled_at"`
+	TOTPLastStep  int64      `gorm:"column:totp_last_step;not null;default:0"` // последний принятый шаг, защита от повтора кода
+
+	Profile Profile `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
 
 	// Связи
 	Attempts     []Attempt     `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
@@ -40,48 +56,220 @@ type Profile struct {
 	Meta   datatypes.JSON // дополнительная мета
 }
 
+// Session — выданный refresh-токен. Каждая ротация создает новую запись
+// в той же цепочке (FamilyID), предыдущая помечается отозванной.
+type Session struct {
+	Model
+	UserID     uint      `gorm:"index;not null"`
+	JTI        string    `gorm:"column:jti;size:64;uniqueIndex;not null"`
+	FamilyID   string    `gorm:"size:64;index;not null"`
+	Device     string    `gorm:"size:255"` // человекочитаемое описание клиента
+	UserAgent  string    `gorm:"size:512"`
+	IP         string    `gorm:"column:ip;size:64"`
+	IssuedAt   time.Time `gorm:"not null"`
+	ExpiresAt  time.Time `gorm:"index;not null"`
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+	ReplacedBy string `gorm:"size:64"` // jti токена, выданного при ротации
+}
+
+// UserToken — одноразовый токен из письма (сброс пароля, подтверждение email).
+// Хранится только SHA-256 хеш токена.
+type UserToken struct {
+	Model
+	UserID    uint             `gorm:"index:idx_user_tokens_user_purpose,priority:1;not null"`
+	Purpose   UserTokenPurpose `gorm:"size:50;index:idx_user_tokens_user_purpose,priority:2;not null"`
+	TokenHash string           `gorm:"size:64;uniqueIndex;not null"`
+	ExpiresAt time.Time        `gorm:"not null"`
+	UsedAt    *time.Time
+}
+
+// RecoveryCode — одноразовый код восстановления доступа при потере TOTP устройства.
+// Хранится только SHA-256 хеш кода.
+type RecoveryCode struct {
+	Model
+	UserID   uint   `gorm:"index;not null"`
+	CodeHash string `gorm:"size:64;not null"`
+	UsedAt   *time.Time
+}
+
+// UserIdentity — внешний аккаунт (OIDC провайдер), через который пользователь может входить.
+type UserIdentity struct {
+	Model
+	UserID      uint   `gorm:"index:idx_user_identities_user_provider,unique,priority:1;not null"`
+	Provider    string `gorm:"size:50;index:idx_user_identities_user_provider,unique,priority:2;index:idx_user_identities_provider_subject,unique,priority:1;not null"`
+	Subject     string `gorm:"size:255;index:idx_user_identities_provider_subject,unique,priority:2;not null"` // claim sub провайдера
+	Email       string `gorm:"size:255"`
+	LastLoginAt *time.Time
+}
+
+// OIDCState — незавершенный вход через OIDC провайдера: state, nonce и PKCE verifier.
+// UserID заполнен, если пользователь привязывает провайдера к своему аккаунту.
+type OIDCState struct {
+	Model
+	State        string    `gorm:"size:64;uniqueIndex;not null"`
+	Provider     string    `gorm:"size:50;not null"`
+	Nonce        string    `gorm:"size:64;not null"`
+	CodeVerifier string    `gorm:"size:128;not null"`
+	UserID       *uint     `gorm:"index"`
+	ExpiresAt    time.Time `gorm:"not null"`
+	UsedAt       *time.Time
+}
+
+func (OIDCState) TableName() string {
+	return "oidc_states"
+}
+
 // Level — карточка уровня (тема, сложность, награда, набор шагов).
 type Level struct {
 	Model
-	Title        string      `gorm:"size:255;not null"`
-	Topic        string      `gorm:"size:255"`
-	Difficulty   string      `gorm:"size:50;index"` // e.g. easy|medium|hard
-	RewardPoints int         `gorm:"not null;default:0"`
-	IsActive     bool        `gorm:"not null;default:true"`
-	Steps        []LevelStep `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Slug         string `gorm:"size:255;default:null;index:uq_levels_slug,unique,where:deleted_at IS NULL"`
+	Title        string `gorm:"size:255;not null"`
+	Topic        string `gorm:"size:255"`
+	Difficulty   string `gorm:"size:50;index"` // e.g. easy|medium|hard
+	RewardPoints int    `gorm:"not null;default:0"`
+	IsActive     bool   `gorm:"not null;default:true"`
+	// Правила подсчета результата; nil — DefaultScoringPolicy
+	ScoringPolicy *ScoringPolicy `gorm:"type:jsonb;serializer:json"`
+	// Место уровня в курсе: юнит и порядок внутри юнита
+	UnitID    *uint `gorm:"index"`
+	UnitOrder int   `gorm:"not null;default:0"`
+	// Опубликованная ревизия, которую видят игроки; nil — уровень еще не публиковался
+	// и игрокам не виден
+	PublishedRevisionID *uint
+	Steps               []LevelStep `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+}
+
+// Course — курс: упорядоченные юниты с уровнями.
+type Course struct {
+	Model
+	Slug        string `gorm:"size:255;not null;index:uq_courses_slug,unique,where:deleted_at IS NULL"`
+	Title       string `gorm:"size:255;not null"`
+	Description string `gorm:"type:text"`
+	Order       int    `gorm:"not null;default:0"`
+	IsActive    bool   `gorm:"not null;default:true"`
+	Units       []Unit `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+}
+
+// Unit — раздел курса.
+type Unit struct {
+	Model
+	CourseID uint    `gorm:"index;not null"`
+	Title    string  `gorm:"size:255;not null"`
+	Order    int     `gorm:"not null;default:0"`
+	Levels   []Level `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
+}
+
+// LevelPrerequisite — ребро графа зависимостей: LevelID открывается после того,
+// как RequiredLevelID пройден не ниже MinScore. Граф ацикличен.
+type LevelPrerequisite struct {
+	LevelID         uint `gorm:"primaryKey"`
+	RequiredLevelID uint `gorm:"primaryKey;index"`
+	MinScore        int  `gorm:"not null;default:70"`
+	CreatedAt       time.Time
+}
+
+// LevelRevision — опубликованная версия уровня: неизменяемый снимок уровня
+// с шагами, вопросами и вариантами ответов (JSON domain.Level).
+type LevelRevision struct {
+	ID             uint           `gorm:"primaryKey"`
+	LevelID        uint           `gorm:"not null;uniqueIndex:uq_level_revision_number,priority:1"`
+	Number         int            `gorm:"not null;uniqueIndex:uq_level_revision_number,priority:2"`
+	Snapshot       datatypes.JSON `gorm:"not null"`
+	PublishedBy    *uint
+	RolledBackFrom *int   // номер ревизии, снимок которой опубликован повторно
+	Note           string `gorm:"size:500"`
+	CreatedAt      time.Time
+}
+
+// NewLevelRevision - ревизия со снимком уровня; level должен содержать шаги
+// с вопросами и вариантами ответов
+func NewLevelRevision(level *Level) (*LevelRevision, error) {
+	snapshot, err := json.Marshal(level)
+	if err != nil {
+		return nil, err
+	}
+	return &LevelRevision{LevelID: level.ID, Snapshot: datatypes.JSON(snapshot)}, nil
+}
+
+// Level - уровень в том виде, в котором он был опубликован
+func (r *LevelRevision) Level() (*Level, error) {
+	var level Level
+	if err := json.Unmarshal(r.Snapshot, &level); err != nil {
+		return nil, err
+	}
+	return &level, nil
 }
 
 // LevelStep — шаг/этап уровня (вопрос, симуляция, текст, тип).
 type LevelStep struct {
 	Model
-	LevelID uint           `gorm:"index:idx_level_step_order,unique,priority:1;not null"`
-	Order   int            `gorm:"index:idx_level_step_order,unique,priority:2;not null"`
+	LevelID uint           `gorm:"index:uq_level_step_order,unique,where:deleted_at IS NULL,priority:1;index:uq_level_steps_slug,unique,where:deleted_at IS NULL,priority:1;not null"`
+	Order   int            `gorm:"index:uq_level_step_order,unique,where:deleted_at IS NULL,priority:2;not null"`
+	Slug    string         `gorm:"size:255;default:null;index:uq_level_steps_slug,unique,where:deleted_at IS NULL,priority:2"`
 	Type    string         `gorm:"size:50;not null;index"` // question|simulation|text|...
 	Title   string         `gorm:"size:255"`
 	Payload datatypes.JSON // произвольный JSON для симуляций/текстовых шагов
 	// If this step is a question, link to Question
-	QuestionID *uint
+	QuestionID *uint     `gorm:"index"`
 	Question   *Question `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
 }
 
 // Question — структура вопроса/вариантов.
 type Question struct {
 	Model
-	Prompt      string   `gorm:"type:text;not null"`
-	Explanation string   `gorm:"type:text"`
-	MultiSelect bool     `gorm:"not null;default:false"`
-	Choices     []Choice `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Slug        string `gorm:"size:255;default:null;index:uq_questions_slug,unique,where:deleted_at IS NULL"`
+	Prompt      string `gorm:"type:text;not null"`
+	Explanation string `gorm:"type:text"`
+	// Вид вопроса; для всех видов, кроме choice, правильный ответ хранится в Spec
+	Kind QuestionKind   `gorm:"size:20;not null;default:'choice'"`
+	Spec datatypes.JSON `gorm:"type:jsonb"`
+	// Шаблон со случайными значениями; nil — обычный вопрос
+	Template    *QuestionTemplate `gorm:"type:jsonb;serializer:json"`
+	MultiSelect bool              `gorm:"not null;default:false"`
+	// Режим оценки ответа; MinCorrect — число верных вариантов для at_least
+	GradingMode GradingMode `gorm:"size:20;not null;default:''"`
+	MinCorrect  int         `gorm:"not null;default:0"`
+	Choices     []Choice    `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
 }
 
 // Choice — варианты ответа.
 type Choice struct {
 	Model
-	QuestionID uint   `gorm:"index;not null"`
+	QuestionID uint   `gorm:"index;index:uq_choices_slug,unique,where:deleted_at IS NULL,priority:1;not null"`
+	Slug       string `gorm:"size:255;default:null;index:uq_choices_slug,unique,where:deleted_at IS NULL,priority:2"`
 	Text       string `gorm:"type:text;not null"`
 	IsCorrect  bool   `gorm:"not null;default:false"`
 	Order      int    `gorm:"not null;default:0"`
 }
 
+// Slug по умолчанию для записей контента, созданных без него (редактор, старые данные):
+// <вид>-<id>. Назначается в той же транзакции, что и вставка.
+
+func (l *Level) AfterCreate(tx *gorm.DB) error {
+	return assignSlug(tx, l, &l.Slug, "level", l.ID)
+}
+
+func (s *LevelStep) AfterCreate(tx *gorm.DB) error {
+	return assignSlug(tx, s, &s.Slug, "step", s.ID)
+}
+
+func (q *Question) AfterCreate(tx *gorm.DB) error {
+	return assignSlug(tx, q, &q.Slug, "question", q.ID)
+}
+
+func (c *Choice) AfterCreate(tx *gorm.DB) error {
+	return assignSlug(tx, c, &c.Slug, "choice", c.ID)
+}
+
+func assignSlug(tx *gorm.DB, model interface{}, slug *string, kind string, id uint) error {
+	if *slug != "" {
+		return nil
+	}
+	*slug = fmt.Sprintf("%s-%d", kind, id)
+	return tx.Model(model).UpdateColumn("slug", *slug).Error
+}
+
 // Attempt — попытка прохождения уровня.
 type Attempt struct {
 	Model
@@ -89,9 +277,17 @@ type Attempt struct {
 	LevelID     uint          `gorm:"index;not null"`
 	Status      AttemptStatus `gorm:"size:50;index;not null;default:'in_progress'"`
 	ResultScore int           `gorm:"not null;default:0"`
+	Passed      bool          `gorm:"not null;default:false"` // результат не ниже проходного балла политики уровня
 	StartedAt   time.Time     `gorm:"not null"`
 	CompletedAt *time.Time
-	Steps       []AttemptStep `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	// Ревизия уровня, на которой начата попытка; nil — попытка идет по рабочим таблицам
+	LevelRevisionID *uint `gorm:"index"`
+	// Зерно для шаблонных вопросов (см. QuestionSeed)
+	Seed int64 `gorm:"not null;default:0"`
+	// Шаг уровня, показанный игроку последним, и время показа; сбрасываются после записи шага
+	ServedStepID *uint
+	ServedAt     *time.Time
+	Steps        []AttemptStep `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
 }
 
 // AttemptStep — запись по шагам внутри попытки.
@@ -103,7 +299,10 @@ type AttemptStep struct {
 	StepOrder   int            `gorm:"not null;index"`
 	Response    datatypes.JSON // ответы пользователя
 	Correct     bool           `gorm:"not null;default:false"`
-	DurationMs  int64          `gorm:"not null;default:0"`
+	Score       float64        `gorm:"not null;default:0"` // доля зачета ответа от 0 до 1
+	ServedAt    *time.Time     // когда шаг был показан игроку
+	AnsweredAt  *time.Time     // когда игрок ответил
+	DurationMs  int64          `gorm:"not null;default:0"` // AnsweredAt - ServedAt
 }
 
 // Achievement — достижения/бейджи.
@@ -127,17 +326,31 @@ type UserAchievement struct {
 // RewardTx — транзакция наград (начисления/списания).
 type RewardTx struct {
 	Model
-	UserID    uint   `gorm:"index;not null"`
-	Amount    int64  `gorm:"not null"`               // положительное — начисление, отрицательное — списание
-	Type      string `gorm:"size:50;index;not null"` // earn|spend|bonus|...
-	Reason    string `gorm:"size:255"`
-	AttemptID *uint  `gorm:"index"`
+	UserID uint   `gorm:"index;not null"`
+	Amount int64  `gorm:"not null"`               // положительное — начисление, отрицательное — списание
+	Type   string `gorm:"size:50;index;not null"` // earn|spend|bonus|...
+	Reason string `gorm:"size:255"`
+	// Награда за прохождение начисляется по попытке не более одного раза
+	AttemptID *uint `gorm:"index:uq_reward_txs_attempt,unique,where:type = 'earn' AND deleted_at IS NULL"`
 }
 
 func (RewardTx) TableName() string {
 	return "reward_txs"
 }
 
+// IdempotencyKey — ответ на запрос с заголовком Idempotency-Key. Повтор запроса
+// с тем же ключом получает сохраненный ответ вместо повторного выполнения.
+type IdempotencyKey struct {
+	Model
+	UserID      uint   `gorm:"index:uq_idempotency_keys_user_key,unique,priority:1;not null"`
+	Key         string `gorm:"size:255;index:uq_idempotency_keys_user_key,unique,priority:2;not null"`
+	Fingerprint string `gorm:"size:64;not null"` // SHA-256 метода, пути и тела запроса
+	StatusCode  int    `gorm:"not null;default:0"`
+	Response    datatypes.JSON
+	CompletedAt *time.Time // nil — запрос еще выполняется
+	ExpiresAt   time.Time  `gorm:"not null;index"`
+}
+
 // Hint — подсказки, которые можно выдавать пользователю.
 type Hint struct {
 	Model
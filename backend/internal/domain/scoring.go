@@ -0,0 +1,111 @@
+package domain
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// ScoringPolicy — правила подсчета результата попытки уровня. Хранится в уровне
+// (и попадает в снимок ревизии); nil в уровне означает DefaultScoringPolicy.
+type ScoringPolicy struct {
+	// Минимальный результат (0–100), с которого уровень считается пройденным
+	PassScore int `json:"pass_score"`
+	// Вклад вопроса в зависимости от числа ошибок до первого верного ответа:
+	// PenaltyCurve[0] — без ошибок; при большем числе ошибок берется последний элемент
+	PenaltyCurve []float64 `json:"penalty_curve"`
+	// Бонус за скорость, начисляется только пройденным попыткам
+	TimeBonus *TimeBonus `json:"time_bonus,omitempty"`
+	// Частичный зачет (GradingPartial) для вопросов с множественным выбором, у которых
+	// режим оценки не задан явно
+	PartialCredit bool `json:"partial_credit,omitempty"`
+}
+
+// TimeBonus — до MaxPoints баллов, линейно убывающих к TargetSeconds от начала попытки
+type TimeBonus struct {
+	MaxPoints     int `json:"max_points"`
+	TargetSeconds int `json:"target_seconds"`
+}
+
+// DefaultScoringPolicy — правила по умолчанию: проходной балл 70 и кривая
+// 1 / 0.7 / 0.4 / 0.1 / 0 для 0, 1, 2, 3 и 4+ ошибок
+func DefaultScoringPolicy() ScoringPolicy {
+	return ScoringPolicy{
+		PassScore:    70,
+		PenaltyCurve: []float64{1, 0.7, 0.4, 0.1, 0},
+	}
+}
+
+// Scoring — действующая политика подсчета уровня
+func (l *Level) Scoring() ScoringPolicy {
+	if l.ScoringPolicy == nil {
+		return DefaultScoringPolicy()
+	}
+	return *l.ScoringPolicy
+}
+
+// Validate — проверка значений политики
+func (p ScoringPolicy) Validate() error {
+	if p.PassScore < 0 || p.PassScore > 100 {
+		return errors.New("pass_score must be between 0 and 100")
+	}
+	if len(p.PenaltyCurve) == 0 {
+		return errors.New("penalty_curve must not be empty")
+	}
+	for i, factor := range p.PenaltyCurve {
+		if factor < 0 || factor > 1 {
+			return errors.New("penalty_curve values must be between 0 and 1")
+		}
+		if i > 0 && factor > p.PenaltyCurve[i-1] {
+			return errors.New("penalty_curve must not increase")
+		}
+	}
+	if p.TimeBonus != nil {
+		if p.TimeBonus.MaxPoints < 0 || p.TimeBonus.MaxPoints > 100 {
+			return errors.New("time_bonus.max_points must be between 0 and 100")
+		}
+		if p.TimeBonus.TargetSeconds <= 0 {
+			return errors.New("time_bonus.target_seconds must be positive")
+		}
+	}
+	return nil
+}
+
+// Factor — вклад вопроса при mistakes ошибках. Частично засчитанный ответ — это
+// дробная ошибка (1 - Grade.Score); такое число интерполируется между точками кривой
+func (p ScoringPolicy) Factor(mistakes float64) float64 {
+	last := len(p.PenaltyCurve) - 1
+	if last < 0 {
+		return 0
+	}
+	if mistakes <= 0 {
+		return p.PenaltyCurve[0]
+	}
+	if mistakes >= float64(last) {
+		return p.PenaltyCurve[last]
+	}
+	lower := int(math.Floor(mistakes))
+	frac := mistakes - float64(lower)
+	return p.PenaltyCurve[lower] + (p.PenaltyCurve[lower+1]-p.PenaltyCurve[lower])*frac
+}
+
+// Passed — пройден ли уровень с результатом score
+func (p ScoringPolicy) Passed(score int) bool {
+	return score >= p.PassScore
+}
+
+// Bonus — бонус за скорость для пройденной попытки длительностью elapsed
+func (p ScoringPolicy) Bonus(score int, elapsed time.Duration) int {
+	if p.TimeBonus == nil || !p.Passed(score) {
+		return 0
+	}
+	target := time.Duration(p.TimeBonus.TargetSeconds) * time.Second
+	if elapsed >= target {
+		return 0
+	}
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	share := 1 - float64(elapsed)/float64(target)
+	return int(float64(p.TimeBonus.MaxPoints)*share + 0.5)
+}
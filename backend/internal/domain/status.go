@@ -7,3 +7,91 @@ const (
 	AttemptCompleted  AttemptStatus = "completed"
 	AttemptFailed     AttemptStatus = "failed"
 )
+
+type UserTokenPurpose string
+
+const (
+	TokenPasswordReset UserTokenPurpose = "password_reset"
+	TokenEmailVerify   UserTokenPurpose = "email_verify"
+)
+
+// UserRole - роль пользователя: learner проходит уровни, editor управляет контентом,
+// admin дополнительно управляет пользователями
+type UserRole string
+
+const (
+	RoleLearner UserRole = "learner"
+	RoleEditor  UserRole = "editor"
+	RoleAdmin   UserRole = "admin"
+)
+
+// Valid - известна ли роль
+func (r UserRole) Valid() bool {
+	switch r {
+	case RoleLearner, RoleEditor, RoleAdmin:
+		return true
+	}
+	return false
+}
+
+// Типы шагов уровня (LevelStep.Type)
+const (
+	StepTypeQuestion   = "question"
+	StepTypeText       = "text"
+	StepTypeSimulation = "simulation"
+)
+
+// Уровни сложности (Level.Difficulty)
+const (
+	DifficultyEasy   = "easy"
+	DifficultyMedium = "medium"
+	DifficultyHard   = "hard"
+)
+
+// LevelState - состояние уровня для конкретного игрока на карте курса
+type LevelState string
+
+const (
+	LevelLocked    LevelState = "locked"
+	LevelAvailable LevelState = "available"
+	LevelCompleted LevelState = "completed"
+)
+
+// GradingMode - способ оценки ответа на вопрос с вариантами
+type GradingMode string
+
+const (
+	GradingDefault GradingMode = ""         // exact, либо partial для множественного выбора при ScoringPolicy.PartialCredit
+	GradingExact   GradingMode = "exact"    // зачет только за точный набор верных вариантов
+	GradingPartial GradingMode = "partial"  // доля верных вариантов, каждый неверный отнимает одно попадание
+	GradingAtLeast GradingMode = "at_least" // зачет за MinCorrect верных вариантов без неверных
+)
+
+// Valid - известен ли режим оценки
+func (m GradingMode) Valid() bool {
+	switch m {
+	case GradingDefault, GradingExact, GradingPartial, GradingAtLeast:
+		return true
+	}
+	return false
+}
+
+// QuestionKind - вид вопроса и формат ответа на него
+type QuestionKind string
+
+const (
+	KindChoice   QuestionKind = "choice"   // выбор из вариантов (Choices)
+	KindNumeric  QuestionKind = "numeric"  // число с допуском (NumericSpec)
+	KindOrdering QuestionKind = "ordering" // расстановка по порядку (OrderingSpec)
+	KindMatching QuestionKind = "matching" // сопоставление пар (MatchingSpec)
+	KindCloze    QuestionKind = "cloze"    // заполнение пропусков в тексте (ClozeSpec)
+)
+
+// Valid - известен ли вид вопроса
+func (k QuestionKind) Valid() bool {
+	switch k {
+	case KindChoice, KindNumeric, KindOrdering, KindMatching, KindCloze:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// TouchStreak — отметка активности на дату now в таймзоне пользователя
+// (IANA name в Meta["timezone"], по умолчанию UTC). Серия растет, если прошлая
+// активность была вчера, иначе начинается заново. false — сегодня уже отмечено.
+func (p *Profile) TouchStreak(now time.Time) bool {
+	var meta map[string]interface{}
+	if len(p.Meta) > 0 {
+		_ = json.Unmarshal(p.Meta, &meta)
+	}
+	if meta == nil {
+		meta = make(map[string]interface{})
+	}
+
+	loc := time.UTC
+	if tzName, _ := meta["timezone"].(string); tzName != "" {
+		if l, err := time.LoadLocation(tzName); err == nil {
+			loc = l
+		}
+	}
+
+	now = now.In(loc)
+	today := now.Format("2006-01-02")
+	last, _ := meta["streak_last_date"].(string)
+	if last == today {
+		return false
+	}
+
+	streak := 1
+	if last != "" && last == now.Add(-24*time.Hour).Format("2006-01-02") {
+		streak = p.Streak + 1
+	}
+
+	meta["streak_last_date"] = today
+	metaJSON, _ := json.Marshal(meta)
+	p.Meta = datatypes.JSON(metaJSON)
+	p.Streak = streak
+	return true
+}
@@ -0,0 +1,289 @@
+package domain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"regexp"
+	"strconv"
+)
+
+// QuestionTemplate — параметризованный вопрос: переменные подставляются в текст вместо
+// {{имя}}, правильный ответ считается по формуле Answer. Поддерживаются numeric-вопросы
+// (Spec задает допуск и единицу, значение берется из формулы) и choice-вопросы с одним
+// верным ответом (варианты строятся из Answer и Distractors)
+type QuestionTemplate struct {
+	Variables   []TemplateVariable `json:"variables"`
+	Answer      string             `json:"answer"`
+	Decimals    int                `json:"decimals,omitempty"`    // округление ответа и дистракторов
+	Distractors []string           `json:"distractors,omitempty"` // формулы; могут ссылаться на answer
+}
+
+// TemplateVariable — переменная шаблона: значение Min + k·Step, не больше Max
+type TemplateVariable struct {
+	Name string  `json:"name"`
+	Min  float64 `json:"min"`
+	Max  float64 `json:"max"`
+	Step float64 `json:"step,omitempty"` // 0 — шаг 1
+}
+
+// TemplateInstance — значения, с которыми вопрос показан в попытке
+type TemplateInstance struct {
+	Seed   int64              `json:"seed"`
+	Values map[string]float64 `json:"values"`
+	Answer float64            `json:"answer"`
+}
+
+// TemplateAnswerVar — переменная с правильным ответом, доступная в формулах дистракторов
+const TemplateAnswerVar = "answer"
+
+// MaxTemplateSteps — наибольшее число шагов в диапазоне переменной
+const MaxTemplateSteps = 1e9
+
+var (
+	templateVarPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	placeholderPattern = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+)
+
+// QuestionSeed — зерно вопроса в попытке: одна попытка всегда видит одни и те же значения,
+// разные попытки и разные вопросы — разные
+func (a *Attempt) QuestionSeed(questionID uint) int64 {
+	return a.Seed ^ int64(questionID)*0x2545F4914F6CDD1D
+}
+
+// ValidateTemplate — проверка шаблона и согласованности с остальными полями вопроса
+func (q *Question) ValidateTemplate() error {
+	t := q.Template
+	if t == nil {
+		return nil
+	}
+	kind := q.QuestionKind()
+	if kind != KindChoice && kind != KindNumeric {
+		return fmt.Errorf("templates are only supported for choice and numeric questions, not %s", kind)
+	}
+	if len(q.Choices) > 0 {
+		return errors.New("choices of a template question are generated and must not be set")
+	}
+	if q.MultiSelect {
+		return errors.New("template questions are single-select")
+	}
+	if q.GradingMode != GradingDefault && q.GradingMode != GradingExact {
+		return errors.New("template questions only support exact grading")
+	}
+	if t.Decimals < 0 || t.Decimals > 6 {
+		return errors.New("decimals must be between 0 and 6")
+	}
+
+	if len(t.Variables) == 0 {
+		return errors.New("at least one variable is required")
+	}
+	defined := map[string]bool{}
+	for _, v := range t.Variables {
+		if !templateVarPattern.MatchString(v.Name) || v.Name == TemplateAnswerVar {
+			return fmt.Errorf("invalid variable name %q", v.Name)
+		}
+		if defined[v.Name] {
+			return fmt.Errorf("duplicate variable %q", v.Name)
+		}
+		defined[v.Name] = true
+		if !isFinite(v.Min) || !isFinite(v.Max) || !isFinite(v.Step) {
+			return fmt.Errorf("variable %q: min, max and step must be finite numbers", v.Name)
+		}
+		if v.Min > v.Max {
+			return fmt.Errorf("variable %q: min must not exceed max", v.Name)
+		}
+		if v.Step < 0 {
+			return fmt.Errorf("variable %q: step must not be negative", v.Name)
+		}
+		if steps := (v.Max - v.Min) / v.step(); !isFinite(steps) || steps > MaxTemplateSteps {
+			return fmt.Errorf("variable %q: range must have at most %.0f steps", v.Name, MaxTemplateSteps)
+		}
+	}
+	for _, match := range placeholderPattern.FindAllStringSubmatch(q.Prompt+" "+q.Explanation, -1) {
+		if !defined[match[1]] {
+			return fmt.Errorf("placeholder {{%s}} is not a variable", match[1])
+		}
+	}
+
+	if err := checkFormula("answer", t.Answer, defined); err != nil {
+		return err
+	}
+	switch kind {
+	case KindChoice:
+		if len(t.Distractors) == 0 {
+			return errors.New("choice templates need at least one distractor")
+		}
+	case KindNumeric:
+		if len(t.Distractors) > 0 {
+			return errors.New("distractors are only used by choice templates")
+		}
+	}
+	withAnswer := map[string]bool{TemplateAnswerVar: true}
+	for name := range defined {
+		withAnswer[name] = true
+	}
+	for i, formula := range t.Distractors {
+		if err := checkFormula(fmt.Sprintf("distractors[%d]", i), formula, withAnswer); err != nil {
+			return err
+		}
+	}
+
+	// Формулы должны считаться на границах диапазонов и на случайных значениях
+	for seed := int64(0); seed < 8; seed++ {
+		instance, _, err := q.Instantiate(seed)
+		if err != nil {
+			return err
+		}
+		if err := instance.ValidateSpec(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkFormula(field, formula string, defined map[string]bool) error {
+	expr, err := ParseExpression(formula)
+	if err != nil {
+		return fmt.Errorf("%s: %w", field, err)
+	}
+	for _, name := range expr.Vars() {
+		if !defined[name] {
+			return fmt.Errorf("%s: unknown variable %q", field, name)
+		}
+	}
+	return nil
+}
+
+// Instantiate — экземпляр шаблонного вопроса для зерна seed. Для вопроса без шаблона
+// возвращается сам вопрос. Варианты choice-вопроса получают ID 1..n в перемешанном порядке
+func (q *Question) Instantiate(seed int64) (*Question, *TemplateInstance, error) {
+	t := q.Template
+	if t == nil {
+		return q, nil, nil
+	}
+	rng := rand.New(rand.NewSource(seed))
+
+	values := make(map[string]float64, len(t.Variables)+1)
+	for _, v := range t.Variables {
+		values[v.Name] = v.pick(rng)
+	}
+	answer, err := evalFormula("answer", t.Answer, values, t.Decimals)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	instance := *q
+	instance.Template = nil
+	instance.Prompt = renderTemplate(q.Prompt, values)
+	instance.Explanation = renderTemplate(q.Explanation, values)
+
+	switch q.QuestionKind() {
+	case KindNumeric:
+		spec := NumericSpec{}
+		if len(q.Spec) > 0 {
+			decoded, err := DecodeSpec[NumericSpec](q)
+			if err != nil {
+				return nil, nil, err
+			}
+			spec = *decoded
+		}
+		spec.Value = answer
+		data, _ := json.Marshal(spec)
+		instance.Spec = data
+	case KindChoice:
+		withAnswer := make(map[string]float64, len(values)+1)
+		for name, value := range values {
+			withAnswer[name] = value
+		}
+		withAnswer[TemplateAnswerVar] = answer
+
+		options := []float64{answer}
+		for i, formula := range t.Distractors {
+			value, err := evalFormula(fmt.Sprintf("distractors[%d]", i), formula, withAnswer, t.Decimals)
+			if err != nil {
+				return nil, nil, err
+			}
+			options = appendDistinct(options, value)
+		}
+		// Совпавшие значения заменяются сдвигами ответа, чтобы число вариантов не менялось
+		step := math.Max(math.Abs(answer)*0.1, math.Pow(10, -float64(t.Decimals)))
+		for k := 1; len(options) < len(t.Distractors)+1; k++ {
+			options = appendDistinct(options, roundTo(answer+float64(k)*step, t.Decimals))
+		}
+
+		order := rng.Perm(len(options))
+		instance.Choices = make([]Choice, len(options))
+		for i, idx := range order {
+			instance.Choices[i] = Choice{
+				QuestionID: q.ID,
+				Text:       FormatNumber(options[idx]),
+				IsCorrect:  idx == 0,
+				Order:      i + 1,
+			}
+			instance.Choices[i].ID = uint(i + 1)
+		}
+	}
+
+	return &instance, &TemplateInstance{Seed: seed, Values: values, Answer: answer}, nil
+}
+
+func (v TemplateVariable) step() float64 {
+	if v.Step == 0 {
+		return 1
+	}
+	return v.Step
+}
+
+// pick - случайное значение переменной; диапазон проверен ValidateTemplate
+func (v TemplateVariable) pick(rng *rand.Rand) float64 {
+	step := v.step()
+	steps := int64(math.Floor((v.Max-v.Min)/step + 1e-9))
+	return roundTo(v.Min+float64(rng.Int63n(steps+1))*step, 9)
+}
+
+func isFinite(value float64) bool {
+	return !math.IsNaN(value) && !math.IsInf(value, 0)
+}
+
+func evalFormula(field, formula string, values map[string]float64, decimals int) (float64, error) {
+	expr, err := ParseExpression(formula)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", field, err)
+	}
+	value, err := expr.Eval(values)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", field, err)
+	}
+	return roundTo(value, decimals), nil
+}
+
+func appendDistinct(values []float64, value float64) []float64 {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+func roundTo(value float64, decimals int) float64 {
+	p := math.Pow(10, float64(decimals))
+	return math.Round(value*p) / p
+}
+
+func renderTemplate(text string, values map[string]float64) string {
+	return placeholderPattern.ReplaceAllStringFunc(text, func(match string) string {
+		name := placeholderPattern.FindStringSubmatch(match)[1]
+		if value, ok := values[name]; ok {
+			return FormatNumber(value)
+		}
+		return match
+	})
+}
+
+// FormatNumber — число без лишних нулей: 1500, 0.05, 1234.5
+func FormatNumber(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -0,0 +1,193 @@
+package domain_test
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ImCtyz/duofinance/backend/internal/domain"
+)
+
+func numericTemplate() *domain.Question {
+	return &domain.Question{
+		Kind:   domain.KindNumeric,
+		Prompt: "You save {{rate}}% of {{income}}. How much is that?",
+		Spec:   []byte(`{"tolerance":0.5,"unit":"$"}`),
+		Template: &domain.QuestionTemplate{
+			Variables: []domain.TemplateVariable{
+				{Name: "income", Min: 1000, Max: 5000, Step: 500},
+				{Name: "rate", Min: 5, Max: 20, Step: 5},
+			},
+			Answer: "income * rate / 100",
+		},
+	}
+}
+
+func choiceTemplate() *domain.Question {
+	return &domain.Question{
+		Prompt: "What is {{a}} + {{b}}?",
+		Template: &domain.QuestionTemplate{
+			Variables: []domain.TemplateVariable{
+				{Name: "a", Min: 1, Max: 9},
+				{Name: "b", Min: 1, Max: 9},
+			},
+			Answer:      "a + b",
+			Distractors: []string{"answer + 1", "answer - 1", "a * b"},
+		},
+	}
+}
+
+func TestInstantiateNumeric(t *testing.T) {
+	q := numericTemplate()
+	if err := q.ValidateTemplate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for seed := int64(0); seed < 50; seed++ {
+		instance, values, err := q.Instantiate(seed)
+		if err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+		income, rate := values.Values["income"], values.Values["rate"]
+		if income < 1000 || income > 5000 || math.Mod(income, 500) != 0 {
+			t.Errorf("seed %d: income %v is outside the range or off step", seed, income)
+		}
+		if rate < 5 || rate > 20 || math.Mod(rate, 5) != 0 {
+			t.Errorf("seed %d: rate %v is outside the range or off step", seed, rate)
+		}
+		if want := income * rate / 100; values.Answer != want {
+			t.Errorf("seed %d: answer %v, want %v", seed, values.Answer, want)
+		}
+		if instance.Template != nil || strings.Contains(instance.Prompt, "{{") {
+			t.Errorf("seed %d: template is not rendered: %q", seed, instance.Prompt)
+		}
+
+		var spec domain.NumericSpec
+		if err := json.Unmarshal(instance.Spec, &spec); err != nil {
+			t.Fatal(err)
+		}
+		if spec.Value != values.Answer || spec.Tolerance != 0.5 || spec.Unit != "$" {
+			t.Errorf("seed %d: spec %+v does not carry the answer and the template spec", seed, spec)
+		}
+	}
+	if q.Template == nil || q.Prompt != numericTemplate().Prompt {
+		t.Error("Instantiate modified the template question")
+	}
+}
+
+func TestInstantiateIsDeterministic(t *testing.T) {
+	q := choiceTemplate()
+	first, firstValues, err := q.Instantiate(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, secondValues, err := q.Instantiate(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(first, second) || !reflect.DeepEqual(firstValues, secondValues) {
+		t.Error("the same seed produced different instances")
+	}
+
+	attempt := &domain.Attempt{Seed: 7}
+	if attempt.QuestionSeed(1) == attempt.QuestionSeed(2) {
+		t.Error("questions of one attempt share a seed")
+	}
+}
+
+func TestInstantiateChoice(t *testing.T) {
+	q := choiceTemplate()
+	if err := q.ValidateTemplate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for seed := int64(0); seed < 50; seed++ {
+		instance, values, err := q.Instantiate(seed)
+		if err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+		// a*b может совпасть с ответом или другим дистрактором; вариантов все равно 4
+		if len(instance.Choices) != 4 {
+			t.Fatalf("seed %d: %d choices, want 4", seed, len(instance.Choices))
+		}
+		seen := map[string]bool{}
+		correct := 0
+		for i, choice := range instance.Choices {
+			if choice.ID != uint(i+1) || choice.Order != i+1 {
+				t.Errorf("seed %d: choice %d has id %d and order %d", seed, i, choice.ID, choice.Order)
+			}
+			if seen[choice.Text] {
+				t.Errorf("seed %d: duplicate choice %q", seed, choice.Text)
+			}
+			seen[choice.Text] = true
+			if choice.IsCorrect {
+				correct++
+				if choice.Text != domain.FormatNumber(values.Answer) {
+					t.Errorf("seed %d: correct choice %q, answer %v", seed, choice.Text, values.Answer)
+				}
+			}
+		}
+		if correct != 1 {
+			t.Errorf("seed %d: %d correct choices", seed, correct)
+		}
+	}
+}
+
+func TestInstantiateWithoutTemplate(t *testing.T) {
+	q := &domain.Question{Prompt: "Plain"}
+	instance, values, err := q.Instantiate(1)
+	if err != nil || instance != q || values != nil {
+		t.Errorf("got %v, %v, %v; want the question itself", instance, values, err)
+	}
+}
+
+func TestValidateTemplateErrors(t *testing.T) {
+	cases := map[string]func(q *domain.Question){
+		"no variables": func(q *domain.Question) { q.Template.Variables = nil },
+		"bad name":     func(q *domain.Question) { q.Template.Variables[0].Name = "1x" },
+		"reserved":     func(q *domain.Question) { q.Template.Variables[0].Name = domain.TemplateAnswerVar },
+		"duplicate":    func(q *domain.Question) { q.Template.Variables[1].Name = "income" },
+		"min > max":    func(q *domain.Question) { q.Template.Variables[0].Min = 9000 },
+		"negative step": func(q *domain.Question) {
+			q.Template.Variables[0].Step = -1
+		},
+		"huge range": func(q *domain.Question) {
+			q.Template.Variables[0] = domain.TemplateVariable{Name: "income", Min: 0, Max: 1e19, Step: 1}
+		},
+		"tiny step": func(q *domain.Question) {
+			q.Template.Variables[0] = domain.TemplateVariable{Name: "income", Min: 0, Max: 1, Step: 1e-300}
+		},
+		"infinite max": func(q *domain.Question) { q.Template.Variables[0].Max = math.Inf(1) },
+		"nan min":      func(q *domain.Question) { q.Template.Variables[0].Min = math.NaN() },
+		"unknown placeholder": func(q *domain.Question) {
+			q.Prompt += " {{missing}}"
+		},
+		"unknown answer var": func(q *domain.Question) { q.Template.Answer = "income * tax" },
+		"bad formula":        func(q *domain.Question) { q.Template.Answer = "income *" },
+		"division by zero":   func(q *domain.Question) { q.Template.Answer = "income / (rate - rate)" },
+		"numeric distractors": func(q *domain.Question) {
+			q.Template.Distractors = []string{"answer + 1"}
+		},
+		"decimals":     func(q *domain.Question) { q.Template.Decimals = 7 },
+		"multi-select": func(q *domain.Question) { q.MultiSelect = true },
+		"choices set": func(q *domain.Question) {
+			q.Choices = []domain.Choice{{Text: "1"}}
+		},
+		"ordering kind": func(q *domain.Question) { q.Kind = domain.KindOrdering },
+	}
+	for name, mutate := range cases {
+		q := numericTemplate()
+		mutate(q)
+		if err := q.ValidateTemplate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	q := choiceTemplate()
+	q.Template.Distractors = nil
+	if err := q.ValidateTemplate(); err == nil {
+		t.Error("choice template without distractors: expected an error")
+	}
+}
@@ -0,0 +1,335 @@
+// Package e2e - сквозные тесты API: роутер SetupRoutes со всеми сервисами поверх
+// одноразовой базы SQLite, фикстуры контента из testdata/fixtures и эталонные
+// JSON-ответы в testdata/golden. Эталоны обновляются запуском с флагом -update:
+//
+//	go test ./internal/e2e -update
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	authpkg "github.com/ImCtyz/duofinance/backend/internal/auth"
+	"github.com/ImCtyz/duofinance/backend/internal/content"
+	"github.com/ImCtyz/duofinance/backend/internal/core"
+	"github.com/ImCtyz/duofinance/backend/internal/domain"
+	apihttp "github.com/ImCtyz/duofinance/backend/internal/http"
+	"github.com/ImCtyz/duofinance/backend/internal/mail"
+	"github.com/ImCtyz/duofinance/backend/internal/repo"
+	"github.com/ImCtyz/duofinance/backend/internal/simulation"
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files with actual responses")
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+	gin.DefaultErrorWriter = io.Discard
+	os.Exit(m.Run())
+}
+
+// models - все таблицы схемы. Миграции из backend/migrations написаны для Postgres и
+// на SQLite не выполняются, поэтому схема создается AutoMigrate по моделям. Эти тесты
+// не проверяют ни SQL миграции, ни расхождение миграций с моделями, ни ограничения,
+// которых нет в тегах моделей (uq_reward_txs_attempt, частичный индекс порядка шагов):
+// миграции применяются только в тестах соответствия internal/repo на Postgres
+// (TEST_DATABASE_URL).
+var models = []interface{}{
+	&domain.User{}, &domain.Profile{}, &domain.Session{}, &domain.UserToken{},
+	&domain.RecoveryCode{}, &domain.UserIdentity{}, &domain.OIDCState{},
+	&domain.Course{}, &domain.Unit{}, &domain.Level{}, &domain.LevelPrerequisite{},
+	&domain.LevelRevision{}, &domain.Question{}, &domain.Choice{}, &domain.LevelStep{},
+	&domain.Attempt{}, &domain.AttemptStep{}, &domain.Achievement{}, &domain.UserAchievement{},
+	&domain.RewardTx{}, &domain.IdempotencyKey{}, &domain.Hint{}, &domain.Reminder{},
+}
+
+// harness - приложение целиком в памяти процесса
+type harness struct {
+	t      *testing.T
+	db     *gorm.DB
+	router *gin.Engine
+	mailer *captureMailer
+}
+
+func newHarness(t *testing.T) *harness {
+	t.Helper()
+
+	dsn := filepath.Join(t.TempDir(), "e2e.db") + "?_pragma=foreign_keys(1)"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("database handle: %v", err)
+	}
+	// SQLite не любит параллельных писателей; транзакции идут через одно соединение
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(models...); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	userRepo := repo.NewUserRepo(db)
+	sessionRepo := repo.NewSessionRepo(db)
+	levelRepo := repo.NewLevelRepo(db)
+	questionRepo := repo.NewQuestionRepo(db)
+	attemptRepo := repo.NewAttemptRepo(db)
+	rewardTxRepo := repo.NewRewardTxRepo(db)
+	achievementRepo := repo.NewAchievementRepo(db)
+	courseRepo := repo.NewCourseRepo(db)
+	txManager := repo.NewTxManager(db)
+
+	mailer := &captureMailer{}
+	jwtManager := authpkg.NewJWTManager(
+		authpkg.NewHMACKeyRing("e2e-access-secret"),
+		"e2e-refresh-secret",
+		15*time.Minute,
+		24*time.Hour,
+	)
+	protection := core.LoginProtection{
+		MaxAccountFailures: 5,
+		FailureWindow:      15 * time.Minute,
+		LockoutBase:        time.Minute,
+		LockoutMax:         time.Hour,
+	}
+	simulations := simulation.NewEngine()
+	authService := core.NewAuthService(userRepo, sessionRepo, repo.NewUserTokenRepo(db), repo.NewRecoveryCodeRepo(db),
+		repo.NewIdentityRepo(db), repo.NewOIDCStateRepo(db), txManager, jwtManager, mailer, "http://app.test", protection, nil)
+	userService := core.NewUserService(userRepo, sessionRepo, rewardTxRepo, attemptRepo)
+	achievementService := core.NewAchievementService(achievementRepo, userRepo)
+	services := apihttp.NewServices(
+		authService,
+		userService,
+		core.NewLevelService(levelRepo, questionRepo, attemptRepo, courseRepo),
+		core.NewAttemptService(attemptRepo, levelRepo, questionRepo, rewardTxRepo, courseRepo, txManager, userService, achievementService, simulations),
+		core.NewRewardService(rewardTxRepo),
+		achievementService,
+		core.NewContentService(levelRepo, questionRepo, simulations),
+		core.NewCourseService(courseRepo, levelRepo, attemptRepo, txManager),
+		core.NewAnalyticsService(attemptRepo, questionRepo, levelRepo),
+		core.NewIdempotencyService(repo.NewIdempotencyRepo(db)),
+	)
+
+	router := gin.New()
+	if err := router.SetTrustedProxies(nil); err != nil {
+		t.Fatalf("trusted proxies: %v", err)
+	}
+	apihttp.SetupRoutes(router, services, db, apihttp.RateLimits{})
+
+	return &harness{t: t, db: db, router: router, mailer: mailer}
+}
+
+// loadFixtures - уровни из testdata/fixtures/*.yaml (импорт контента с публикацией)
+// и достижения из testdata/fixtures/achievements.json
+func (h *harness) loadFixtures() {
+	h.t.Helper()
+	paths, err := filepath.Glob(filepath.Join("testdata", "fixtures", "*.yaml"))
+	if err != nil {
+		h.t.Fatalf("fixtures: %v", err)
+	}
+	syncer := content.NewSyncer(h.db)
+	for _, path := range paths {
+		f, err := content.Load(path)
+		if err != nil {
+			h.t.Fatalf("load %s: %v", path, err)
+		}
+		if _, err := syncer.Import(context.Background(), f, true); err != nil {
+			h.t.Fatalf("import %s: %v", path, err)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join("testdata", "fixtures", "achievements.json"))
+	if err != nil {
+		h.t.Fatalf("achievements: %v", err)
+	}
+	var achievements []domain.Achievement
+	if err := json.Unmarshal(data, &achievements); err != nil {
+		h.t.Fatalf("achievements: %v", err)
+	}
+	if err := h.db.Create(&achievements).Error; err != nil {
+		h.t.Fatalf("achievements: %v", err)
+	}
+}
+
+// response - ответ API
+type response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// request - параметры запроса; Token - access токен, Header - дополнительные заголовки
+type request struct {
+	Method string
+	Path   string
+	Token  string
+	Body   interface{}
+	Header map[string]string
+}
+
+func (h *harness) do(req request) *response {
+	h.t.Helper()
+	var body io.Reader
+	if req.Body != nil {
+		data, err := json.Marshal(req.Body)
+		if err != nil {
+			h.t.Fatalf("encode body: %v", err)
+		}
+		body = bytes.NewReader(data)
+	}
+	httpReq := httptest.NewRequest(req.Method, req.Path, body)
+	if req.Body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	if req.Token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+req.Token)
+	}
+	for name, value := range req.Header {
+		httpReq.Header.Set(name, value)
+	}
+
+	rec := httptest.NewRecorder()
+	h.router.ServeHTTP(rec, httpReq)
+	return &response{Status: rec.Code, Header: rec.Header(), Body: rec.Body.Bytes()}
+}
+
+// data - поле data успешного ответа APIResponse
+func (h *harness) data(resp *response, target interface{}) {
+	h.t.Helper()
+	var envelope struct {
+		Success bool            `json:"success"`
+		Data    json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(resp.Body, &envelope); err != nil || !envelope.Success {
+		h.t.Fatalf("unexpected response %d: %s", resp.Status, resp.Body)
+	}
+	if err := json.Unmarshal(envelope.Data, target); err != nil {
+		h.t.Fatalf("decode data: %v: %s", err, envelope.Data)
+	}
+}
+
+// golden - сравнение ответа с testdata/golden/<name>.json после нормализации
+// изменчивых значений (токены, время)
+func (h *harness) golden(name string, resp *response) {
+	h.t.Helper()
+	var body interface{}
+	if err := json.Unmarshal(resp.Body, &body); err != nil {
+		h.t.Fatalf("%s: response is not JSON (%d): %s", name, resp.Status, resp.Body)
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(map[string]interface{}{
+		"status": resp.Status,
+		"body":   normalize("", body),
+	})
+	if err != nil {
+		h.t.Fatalf("%s: %v", name, err)
+	}
+	actual := buf.Bytes()
+
+	path := filepath.Join("testdata", "golden", name+".json")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			h.t.Fatalf("%s: %v", name, err)
+		}
+		if err := os.WriteFile(path, actual, 0o644); err != nil {
+			h.t.Fatalf("%s: %v", name, err)
+		}
+		return
+	}
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		h.t.Fatalf("%s: %v (run with -update to create it)", name, err)
+	}
+	if !bytes.Equal(expected, actual) {
+		h.t.Errorf("%s: response differs from %s\n--- expected\n%s\n--- actual\n%s", name, path, expected, actual)
+	}
+}
+
+// volatileKeys - поля, значения которых меняются от запуска к запуску
+var volatileKeys = map[string]bool{
+	"access_token":  true,
+	"refresh_token": true,
+	"session_id":    true,
+	"seed":          true,
+	"duration_ms":   true,
+}
+
+func normalize(key string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = normalize(k, item)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalize(key, item)
+		}
+		return v
+	case nil:
+		return nil
+	}
+	if volatileKeys[key] {
+		return "<" + key + ">"
+	}
+	if s, ok := value.(string); ok {
+		if _, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return "<time>"
+		}
+	}
+	return value
+}
+
+// captureMailer - письма сохраняются в памяти вместо отправки
+type captureMailer struct {
+	mu       sync.Mutex
+	messages []mail.Message
+}
+
+func (m *captureMailer) Send(ctx context.Context, msg mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// token - токен из ссылки (?token=...) в последнем письме на адрес to
+func (m *captureMailer) token(to string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if !strings.EqualFold(m.messages[i].To, to) {
+			continue
+		}
+		_, rest, found := strings.Cut(m.messages[i].Body, "token=")
+		if !found {
+			return "", fmt.Errorf("last mail to %s has no token link", to)
+		}
+		if end := strings.IndexAny(rest, " \t\r\n\"'<"); end >= 0 {
+			rest = rest[:end]
+		}
+		return rest, nil
+	}
+	return "", fmt.Errorf("no mail sent to %s", to)
+}
@@ -1,13 +1,21 @@
 package http
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/rand"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/ImCtyz/duofinance/backend/internal/auth"
 	"github.com/ImCtyz/duofinance/backend/internal/core"
 	"github.com/ImCtyz/duofinance/backend/internal/domain"
+	"github.com/ImCtyz/duofinance/backend/internal/simulation"
 	"github.com/gin-gonic/gin"
+	"gorm.io/datatypes"
 	"gorm.io/gorm"
 )
 
@@ -63,6 +71,15 @@ func ReadyHandler(db *gorm.DB) gin.HandlerFunc {
 	}
 }
 
+// JWKSHandler - публичные ключи проверки access токенов.
+// Отдается в стандартном формате JWK Set без обертки APIResponse.
+func JWKSHandler(authService core.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, authService.GetJWKS(c.Request.Context()))
+	}
+}
+
 // Auth handlers
 
 // RegisterHandler - регистрация пользователя
@@ -96,9 +113,12 @@ func RegisterHandler(authService core.AuthService) gin.HandlerFunc {
 		c.JSON(http.StatusCreated, APIResponse{
 			Success: true,
 			Data: UserInfo{
-				ID:       user.ID,
-				Email:    user.Email,
-				Username: user.Username,
+				ID:            user.ID,
+				Email:         user.Email,
+				Username:      user.Username,
+				Role:          string(user.Role),
+				EmailVerified: user.EmailVerifiedAt != nil,
+				MFAEnabled:    user.TOTPEnabledAt != nil,
 			},
 		})
 	}
@@ -120,8 +140,11 @@ func LoginHandler(authService core.AuthService) gin.HandlerFunc {
 			return
 		}
 
-		accessToken, refreshToken, user, err := authService.Login(c.Request.Context(), req.Email, req.Password)
+		result, err := authService.Login(c.Request.Context(), req.Email, req.Password, clientInfo(c))
 		if err != nil {
+			if respondLocked(c, err) {
+				return
+			}
 			c.JSON(http.StatusUnauthorized, APIResponse{
 				Success: false,
 				Error: &APIError{
@@ -132,21 +155,122 @@ func LoginHandler(authService core.AuthService) gin.HandlerFunc {
 			return
 		}
 
-		userInfo := &UserInfo{
-			ID:       user.ID,
-			Email:    user.Email,
-			Username: user.Username,
+		respondLogin(c, result)
+	}
+}
+
+// MFAVerifyHandler - второй шаг входа: код TOTP или код восстановления
+func MFAVerifyHandler(authService core.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req MFAVerifyRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error: &APIError{
+					Code:    ErrCodeValidation,
+					Message: "Invalid request data",
+					Details: err.Error(),
+				},
+			})
+			return
+		}
+
+		result, err := authService.VerifyMFA(c.Request.Context(), req.MFAToken, req.Code, clientInfo(c))
+		if err != nil {
+			if respondLocked(c, err) {
+				return
+			}
+			code, message := ErrCodeInvalidToken, "Invalid or expired MFA token"
+			switch {
+			case errors.Is(err, core.ErrInvalidMFACode):
+				code, message = ErrCodeInvalidMFACode, "Invalid two-factor code"
+			case errors.Is(err, auth.ErrExpiredToken):
+				code = ErrCodeExpiredToken
+			case !errors.Is(err, auth.ErrInvalidToken):
+				c.JSON(http.StatusInternalServerError, APIResponse{
+					Success: false,
+					Error: &APIError{
+						Code:    ErrCodeInternal,
+						Message: "Failed to verify two-factor code",
+					},
+				})
+				return
+			}
+			c.JSON(http.StatusUnauthorized, APIResponse{
+				Success: false,
+				Error: &APIError{
+					Code:    code,
+					Message: message,
+				},
+			})
+			return
 		}
 
 		c.JSON(http.StatusOK, APIResponse{
 			Success: true,
-			Data: AuthResponse{
-				AccessToken:  accessToken,
-				RefreshToken: refreshToken,
-				User:         userInfo,
+			Data:    authResponse(result),
+		})
+	}
+}
+
+// respondLogin - токены либо запрос второго фактора
+func respondLogin(c *gin.Context, result *core.LoginResult) {
+	if result.MFARequired {
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data: MFAChallengeResponse{
+				MFARequired: true,
+				MFAToken:    result.MFAToken,
+				ExpiresIn:   int(auth.MFATokenTTL / time.Second),
 			},
 		})
+		return
+	}
+
+	c.JSON(http.StatusOK, APIResponse{
+		Success: true,
+		Data:    authResponse(result),
+	})
+}
+
+// authResponse - токены и пользователь после успешного входа
+func authResponse(result *core.LoginResult) AuthResponse {
+	user := result.User
+	return AuthResponse{
+		AccessToken:  result.AccessToken,
+		RefreshToken: result.RefreshToken,
+		User: &UserInfo{
+			ID:            user.ID,
+			Email:         user.Email,
+			Username:      user.Username,
+			Role:          string(user.Role),
+			EmailVerified: user.EmailVerifiedAt != nil,
+			MFAEnabled:    user.TOTPEnabledAt != nil,
+		},
+	}
+}
+
+// respondLocked - ответ на временную блокировку входа; false, если err не блокировка
+func respondLocked(c *gin.Context, err error) bool {
+	var locked *core.LockedError
+	if !errors.As(err, &locked) {
+		return false
 	}
+
+	status, code, message := http.StatusTooManyRequests, ErrCodeRateLimited, "Too many failed login attempts, try again later"
+	if errors.Is(err, core.ErrAccountLocked) {
+		status, code, message = http.StatusLocked, ErrCodeAccountLocked, "Account is temporarily locked, try again later"
+	}
+	setRetryAfter(c, time.Until(locked.Until))
+	c.JSON(status, APIResponse{
+		Success: false,
+		Error: &APIError{
+			Code:    code,
+			Message: message,
+			Details: gin.H{"locked_until": locked.Until},
+		},
+	})
+	return true
 }
 
 // RefreshTokenHandler - обновление токена
@@ -165,13 +289,17 @@ func RefreshTokenHandler(authService core.AuthService) gin.HandlerFunc {
 			return
 		}
 
-		accessToken, refreshToken, err := authService.RefreshToken(c.Request.Context(), req.RefreshToken)
+		accessToken, refreshToken, err := authService.RefreshToken(c.Request.Context(), req.RefreshToken, clientInfo(c))
 		if err != nil {
+			message := "Invalid or expired refresh token"
+			if errors.Is(err, core.ErrRefreshTokenReused) || errors.Is(err, core.ErrSessionRevoked) {
+				message = "Session has been revoked"
+			}
 			c.JSON(http.StatusUnauthorized, APIResponse{
 				Success: false,
 				Error: &APIError{
 					Code:    ErrCodeInvalidToken,
-					Message: "Invalid or expired refresh token",
+					Message: message,
 				},
 			})
 			return
@@ -202,8 +330,8 @@ func LogoutHandler(authService core.AuthService) gin.HandlerFunc {
 			return
 		}
 
-		// Вызываем logout
-		err = authService.Logout(c.Request.Context(), userID)
+		// Отзываем текущую сессию
+		err = authService.Logout(c.Request.Context(), userID, GetSessionIDFromContext(c))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, APIResponse{
 				Success: false,
@@ -225,251 +353,2387 @@ func LogoutHandler(authService core.AuthService) gin.HandlerFunc {
 	}
 }
 
-// MeHandler - получение информации о текущем пользователе
-func MeHandler(authService core.AuthService, userService core.UserService) gin.HandlerFunc {
+// ForgotPasswordHandler - запрос письма для сброса пароля
+func ForgotPasswordHandler(authService core.AuthService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userID, err := GetUserIDFromContext(c)
+		var req ForgotPasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error: &APIError{
+					Code:    ErrCodeValidation,
+					Message: "Invalid request data",
+					Details: err.Error(),
+				},
+			})
+			return
+		}
+
+		err := authService.RequestPasswordReset(c.Request.Context(), req.Email)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, APIResponse{
 				Success: false,
 				Error: &APIError{
 					Code:    ErrCodeInternal,
-					Message: "Failed to get user ID",
+					Message: "Failed to request password reset",
 				},
 			})
 			return
 		}
 
-		user, err := authService.GetCurrentUser(c.Request.Context(), userID)
-		if err != nil {
-			c.JSON(http.StatusNotFound, APIResponse{
+		// Одинаковый ответ для существующих и несуществующих email
+		c.JSON(http.StatusAccepted, APIResponse{
+			Success: true,
+			Data: gin.H{
+				"message": "If the email is registered, a password reset link has been sent",
+			},
+		})
+	}
+}
+
+// ResetPasswordHandler - установка нового пароля по токену из письма
+func ResetPasswordHandler(authService core.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ResetPasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, APIResponse{
 				Success: false,
 				Error: &APIError{
-					Code:    ErrCodeNotFound,
-					Message: "User not found",
+					Code:    ErrCodeValidation,
+					Message: "Invalid request data",
+					Details: err.Error(),
 				},
 			})
 			return
 		}
 
-		profile, err := userService.GetProfile(c.Request.Context(), userID)
+		err := authService.ResetPassword(c.Request.Context(), req.Token, req.Password)
 		if err != nil {
-			// Если профиль не найден, создаем дефолтный
-			profile = &domain.Profile{
-				UserID: userID,
-				Streak: 0,
-				Stats:  nil,
-				Meta:   nil,
+			if errors.Is(err, core.ErrInvalidUserToken) {
+				c.JSON(http.StatusBadRequest, APIResponse{
+					Success: false,
+					Error: &APIError{
+						Code:    ErrCodeInvalidToken,
+						Message: "Invalid or expired reset token",
+					},
+				})
+				return
 			}
-		}
-
-		diamonds, err := userService.GetDiamondsBalance(c.Request.Context(), userID)
-		if err != nil {
-			// Временно установить 0 если ошибка
-			diamonds = 0
+			c.JSON(http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error: &APIError{
+					Code:    ErrCodeInternal,
+					Message: "Failed to reset password",
+				},
+			})
+			return
 		}
 
 		c.JSON(http.StatusOK, APIResponse{
 			Success: true,
-			Data: UserInfo{
-				ID:       user.ID,
-				Email:    user.Email,
-				Username: user.Username,
-				Profile: &ProfileInfo{
-					Streak:   profile.Streak,
-					Diamonds: diamonds,
-					Stats:    make(map[string]interface{}), // TODO: правильно обработать datatypes.JSON
-				},
+			Data: gin.H{
+				"message": "Password has been reset",
 			},
 		})
 	}
 }
 
-// Level handlers
-
-// GetLevelsHandler - получение списка уровней
-func GetLevelsHandler(levelService core.LevelService) gin.HandlerFunc {
+// VerifyEmailHandler - подтверждение email по токену из письма
+func VerifyEmailHandler(authService core.AuthService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		levels, err := levelService.GetLevels(c.Request.Context())
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, APIResponse{
+		var req VerifyEmailRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, APIResponse{
 				Success: false,
 				Error: &APIError{
-					Code:    ErrCodeInternal,
-					Message: "Failed to get levels",
+					Code:    ErrCodeValidation,
+					Message: "Invalid request data",
+					Details: err.Error(),
 				},
 			})
 			return
 		}
 
-		var levelInfos []LevelInfo
-		for _, level := range levels {
-			levelInfos = append(levelInfos, LevelInfo{
-				ID:           level.ID,
-				Title:        level.Title,
-				Topic:        level.Topic,
-				Difficulty:   level.Difficulty,
-				RewardPoints: level.RewardPoints,
-				IsActive:     level.IsActive,
+		err := authService.VerifyEmail(c.Request.Context(), req.Token)
+		if err != nil {
+			if errors.Is(err, core.ErrInvalidUserToken) {
+				c.JSON(http.StatusBadRequest, APIResponse{
+					Success: false,
+					Error: &APIError{
+						Code:    ErrCodeInvalidToken,
+						Message: "Invalid or expired verification token",
+					},
+				})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error: &APIError{
+					Code:    ErrCodeInternal,
+					Message: "Failed to verify email",
+				},
 			})
+			return
 		}
 
 		c.JSON(http.StatusOK, APIResponse{
 			Success: true,
-			Data:    levelInfos,
-			Meta: &Meta{
-				Total: len(levelInfos),
+			Data: gin.H{
+				"message": "Email verified",
 			},
 		})
 	}
 }
 
-// GetLevelHandler - получение деталей уровня
-func GetLevelHandler(levelService core.LevelService) gin.HandlerFunc {
+// ResendVerificationHandler - повторная отправка письма для подтверждения email
+func ResendVerificationHandler(authService core.AuthService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		idStr := c.Param("id")
-		id, err := strconv.ParseUint(idStr, 10, 32)
+		userID, err := GetUserIDFromContext(c)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, APIResponse{
+			c.JSON(http.StatusInternalServerError, APIResponse{
 				Success: false,
 				Error: &APIError{
-					Code:    ErrCodeValidation,
-					Message: "Invalid level ID",
+					Code:    ErrCodeInternal,
+					Message: "Failed to get user ID",
 				},
 			})
 			return
 		}
 
-		level, err := levelService.GetLevel(c.Request.Context(), uint(id))
+		err = authService.SendEmailVerification(c.Request.Context(), userID)
 		if err != nil {
-			c.JSON(http.StatusNotFound, APIResponse{
+			if errors.Is(err, core.ErrEmailVerified) {
+				c.JSON(http.StatusConflict, APIResponse{
+					Success: false,
+					Error: &APIError{
+						Code:    ErrCodeEmailVerified,
+						Message: "Email is already verified",
+					},
+				})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, APIResponse{
 				Success: false,
 				Error: &APIError{
-					Code:    ErrCodeLevelNotFound,
-					Message: "Level not found",
+					Code:    ErrCodeInternal,
+					Message: "Failed to send verification email",
 				},
 			})
 			return
 		}
 
-		// Конвертируем шаги в нужный формат
-		var steps []map[string]interface{}
-		for _, step := range level.Steps {
-			stepData := map[string]interface{}{
-				"id":    step.ID,
-				"order": step.Order,
-				"type":  step.Type,
-				"title": step.Title,
-			}
-
-			// Добавляем payload если есть
-			if step.Payload != nil {
-				stepData["payload"] = step.Payload
-			}
-
-			// Добавляем question_id если это вопрос
-			if step.QuestionID != nil {
-				stepData["question_id"] = *step.QuestionID
-			}
-
-			steps = append(steps, stepData)
-		}
-
-		c.JSON(http.StatusOK, APIResponse{
+		c.JSON(http.StatusAccepted, APIResponse{
 			Success: true,
-			Data: map[string]interface{}{
-				"id":            level.ID,
-				"title":         level.Title,
-				"topic":         level.Topic,
-				"difficulty":    level.Difficulty,
-				"reward_points": level.RewardPoints,
-				"is_active":     level.IsActive,
-				"description":   "",
-				"steps_count":   len(level.Steps),
-				"steps":         steps,
+			Data: gin.H{
+				"message": "Verification email sent",
 			},
 		})
 	}
 }
 
-// GetLevelsByDifficultyHandler - получение уровней по сложности
-func GetLevelsByDifficultyHandler(levelService core.LevelService) gin.HandlerFunc {
+// GetSessionsHandler - список активных сессий пользователя
+func GetSessionsHandler(authService core.AuthService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		difficulty := c.Param("difficulty")
-		levels, err := levelService.GetLevelsByDifficulty(c.Request.Context(), difficulty)
+		userID, err := GetUserIDFromContext(c)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, APIResponse{
 				Success: false,
 				Error: &APIError{
 					Code:    ErrCodeInternal,
-					Message: "Failed to get levels by difficulty",
+					Message: "Failed to get user ID",
 				},
 			})
 			return
 		}
 
-		var levelInfos []LevelInfo
-		for _, level := range levels {
-			levelInfos = append(levelInfos, LevelInfo{
-				ID:           level.ID,
-				Title:        level.Title,
-				Topic:        level.Topic,
-				Difficulty:   level.Difficulty,
-				RewardPoints: level.RewardPoints,
-				IsActive:     level.IsActive,
+		sessions, err := authService.GetSessions(c.Request.Context(), userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error: &APIError{
+					Code:    ErrCodeInternal,
+					Message: "Failed to get sessions",
+					Details: err.Error(),
+				},
 			})
+			return
+		}
+
+		currentSessionID := GetSessionIDFromContext(c)
+		sessionInfos := []SessionInfo{}
+		for _, session := range sessions {
+			sessionInfo := SessionInfo{
+				ID:        session.FamilyID,
+				Device:    session.Device,
+				UserAgent: session.UserAgent,
+				IP:        session.IP,
+				ExpiresAt: session.ExpiresAt.Format(time.RFC3339),
+				Current:   session.FamilyID == currentSessionID,
+			}
+			if session.LastUsedAt != nil {
+				lastUsedAt := session.LastUsedAt.Format(time.RFC3339)
+				sessionInfo.LastUsedAt = &lastUsedAt
+			}
+			sessionInfos = append(sessionInfos, sessionInfo)
 		}
 
 		c.JSON(http.StatusOK, APIResponse{
 			Success: true,
-			Data:    levelInfos,
+			Data:    sessionInfos,
 			Meta: &Meta{
-				Total: len(levelInfos),
+				Total: len(sessionInfos),
 			},
 		})
 	}
 }
 
-// GetLevelsByTopicHandler - получение уровней по теме
-func GetLevelsByTopicHandler(levelService core.LevelService) gin.HandlerFunc {
+// RevokeSessionHandler - отзыв сессии пользователя
+func RevokeSessionHandler(authService core.AuthService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		topic := c.Param("topic")
-		levels, err := levelService.GetLevelsByTopic(c.Request.Context(), topic)
+		userID, err := GetUserIDFromContext(c)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, APIResponse{
 				Success: false,
 				Error: &APIError{
-					Code:    ErrCodeInternal,
-					Message: "Failed to get levels by topic",
+					Code:    ErrCodeInternal,
+					Message: "Failed to get user ID",
+				},
+			})
+			return
+		}
+
+		err = authService.RevokeSession(c.Request.Context(), userID, c.Param("id"))
+		if err != nil {
+			if errors.Is(err, core.ErrSessionNotFound) {
+				c.JSON(http.StatusNotFound, APIResponse{
+					Success: false,
+					Error: &APIError{
+						Code:    ErrCodeSessionNotFound,
+						Message: "Session not found",
+					},
+				})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error: &APIError{
+					Code:    ErrCodeInternal,
+					Message: "Failed to revoke session",
+					Details: err.Error(),
+				},
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data: gin.H{
+				"message": "Session revoked",
+			},
+		})
+	}
+}
+
+// RevokeOtherSessionsHandler - отзыв всех сессий пользователя, кроме текущей
+func RevokeOtherSessionsHandler(authService core.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := GetUserIDFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error: &APIError{
+					Code:    ErrCodeInternal,
+					Message: "Failed to get user ID",
+				},
+			})
+			return
+		}
+
+		err = authService.RevokeOtherSessions(c.Request.Context(), userID, GetSessionIDFromContext(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error: &APIError{
+					Code:    ErrCodeInternal,
+					Message: "Failed to revoke sessions",
+					Details: err.Error(),
+				},
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data: gin.H{
+				"message": "Other sessions revoked",
+			},
+		})
+	}
+}
+
+// EnrollTOTPHandler - начало подключения TOTP: секрет, otpauth URI и коды восстановления
+func EnrollTOTPHandler(authService core.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := GetUserIDFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error: &APIError{
+					Code:    ErrCodeInternal,
+					Message: "Failed to get user ID",
+				},
+			})
+			return
+		}
+
+		enrollment, err := authService.EnrollTOTP(c.Request.Context(), userID)
+		if err != nil {
+			if errors.Is(err, core.ErrMFAAlreadyEnabled) {
+				c.JSON(http.StatusConflict, APIResponse{
+					Success: false,
+					Error: &APIError{
+						Code:    ErrCodeMFAAlreadyEnabled,
+						Message: "Two-factor authentication is already enabled",
+					},
+				})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error: &APIError{
+					Code:    ErrCodeInternal,
+					Message: "Failed to enroll TOTP",
+				},
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data:    enrollment,
+		})
+	}
+}
+
+// ConfirmTOTPHandler - включение 2FA первым кодом из приложения
+func ConfirmTOTPHandler(authService core.AuthService) gin.HandlerFunc {
+	return totpCodeHandler(authService.ConfirmTOTP, "Two-factor authentication enabled")
+}
+
+// DisableTOTPHandler - отключение 2FA кодом TOTP или кодом восстановления
+func DisableTOTPHandler(authService core.AuthService) gin.HandlerFunc {
+	return totpCodeHandler(authService.DisableTOTP, "Two-factor authentication disabled")
+}
+
+// totpCodeHandler - общий обработчик действий с 2FA, подтверждаемых кодом
+func totpCodeHandler(action func(ctx context.Context, userID uint, code string) error, successMessage string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := GetUserIDFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error: &APIError{
+					Code:    ErrCodeInternal,
+					Message: "Failed to get user ID",
+				},
+			})
+			return
+		}
+
+		var req TOTPCodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error: &APIError{
+					Code:    ErrCodeValidation,
+					Message: "Invalid request data",
+					Details: err.Error(),
+				},
+			})
+			return
+		}
+
+		err = action(c.Request.Context(), userID, req.Code)
+		if err != nil {
+			if respondLocked(c, err) {
+				return
+			}
+			status, code, message := http.StatusInternalServerError, ErrCodeInternal, "Failed to update two-factor authentication"
+			switch {
+			case errors.Is(err, core.ErrInvalidMFACode):
+				status, code, message = http.StatusBadRequest, ErrCodeInvalidMFACode, "Invalid two-factor code"
+			case errors.Is(err, core.ErrMFAAlreadyEnabled):
+				status, code, message = http.StatusConflict, ErrCodeMFAAlreadyEnabled, "Two-factor authentication is already enabled"
+			case errors.Is(err, core.ErrMFANotEnabled):
+				status, code, message = http.StatusConflict, ErrCodeMFANotEnabled, "Two-factor authentication is not set up"
+			}
+			c.JSON(status, APIResponse{
+				Success: false,
+				Error: &APIError{
+					Code:    code,
+					Message: message,
+				},
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data: gin.H{
+				"message": successMessage,
+			},
+		})
+	}
+}
+
+// GetOIDCProvidersHandler - список доступных провайдеров входа
+func GetOIDCProvidersHandler(authService core.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data: gin.H{
+				"providers": authService.GetOIDCProviders(c.Request.Context()),
+			},
+		})
+	}
+}
+
+// OIDCAuthorizeHandler - начало входа через OIDC провайдера
+func OIDCAuthorizeHandler(authService core.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authURL, err := authService.StartOIDCLogin(c.Request.Context(), c.Param("provider"))
+		if err != nil {
+			respondOIDCError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data:    OIDCAuthorizeResponse{AuthorizationURL: authURL},
+		})
+	}
+}
+
+// OIDCCallbackHandler - завершение входа через OIDC провайдера
+func OIDCCallbackHandler(authService core.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req OIDCCallbackRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error: &APIError{
+					Code:    ErrCodeValidation,
+					Message: "Invalid request data",
+					Details: err.Error(),
+				},
+			})
+			return
+		}
+
+		result, err := authService.CompleteOIDCLogin(c.Request.Context(), c.Param("provider"), req.Code, req.State, clientInfo(c))
+		if err != nil {
+			respondOIDCError(c, err)
+			return
+		}
+
+		respondLogin(c, result)
+	}
+}
+
+// GetIdentitiesHandler - привязанные внешние аккаунты
+func GetIdentitiesHandler(authService core.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := GetUserIDFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error: &APIError{
+					Code:    ErrCodeInternal,
+					Message: "Failed to get user ID",
+				},
+			})
+			return
+		}
+
+		identities, err := authService.GetIdentities(c.Request.Context(), userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error: &APIError{
+					Code:    ErrCodeInternal,
+					Message: "Failed to get identities",
+				},
+			})
+			return
+		}
+
+		result := make([]IdentityInfo, 0, len(identities))
+		for _, identity := range identities {
+			result = append(result, identityInfo(identity))
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data:    result,
+		})
+	}
+}
+
+// LinkIdentityHandler - начало привязки OIDC провайдера к аккаунту
+func LinkIdentityHandler(authService core.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := GetUserIDFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error: &APIError{
+					Code:    ErrCodeInternal,
+					Message: "Failed to get user ID",
+				},
+			})
+			return
+		}
+
+		authURL, err := authService.StartOIDCLink(c.Request.Context(), userID, c.Param("provider"))
+		if err != nil {
+			respondOIDCError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data:    OIDCAuthorizeResponse{AuthorizationURL: authURL},
+		})
+	}
+}
+
+// LinkIdentityCallbackHandler - завершение привязки OIDC провайдера
+func LinkIdentityCallbackHandler(authService core.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := GetUserIDFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error: &APIError{
+					Code:    ErrCodeInternal,
+					Message: "Failed to get user ID",
+				},
+			})
+			return
+		}
+
+		var req OIDCCallbackRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error: &APIError{
+					Code:    ErrCodeValidation,
+					Message: "Invalid request data",
+					Details: err.Error(),
+				},
+			})
+			return
+		}
+
+		identity, err := authService.CompleteOIDCLink(c.Request.Context(), userID, c.Param("provider"), req.Code, req.State)
+		if err != nil {
+			respondOIDCError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data:    identityInfo(identity),
+		})
+	}
+}
+
+// UnlinkIdentityHandler - отвязка OIDC провайдера
+func UnlinkIdentityHandler(authService core.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := GetUserIDFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error: &APIError{
+					Code:    ErrCodeInternal,
+					Message: "Failed to get user ID",
+				},
+			})
+			return
+		}
+
+		err = authService.UnlinkIdentity(c.Request.Context(), userID, c.Param("provider"))
+		if err != nil {
+			respondOIDCError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data: gin.H{
+				"message": "Identity unlinked",
+			},
+		})
+	}
+}
+
+// respondOIDCError - ответ на ошибку входа или привязки через OIDC
+func respondOIDCError(c *gin.Context, err error) {
+	status, code, message := http.StatusInternalServerError, ErrCodeInternal, "OIDC request failed"
+	switch {
+	case errors.Is(err, core.ErrUnknownProvider):
+		status, code, message = http.StatusNotFound, ErrCodeUnknownProvider, "Unknown identity provider"
+	case errors.Is(err, core.ErrIdentityNotFound):
+		status, code, message = http.StatusNotFound, ErrCodeNotFound, "Identity is not linked"
+	case errors.Is(err, core.ErrInvalidOIDCState):
+		status, code, message = http.StatusBadRequest, ErrCodeOIDCFailed, "Invalid or expired state"
+	case errors.Is(err, core.ErrOIDCFailed):
+		status, code, message = http.StatusUnauthorized, ErrCodeOIDCFailed, "Identity provider authentication failed"
+	case errors.Is(err, core.ErrOIDCEmailRequired):
+		status, code, message = http.StatusBadRequest, ErrCodeOIDCFailed, "Identity provider did not share an email address"
+	case errors.Is(err, core.ErrAccountExists):
+		status, code, message = http.StatusConflict, ErrCodeAccountExists, "An account with this email already exists; sign in and link the provider"
+	case errors.Is(err, core.ErrIdentityLinked):
+		status, code, message = http.StatusConflict, ErrCodeIdentityLinked, "Identity is already linked to an account"
+	case errors.Is(err, core.ErrLastLoginMethod):
+		status, code, message = http.StatusConflict, ErrCodeLastLoginMethod, "Set a password before unlinking the only identity provider"
+	}
+	c.JSON(status, APIResponse{
+		Success: false,
+		Error: &APIError{
+			Code:    code,
+			Message: message,
+		},
+	})
+}
+
+// identityInfo - внешний аккаунт для ответа
+func identityInfo(identity *domain.UserIdentity) IdentityInfo {
+	info := IdentityInfo{
+		Provider: identity.Provider,
+		Email:    identity.Email,
+		LinkedAt: identity.CreatedAt.Format(time.RFC3339),
+	}
+	if identity.LastLoginAt != nil {
+		lastLogin := identity.LastLoginAt.Format(time.RFC3339)
+		info.LastLoginAt = &lastLogin
+	}
+	return info
+}
+
+// clientInfo - сведения о клиенте текущего запроса
+func clientInfo(c *gin.Context) core.ClientInfo {
+	return core.ClientInfo{
+		UserAgent: c.Request.UserAgent(),
+		IP:        c.ClientIP(),
+	}
+}
+
+// MeHandler - получение информации о текущем пользователе
+func MeHandler(authService core.AuthService, userService core.UserService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := GetUserIDFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error: &APIError{
+					Code:    ErrCodeInternal,
+					Message: "Failed to get user ID",
+				},
+			})
+			return
+		}
+
+		user, err := authService.GetCurrentUser(c.Request.Context(), userID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, APIResponse{
+				Success: false,
+				Error: &APIError{
+					Code:    ErrCodeNotFound,
+					Message: "User not found",
+				},
+			})
+			return
+		}
+
+		profile, err := userService.GetProfile(c.Request.Context(), userID)
+		if err != nil {
+			// Если профиль не найден, создаем дефолтный
+			profile = &domain.Profile{
+				UserID: userID,
+				Streak: 0,
+				Stats:  nil,
+				Meta:   nil,
+			}
+		}
+
+		diamonds, err := userService.GetDiamondsBalance(c.Request.Context(), userID)
+		if err != nil {
+			// Временно установить 0 если ошибка
+			diamonds = 0
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data: UserInfo{
+				ID:            user.ID,
+				Email:         user.Email,
+				Username:      user.Username,
+				Role:          string(user.Role),
+				EmailVerified: user.EmailVerifiedAt != nil,
+				MFAEnabled:    user.TOTPEnabledAt != nil,
+				Profile: &ProfileInfo{
+					Streak:   profile.Streak,
+					Diamonds: diamonds,
+					Stats:    make(map[string]interface{}), // TODO: правильно обработать datatypes.JSON
+				},
+			},
+		})
+	}
+}
+
+// Level handlers
+
+// GetLevelsHandler - получение списка уровней
+func GetLevelsHandler(levelService core.LevelService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		levels, err := levelService.GetLevels(c.Request.Context())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error: &APIError{
+					Code:    ErrCodeInternal,
+					Message: "Failed to get levels",
+				},
+			})
+			return
+		}
+
+		var levelInfos []LevelInfo
+		for _, level := range levels {
+			levelInfos = append(levelInfos, LevelInfo{
+				ID:           level.ID,
+				Title:        level.Title,
+				Topic:        level.Topic,
+				Difficulty:   level.Difficulty,
+				RewardPoints: level.RewardPoints,
+				IsActive:     level.IsActive,
+			})
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data:    levelInfos,
+			Meta: &Meta{
+				Total: len(levelInfos),
+			},
+		})
+	}
+}
+
+// GetLevelHandler - получение деталей уровня
+func GetLevelHandler(levelService core.LevelService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idStr := c.Param("id")
+		id, err := strconv.ParseUint(idStr, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error: &APIError{
+					Code:    ErrCodeValidation,
+					Message: "Invalid level ID",
+				},
+			})
+			return
+		}
+
+		level, err := levelService.GetLevel(c.Request.Context(), uint(id))
+		if err != nil {
+			c.JSON(http.StatusNotFound, APIResponse{
+				Success: false,
+				Error: &APIError{
+					Code:    ErrCodeLevelNotFound,
+					Message: "Level not found",
+				},
+			})
+			return
+		}
+
+		// Конвертируем шаги в нужный формат
+		var steps []map[string]interface{}
+		for _, step := range level.Steps {
+			stepData := map[string]interface{}{
+				"id":    step.ID,
+				"order": step.Order,
+				"type":  step.Type,
+				"title": step.Title,
+			}
+
+			// Добавляем payload если есть
+			if step.Payload != nil {
+				stepData["payload"] = step.Payload
+			}
+
+			// Добавляем question_id если это вопрос
+			if step.QuestionID != nil {
+				stepData["question_id"] = *step.QuestionID
+			}
+
+			steps = append(steps, stepData)
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data: map[string]interface{}{
+				"id":            level.ID,
+				"title":         level.Title,
+				"topic":         level.Topic,
+				"difficulty":    level.Difficulty,
+				"reward_points": level.RewardPoints,
+				"is_active":     level.IsActive,
+				"description":   "",
+				"steps_count":   len(level.Steps),
+				"steps":         steps,
+			},
+		})
+	}
+}
+
+// GetLevelsByDifficultyHandler - получение уровней по сложности
+func GetLevelsByDifficultyHandler(levelService core.LevelService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		difficulty := c.Param("difficulty")
+		levels, err := levelService.GetLevelsByDifficulty(c.Request.Context(), difficulty)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error: &APIError{
+					Code:    ErrCodeInternal,
+					Message: "Failed to get levels by difficulty",
+				},
+			})
+			return
+		}
+
+		var levelInfos []LevelInfo
+		for _, level := range levels {
+			levelInfos = append(levelInfos, LevelInfo{
+				ID:           level.ID,
+				Title:        level.Title,
+				Topic:        level.Topic,
+				Difficulty:   level.Difficulty,
+				RewardPoints: level.RewardPoints,
+				IsActive:     level.IsActive,
+			})
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data:    levelInfos,
+			Meta: &Meta{
+				Total: len(levelInfos),
+			},
+		})
+	}
+}
+
+// GetLevelsByTopicHandler - получение уровней по теме
+func GetLevelsByTopicHandler(levelService core.LevelService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		topic := c.Param("topic")
+		levels, err := levelService.GetLevelsByTopic(c.Request.Context(), topic)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error: &APIError{
+					Code:    ErrCodeInternal,
+					Message: "Failed to get levels by topic",
+				},
+			})
+			return
+		}
+
+		var levelInfos []LevelInfo
+		for _, level := range levels {
+			levelInfos = append(levelInfos, LevelInfo{
+				ID:           level.ID,
+				Title:        level.Title,
+				Topic:        level.Topic,
+				Difficulty:   level.Difficulty,
+				RewardPoints: level.RewardPoints,
+				IsActive:     level.IsActive,
+			})
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data:    levelInfos,
+			Meta: &Meta{
+				Total: len(levelInfos),
+			},
+		})
+	}
+}
+
+// Content authoring handlers
+
+// EditorListLevelsHandler - все уровни для редактора (?include_deleted=true — вместе с удаленными)
+func EditorListLevelsHandler(contentService core.ContentService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		levels, err := contentService.ListLevels(c.Request.Context(), c.Query("include_deleted") == "true")
+		if err != nil {
+			respondContentError(c, err, "Failed to get levels")
+			return
+		}
+
+		result := make([]EditorLevel, 0, len(levels))
+		for _, level := range levels {
+			result = append(result, editorLevel(level))
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data:    result,
+			Meta: &Meta{
+				Total: len(result),
+			},
+		})
+	}
+}
+
+// EditorGetLevelHandler - уровень с шагами для редактора
+func EditorGetLevelHandler(contentService core.ContentService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := pathID(c, "id", "Invalid level ID")
+		if !ok {
+			return
+		}
+
+		level, err := contentService.GetLevel(c.Request.Context(), id, c.Query("include_deleted") == "true")
+		if err != nil {
+			respondContentError(c, err, "Failed to get level")
+			return
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data:    editorLevel(level),
+		})
+	}
+}
+
+// EditorCreateLevelHandler - создание уровня
+func EditorCreateLevelHandler(contentService core.ContentService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req LevelRequest
+		if !bindContentRequest(c, &req) {
+			return
+		}
+
+		level := levelFromRequest(req)
+		if err := contentService.CreateLevel(c.Request.Context(), level); err != nil {
+			respondContentError(c, err, "Failed to create level")
+			return
+		}
+
+		c.JSON(http.StatusCreated, APIResponse{
+			Success: true,
+			Data:    editorLevel(level),
+		})
+	}
+}
+
+// EditorUpdateLevelHandler - изменение уровня
+func EditorUpdateLevelHandler(contentService core.ContentService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := pathID(c, "id", "Invalid level ID")
+		if !ok {
+			return
+		}
+
+		var req LevelRequest
+		if !bindContentRequest(c, &req) {
+			return
+		}
+
+		level := levelFromRequest(req)
+		level.ID = id
+		if err := contentService.UpdateLevel(c.Request.Context(), level); err != nil {
+			respondContentError(c, err, "Failed to update level")
+			return
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data:    editorLevel(level),
+		})
+	}
+}
+
+// EditorDeleteLevelHandler - мягкое удаление уровня
+func EditorDeleteLevelHandler(contentService core.ContentService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := pathID(c, "id", "Invalid level ID")
+		if !ok {
+			return
+		}
+
+		if err := contentService.DeleteLevel(c.Request.Context(), id); err != nil {
+			respondContentError(c, err, "Failed to delete level")
+			return
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data: gin.H{
+				"message": "Level deleted",
+			},
+		})
+	}
+}
+
+// EditorRestoreLevelHandler - восстановление удаленного уровня
+func EditorRestoreLevelHandler(contentService core.ContentService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := pathID(c, "id", "Invalid level ID")
+		if !ok {
+			return
+		}
+
+		if err := contentService.RestoreLevel(c.Request.Context(), id); err != nil {
+			respondContentError(c, err, "Failed to restore level")
+			return
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data: gin.H{
+				"message": "Level restored",
+			},
+		})
+	}
+}
+
+// EditorListStepsHandler - шаги уровня (?include_deleted=true — вместе с удаленными)
+func EditorListStepsHandler(contentService core.ContentService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		levelID, ok := pathID(c, "id", "Invalid level ID")
+		if !ok {
+			return
+		}
+
+		steps, err := contentService.ListSteps(c.Request.Context(), levelID, c.Query("include_deleted") == "true")
+		if err != nil {
+			respondContentError(c, err, "Failed to get steps")
+			return
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data:    editorSteps(steps),
+			Meta: &Meta{
+				Total: len(steps),
+			},
+		})
+	}
+}
+
+// EditorCreateStepHandler - добавление шага в уровень
+func EditorCreateStepHandler(contentService core.ContentService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		levelID, ok := pathID(c, "id", "Invalid level ID")
+		if !ok {
+			return
+		}
+
+		var req StepRequest
+		if !bindContentRequest(c, &req) {
+			return
+		}
+
+		step := stepFromRequest(req)
+		step.LevelID = levelID
+		if err := contentService.CreateStep(c.Request.Context(), step); err != nil {
+			respondContentError(c, err, "Failed to create step")
+			return
+		}
+
+		c.JSON(http.StatusCreated, APIResponse{
+			Success: true,
+			Data:    editorStep(step),
+		})
+	}
+}
+
+// EditorReorderStepsHandler - новый порядок шагов уровня
+func EditorReorderStepsHandler(contentService core.ContentService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		levelID, ok := pathID(c, "id", "Invalid level ID")
+		if !ok {
+			return
+		}
+
+		var req ReorderStepsRequest
+		if !bindContentRequest(c, &req) {
+			return
+		}
+
+		steps, err := contentService.ReorderSteps(c.Request.Context(), levelID, req.StepIDs)
+		if err != nil {
+			respondContentError(c, err, "Failed to reorder steps")
+			return
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data:    editorSteps(steps),
+		})
+	}
+}
+
+// EditorUpdateStepHandler - изменение шага
+func EditorUpdateStepHandler(contentService core.ContentService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := pathID(c, "id", "Invalid step ID")
+		if !ok {
+			return
+		}
+
+		var req StepRequest
+		if !bindContentRequest(c, &req) {
+			return
+		}
+
+		step := stepFromRequest(req)
+		step.ID = id
+		if err := contentService.UpdateStep(c.Request.Context(), step); err != nil {
+			respondContentError(c, err, "Failed to update step")
+			return
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data:    editorStep(step),
+		})
+	}
+}
+
+// EditorDeleteStepHandler - мягкое удаление шага
+func EditorDeleteStepHandler(contentService core.ContentService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := pathID(c, "id", "Invalid step ID")
+		if !ok {
+			return
+		}
+
+		if err := contentService.DeleteStep(c.Request.Context(), id); err != nil {
+			respondContentError(c, err, "Failed to delete step")
+			return
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data: gin.H{
+				"message": "Step deleted",
+			},
+		})
+	}
+}
+
+// EditorGetQuestionHandler - вопрос с правильными ответами
+func EditorGetQuestionHandler(contentService core.ContentService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := pathID(c, "id", "Invalid question ID")
+		if !ok {
+			return
+		}
+
+		question, err := contentService.GetQuestion(c.Request.Context(), id)
+		if err != nil {
+			respondContentError(c, err, "Failed to get question")
+			return
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data:    editorQuestion(question),
+		})
+	}
+}
+
+// EditorCreateQuestionHandler - создание вопроса с вариантами ответов
+func EditorCreateQuestionHandler(contentService core.ContentService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req QuestionRequest
+		if !bindContentRequest(c, &req) {
+			return
+		}
+
+		question := &domain.Question{
+			Prompt:      req.Prompt,
+			Explanation: req.Explanation,
+			Kind:        domain.QuestionKind(req.Kind),
+			Spec:        questionSpec(req.Spec),
+			Template:    questionTemplateFromRequest(req.Template),
+			MultiSelect: req.MultiSelect,
+			GradingMode: domain.GradingMode(req.GradingMode),
+			MinCorrect:  req.MinCorrect,
+		}
+		for _, choice := range req.Choices {
+			question.Choices = append(question.Choices, domain.Choice{
+				Text:      choice.Text,
+				IsCorrect: choice.IsCorrect,
+				Order:     choice.Order,
+			})
+		}
+
+		if err := contentService.CreateQuestion(c.Request.Context(), question); err != nil {
+			respondContentError(c, err, "Failed to create question")
+			return
+		}
+
+		c.JSON(http.StatusCreated, APIResponse{
+			Success: true,
+			Data:    editorQuestion(question),
+		})
+	}
+}
+
+// EditorUpdateQuestionHandler - изменение текста и режима вопроса; варианты меняются отдельно
+func EditorUpdateQuestionHandler(contentService core.ContentService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := pathID(c, "id", "Invalid question ID")
+		if !ok {
+			return
+		}
+
+		var req QuestionRequest
+		if !bindContentRequest(c, &req) {
+			return
+		}
+
+		question := &domain.Question{
+			Prompt:      req.Prompt,
+			Explanation: req.Explanation,
+			Kind:        domain.QuestionKind(req.Kind),
+			Spec:        questionSpec(req.Spec),
+			Template:    questionTemplateFromRequest(req.Template),
+			MultiSelect: req.MultiSelect,
+			GradingMode: domain.GradingMode(req.GradingMode),
+			MinCorrect:  req.MinCorrect,
+		}
+		question.ID = id
+		if err := contentService.UpdateQuestion(c.Request.Context(), question); err != nil {
+			respondContentError(c, err, "Failed to update question")
+			return
+		}
+
+		updated, err := contentService.GetQuestion(c.Request.Context(), id)
+		if err != nil {
+			respondContentError(c, err, "Failed to get question")
+			return
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data:    editorQuestion(updated),
+		})
+	}
+}
+
+// EditorDeleteQuestionHandler - удаление вопроса, не используемого в шагах
+func EditorDeleteQuestionHandler(contentService core.ContentService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := pathID(c, "id", "Invalid question ID")
+		if !ok {
+			return
+		}
+
+		if err := contentService.DeleteQuestion(c.Request.Context(), id); err != nil {
+			respondContentError(c, err, "Failed to delete question")
+			return
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data: gin.H{
+				"message": "Question deleted",
+			},
+		})
+	}
+}
+
+// EditorCreateChoiceHandler - добавление варианта ответа
+func EditorCreateChoiceHandler(contentService core.ContentService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		questionID, ok := pathID(c, "id", "Invalid question ID")
+		if !ok {
+			return
+		}
+
+		var req ChoiceRequest
+		if !bindContentRequest(c, &req) {
+			return
+		}
+
+		choice := &domain.Choice{
+			QuestionID: questionID,
+			Text:       req.Text,
+			IsCorrect:  req.IsCorrect,
+			Order:      req.Order,
+		}
+		if err := contentService.CreateChoice(c.Request.Context(), choice); err != nil {
+			respondContentError(c, err, "Failed to create choice")
+			return
+		}
+
+		c.JSON(http.StatusCreated, APIResponse{
+			Success: true,
+			Data:    editorChoice(choice),
+		})
+	}
+}
+
+// EditorUpdateChoiceHandler - изменение варианта ответа
+func EditorUpdateChoiceHandler(contentService core.ContentService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := pathID(c, "id", "Invalid choice ID")
+		if !ok {
+			return
+		}
+
+		var req ChoiceRequest
+		if !bindContentRequest(c, &req) {
+			return
+		}
+
+		choice := &domain.Choice{
+			Text:      req.Text,
+			IsCorrect: req.IsCorrect,
+			Order:     req.Order,
+		}
+		choice.ID = id
+		if err := contentService.UpdateChoice(c.Request.Context(), choice); err != nil {
+			respondContentError(c, err, "Failed to update choice")
+			return
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data:    editorChoice(choice),
+		})
+	}
+}
+
+// EditorDeleteChoiceHandler - удаление варианта ответа
+func EditorDeleteChoiceHandler(contentService core.ContentService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := pathID(c, "id", "Invalid choice ID")
+		if !ok {
+			return
+		}
+
+		if err := contentService.DeleteChoice(c.Request.Context(), id); err != nil {
+			respondContentError(c, err, "Failed to delete choice")
+			return
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data: gin.H{
+				"message": "Choice deleted",
+			},
+		})
+	}
+}
+
+// EditorQuestionAnalyticsHandler - статистика ответов на вопрос
+func EditorQuestionAnalyticsHandler(analyticsService core.AnalyticsService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := pathID(c, "id", "Invalid question ID")
+		if !ok {
+			return
+		}
+
+		stats, err := analyticsService.QuestionAnalytics(c.Request.Context(), id)
+		if err != nil {
+			respondContentError(c, err, "Failed to get question analytics")
+			return
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data:    questionAnalytics(stats),
+		})
+	}
+}
+
+// EditorLevelAnalyticsHandler - статистика по всем вопросам уровня
+func EditorLevelAnalyticsHandler(analyticsService core.AnalyticsService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := pathID(c, "id", "Invalid level ID")
+		if !ok {
+			return
+		}
+
+		stats, err := analyticsService.LevelAnalytics(c.Request.Context(), id)
+		if err != nil {
+			respondContentError(c, err, "Failed to get level analytics")
+			return
+		}
+
+		questions := make([]QuestionAnalytics, 0, len(stats))
+		for _, s := range stats {
+			questions = append(questions, questionAnalytics(s))
+		}
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data: gin.H{
+				"level_id":  id,
+				"questions": questions,
+			},
+		})
+	}
+}
+
+func questionAnalytics(stats *core.QuestionAnalytics) QuestionAnalytics {
+	result := QuestionAnalytics{
+		QuestionID:          stats.QuestionID,
+		Prompt:              stats.Prompt,
+		Kind:                string(stats.Kind),
+		Attempts:            stats.Attempts,
+		Answers:             stats.Answers,
+		FirstTryCorrectRate: stats.FirstTryCorrectRate,
+		AverageScore:        stats.AverageScore,
+		AverageDurationMs:   stats.AverageDurationMs,
+		Discrimination:      stats.Discrimination,
+		Flags:               stats.Flags,
+	}
+	for _, choice := range stats.Choices {
+		result.Choices = append(result.Choices, ChoiceStat{
+			ChoiceID:  choice.ChoiceID,
+			Text:      choice.Text,
+			IsCorrect: choice.IsCorrect,
+			Picks:     choice.Picks,
+			Rate:      choice.Rate,
+		})
+	}
+	return result
+}
+
+// EditorPublishLevelHandler - публикация черновика уровня как новой ревизии
+func EditorPublishLevelHandler(contentService core.ContentService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		levelID, ok := pathID(c, "id", "Invalid level ID")
+		if !ok {
+			return
+		}
+		actorID, err := GetUserIDFromContext(c)
+		if err != nil {
+			respondContentError(c, err, "Failed to get user ID")
+			return
+		}
+
+		var req PublishLevelRequest
+		if c.Request.ContentLength > 0 && !bindContentRequest(c, &req) {
+			return
+		}
+
+		revision, err := contentService.PublishLevel(c.Request.Context(), levelID, actorID, req.Note)
+		if err != nil {
+			respondContentError(c, err, "Failed to publish level")
+			return
+		}
+
+		c.JSON(http.StatusCreated, APIResponse{
+			Success: true,
+			Data:    revisionInfo(revision),
+		})
+	}
+}
+
+// EditorListRevisionsHandler - история опубликованных ревизий уровня
+func EditorListRevisionsHandler(contentService core.ContentService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		levelID, ok := pathID(c, "id", "Invalid level ID")
+		if !ok {
+			return
+		}
+
+		revisions, err := contentService.ListRevisions(c.Request.Context(), levelID)
+		if err != nil {
+			respondContentError(c, err, "Failed to get revisions")
+			return
+		}
+
+		result := make([]RevisionInfo, 0, len(revisions))
+		for _, revision := range revisions {
+			result = append(result, revisionInfo(revision))
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data:    result,
+			Meta: &Meta{
+				Total: len(result),
+			},
+		})
+	}
+}
+
+// EditorGetRevisionHandler - ревизия уровня со снимком шагов и вопросов
+func EditorGetRevisionHandler(contentService core.ContentService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		levelID, ok := pathID(c, "id", "Invalid level ID")
+		if !ok {
+			return
+		}
+		number, ok := pathID(c, "number", "Invalid revision number")
+		if !ok {
+			return
+		}
+
+		revision, err := contentService.GetRevision(c.Request.Context(), levelID, int(number))
+		if err != nil {
+			respondContentError(c, err, "Failed to get revision")
+			return
+		}
+		level, err := revision.Level()
+		if err != nil {
+			respondContentError(c, err, "Failed to read revision snapshot")
+			return
+		}
+
+		detail := RevisionDetail{
+			RevisionInfo: revisionInfo(revision),
+			Level:        editorLevel(level),
+		}
+		for i, step := range level.Steps {
+			if step.Question != nil {
+				question := editorQuestion(step.Question)
+				detail.Level.Steps[i].Question = &question
+			}
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data:    detail,
+		})
+	}
+}
+
+// EditorRollbackLevelHandler - повторная публикация снимка предыдущей ревизии
+func EditorRollbackLevelHandler(contentService core.ContentService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		levelID, ok := pathID(c, "id", "Invalid level ID")
+		if !ok {
+			return
+		}
+		number, ok := pathID(c, "number", "Invalid revision number")
+		if !ok {
+			return
+		}
+		actorID, err := GetUserIDFromContext(c)
+		if err != nil {
+			respondContentError(c, err, "Failed to get user ID")
+			return
+		}
+
+		revision, err := contentService.RollbackLevel(c.Request.Context(), levelID, int(number), actorID)
+		if err != nil {
+			respondContentError(c, err, "Failed to roll back level")
+			return
+		}
+
+		c.JSON(http.StatusCreated, APIResponse{
+			Success: true,
+			Data:    revisionInfo(revision),
+		})
+	}
+}
+
+// GetCoursesHandler - список активных курсов
+func GetCoursesHandler(courseService core.CourseService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		courses, err := courseService.ListCourses(c.Request.Context(), false)
+		if err != nil {
+			respondContentError(c, err, "Failed to get courses")
+			return
+		}
+
+		result := make([]CourseInfo, 0, len(courses))
+		for _, course := range courses {
+			result = append(result, courseInfo(course))
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data:    result,
+			Meta: &Meta{
+				Total: len(result),
+			},
+		})
+	}
+}
+
+// GetCourseMapHandler - карта курса: юниты и уровни с состоянием locked/available/completed
+func GetCourseMapHandler(courseService core.CourseService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		courseID, ok := pathID(c, "id", "Invalid course ID")
+		if !ok {
+			return
+		}
+		userID, err := GetUserIDFromContext(c)
+		if err != nil {
+			respondContentError(c, err, "Failed to get user ID")
+			return
+		}
+
+		courseMap, err := courseService.GetCourseMap(c.Request.Context(), courseID, userID)
+		if err != nil {
+			respondContentError(c, err, "Failed to get course map")
+			return
+		}
+
+		result := CourseMap{
+			Course: courseInfo(courseMap.Course),
+			Units:  make([]CourseMapUnit, 0, len(courseMap.Units)),
+		}
+		for _, unit := range courseMap.Units {
+			mapUnit := CourseMapUnit{
+				ID:     unit.Unit.ID,
+				Title:  unit.Unit.Title,
+				Order:  unit.Unit.Order,
+				Levels: make([]CourseMapLevel, 0, len(unit.Levels)),
+			}
+			for _, progress := range unit.Levels {
+				mapUnit.Levels = append(mapUnit.Levels, CourseMapLevel{
+					LevelInfo:     editorLevel(progress.Level).LevelInfo,
+					State:         string(progress.State),
+					BestScore:     progress.BestScore,
+					Prerequisites: prerequisiteInfos(progress.Prerequisites),
+				})
+			}
+			result.Units = append(result.Units, mapUnit)
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data:    result,
+		})
+	}
+}
+
+// EditorListCoursesHandler - все курсы, включая неактивные
+func EditorListCoursesHandler(courseService core.CourseService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		courses, err := courseService.ListCourses(c.Request.Context(), true)
+		if err != nil {
+			respondContentError(c, err, "Failed to get courses")
+			return
+		}
+
+		result := make([]CourseInfo, 0, len(courses))
+		for _, course := range courses {
+			result = append(result, courseInfo(course))
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data:    result,
+			Meta: &Meta{
+				Total: len(result),
+			},
+		})
+	}
+}
+
+// EditorCreateCourseHandler - создание курса
+func EditorCreateCourseHandler(courseService core.CourseService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req CourseRequest
+		if !bindContentRequest(c, &req) {
+			return
+		}
+
+		course := courseFromRequest(req)
+		if err := courseService.CreateCourse(c.Request.Context(), course); err != nil {
+			respondContentError(c, err, "Failed to create course")
+			return
+		}
+
+		c.JSON(http.StatusCreated, APIResponse{
+			Success: true,
+			Data:    courseInfo(course),
+		})
+	}
+}
+
+// EditorUpdateCourseHandler - изменение курса
+func EditorUpdateCourseHandler(courseService core.CourseService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := pathID(c, "id", "Invalid course ID")
+		if !ok {
+			return
+		}
+
+		var req CourseRequest
+		if !bindContentRequest(c, &req) {
+			return
+		}
+
+		course := courseFromRequest(req)
+		course.ID = id
+		if err := courseService.UpdateCourse(c.Request.Context(), course); err != nil {
+			respondContentError(c, err, "Failed to update course")
+			return
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data:    courseInfo(course),
+		})
+	}
+}
+
+// EditorListUnitsHandler - юниты курса со всеми уровнями
+func EditorListUnitsHandler(courseService core.CourseService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		courseID, ok := pathID(c, "id", "Invalid course ID")
+		if !ok {
+			return
+		}
+
+		units, err := courseService.ListUnits(c.Request.Context(), courseID)
+		if err != nil {
+			respondContentError(c, err, "Failed to get units")
+			return
+		}
+
+		result := make([]UnitInfo, 0, len(units))
+		for _, unit := range units {
+			result = append(result, unitInfo(unit))
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data:    result,
+			Meta: &Meta{
+				Total: len(result),
+			},
+		})
+	}
+}
+
+// EditorCreateUnitHandler - добавление юнита в курс
+func EditorCreateUnitHandler(courseService core.CourseService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		courseID, ok := pathID(c, "id", "Invalid course ID")
+		if !ok {
+			return
+		}
+
+		var req UnitRequest
+		if !bindContentRequest(c, &req) {
+			return
+		}
+
+		unit := &domain.Unit{CourseID: courseID, Title: req.Title, Order: req.Order}
+		if err := courseService.CreateUnit(c.Request.Context(), unit); err != nil {
+			respondContentError(c, err, "Failed to create unit")
+			return
+		}
+
+		c.JSON(http.StatusCreated, APIResponse{
+			Success: true,
+			Data:    unitInfo(unit),
+		})
+	}
+}
+
+// EditorUpdateUnitHandler - изменение юнита
+func EditorUpdateUnitHandler(courseService core.CourseService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := pathID(c, "id", "Invalid unit ID")
+		if !ok {
+			return
+		}
+
+		var req UnitRequest
+		if !bindContentRequest(c, &req) {
+			return
+		}
+
+		unit := &domain.Unit{Title: req.Title, Order: req.Order}
+		unit.ID = id
+		if err := courseService.UpdateUnit(c.Request.Context(), unit); err != nil {
+			respondContentError(c, err, "Failed to update unit")
+			return
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data:    unitInfo(unit),
+		})
+	}
+}
+
+// EditorPlaceLevelHandler - перенос уровня в юнит и на позицию внутри юнита
+func EditorPlaceLevelHandler(courseService core.CourseService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		levelID, ok := pathID(c, "id", "Invalid level ID")
+		if !ok {
+			return
+		}
+
+		var req PlacementRequest
+		if !bindContentRequest(c, &req) {
+			return
+		}
+
+		if err := courseService.PlaceLevel(c.Request.Context(), levelID, req.UnitID, req.Order); err != nil {
+			respondContentError(c, err, "Failed to place level")
+			return
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data: gin.H{
+				"level_id":   levelID,
+				"unit_id":    req.UnitID,
+				"unit_order": req.Order,
+			},
+		})
+	}
+}
+
+// EditorGetPrerequisitesHandler - зависимости уровня
+func EditorGetPrerequisitesHandler(courseService core.CourseService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		levelID, ok := pathID(c, "id", "Invalid level ID")
+		if !ok {
+			return
+		}
+
+		prerequisites, err := courseService.GetPrerequisites(c.Request.Context(), levelID)
+		if err != nil {
+			respondContentError(c, err, "Failed to get prerequisites")
+			return
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data:    prerequisiteInfos(prerequisites),
+		})
+	}
+}
+
+// EditorSetPrerequisitesHandler - замена зависимостей уровня; цикл в графе — 409
+func EditorSetPrerequisitesHandler(courseService core.CourseService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		levelID, ok := pathID(c, "id", "Invalid level ID")
+		if !ok {
+			return
+		}
+
+		var req PrerequisitesRequest
+		if !bindContentRequest(c, &req) {
+			return
+		}
+
+		prerequisites := make([]*domain.LevelPrerequisite, 0, len(req.Prerequisites))
+		for _, item := range req.Prerequisites {
+			minScore := 70
+			if item.MinScore != nil {
+				minScore = *item.MinScore
+			}
+			prerequisites = append(prerequisites, &domain.LevelPrerequisite{
+				LevelID:         levelID,
+				RequiredLevelID: item.RequiredLevelID,
+				MinScore:        minScore,
+			})
+		}
+
+		saved, err := courseService.SetPrerequisites(c.Request.Context(), levelID, prerequisites)
+		if err != nil {
+			respondContentError(c, err, "Failed to set prerequisites")
+			return
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data:    prerequisiteInfos(saved),
+		})
+	}
+}
+
+// contextUserID - ID пользователя из контекста; при ошибке отвечает 500
+func contextUserID(c *gin.Context) (uint, bool) {
+	userID, err := GetUserIDFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error: &APIError{
+				Code:    ErrCodeInternal,
+				Message: "Failed to get user ID",
+			},
+		})
+		return 0, false
+	}
+	return userID, true
+}
+
+// pathID - числовой параметр пути; при ошибке отвечает 400
+func pathID(c *gin.Context, name, message string) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param(name), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error: &APIError{
+				Code:    ErrCodeValidation,
+				Message: message,
+			},
+		})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func bindContentRequest(c *gin.Context, req interface{}) bool {
+	if err := c.ShouldBindJSON(req); err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error: &APIError{
+				Code:    ErrCodeValidation,
+				Message: "Invalid request data",
+				Details: err.Error(),
+			},
+		})
+		return false
+	}
+	return true
+}
+
+// respondContentError - ответ на ошибку ContentService или CourseService
+func respondContentError(c *gin.Context, err error, message string) {
+	var validationErr *core.ValidationError
+	status, code := http.StatusInternalServerError, ErrCodeInternal
+	var details interface{}
+
+	switch {
+	case errors.As(err, &validationErr):
+		status, code, message = http.StatusBadRequest, ErrCodeValidation, validationErr.Error()
+		details = gin.H{"field": validationErr.Field}
+	case errors.Is(err, core.ErrLevelNotFound):
+		status, code, message = http.StatusNotFound, ErrCodeLevelNotFound, "Level not found"
+	case errors.Is(err, core.ErrStepNotFound):
+		status, code, message = http.StatusNotFound, ErrCodeStepNotFound, "Step not found"
+	case errors.Is(err, core.ErrQuestionNotFound):
+		status, code, message = http.StatusNotFound, ErrCodeQuestionNotFound, "Question not found"
+	case errors.Is(err, core.ErrChoiceNotFound):
+		status, code, message = http.StatusNotFound, ErrCodeChoiceNotFound, "Choice not found"
+	case errors.Is(err, core.ErrStepOrderTaken):
+		status, code, message = http.StatusConflict, ErrCodeStepOrderTaken, "Another step already has this order"
+	case errors.Is(err, core.ErrQuestionInUse):
+		status, code, message = http.StatusConflict, ErrCodeQuestionInUse, "Question is used by a level step"
+	case errors.Is(err, core.ErrRevisionNotFound):
+		status, code, message = http.StatusNotFound, ErrCodeRevisionNotFound, "Revision not found"
+	case errors.Is(err, core.ErrCourseNotFound):
+		status, code, message = http.StatusNotFound, ErrCodeCourseNotFound, "Course not found"
+	case errors.Is(err, core.ErrUnitNotFound):
+		status, code, message = http.StatusNotFound, ErrCodeUnitNotFound, "Unit not found"
+	case errors.Is(err, core.ErrPrerequisiteCycle):
+		status, code, message = http.StatusConflict, ErrCodePrerequisiteCycle, "Prerequisites would form a cycle"
+	}
+
+	c.JSON(status, APIResponse{
+		Success: false,
+		Error: &APIError{
+			Code:    code,
+			Message: message,
+			Details: details,
+		},
+	})
+}
+
+func levelFromRequest(req LevelRequest) *domain.Level {
+	isActive := true
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+	return &domain.Level{
+		Title:         req.Title,
+		Topic:         req.Topic,
+		Difficulty:    req.Difficulty,
+		RewardPoints:  req.RewardPoints,
+		IsActive:      isActive,
+		ScoringPolicy: scoringPolicyFromRequest(req.ScoringPolicy),
+	}
+}
+
+func scoringPolicyFromRequest(req *ScoringPolicy) *domain.ScoringPolicy {
+	if req == nil {
+		return nil
+	}
+	policy := &domain.ScoringPolicy{
+		PassScore:     req.PassScore,
+		PenaltyCurve:  req.PenaltyCurve,
+		PartialCredit: req.PartialCredit,
+	}
+	if req.TimeBonus != nil {
+		policy.TimeBonus = &domain.TimeBonus{
+			MaxPoints:     req.TimeBonus.MaxPoints,
+			TargetSeconds: req.TimeBonus.TargetSeconds,
+		}
+	}
+	return policy
+}
+
+func scoringPolicyInfo(policy domain.ScoringPolicy) ScoringPolicy {
+	result := ScoringPolicy{
+		PassScore:     policy.PassScore,
+		PenaltyCurve:  policy.PenaltyCurve,
+		PartialCredit: policy.PartialCredit,
+	}
+	if policy.TimeBonus != nil {
+		result.TimeBonus = &TimeBonus{
+			MaxPoints:     policy.TimeBonus.MaxPoints,
+			TargetSeconds: policy.TimeBonus.TargetSeconds,
+		}
+	}
+	return result
+}
+
+func stepFromRequest(req StepRequest) *domain.LevelStep {
+	step := &domain.LevelStep{
+		Order:      req.Order,
+		Type:       req.Type,
+		Title:      req.Title,
+		QuestionID: req.QuestionID,
+	}
+	if len(req.Payload) > 0 && string(req.Payload) != "null" {
+		step.Payload = datatypes.JSON(req.Payload)
+	}
+	return step
+}
+
+func editorLevel(level *domain.Level) EditorLevel {
+	result := EditorLevel{
+		LevelInfo: LevelInfo{
+			ID:           level.ID,
+			Title:        level.Title,
+			Topic:        level.Topic,
+			Difficulty:   level.Difficulty,
+			RewardPoints: level.RewardPoints,
+			IsActive:     level.IsActive,
+		},
+		Slug:                level.Slug,
+		UnitID:              level.UnitID,
+		UnitOrder:           level.UnitOrder,
+		PublishedRevisionID: level.PublishedRevisionID,
+		CreatedAt:           level.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:           level.UpdatedAt.Format(time.RFC3339),
+		DeletedAt:           deletedAt(level.DeletedAt),
+	}
+	if level.ScoringPolicy != nil {
+		policy := scoringPolicyInfo(*level.ScoringPolicy)
+		result.ScoringPolicy = &policy
+	}
+	for i := range level.Steps {
+		result.Steps = append(result.Steps, editorStep(&level.Steps[i]))
+	}
+	return result
+}
+
+func courseFromRequest(req CourseRequest) *domain.Course {
+	isActive := true
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+	return &domain.Course{
+		Slug:        req.Slug,
+		Title:       req.Title,
+		Description: req.Description,
+		Order:       req.Order,
+		IsActive:    isActive,
+	}
+}
+
+func courseInfo(course *domain.Course) CourseInfo {
+	return CourseInfo{
+		ID:          course.ID,
+		Slug:        course.Slug,
+		Title:       course.Title,
+		Description: course.Description,
+		Order:       course.Order,
+		IsActive:    course.IsActive,
+	}
+}
+
+func unitInfo(unit *domain.Unit) UnitInfo {
+	result := UnitInfo{
+		ID:       unit.ID,
+		CourseID: unit.CourseID,
+		Title:    unit.Title,
+		Order:    unit.Order,
+		Levels:   make([]EditorLevel, 0, len(unit.Levels)),
+	}
+	for i := range unit.Levels {
+		result.Levels = append(result.Levels, editorLevel(&unit.Levels[i]))
+	}
+	return result
+}
+
+func prerequisiteInfos(prerequisites []*domain.LevelPrerequisite) []PrerequisiteInfo {
+	result := make([]PrerequisiteInfo, 0, len(prerequisites))
+	for _, prerequisite := range prerequisites {
+		result = append(result, PrerequisiteInfo{
+			RequiredLevelID: prerequisite.RequiredLevelID,
+			MinScore:        prerequisite.MinScore,
+		})
+	}
+	return result
+}
+
+func revisionInfo(revision *domain.LevelRevision) RevisionInfo {
+	return RevisionInfo{
+		ID:             revision.ID,
+		LevelID:        revision.LevelID,
+		Number:         revision.Number,
+		PublishedBy:    revision.PublishedBy,
+		RolledBackFrom: revision.RolledBackFrom,
+		Note:           revision.Note,
+		CreatedAt:      revision.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func editorSteps(steps []*domain.LevelStep) []EditorStep {
+	result := make([]EditorStep, 0, len(steps))
+	for _, step := range steps {
+		result = append(result, editorStep(step))
+	}
+	return result
+}
+
+func editorStep(step *domain.LevelStep) EditorStep {
+	result := EditorStep{
+		ID:         step.ID,
+		LevelID:    step.LevelID,
+		Slug:       step.Slug,
+		Order:      step.Order,
+		Type:       step.Type,
+		Title:      step.Title,
+		QuestionID: step.QuestionID,
+		DeletedAt:  deletedAt(step.DeletedAt),
+	}
+	if len(step.Payload) > 0 {
+		result.Payload = json.RawMessage(step.Payload)
+	}
+	return result
+}
+
+func editorQuestion(question *domain.Question) EditorQuestion {
+	result := EditorQuestion{
+		ID:          question.ID,
+		Slug:        question.Slug,
+		Prompt:      question.Prompt,
+		Explanation: question.Explanation,
+		Kind:        string(question.QuestionKind()),
+		Spec:        json.RawMessage(question.Spec),
+		Template:    questionTemplateInfo(question.Template),
+		MultiSelect: question.MultiSelect,
+		GradingMode: string(question.GradingMode),
+		MinCorrect:  question.MinCorrect,
+		Choices:     make([]EditorChoice, 0, len(question.Choices)),
+	}
+	for i := range question.Choices {
+		result.Choices = append(result.Choices, editorChoice(&question.Choices[i]))
+	}
+	return result
+}
+
+// questionSpec - Spec вопроса из запроса; null и пустое значение означают отсутствие
+func questionSpec(raw json.RawMessage) datatypes.JSON {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	return datatypes.JSON(raw)
+}
+
+func questionTemplateFromRequest(req *QuestionTemplate) *domain.QuestionTemplate {
+	if req == nil {
+		return nil
+	}
+	template := &domain.QuestionTemplate{
+		Answer:      req.Answer,
+		Decimals:    req.Decimals,
+		Distractors: req.Distractors,
+	}
+	for _, v := range req.Variables {
+		template.Variables = append(template.Variables, domain.TemplateVariable{
+			Name: v.Name,
+			Min:  v.Min,
+			Max:  v.Max,
+			Step: v.Step,
+		})
+	}
+	return template
+}
+
+func questionTemplateInfo(template *domain.QuestionTemplate) *QuestionTemplate {
+	if template == nil {
+		return nil
+	}
+	result := &QuestionTemplate{
+		Answer:      template.Answer,
+		Decimals:    template.Decimals,
+		Distractors: template.Distractors,
+	}
+	for _, v := range template.Variables {
+		result.Variables = append(result.Variables, TemplateVariable{
+			Name: v.Name,
+			Min:  v.Min,
+			Max:  v.Max,
+			Step: v.Step,
+		})
+	}
+	return result
+}
+
+func editorChoice(choice *domain.Choice) EditorChoice {
+	return EditorChoice{
+		ID:         choice.ID,
+		QuestionID: choice.QuestionID,
+		Slug:       choice.Slug,
+		Text:       choice.Text,
+		IsCorrect:  choice.IsCorrect,
+		Order:      choice.Order,
+	}
+}
+
+func deletedAt(value gorm.DeletedAt) *string {
+	if !value.Valid {
+		return nil
+	}
+	formatted := value.Time.Format(time.RFC3339)
+	return &formatted
+}
+
+// User handlers
+
+// ListUsersHandler - список пользователей (администратор)
+func ListUsersHandler(userService core.UserService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+		pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+
+		list, err := userService.ListUsers(c.Request.Context(), page, pageSize)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error: &APIError{
+					Code:    ErrCodeInternal,
+					Message: "Failed to get users",
+				},
+			})
+			return
+		}
+
+		result := make([]UserInfo, 0, len(list.Users))
+		for _, user := range list.Users {
+			result = append(result, UserInfo{
+				ID:            user.ID,
+				Email:         user.Email,
+				Username:      user.Username,
+				Role:          string(user.Role),
+				EmailVerified: user.EmailVerifiedAt != nil,
+				MFAEnabled:    user.TOTPEnabledAt != nil,
+			})
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data:    result,
+			Meta: &Meta{
+				Total:    int(list.Total),
+				Page:     list.Page,
+				PageSize: list.PageSize,
+			},
+		})
+	}
+}
+
+// SetUserRoleHandler - изменение роли пользователя (администратор)
+func SetUserRoleHandler(userService core.UserService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actorID, err := GetUserIDFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error: &APIError{
+					Code:    ErrCodeInternal,
+					Message: "Failed to get user ID",
+				},
+			})
+			return
+		}
+
+		userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error: &APIError{
+					Code:    ErrCodeValidation,
+					Message: "Invalid user ID",
+				},
+			})
+			return
+		}
+
+		var req SetRoleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error: &APIError{
+					Code:    ErrCodeValidation,
+					Message: "Invalid request data",
+					Details: err.Error(),
 				},
 			})
 			return
 		}
 
-		var levelInfos []LevelInfo
-		for _, level := range levels {
-			levelInfos = append(levelInfos, LevelInfo{
-				ID:           level.ID,
-				Title:        level.Title,
-				Topic:        level.Topic,
-				Difficulty:   level.Difficulty,
-				RewardPoints: level.RewardPoints,
-				IsActive:     level.IsActive,
+		err = userService.SetRole(c.Request.Context(), actorID, uint(userID), domain.UserRole(req.Role))
+		if err != nil {
+			status, code, message := http.StatusInternalServerError, ErrCodeInternal, "Failed to update role"
+			switch {
+			case errors.Is(err, core.ErrUserNotFound):
+				status, code, message = http.StatusNotFound, ErrCodeNotFound, "User not found"
+			case errors.Is(err, core.ErrInvalidRole):
+				status, code, message = http.StatusBadRequest, ErrCodeValidation, "Invalid role"
+			case errors.Is(err, core.ErrOwnRole):
+				status, code, message = http.StatusConflict, ErrCodeConflict, "Cannot change your own role"
+			}
+			c.JSON(status, APIResponse{
+				Success: false,
+				Error: &APIError{
+					Code:    code,
+					Message: message,
+				},
 			})
+			return
 		}
 
 		c.JSON(http.StatusOK, APIResponse{
 			Success: true,
-			Data:    levelInfos,
-			Meta: &Meta{
-				Total: len(levelInfos),
+			Data: gin.H{
+				"message": "Role updated",
 			},
 		})
 	}
 }
 
-// User handlers
-
 // UpdateProfileHandler - обновление профиля пользователя
 func UpdateProfileHandler(userService core.UserService) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -807,25 +3071,23 @@ func StartAttemptHandler(attemptService core.AttemptService) gin.HandlerFunc {
 		attempt, err := attemptService.StartAttempt(c.Request.Context(), userID, req.LevelID)
 		if err != nil {
 			// Разные ответы для разных причин отказа
-			status := http.StatusBadRequest
-			code := ErrCodeLevelNotFound
-			msg := err.Error()
-			if msg == "previous level not completed" {
-				status = http.StatusForbidden
-				code = ErrCodeForbidden
-			} else if msg == "level is not active" {
-				status = http.StatusForbidden
-				code = ErrCodeForbidden
-			} else if msg == "level not found" {
-				status = http.StatusNotFound
-				code = ErrCodeLevelNotFound
+			var status int
+			var code string
+			switch {
+			case errors.Is(err, core.ErrLevelNotFound):
+				status, code = http.StatusNotFound, ErrCodeLevelNotFound
+			case errors.Is(err, core.ErrLevelLocked), errors.Is(err, core.ErrLevelInactive):
+				status, code = http.StatusForbidden, ErrCodeForbidden
+			default:
+				respondAttemptError(c, err)
+				return
 			}
 
 			c.JSON(status, APIResponse{
 				Success: false,
 				Error: &APIError{
 					Code:    code,
-					Message: msg,
+					Message: err.Error(),
 				},
 			})
 			return
@@ -981,22 +3243,18 @@ func GetAttemptHandler(attemptService core.AttemptService) gin.HandlerFunc {
 // GetNextQuestionHandler - получение следующего вопроса
 func GetNextQuestionHandler(attemptService core.AttemptService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		idStr := c.Param("id")
-		attemptID, err := strconv.ParseUint(idStr, 10, 32)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, APIResponse{
-				Success: false,
-				Error: &APIError{
-					Code:    ErrCodeValidation,
-					Message: "Invalid attempt ID",
-				},
-			})
+		userID, ok := contextUserID(c)
+		if !ok {
+			return
+		}
+		attemptID, ok := pathID(c, "id", "Invalid attempt ID")
+		if !ok {
 			return
 		}
 
-		question, err := attemptService.GetNextQuestion(c.Request.Context(), uint(attemptID))
+		question, err := attemptService.GetNextQuestion(c.Request.Context(), userID, attemptID)
 		if err != nil {
-			if err.Error() == "no more questions" {
+			if errors.Is(err, core.ErrNoMoreQuestions) {
 				c.JSON(http.StatusOK, APIResponse{
 					Success: true,
 					Data: gin.H{
@@ -1006,13 +3264,7 @@ func GetNextQuestionHandler(attemptService core.AttemptService) gin.HandlerFunc
 				})
 				return
 			}
-			c.JSON(http.StatusBadRequest, APIResponse{
-				Success: false,
-				Error: &APIError{
-					Code:    ErrCodeQuestionNotFound,
-					Message: err.Error(),
-				},
-			})
+			respondAttemptError(c, err)
 			return
 		}
 
@@ -1027,24 +3279,9 @@ func GetNextQuestionHandler(attemptService core.AttemptService) gin.HandlerFunc
 			return
 		}
 
-		var choices []ChoiceInfo
-		for _, choice := range question.Choices {
-			choices = append(choices, ChoiceInfo{
-				ID:   choice.ID,
-				Text: choice.Text,
-			})
-		}
-
-		questionInfo := QuestionInfo{
-			ID:          question.ID,
-			Prompt:      question.Prompt,
-			MultiSelect: question.MultiSelect,
-			Choices:     choices,
-		}
-
 		c.JSON(http.StatusOK, APIResponse{
 			Success: true,
-			Data:    questionInfo,
+			Data:    questionInfo(question),
 		})
 	}
 }
@@ -1052,16 +3289,12 @@ func GetNextQuestionHandler(attemptService core.AttemptService) gin.HandlerFunc
 // AnswerQuestionHandler - ответ на вопрос
 func AnswerQuestionHandler(attemptService core.AttemptService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		idStr := c.Param("id")
-		attemptID, err := strconv.ParseUint(idStr, 10, 32)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, APIResponse{
-				Success: false,
-				Error: &APIError{
-					Code:    ErrCodeValidation,
-					Message: "Invalid attempt ID",
-				},
-			})
+		userID, ok := contextUserID(c)
+		if !ok {
+			return
+		}
+		attemptID, ok := pathID(c, "id", "Invalid attempt ID")
+		if !ok {
 			return
 		}
 
@@ -1078,53 +3311,432 @@ func AnswerQuestionHandler(attemptService core.AttemptService) gin.HandlerFunc {
 			return
 		}
 
-		isCorrect, explanation, err := attemptService.AnswerQuestion(c.Request.Context(), uint(attemptID), req.QuestionID, req.ChoiceIDs)
+		answer, err := attemptService.AnswerQuestion(c.Request.Context(), userID, attemptID, req.QuestionID, domain.Answer{
+			ChoiceIDs: req.ChoiceIDs,
+			Number:    req.Numeric,
+			Order:     req.Order,
+			Matches:   req.Matches,
+			Blanks:    req.Blanks,
+		})
 		if err != nil {
-			c.JSON(http.StatusBadRequest, APIResponse{
-				Success: false,
-				Error: &APIError{
-					Code:    ErrCodeAttemptNotFound,
-					Message: err.Error(),
-				},
-			})
+			respondAttemptError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data:    answerResponse(answer),
+		})
+	}
+}
+
+func answerResponse(answer *core.AnswerResult) AnswerResponse {
+	result := AnswerResponse{
+		Correct:     answer.Correct,
+		Score:       answer.Score,
+		GradingMode: string(answer.Mode),
+		Explanation: answer.Explanation,
+		Choices:     make([]ChoiceResult, 0, len(answer.Choices)),
+	}
+	for _, choice := range answer.Choices {
+		result.Choices = append(result.Choices, ChoiceResult{
+			ChoiceID: choice.ChoiceID,
+			Selected: choice.Selected,
+			Correct:  choice.Correct,
+		})
+	}
+	for _, part := range answer.Parts {
+		result.Parts = append(result.Parts, PartResult{ID: part.ID, Correct: part.Correct})
+	}
+	return result
+}
+
+// questionInfo - вопрос для игрока: правильные ответы не раскрываются, а элементы
+// ordering и правые части matching перемешиваются детерминированно по ID вопроса
+func questionInfo(question *domain.Question) QuestionInfo {
+	info := QuestionInfo{
+		ID:          question.ID,
+		Kind:        string(question.QuestionKind()),
+		Prompt:      question.Prompt,
+		MultiSelect: question.MultiSelect,
+	}
+	for _, choice := range question.Choices {
+		info.Choices = append(info.Choices, ChoiceInfo{
+			ID:   choice.ID,
+			Text: choice.Text,
+		})
+	}
+
+	shuffle := rand.New(rand.NewSource(int64(question.ID))).Shuffle
+	switch question.QuestionKind() {
+	case domain.KindNumeric:
+		if spec, err := domain.DecodeSpec[domain.NumericSpec](question); err == nil {
+			info.Unit = spec.Unit
+		}
+	case domain.KindOrdering:
+		if spec, err := domain.DecodeSpec[domain.OrderingSpec](question); err == nil {
+			for _, item := range spec.Items {
+				info.Items = append(info.Items, ItemInfo{ID: item.ID, Text: item.Text})
+			}
+			shuffle(len(info.Items), func(i, j int) { info.Items[i], info.Items[j] = info.Items[j], info.Items[i] })
+		}
+	case domain.KindMatching:
+		if spec, err := domain.DecodeSpec[domain.MatchingSpec](question); err == nil {
+			for _, pair := range spec.Pairs {
+				info.Left = append(info.Left, ItemInfo{ID: pair.ID, Text: pair.Left})
+				info.Right = append(info.Right, pair.Right)
+			}
+			shuffle(len(info.Right), func(i, j int) { info.Right[i], info.Right[j] = info.Right[j], info.Right[i] })
+		}
+	case domain.KindCloze:
+		if spec, err := domain.DecodeSpec[domain.ClozeSpec](question); err == nil {
+			info.Text = spec.Text
+			for _, blank := range spec.Blanks {
+				info.Blanks = append(info.Blanks, blank.ID)
+			}
+		}
+	}
+	return info
+}
+
+// CurrentStepHandler - текущий шаг попытки в виде конверта по типу шага
+func CurrentStepHandler(attemptService core.AttemptService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := contextUserID(c)
+		if !ok {
+			return
+		}
+		attemptID, ok := pathID(c, "id", "Invalid attempt ID")
+		if !ok {
+			return
+		}
+
+		envelope, err := attemptService.CurrentStep(c.Request.Context(), userID, attemptID)
+		if err != nil {
+			respondStepError(c, err)
 			return
 		}
 
 		c.JSON(http.StatusOK, APIResponse{
 			Success: true,
-			Data: AnswerResponse{
-				Correct:     isCorrect,
-				Explanation: explanation,
+			Data: CurrentStepResponse{
+				Done: envelope == nil,
+				Step: stepEnvelope(envelope),
 			},
 		})
 	}
 }
 
-// CompleteAttemptHandler - завершение попытки
-func CompleteAttemptHandler(attemptService core.AttemptService) gin.HandlerFunc {
+// AdvanceStepHandler - прохождение текущего шага: прочтение текста, ответ или ход симуляции
+func AdvanceStepHandler(attemptService core.AttemptService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		idStr := c.Param("id")
-		attemptID, err := strconv.ParseUint(idStr, 10, 32)
+		userID, ok := contextUserID(c)
+		if !ok {
+			return
+		}
+		attemptID, ok := pathID(c, "id", "Invalid attempt ID")
+		if !ok {
+			return
+		}
+		stepID, ok := pathID(c, "stepId", "Invalid step ID")
+		if !ok {
+			return
+		}
+
+		// Текстовый шаг продвигается без тела запроса
+		var req StepAdvanceRequest
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, APIResponse{
+					Success: false,
+					Error: &APIError{
+						Code:    ErrCodeValidation,
+						Message: "Invalid request data",
+						Details: err.Error(),
+					},
+				})
+				return
+			}
+		}
+
+		result, err := attemptService.AdvanceStep(c.Request.Context(), userID, attemptID, stepID, core.StepInput{
+			Answer: domain.Answer{
+				ChoiceIDs: req.ChoiceIDs,
+				Number:    req.Numeric,
+				Order:     req.Order,
+				Matches:   req.Matches,
+				Blanks:    req.Blanks,
+			},
+			Decision: req.Decision,
+		})
+		if err != nil {
+			respondStepError(c, err)
+			return
+		}
+
+		response := StepAdvanceResponse{
+			Done: result.Next == nil,
+			Next: stepEnvelope(result.Next),
+		}
+		if result.Answer != nil {
+			answer := answerResponse(result.Answer)
+			response.Answer = &answer
+		}
+		if result.Simulation != nil {
+			simulation := simulationResponse(result.Simulation)
+			response.Simulation = &simulation
+		}
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data:    response,
+		})
+	}
+}
+
+func respondStepError(c *gin.Context, err error) {
+	if errors.Is(err, core.ErrStepNotCurrent) {
+		c.JSON(http.StatusConflict, APIResponse{
+			Success: false,
+			Error: &APIError{
+				Code:    ErrCodeStepNotCurrent,
+				Message: "Step is not the current step of the attempt",
+			},
+		})
+		return
+	}
+	respondSimulationError(c, err)
+}
+
+func stepEnvelope(envelope *core.StepEnvelope) *StepEnvelope {
+	if envelope == nil {
+		return nil
+	}
+	result := &StepEnvelope{
+		StepID: envelope.Step.ID,
+		Type:   envelope.Step.Type,
+		Title:  envelope.Step.Title,
+		Index:  envelope.Index,
+		Total:  envelope.Total,
+	}
+	switch {
+	case envelope.Question != nil:
+		question := questionInfo(envelope.Question)
+		result.Question = &question
+	case envelope.Simulation != nil:
+		simulation := simulationResponse(envelope.Simulation)
+		result.Simulation = &simulation
+	default:
+		if len(envelope.Step.Payload) > 0 {
+			result.Content = json.RawMessage(envelope.Step.Payload)
+		}
+	}
+	return result
+}
+
+// ListSimulationsHandler - текущее состояние всех симуляций попытки
+func ListSimulationsHandler(attemptService core.AttemptService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := contextUserID(c)
+		if !ok {
+			return
+		}
+		attemptID, ok := pathID(c, "id", "Invalid attempt ID")
+		if !ok {
+			return
+		}
+
+		results, err := attemptService.ListSimulations(c.Request.Context(), userID, attemptID)
+		if err != nil {
+			respondSimulationError(c, err)
+			return
+		}
+
+		simulations := make([]SimulationResponse, 0, len(results))
+		for _, result := range results {
+			simulations = append(simulations, simulationResponse(result))
+		}
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data:    simulations,
+		})
+	}
+}
+
+// GetSimulationHandler - текущее состояние шага-симуляции
+func GetSimulationHandler(attemptService core.AttemptService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := contextUserID(c)
+		if !ok {
+			return
+		}
+		attemptID, ok := pathID(c, "id", "Invalid attempt ID")
+		if !ok {
+			return
+		}
+		stepID, ok := pathID(c, "stepId", "Invalid step ID")
+		if !ok {
+			return
+		}
+
+		result, err := attemptService.GetSimulation(c.Request.Context(), userID, attemptID, stepID)
 		if err != nil {
+			respondSimulationError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data:    simulationResponse(result),
+		})
+	}
+}
+
+// AdvanceSimulationHandler - ход симуляции: решение игрока и новое состояние
+func AdvanceSimulationHandler(attemptService core.AttemptService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := contextUserID(c)
+		if !ok {
+			return
+		}
+		attemptID, ok := pathID(c, "id", "Invalid attempt ID")
+		if !ok {
+			return
+		}
+		stepID, ok := pathID(c, "stepId", "Invalid step ID")
+		if !ok {
+			return
+		}
+
+		var req SimulationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, APIResponse{
 				Success: false,
 				Error: &APIError{
 					Code:    ErrCodeValidation,
-					Message: "Invalid attempt ID",
+					Message: "Invalid request data",
+					Details: err.Error(),
 				},
 			})
 			return
 		}
 
-		result, err := attemptService.CompleteAttempt(c.Request.Context(), uint(attemptID))
+		result, err := attemptService.AdvanceSimulation(c.Request.Context(), userID, attemptID, stepID, req.Decision)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, APIResponse{
-				Success: false,
-				Error: &APIError{
-					Code:    ErrCodeAttemptNotFound,
-					Message: err.Error(),
-				},
+			respondStepError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, APIResponse{
+			Success: true,
+			Data:    simulationResponse(result),
+		})
+	}
+}
+
+func respondSimulationError(c *gin.Context, err error) {
+	var decisionErr *simulation.DecisionError
+	status, code, message := http.StatusBadRequest, ErrCodeValidation, err.Error()
+
+	switch {
+	case errors.As(err, &decisionErr):
+	case errors.Is(err, simulation.ErrFinished):
+		status, code = http.StatusConflict, ErrCodeSimulationFinished
+	case errors.Is(err, core.ErrStepNotFound):
+		status, code, message = http.StatusNotFound, ErrCodeStepNotFound, "Simulation step not found"
+	default:
+		respondAttemptError(c, err)
+		return
+	}
+
+	c.JSON(status, APIResponse{
+		Success: false,
+		Error: &APIError{
+			Code:    code,
+			Message: message,
+		},
+	})
+}
+
+func simulationResponse(result *core.SimulationResult) SimulationResponse {
+	response := SimulationResponse{
+		StepID:    result.StepID,
+		Title:     result.Title,
+		Simulator: result.Simulator,
+		Turn:      result.State.Turn,
+		Turns:     result.State.Turns,
+		Done:      result.State.Done,
+		Metrics:   result.State.Metrics,
+		Events:    result.State.Events,
+	}
+	if result.Outcome != nil {
+		outcome := &SimulationOutcome{
+			Score:      result.Outcome.Score,
+			Passed:     result.Outcome.Passed,
+			Objectives: make([]ObjectiveResult, 0, len(result.Outcome.Objectives)),
+		}
+		for _, objective := range result.Outcome.Objectives {
+			outcome.Objectives = append(outcome.Objectives, ObjectiveResult{
+				Metric: objective.Metric,
+				Op:     objective.Op,
+				Target: objective.Value,
+				Actual: objective.Actual,
+				Met:    objective.Met,
+				Label:  objective.Label,
 			})
+		}
+		response.Outcome = outcome
+	}
+	return response
+}
+
+// respondAttemptError - ошибка доступа к попытке или неверный ответ; остальные ошибки
+// внутренние: клиент получает 500 без текста ошибки
+func respondAttemptError(c *gin.Context, err error) {
+	var validationErr *core.ValidationError
+	status, code, message := http.StatusBadRequest, ErrCodeValidation, ""
+	var details interface{}
+
+	switch {
+	case errors.As(err, &validationErr):
+		message = validationErr.Error()
+		details = gin.H{"field": validationErr.Field}
+	case errors.Is(err, core.ErrAttemptNotFound):
+		status, code, message = http.StatusNotFound, ErrCodeAttemptNotFound, "Attempt not found"
+	case errors.Is(err, core.ErrAttemptForbidden):
+		status, code, message = http.StatusForbidden, ErrCodeForbidden, "Attempt belongs to another user"
+	case errors.Is(err, core.ErrAttemptCompleted):
+		status, code, message = http.StatusConflict, ErrCodeAttemptCompleted, err.Error()
+	default:
+		// 500 не сохраняется по Idempotency-Key, и клиент может повторить запрос
+		log.Printf("attempt request %s %s failed: %v", c.Request.Method, c.Request.URL.Path, err)
+		status, code, message = http.StatusInternalServerError, ErrCodeInternal, "Internal server error"
+	}
+
+	c.JSON(status, APIResponse{
+		Success: false,
+		Error: &APIError{
+			Code:    code,
+			Message: message,
+			Details: details,
+		},
+	})
+}
+
+// CompleteAttemptHandler - завершение попытки
+func CompleteAttemptHandler(attemptService core.AttemptService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := contextUserID(c)
+		if !ok {
+			return
+		}
+		attemptID, ok := pathID(c, "id", "Invalid attempt ID")
+		if !ok {
+			return
+		}
+
+		result, err := attemptService.CompleteAttempt(c.Request.Context(), userID, attemptID)
+		if err != nil {
+			respondAttemptError(c, err)
 			return
 		}
 
@@ -1146,9 +3758,18 @@ func CompleteAttemptHandler(attemptService core.AttemptService) gin.HandlerFunc
 			wrongQuestions = append(wrongQuestions, &WrongQuestion{
 				QuestionID:       wq.QuestionID,
 				Prompt:           wq.Prompt,
+				Kind:             string(wq.Kind),
 				YourChoiceIDs:    wq.YourChoiceIDs,
 				CorrectChoiceIDs: wq.CorrectChoiceIDs,
-				Explanation:      wq.Explanation,
+				YourAnswer: GivenAnswer{
+					ChoiceIDs: wq.YourAnswer.ChoiceIDs,
+					Numeric:   wq.YourAnswer.Number,
+					Order:     wq.YourAnswer.Order,
+					Matches:   wq.YourAnswer.Matches,
+					Blanks:    wq.YourAnswer.Blanks,
+				},
+				Solution:    json.RawMessage(wq.Solution),
+				Explanation: wq.Explanation,
 			})
 		}
 
@@ -1167,6 +3788,9 @@ func CompleteAttemptHandler(attemptService core.AttemptService) gin.HandlerFunc
 			TotalQuestions: result.TotalQuestions,
 			CorrectAnswers: result.CorrectAnswers,
 			WrongQuestions: wrongQuestions,
+			Passed:         result.Passed,
+			TimeBonus:      result.TimeBonus,
+			Policy:         scoringPolicyInfo(result.Policy),
 			Reward:         rewardInfo,
 		}
 
@@ -1207,19 +3831,7 @@ func CancelAttemptHandler(attemptService core.AttemptService) gin.HandlerFunc {
 
 		err = attemptService.CancelAttempt(c.Request.Context(), uint(attemptID), userID)
 		if err != nil {
-			status := http.StatusBadRequest
-			code := ErrCodeInternal
-			if err.Error() == "forbidden" {
-				status = http.StatusForbidden
-				code = ErrCodeForbidden
-			}
-			c.JSON(status, APIResponse{
-				Success: false,
-				Error: &APIError{
-					Code:    code,
-					Message: err.Error(),
-				},
-			})
+			respondAttemptError(c, err)
 			return
 		}
 
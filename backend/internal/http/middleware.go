@@ -1,13 +1,23 @@
 package http
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/ImCtyz/duofinance/backend/internal/auth"
 	"github.com/ImCtyz/duofinance/backend/internal/core"
+	"github.com/ImCtyz/duofinance/backend/internal/domain"
+	"github.com/ImCtyz/duofinance/backend/internal/ratelimit"
 	"github.com/gin-gonic/gin"
 )
 
@@ -33,7 +43,7 @@ func CORSMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Credentials", "true")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, Idempotency-Key")
 		c.Header("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
 
 		if c.Request.Method == "OPTIONS" {
@@ -93,28 +103,89 @@ func AuthMiddleware(authService core.AuthService) gin.HandlerFunc {
 		}
 
 		token := parts[1]
-		userID, err := authService.ValidateToken(c.Request.Context(), token)
+		claims, err := authService.ValidateToken(c.Request.Context(), token)
 		if err != nil {
-			var errorCode string
+			errorCode := ErrCodeInvalidToken
+			message := "Invalid or expired token"
 			if err == auth.ErrExpiredToken {
 				errorCode = ErrCodeExpiredToken
-			} else {
-				errorCode = ErrCodeInvalidToken
+			} else if err == core.ErrSessionRevoked {
+				errorCode = ErrCodeSessionRevoked
+				message = "Session has been revoked"
 			}
 
 			c.JSON(http.StatusUnauthorized, APIResponse{
 				Success: false,
 				Error: &APIError{
 					Code:    errorCode,
-					Message: "Invalid or expired token",
+					Message: message,
+				},
+			})
+			c.Abort()
+			return
+		}
+
+		// Сохраняем userID, роль и идентификатор сессии в контекст
+		c.Set("userID", claims.UserID)
+		c.Set("role", domain.UserRole(claims.Role))
+		c.Set("sessionID", claims.SessionID)
+		c.Next()
+	}
+}
+
+// RequireRole - доступ только для пользователей с одной из ролей roles.
+// Используется после AuthMiddleware; роль берется из access токена.
+func RequireRole(roles ...domain.UserRole) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := GetRoleFromContext(c)
+		for _, allowed := range roles {
+			if role == allowed {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, APIResponse{
+			Success: false,
+			Error: &APIError{
+				Code:    ErrCodeForbidden,
+				Message: "Insufficient permissions",
+			},
+		})
+		c.Abort()
+	}
+}
+
+// RequireVerifiedEmail - доступ только для пользователей с подтвержденным email.
+// Используется после AuthMiddleware.
+func RequireVerifiedEmail(authService core.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := GetUserIDFromContext(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, APIResponse{
+				Success: false,
+				Error: &APIError{
+					Code:    ErrCodeUnauthorized,
+					Message: "Authentication required",
+				},
+			})
+			c.Abort()
+			return
+		}
+
+		user, err := authService.GetCurrentUser(c.Request.Context(), userID)
+		if err != nil || user.EmailVerifiedAt == nil {
+			c.JSON(http.StatusForbidden, APIResponse{
+				Success: false,
+				Error: &APIError{
+					Code:    ErrCodeEmailNotVerified,
+					Message: "Email address is not verified",
 				},
 			})
 			c.Abort()
 			return
 		}
 
-		// Сохраняем userID в контекст
-		c.Set("userID", userID)
 		c.Next()
 	}
 }
@@ -134,33 +205,34 @@ func GetUserIDFromContext(c *gin.Context) (uint, error) {
 	return id, nil
 }
 
-// RateLimitMiddleware - ограничение частоты запросов (базовая реализация)
-func RateLimitMiddleware() gin.HandlerFunc {
-	// Простая реализация in-memory rate limiting
-	// В продакшене лучше использовать Redis
-	requests := make(map[string][]time.Time)
+// GetRoleFromContext - роль текущего пользователя (пустая для токенов без роли)
+func GetRoleFromContext(c *gin.Context) domain.UserRole {
+	role, _ := c.Get("role")
+	r, _ := role.(domain.UserRole)
+	return r
+}
+
+// GetSessionIDFromContext - получение идентификатора сессии из контекста
+func GetSessionIDFromContext(c *gin.Context) string {
+	sessionID, _ := c.Get("sessionID")
+	id, _ := sessionID.(string)
+	return id
+}
 
+// RateLimitMiddleware - ограничение частоты запросов с одного IP адреса.
+// Лимитер общий для всех запросов группы маршрутов, на которую навешан middleware.
+func RateLimitMiddleware(limiter *ratelimit.Limiter) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		clientIP := c.ClientIP()
-		now := time.Now()
-
-		// Очищаем старые запросы (старше 1 минуты)
-		if clientRequests, exists := requests[clientIP]; exists {
-			var validRequests []time.Time
-			for _, reqTime := range clientRequests {
-				if now.Sub(reqTime) < time.Minute {
-					validRequests = append(validRequests, reqTime)
-				}
-			}
-			requests[clientIP] = validRequests
-		}
+		allowed, remaining, retryAfter := limiter.Allow(c.ClientIP())
+		c.Header("X-RateLimit-Limit", strconv.Itoa(limiter.Limit()))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(remaining))
 
-		// Проверяем лимит (60 запросов в минуту)
-		if len(requests[clientIP]) >= 60 {
+		if !allowed {
+			setRetryAfter(c, retryAfter)
 			c.JSON(http.StatusTooManyRequests, APIResponse{
 				Success: false,
 				Error: &APIError{
-					Code:    "RATE_LIMIT_EXCEEDED",
+					Code:    ErrCodeRateLimited,
 					Message: "Too many requests",
 				},
 			})
@@ -168,8 +240,121 @@ func RateLimitMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// Добавляем текущий запрос
-		requests[clientIP] = append(requests[clientIP], now)
 		c.Next()
 	}
 }
+
+// setRetryAfter - заголовок Retry-After в секундах (с округлением вверх)
+func setRetryAfter(c *gin.Context, d time.Duration) {
+	seconds := int((d + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+}
+
+// IdempotencyMiddleware - повтор запроса с тем же заголовком Idempotency-Key получает
+// сохраненный ответ вместо повторного выполнения. Ответы 5xx не сохраняются, и такой
+// запрос можно повторить с тем же ключом. Ставится после AuthMiddleware.
+func IdempotencyMiddleware(idempotencyService core.IdempotencyService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("Idempotency-Key")
+		if key == "" {
+			c.Next()
+			return
+		}
+		userID, err := GetUserIDFromContext(c)
+		if err != nil {
+			abortIdempotency(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to get user ID", nil)
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			abortIdempotency(c, http.StatusBadRequest, ErrCodeValidation, "Failed to read request body", nil)
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		hash := sha256.New()
+		fmt.Fprintf(hash, "%s %s\n", c.Request.Method, c.Request.URL.Path)
+		hash.Write(body)
+		fingerprint := hex.EncodeToString(hash.Sum(nil))
+
+		record, err := idempotencyService.Begin(c.Request.Context(), userID, key, fingerprint)
+		if err != nil {
+			var validationErr *core.ValidationError
+			switch {
+			case errors.As(err, &validationErr):
+				abortIdempotency(c, http.StatusBadRequest, ErrCodeValidation, validationErr.Error(), gin.H{"field": validationErr.Field})
+			case errors.Is(err, core.ErrIdempotencyInUse):
+				abortIdempotency(c, http.StatusConflict, ErrCodeIdempotencyInUse, "Request with this Idempotency-Key is still in progress", nil)
+			case errors.Is(err, core.ErrIdempotencyReused):
+				abortIdempotency(c, http.StatusUnprocessableEntity, ErrCodeIdempotencyReused, "Idempotency-Key was used with a different request", nil)
+			default:
+				abortIdempotency(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to check Idempotency-Key", nil)
+			}
+			return
+		}
+		if record.CompletedAt != nil {
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(record.StatusCode, "application/json; charset=utf-8", record.Response)
+			c.Abort()
+			return
+		}
+
+		writer := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+
+		// Ключ освобождается и при панике обработчика (она считается ответом 5xx),
+		// иначе повторы получали бы 409 до истечения срока ключа. Паника передается
+		// дальше в RecoveryMiddleware.
+		defer func() {
+			recovered := recover()
+
+			// Ответ сохраняется, даже если клиент уже отключился
+			ctx := context.WithoutCancel(c.Request.Context())
+			var err error
+			if status := writer.Status(); recovered != nil || status >= http.StatusInternalServerError {
+				err = idempotencyService.Release(ctx, record.ID)
+			} else {
+				err = idempotencyService.Finish(ctx, record.ID, status, writer.body.Bytes())
+			}
+			if err != nil {
+				log.Printf("Failed to store idempotent response for key %q: %v", key, err)
+			}
+
+			if recovered != nil {
+				panic(recovered)
+			}
+		}()
+		c.Next()
+	}
+}
+
+func abortIdempotency(c *gin.Context, status int, code, message string, details interface{}) {
+	c.JSON(status, APIResponse{
+		Success: false,
+		Error: &APIError{
+			Code:    code,
+			Message: message,
+			Details: details,
+		},
+	})
+	c.Abort()
+}
+
+// recordingWriter - копия тела ответа для сохранения по ключу идемпотентности
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
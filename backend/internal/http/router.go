@@ -1,13 +1,23 @@
 package http
 
 import (
+	"time"
+
 	"github.com/ImCtyz/duofinance/backend/internal/core"
+	"github.com/ImCtyz/duofinance/backend/internal/domain"
+	"github.com/ImCtyz/duofinance/backend/internal/ratelimit"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
+// RateLimits - лимиты запросов в минуту с одного IP по группам маршрутов (0 — без ограничения)
+type RateLimits struct {
+	Auth int // публичные эндпоинты аутентификации
+	API  int // защищенные эндпоинты
+}
+
 // SetupRoutes - настройка всех маршрутов API
-func SetupRoutes(r *gin.Engine, services *Services, db *gorm.DB) {
+func SetupRoutes(r *gin.Engine, services *Services, db *gorm.DB, limits RateLimits) {
 	// Middleware для всех маршрутов
 	r.Use(LoggerMiddleware())
 	r.Use(CORSMiddleware())
@@ -17,19 +27,29 @@ func SetupRoutes(r *gin.Engine, services *Services, db *gorm.DB) {
 	r.GET("/health", HealthHandler)
 	r.GET("/ready", ReadyHandler(db))
 
+	// Публичные ключи для проверки access токенов другими сервисами
+	r.GET("/.well-known/jwks.json", JWKSHandler(services.Auth))
+
 	// API v1
 	v1 := r.Group("/v1")
 	{
 		// Аутентификация (публичные эндпоинты)
-		auth := v1.Group("/auth")
+		auth := v1.Group("/auth", rateLimit(limits.Auth)...)
 		{
 			auth.POST("/register", RegisterHandler(services.Auth))
 			auth.POST("/login", LoginHandler(services.Auth))
 			auth.POST("/refresh", RefreshTokenHandler(services.Auth))
+			auth.POST("/mfa/verify", MFAVerifyHandler(services.Auth))
+			auth.GET("/oidc/providers", GetOIDCProvidersHandler(services.Auth))
+			auth.POST("/oidc/:provider/authorize", OIDCAuthorizeHandler(services.Auth))
+			auth.POST("/oidc/:provider/callback", OIDCCallbackHandler(services.Auth))
+			auth.POST("/password/forgot", ForgotPasswordHandler(services.Auth))
+			auth.POST("/password/reset", ResetPasswordHandler(services.Auth))
+			auth.POST("/verify-email", VerifyEmailHandler(services.Auth))
 		}
 
 		// Защищенные эндпоинты (требуют аутентификации)
-		protected := v1.Group("", AuthMiddleware(services.Auth))
+		protected := v1.Group("", append(rateLimit(limits.API), AuthMiddleware(services.Auth))...)
 		{
 			// Аутентификация (защищенные эндпоинты)
 			protected.POST("/logout", LogoutHandler(services.Auth))
@@ -38,6 +58,25 @@ func SetupRoutes(r *gin.Engine, services *Services, db *gorm.DB) {
 			protected.GET("/me", MeHandler(services.Auth, services.User))
 			protected.PUT("/me/profile", UpdateProfileHandler(services.User))
 			protected.GET("/me/stats", GetUserStatsHandler(services.User))
+			protected.POST("/me/verify-email/resend", ResendVerificationHandler(services.Auth))
+
+			// Двухфакторная аутентификация и привязка внешних аккаунтов — только
+			// с подтвержденным email: он остается способом восстановить доступ
+			verified := RequireVerifiedEmail(services.Auth)
+			protected.POST("/me/mfa/totp/enroll", verified, EnrollTOTPHandler(services.Auth))
+			protected.POST("/me/mfa/totp/confirm", verified, ConfirmTOTPHandler(services.Auth))
+			protected.POST("/me/mfa/totp/disable", DisableTOTPHandler(services.Auth))
+
+			// Внешние аккаунты (OIDC)
+			protected.GET("/me/identities", GetIdentitiesHandler(services.Auth))
+			protected.POST("/me/identities/:provider/authorize", verified, LinkIdentityHandler(services.Auth))
+			protected.POST("/me/identities/:provider/callback", verified, LinkIdentityCallbackHandler(services.Auth))
+			protected.DELETE("/me/identities/:provider", UnlinkIdentityHandler(services.Auth))
+
+			// Сессии пользователя
+			protected.GET("/me/sessions", GetSessionsHandler(services.Auth))
+			protected.DELETE("/me/sessions/:id", RevokeSessionHandler(services.Auth))
+			protected.POST("/me/sessions/revoke-others", RevokeOtherSessionsHandler(services.Auth))
 
 			// Уровни/уроки
 			levels := protected.Group("/levels")
@@ -48,6 +87,13 @@ func SetupRoutes(r *gin.Engine, services *Services, db *gorm.DB) {
 				levels.GET("/topic/:topic", GetLevelsByTopicHandler(services.Level))
 			}
 
+			// Курсы и карта открытых уровней
+			courses := protected.Group("/courses")
+			{
+				courses.GET("", GetCoursesHandler(services.Course))
+				courses.GET("/:id/map", GetCourseMapHandler(services.Course))
+			}
+
 			// Попытки прохождения
 			attempts := protected.Group("/attempts")
 			{
@@ -55,8 +101,13 @@ func SetupRoutes(r *gin.Engine, services *Services, db *gorm.DB) {
 				attempts.GET("", GetUserAttemptsHandler(services.Attempt))
 				attempts.GET("/:id", GetAttemptHandler(services.Attempt))
 				attempts.GET("/:id/next", GetNextQuestionHandler(services.Attempt))
-				attempts.POST("/:id/answer", AnswerQuestionHandler(services.Attempt))
-				attempts.POST("/:id/complete", CompleteAttemptHandler(services.Attempt))
+				attempts.POST("/:id/answer", IdempotencyMiddleware(services.Idempotency), AnswerQuestionHandler(services.Attempt))
+				attempts.GET("/:id/current-step", CurrentStepHandler(services.Attempt))
+				attempts.POST("/:id/steps/:stepId/advance", AdvanceStepHandler(services.Attempt))
+				attempts.GET("/:id/simulations", ListSimulationsHandler(services.Attempt))
+				attempts.GET("/:id/simulations/:stepId", GetSimulationHandler(services.Attempt))
+				attempts.POST("/:id/simulations/:stepId", AdvanceSimulationHandler(services.Attempt))
+				attempts.POST("/:id/complete", IdempotencyMiddleware(services.Idempotency), CompleteAttemptHandler(services.Attempt))
 				attempts.POST("/:id/cancel", CancelAttemptHandler(services.Attempt))
 			}
 
@@ -67,6 +118,51 @@ func SetupRoutes(r *gin.Engine, services *Services, db *gorm.DB) {
 				rewards.GET("/transactions", GetTransactionHistoryHandler(services.Reward))
 			}
 
+			// Администрирование
+			admin := protected.Group("/admin", RequireRole(domain.RoleAdmin))
+			{
+				admin.GET("/users", ListUsersHandler(services.User))
+				admin.PUT("/users/:id/role", SetUserRoleHandler(services.User))
+			}
+
+			// Редактор контента
+			editor := protected.Group("/editor", RequireRole(domain.RoleEditor, domain.RoleAdmin))
+			{
+				editor.GET("/levels", EditorListLevelsHandler(services.Content))
+				editor.POST("/levels", EditorCreateLevelHandler(services.Content))
+				editor.GET("/levels/:id", EditorGetLevelHandler(services.Content))
+				editor.PUT("/levels/:id", EditorUpdateLevelHandler(services.Content))
+				editor.DELETE("/levels/:id", EditorDeleteLevelHandler(services.Content))
+				editor.POST("/levels/:id/restore", EditorRestoreLevelHandler(services.Content))
+				editor.POST("/levels/:id/publish", EditorPublishLevelHandler(services.Content))
+				editor.GET("/levels/:id/revisions", EditorListRevisionsHandler(services.Content))
+				editor.GET("/levels/:id/revisions/:number", EditorGetRevisionHandler(services.Content))
+				editor.POST("/levels/:id/revisions/:number/rollback", EditorRollbackLevelHandler(services.Content))
+				editor.GET("/levels/:id/steps", EditorListStepsHandler(services.Content))
+				editor.POST("/levels/:id/steps", EditorCreateStepHandler(services.Content))
+				editor.PUT("/levels/:id/steps/order", EditorReorderStepsHandler(services.Content))
+				editor.PUT("/steps/:id", EditorUpdateStepHandler(services.Content))
+				editor.DELETE("/steps/:id", EditorDeleteStepHandler(services.Content))
+				editor.POST("/questions", EditorCreateQuestionHandler(services.Content))
+				editor.GET("/questions/:id", EditorGetQuestionHandler(services.Content))
+				editor.PUT("/questions/:id", EditorUpdateQuestionHandler(services.Content))
+				editor.DELETE("/questions/:id", EditorDeleteQuestionHandler(services.Content))
+				editor.POST("/questions/:id/choices", EditorCreateChoiceHandler(services.Content))
+				editor.PUT("/choices/:id", EditorUpdateChoiceHandler(services.Content))
+				editor.DELETE("/choices/:id", EditorDeleteChoiceHandler(services.Content))
+				editor.GET("/questions/:id/analytics", EditorQuestionAnalyticsHandler(services.Analytics))
+				editor.GET("/levels/:id/analytics", EditorLevelAnalyticsHandler(services.Analytics))
+				editor.GET("/courses", EditorListCoursesHandler(services.Course))
+				editor.POST("/courses", EditorCreateCourseHandler(services.Course))
+				editor.PUT("/courses/:id", EditorUpdateCourseHandler(services.Course))
+				editor.GET("/courses/:id/units", EditorListUnitsHandler(services.Course))
+				editor.POST("/courses/:id/units", EditorCreateUnitHandler(services.Course))
+				editor.PUT("/units/:id", EditorUpdateUnitHandler(services.Course))
+				editor.PUT("/levels/:id/placement", EditorPlaceLevelHandler(services.Course))
+				editor.GET("/levels/:id/prerequisites", EditorGetPrerequisitesHandler(services.Course))
+				editor.PUT("/levels/:id/prerequisites", EditorSetPrerequisitesHandler(services.Course))
+			}
+
 			// Достижения
 			achievements := protected.Group("/achievements")
 			{
@@ -78,6 +174,14 @@ func SetupRoutes(r *gin.Engine, services *Services, db *gorm.DB) {
 	}
 }
 
+// rateLimit - middleware ограничения для группы маршрутов (пусто, если лимит не задан)
+func rateLimit(perMinute int) []gin.HandlerFunc {
+	if perMinute <= 0 {
+		return nil
+	}
+	return []gin.HandlerFunc{RateLimitMiddleware(ratelimit.NewLimiter(perMinute, time.Minute))}
+}
+
 // Services - структура с всеми сервисами
 type Services struct {
 	Auth        core.AuthService
@@ -86,6 +190,10 @@ type Services struct {
 	Attempt     core.AttemptService
 	Reward      core.RewardService
 	Achievement core.AchievementService
+	Content     core.ContentService
+	Course      core.CourseService
+	Analytics   core.AnalyticsService
+	Idempotency core.IdempotencyService
 }
 
 // NewServices - создание структуры сервисов
@@ -96,6 +204,10 @@ func NewServices(
 	attempt core.AttemptService,
 	reward core.RewardService,
 	achievement core.AchievementService,
+	content core.ContentService,
+	course core.CourseService,
+	analytics core.AnalyticsService,
+	idempotency core.IdempotencyService,
 ) *Services {
 	return &Services{
 		Auth:        auth,
@@ -104,5 +216,9 @@ func NewServices(
 		Attempt:     attempt,
 		Reward:      reward,
 		Achievement: achievement,
+		Content:     content,
+		Course:      course,
+		Analytics:   analytics,
+		Idempotency: idempotency,
 	}
 }
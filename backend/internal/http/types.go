@@ -1,5 +1,7 @@
 package http
 
+import "encoding/json"
+
 // APIResponse - стандартный ответ API
 type APIResponse struct {
 	Success bool        `json:"success"`
@@ -40,6 +42,64 @@ type RefreshTokenRequest struct {
 	RefreshToken string `json:"refresh_token" binding:"required"`
 }
 
+// ForgotPasswordRequest - запрос письма для сброса пароля
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// ResetPasswordRequest - установка нового пароля по токену из письма
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=6"`
+}
+
+// VerifyEmailRequest - подтверждение email по токену из письма
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// MFAVerifyRequest - второй шаг входа
+type MFAVerifyRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	Code     string `json:"code" binding:"required"` // код TOTP или код восстановления
+}
+
+// TOTPCodeRequest - код для подтверждения или отключения TOTP
+type TOTPCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// MFAChallengeResponse - ответ на вход при включенной 2FA
+type MFAChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int    `json:"expires_in"` // секунды
+}
+
+// SetRoleRequest - изменение роли пользователя
+type SetRoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=learner editor admin"`
+}
+
+// OIDCCallbackRequest - код и state из redirect провайдера
+type OIDCCallbackRequest struct {
+	Code  string `json:"code" binding:"required"`
+	State string `json:"state" binding:"required"`
+}
+
+// OIDCAuthorizeResponse - адрес страницы входа провайдера
+type OIDCAuthorizeResponse struct {
+	AuthorizationURL string `json:"authorization_url"`
+}
+
+// IdentityInfo - привязанный внешний аккаунт
+type IdentityInfo struct {
+	Provider    string  `json:"provider"`
+	Email       string  `json:"email,omitempty"`
+	LinkedAt    string  `json:"linked_at"`
+	LastLoginAt *string `json:"last_login_at,omitempty"`
+}
+
 // AuthResponse - ответ с токенами
 type AuthResponse struct {
 	AccessToken  string    `json:"access_token"`
@@ -47,12 +107,26 @@ type AuthResponse struct {
 	User         *UserInfo `json:"user"`
 }
 
+// SessionInfo - информация об активной сессии пользователя
+type SessionInfo struct {
+	ID         string  `json:"id"`
+	Device     string  `json:"device"`
+	UserAgent  string  `json:"user_agent"`
+	IP         string  `json:"ip"`
+	LastUsedAt *string `json:"last_used_at,omitempty"`
+	ExpiresAt  string  `json:"expires_at"`
+	Current    bool    `json:"current"`
+}
+
 // UserInfo - информация о пользователе для ответа
 type UserInfo struct {
-	ID       uint         `json:"id"`
-	Email    string       `json:"email"`
-	Username string       `json:"username"`
-	Profile  *ProfileInfo `json:"profile,omitempty"`
+	ID            uint         `json:"id"`
+	Email         string       `json:"email"`
+	Username      string       `json:"username"`
+	Role          string       `json:"role"`
+	EmailVerified bool         `json:"email_verified"`
+	MFAEnabled    bool         `json:"mfa_enabled"`
+	Profile       *ProfileInfo `json:"profile,omitempty"`
 }
 
 // ProfileInfo - информация о профиле
@@ -68,24 +142,131 @@ type StartAttemptRequest struct {
 }
 
 // AnswerRequest - запрос с ответом на вопрос
+// Заполняется поле, соответствующее виду вопроса (QuestionInfo.Kind)
 type AnswerRequest struct {
-	QuestionID uint   `json:"question_id" binding:"required"`
-	ChoiceIDs  []uint `json:"choice_ids" binding:"required"`
+	QuestionID uint              `json:"question_id" binding:"required"`
+	ChoiceIDs  []uint            `json:"choice_ids"` // choice
+	Numeric    *float64          `json:"numeric"`    // numeric
+	Order      []string          `json:"order"`      // ordering: ID элементов по порядку
+	Matches    map[string]string `json:"matches"`    // matching: ID левой части -> текст правой
+	Blanks     map[string]string `json:"blanks"`     // cloze: ID пропуска -> текст
 }
 
 // AnswerResponse - ответ на вопрос
 type AnswerResponse struct {
-	Correct      bool          `json:"correct"`
-	Explanation  string        `json:"explanation,omitempty"`
-	NextQuestion *QuestionInfo `json:"next_question,omitempty"`
+	Correct      bool           `json:"correct"`
+	Score        float64        `json:"score"`        // доля зачета от 0 до 1
+	GradingMode  string         `json:"grading_mode"` // exact, partial или at_least
+	Explanation  string         `json:"explanation,omitempty"`
+	Choices      []ChoiceResult `json:"choices"`
+	Parts        []PartResult   `json:"parts,omitempty"` // для ordering, matching и cloze
+	NextQuestion *QuestionInfo  `json:"next_question,omitempty"`
+}
+
+// ChoiceResult - разбор ответа по варианту: выбран ли он и верен ли
+type ChoiceResult struct {
+	ChoiceID uint `json:"choice_id"`
+	Selected bool `json:"selected"`
+	Correct  bool `json:"correct"`
 }
 
-// QuestionInfo - информация о вопросе
+// PartResult - разбор ответа по элементу, паре или пропуску
+type PartResult struct {
+	ID      string `json:"id"`
+	Correct bool   `json:"correct"`
+}
+
+// StepEnvelope - шаг попытки; заполнено поле, соответствующее type
+type StepEnvelope struct {
+	StepID     uint                `json:"step_id"`
+	Type       string              `json:"type"` // text, question или simulation
+	Title      string              `json:"title,omitempty"`
+	Index      int                 `json:"index"` // номер шага, начиная с 1
+	Total      int                 `json:"total"`
+	Content    json.RawMessage     `json:"content,omitempty"` // payload текстового шага
+	Question   *QuestionInfo       `json:"question,omitempty"`
+	Simulation *SimulationResponse `json:"simulation,omitempty"`
+}
+
+// CurrentStepResponse - текущий шаг попытки; done — все шаги пройдены, можно завершать
+type CurrentStepResponse struct {
+	Done bool          `json:"done"`
+	Step *StepEnvelope `json:"step"`
+}
+
+// StepAdvanceRequest - прохождение шага: ответ для вопроса (поля как в AnswerRequest),
+// decision для симуляции; для текста тело может быть пустым
+type StepAdvanceRequest struct {
+	ChoiceIDs []uint            `json:"choice_ids"`
+	Numeric   *float64          `json:"numeric"`
+	Order     []string          `json:"order"`
+	Matches   map[string]string `json:"matches"`
+	Blanks    map[string]string `json:"blanks"`
+	Decision  json.RawMessage   `json:"decision"`
+}
+
+// StepAdvanceResponse - результат прохождения шага и следующий шаг
+type StepAdvanceResponse struct {
+	Answer     *AnswerResponse     `json:"answer,omitempty"`
+	Simulation *SimulationResponse `json:"simulation,omitempty"`
+	Done       bool                `json:"done"`
+	Next       *StepEnvelope       `json:"next"`
+}
+
+// SimulationRequest - ход симуляции; формат решения зависит от симулятора
+type SimulationRequest struct {
+	Decision json.RawMessage `json:"decision"`
+}
+
+// SimulationResponse - состояние шага-симуляции
+type SimulationResponse struct {
+	StepID    uint               `json:"step_id"`
+	Title     string             `json:"title,omitempty"`
+	Simulator string             `json:"simulator"`
+	Turn      int                `json:"turn"`
+	Turns     int                `json:"turns"`
+	Done      bool               `json:"done"`
+	Metrics   map[string]float64 `json:"metrics"`
+	Events    []string           `json:"events,omitempty"`
+	Outcome   *SimulationOutcome `json:"outcome,omitempty"` // после последнего хода
+}
+
+// SimulationOutcome - оценка симуляции по целям
+type SimulationOutcome struct {
+	Score      float64           `json:"score"` // доля выполненных целей с учетом весов
+	Passed     bool              `json:"passed"`
+	Objectives []ObjectiveResult `json:"objectives"`
+}
+
+// ObjectiveResult - выполнение цели симуляции
+type ObjectiveResult struct {
+	Metric string  `json:"metric"`
+	Op     string  `json:"op"`
+	Target float64 `json:"target"`
+	Actual float64 `json:"actual"`
+	Met    bool    `json:"met"`
+	Label  string  `json:"label,omitempty"`
+}
+
+// QuestionInfo - информация о вопросе без правильного ответа
 type QuestionInfo struct {
 	ID          uint         `json:"id"`
+	Kind        string       `json:"kind"` // choice, numeric, ordering, matching или cloze
 	Prompt      string       `json:"prompt"`
 	MultiSelect bool         `json:"multi_select"`
 	Choices     []ChoiceInfo `json:"choices"`
+	Unit        string       `json:"unit,omitempty"`   // numeric
+	Items       []ItemInfo   `json:"items,omitempty"`  // ordering: элементы в перемешанном порядке
+	Left        []ItemInfo   `json:"left,omitempty"`   // matching: левые части
+	Right       []string     `json:"right,omitempty"`  // matching: перемешанные правые части
+	Text        string       `json:"text,omitempty"`   // cloze: текст с пропусками {{id}}
+	Blanks      []string     `json:"blanks,omitempty"` // cloze: ID пропусков
+}
+
+// ItemInfo - элемент упорядочивания или левая часть пары
+type ItemInfo struct {
+	ID   string `json:"id"`
+	Text string `json:"text"`
 }
 
 // ChoiceInfo - информация о варианте ответа
@@ -128,16 +309,61 @@ type AttemptResult struct {
 	TotalQuestions int              `json:"total_questions"`
 	CorrectAnswers int              `json:"correct_answers"`
 	WrongQuestions []*WrongQuestion `json:"wrong_questions"`
+	Passed         bool             `json:"passed"`
+	TimeBonus      int              `json:"time_bonus"` // уже включен в score
+	Policy         ScoringPolicy    `json:"policy"`
 	Reward         *RewardInfo      `json:"reward"`
 }
 
+// ScoringPolicy - правила подсчета результата уровня
+type ScoringPolicy struct {
+	PassScore     int        `json:"pass_score"`
+	PenaltyCurve  []float64  `json:"penalty_curve" binding:"required"`
+	TimeBonus     *TimeBonus `json:"time_bonus,omitempty"`
+	PartialCredit bool       `json:"partial_credit"`
+}
+
+// TimeBonus - бонус за скорость: до max_points баллов, убывающих к target_seconds
+type TimeBonus struct {
+	MaxPoints     int `json:"max_points"`
+	TargetSeconds int `json:"target_seconds"`
+}
+
+// QuestionTemplate - шаблон вопроса со случайными значениями переменных
+type QuestionTemplate struct {
+	Variables   []TemplateVariable `json:"variables" binding:"required"`
+	Answer      string             `json:"answer" binding:"required"` // формула правильного ответа
+	Decimals    int                `json:"decimals"`
+	Distractors []string           `json:"distractors"` // формулы неверных вариантов для choice
+}
+
+// TemplateVariable - переменная шаблона: min + k*step, не больше max
+type TemplateVariable struct {
+	Name string  `json:"name" binding:"required"`
+	Min  float64 `json:"min"`
+	Max  float64 `json:"max"`
+	Step float64 `json:"step"`
+}
+
 // WrongQuestion - неправильно отвеченный вопрос
 type WrongQuestion struct {
-	QuestionID       uint   `json:"question_id"`
-	Prompt           string `json:"prompt"`
-	YourChoiceIDs    []uint `json:"your_choice_ids"`
-	CorrectChoiceIDs []uint `json:"correct_choice_ids"`
-	Explanation      string `json:"explanation"`
+	QuestionID       uint            `json:"question_id"`
+	Prompt           string          `json:"prompt"`
+	Kind             string          `json:"kind"`
+	YourChoiceIDs    []uint          `json:"your_choice_ids"`
+	CorrectChoiceIDs []uint          `json:"correct_choice_ids"`
+	YourAnswer       GivenAnswer     `json:"your_answer"`
+	Solution         json.RawMessage `json:"solution,omitempty"` // правильный ответ для видов, кроме choice
+	Explanation      string          `json:"explanation"`
+}
+
+// GivenAnswer - ответ игрока в формате AnswerRequest
+type GivenAnswer struct {
+	ChoiceIDs []uint            `json:"choice_ids,omitempty"`
+	Numeric   *float64          `json:"numeric,omitempty"`
+	Order     []string          `json:"order,omitempty"`
+	Matches   map[string]string `json:"matches,omitempty"`
+	Blanks    map[string]string `json:"blanks,omitempty"`
 }
 
 // RewardInfo - информация о награде
@@ -167,6 +393,229 @@ type AchievementInfo struct {
 	Points      int    `json:"points"`
 }
 
+// LevelRequest - создание/изменение уровня редактором
+type LevelRequest struct {
+	Title        string `json:"title" binding:"required,max=255"`
+	Topic        string `json:"topic" binding:"max=255"`
+	Difficulty   string `json:"difficulty"`
+	RewardPoints int    `json:"reward_points"`
+	IsActive     *bool  `json:"is_active"` // по умолчанию true
+	// Правила подсчета; null — политика по умолчанию (проходной балл 70)
+	ScoringPolicy *ScoringPolicy `json:"scoring_policy"`
+}
+
+// StepRequest - создание/изменение шага уровня
+type StepRequest struct {
+	Order      int             `json:"order"` // 0 — в конец уровня (при изменении — не менять)
+	Type       string          `json:"type" binding:"required"`
+	Title      string          `json:"title" binding:"max=255"`
+	Payload    json.RawMessage `json:"payload"`
+	QuestionID *uint           `json:"question_id"`
+}
+
+// ReorderStepsRequest - новый порядок шагов уровня
+type ReorderStepsRequest struct {
+	StepIDs []uint `json:"step_ids" binding:"required"`
+}
+
+// QuestionRequest - создание/изменение вопроса; варианты учитываются только при создании
+type QuestionRequest struct {
+	Prompt      string            `json:"prompt" binding:"required"`
+	Explanation string            `json:"explanation"`
+	Kind        string            `json:"kind"`     // пусто — choice
+	Spec        json.RawMessage   `json:"spec"`     // правильный ответ для numeric, ordering, matching и cloze
+	Template    *QuestionTemplate `json:"template"` // null — обычный вопрос
+	MultiSelect bool              `json:"multi_select"`
+	GradingMode string            `json:"grading_mode"` // exact, partial или at_least; пусто — по политике уровня
+	MinCorrect  int               `json:"min_correct"`  // для at_least
+	Choices     []ChoiceRequest   `json:"choices"`
+}
+
+// ChoiceRequest - создание/изменение варианта ответа
+type ChoiceRequest struct {
+	Text      string `json:"text" binding:"required"`
+	IsCorrect bool   `json:"is_correct"`
+	Order     int    `json:"order"`
+}
+
+// PublishLevelRequest - публикация черновика уровня
+type PublishLevelRequest struct {
+	Note string `json:"note" binding:"max=500"`
+}
+
+// RevisionInfo - опубликованная ревизия уровня
+type RevisionInfo struct {
+	ID             uint   `json:"id"`
+	LevelID        uint   `json:"level_id"`
+	Number         int    `json:"number"`
+	PublishedBy    *uint  `json:"published_by,omitempty"`
+	RolledBackFrom *int   `json:"rolled_back_from,omitempty"`
+	Note           string `json:"note,omitempty"`
+	CreatedAt      string `json:"created_at"`
+}
+
+// RevisionDetail - ревизия вместе со снимком уровня
+type RevisionDetail struct {
+	RevisionInfo
+	Level EditorLevel `json:"level"`
+}
+
+// EditorLevel - уровень в редакторе
+type EditorLevel struct {
+	LevelInfo
+	Slug                string         `json:"slug"`
+	UnitID              *uint          `json:"unit_id,omitempty"`
+	UnitOrder           int            `json:"unit_order"`
+	ScoringPolicy       *ScoringPolicy `json:"scoring_policy,omitempty"` // пусто — политика по умолчанию
+	PublishedRevisionID *uint          `json:"published_revision_id,omitempty"`
+	CreatedAt           string         `json:"created_at"`
+	UpdatedAt           string         `json:"updated_at"`
+	DeletedAt           *string        `json:"deleted_at,omitempty"`
+	Steps               []EditorStep   `json:"steps,omitempty"`
+}
+
+// EditorStep - шаг уровня в редакторе
+type EditorStep struct {
+	ID         uint            `json:"id"`
+	LevelID    uint            `json:"level_id"`
+	Slug       string          `json:"slug"`
+	Order      int             `json:"order"`
+	Type       string          `json:"type"`
+	Title      string          `json:"title"`
+	Payload    json.RawMessage `json:"payload,omitempty"`
+	QuestionID *uint           `json:"question_id,omitempty"`
+	Question   *EditorQuestion `json:"question,omitempty"` // только в снимке ревизии
+	DeletedAt  *string         `json:"deleted_at,omitempty"`
+}
+
+// EditorQuestion - вопрос с правильными ответами в редакторе
+type EditorQuestion struct {
+	ID          uint              `json:"id"`
+	Slug        string            `json:"slug"`
+	Prompt      string            `json:"prompt"`
+	Explanation string            `json:"explanation"`
+	Kind        string            `json:"kind"`
+	Spec        json.RawMessage   `json:"spec,omitempty"`
+	Template    *QuestionTemplate `json:"template,omitempty"`
+	MultiSelect bool              `json:"multi_select"`
+	GradingMode string            `json:"grading_mode,omitempty"`
+	MinCorrect  int               `json:"min_correct,omitempty"`
+	Choices     []EditorChoice    `json:"choices"`
+}
+
+// EditorChoice - вариант ответа в редакторе
+type EditorChoice struct {
+	ID         uint   `json:"id"`
+	QuestionID uint   `json:"question_id"`
+	Slug       string `json:"slug"`
+	Text       string `json:"text"`
+	IsCorrect  bool   `json:"is_correct"`
+	Order      int    `json:"order"`
+}
+
+// QuestionAnalytics - статистика ответов на вопрос для редактора
+type QuestionAnalytics struct {
+	QuestionID          uint         `json:"question_id"`
+	Prompt              string       `json:"prompt"`
+	Kind                string       `json:"kind"`
+	Attempts            int          `json:"attempts"`
+	Answers             int          `json:"answers"`
+	FirstTryCorrectRate float64      `json:"first_try_correct_rate"`
+	AverageScore        float64      `json:"average_score"`
+	AverageDurationMs   int64        `json:"average_duration_ms"`
+	Choices             []ChoiceStat `json:"choices,omitempty"`
+	Discrimination      *float64     `json:"discrimination"`
+	Flags               []string     `json:"flags"`
+}
+
+// ChoiceStat - выбор варианта в первых ответах
+type ChoiceStat struct {
+	ChoiceID  uint    `json:"choice_id"`
+	Text      string  `json:"text"`
+	IsCorrect bool    `json:"is_correct"`
+	Picks     int     `json:"picks"`
+	Rate      float64 `json:"rate"`
+}
+
+// CourseRequest - создание/изменение курса
+type CourseRequest struct {
+	Slug        string `json:"slug" binding:"required,max=255"`
+	Title       string `json:"title" binding:"required,max=255"`
+	Description string `json:"description"`
+	Order       int    `json:"order"`
+	IsActive    *bool  `json:"is_active"` // по умолчанию true
+}
+
+// UnitRequest - создание/изменение юнита курса
+type UnitRequest struct {
+	Title string `json:"title" binding:"required,max=255"`
+	Order int    `json:"order"`
+}
+
+// PlacementRequest - место уровня в курсе; unit_id null убирает уровень из курса
+type PlacementRequest struct {
+	UnitID *uint `json:"unit_id"`
+	Order  int   `json:"order"`
+}
+
+// PrerequisitesRequest - полный список зависимостей уровня
+type PrerequisitesRequest struct {
+	Prerequisites []PrerequisiteRequest `json:"prerequisites" binding:"dive"`
+}
+
+// PrerequisiteRequest - уровень, который нужно пройти раньше
+type PrerequisiteRequest struct {
+	RequiredLevelID uint `json:"required_level_id" binding:"required"`
+	MinScore        *int `json:"min_score"` // по умолчанию 70
+}
+
+// PrerequisiteInfo - зависимость уровня
+type PrerequisiteInfo struct {
+	RequiredLevelID uint `json:"required_level_id"`
+	MinScore        int  `json:"min_score"`
+}
+
+// CourseInfo - информация о курсе
+type CourseInfo struct {
+	ID          uint   `json:"id"`
+	Slug        string `json:"slug"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Order       int    `json:"order"`
+	IsActive    bool   `json:"is_active"`
+}
+
+// UnitInfo - юнит курса в редакторе
+type UnitInfo struct {
+	ID       uint          `json:"id"`
+	CourseID uint          `json:"course_id"`
+	Title    string        `json:"title"`
+	Order    int           `json:"order"`
+	Levels   []EditorLevel `json:"levels"`
+}
+
+// CourseMap - карта курса с состоянием уровней для текущего пользователя
+type CourseMap struct {
+	Course CourseInfo      `json:"course"`
+	Units  []CourseMapUnit `json:"units"`
+}
+
+// CourseMapUnit - юнит на карте курса
+type CourseMapUnit struct {
+	ID     uint             `json:"id"`
+	Title  string           `json:"title"`
+	Order  int              `json:"order"`
+	Levels []CourseMapLevel `json:"levels"`
+}
+
+// CourseMapLevel - уровень на карте курса: locked, available или completed
+type CourseMapLevel struct {
+	LevelInfo
+	State         string             `json:"state"`
+	BestScore     *int               `json:"best_score,omitempty"`
+	Prerequisites []PrerequisiteInfo `json:"prerequisites"`
+}
+
 // Коды ошибок
 const (
 	ErrCodeValidation         = "VALIDATION_ERROR"
@@ -177,11 +626,37 @@ const (
 	ErrCodeInternal           = "INTERNAL_ERROR"
 	ErrCodeInvalidToken       = "INVALID_TOKEN"
 	ErrCodeExpiredToken       = "EXPIRED_TOKEN"
+	ErrCodeSessionRevoked     = "SESSION_REVOKED"
+	ErrCodeSessionNotFound    = "SESSION_NOT_FOUND"
+	ErrCodeEmailNotVerified   = "EMAIL_NOT_VERIFIED"
+	ErrCodeEmailVerified      = "EMAIL_ALREADY_VERIFIED"
 	ErrCodeUserExists         = "USER_EXISTS"
 	ErrCodeInvalidCredentials = "INVALID_CREDENTIALS"
+	ErrCodeAccountLocked      = "ACCOUNT_LOCKED"
+	ErrCodeRateLimited        = "RATE_LIMIT_EXCEEDED"
+	ErrCodeInvalidMFACode     = "INVALID_MFA_CODE"
+	ErrCodeMFAAlreadyEnabled  = "MFA_ALREADY_ENABLED"
+	ErrCodeMFANotEnabled      = "MFA_NOT_ENABLED"
+	ErrCodeUnknownProvider    = "UNKNOWN_PROVIDER"
+	ErrCodeOIDCFailed         = "OIDC_AUTH_FAILED"
+	ErrCodeAccountExists      = "ACCOUNT_EXISTS"
+	ErrCodeIdentityLinked     = "IDENTITY_ALREADY_LINKED"
+	ErrCodeLastLoginMethod    = "LAST_LOGIN_METHOD"
 	ErrCodeLevelNotFound      = "LEVEL_NOT_FOUND"
 	ErrCodeAttemptNotFound    = "ATTEMPT_NOT_FOUND"
 	ErrCodeQuestionNotFound   = "QUESTION_NOT_FOUND"
+	ErrCodeStepNotFound       = "STEP_NOT_FOUND"
+	ErrCodeChoiceNotFound     = "CHOICE_NOT_FOUND"
+	ErrCodeStepOrderTaken     = "STEP_ORDER_TAKEN"
+	ErrCodeQuestionInUse      = "QUESTION_IN_USE"
+	ErrCodeRevisionNotFound   = "REVISION_NOT_FOUND"
+	ErrCodeCourseNotFound     = "COURSE_NOT_FOUND"
+	ErrCodeUnitNotFound       = "UNIT_NOT_FOUND"
+	ErrCodePrerequisiteCycle  = "PREREQUISITE_CYCLE"
 	ErrCodeAttemptCompleted   = "ATTEMPT_COMPLETED"
+	ErrCodeSimulationFinished = "SIMULATION_FINISHED"
+	ErrCodeStepNotCurrent     = "STEP_NOT_CURRENT"
+	ErrCodeIdempotencyInUse   = "IDEMPOTENCY_KEY_IN_USE"
+	ErrCodeIdempotencyReused  = "IDEMPOTENCY_KEY_REUSED"
 	ErrCodeInsufficientFunds  = "INSUFFICIENT_FUNDS"
 )
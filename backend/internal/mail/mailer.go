@@ -0,0 +1,116 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message - письмо пользователю
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer - интерфейс отправки писем
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// SMTPMailer - отправка писем через SMTP сервер
+type SMTPMailer struct {
+	host     string
+	port     int
+	username string
+	password string
+	from     string
+}
+
+// NewSMTPMailer - создание SMTP отправителя. Если username пуст, авторизация не выполняется.
+func NewSMTPMailer(host string, port int, username, password, from string) *SMTPMailer {
+	return &SMTPMailer{host: host, port: port, username: username, password: password, from: from}
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	var auth smtp.Auth
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+
+	addr := net.JoinHostPort(m.host, strconv.Itoa(m.port))
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(addr, auth, m.from, []string{msg.To}, buildMessage(m.from, msg))
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// LogMailer - письма для локальной разработки: пишутся в файл или в лог вместо отправки
+type LogMailer struct {
+	mu     sync.Mutex
+	path   string
+	from   string
+	redact bool
+}
+
+// tokenPattern - одноразовые токены в ссылках писем
+var tokenPattern = regexp.MustCompile(`([?&]token=)[^\s&]+`)
+
+// NewLogMailer - создание отправителя-заглушки. Пустой path — вывод в стандартный лог.
+// При redact токены в ссылках заменяются, чтобы лог не давал доступа к аккаунтам
+func NewLogMailer(path, from string, redact bool) *LogMailer {
+	return &LogMailer{path: path, from: from, redact: redact}
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	if m.redact {
+		msg.Body = tokenPattern.ReplaceAllString(msg.Body, "${1}<redacted>")
+	}
+	if m.path == "" {
+		log.Printf("mail to=%s subject=%q\n%s", msg.To, msg.Subject, msg.Body)
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := os.OpenFile(m.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(f, "%s\r\n\r\n", buildMessage(m.from, msg))
+	return err
+}
+
+// buildMessage - письмо в формате RFC 5322
+func buildMessage(from string, msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
@@ -0,0 +1,338 @@
+// Package migrate - применение SQL миграций из backend/migrations. Версия схемы
+// хранится в таблице schema_migrations в том же формате, что у migrate/migrate,
+// поэтому базы, размеченные внешним инструментом, продолжают работать.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+var (
+	// ErrDirty - предыдущая миграция упала на середине; схему нужно проверить вручную
+	// и отметить верную версию командой force
+	ErrDirty = errors.New("database schema is dirty")
+	// ErrSchemaMismatch - версия схемы в базе не совпадает с ожидаемой бинарником
+	ErrSchemaMismatch = errors.New("database schema version mismatch")
+	// ErrUnknownVersion - в базе версия, для которой нет файла миграции
+	ErrUnknownVersion = errors.New("unknown schema version")
+)
+
+// Migration - пара файлов NNNN_name.up.sql / NNNN_name.down.sql
+type Migration struct {
+	Version uint
+	Name    string
+	Up      string
+	Down    string
+}
+
+// State - состояние схемы; Version 0 - ни одна миграция не применена
+type State struct {
+	Version uint
+	Dirty   bool
+}
+
+var fileName = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Load - миграции из fsys по возрастанию версии; у каждой должны быть оба файла
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[uint]*Migration)
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		version, err := strconv.ParseUint(match[1], 10, 32)
+		if err != nil || version == 0 {
+			return nil, fmt.Errorf("%s: invalid migration version", entry.Name())
+		}
+		data, err := fs.ReadFile(fsys, path.Clean(entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[uint(version)]
+		if !ok {
+			m = &Migration{Version: uint(version), Name: match[2]}
+			byVersion[m.Version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %04d has two names: %s and %s", m.Version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s must have both up and down files", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Runner - применение миграций к базе Postgres. Все операции выполняются на одном
+// соединении под advisory-блокировкой, поэтому несколько экземпляров сервера
+// не применят миграции одновременно.
+type Runner struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// lockID - ключ pg_advisory_lock для миграций
+const lockID = 4719023305
+
+// NewRunner - создание исполнителя для migrations (в порядке возрастания версии)
+func NewRunner(db *sql.DB, migrations []Migration) *Runner {
+	return &Runner{db: db, migrations: migrations}
+}
+
+// Migrations - известные бинарнику миграции
+func (r *Runner) Migrations() []Migration {
+	return r.migrations
+}
+
+// Latest - версия схемы, которую ожидает бинарник
+func (r *Runner) Latest() uint {
+	if len(r.migrations) == 0 {
+		return 0
+	}
+	return r.migrations[len(r.migrations)-1].Version
+}
+
+// State - текущая версия схемы в базе
+func (r *Runner) State(ctx context.Context) (State, error) {
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return State{}, err
+	}
+	defer conn.Close()
+	return readState(ctx, conn)
+}
+
+// Check - совпадает ли версия схемы в базе с Latest
+func (r *Runner) Check(ctx context.Context) error {
+	state, err := r.State(ctx)
+	if err != nil {
+		return err
+	}
+	if state.Dirty {
+		return fmt.Errorf("%w: version %d is dirty", ErrSchemaMismatch, state.Version)
+	}
+	if state.Version != r.Latest() {
+		return fmt.Errorf("%w: database is at version %d, binary expects %d", ErrSchemaMismatch, state.Version, r.Latest())
+	}
+	return nil
+}
+
+// Up - применить все еще не примененные миграции; возвращает примененные
+func (r *Runner) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+	err := r.locked(ctx, func(conn *sql.Conn, state State) error {
+		if state.Version > r.Latest() {
+			return fmt.Errorf("%w: database is at version %d, newer than %d known to the binary",
+				ErrUnknownVersion, state.Version, r.Latest())
+		}
+		for _, m := range r.migrations {
+			if m.Version <= state.Version {
+				continue
+			}
+			if err := apply(ctx, conn, m.Version, m.Up); err != nil {
+				return fmt.Errorf("migration %04d_%s up: %w", m.Version, m.Name, err)
+			}
+			applied = append(applied, m)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down - откатить steps последних миграций; возвращает откаченные
+func (r *Runner) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var reverted []Migration
+	err := r.locked(ctx, func(conn *sql.Conn, state State) error {
+		current := r.index(state.Version)
+		if state.Version != 0 && current < 0 {
+			return fmt.Errorf("%w: %d", ErrUnknownVersion, state.Version)
+		}
+		for i := current; i >= 0 && len(reverted) < steps; i-- {
+			m := r.migrations[i]
+			var target uint
+			if i > 0 {
+				target = r.migrations[i-1].Version
+			}
+			if err := apply(ctx, conn, target, m.Down); err != nil {
+				return fmt.Errorf("migration %04d_%s down: %w", m.Version, m.Name, err)
+			}
+			reverted = append(reverted, m)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Force - записать версию схемы без выполнения миграций и снять отметку dirty
+func (r *Runner) Force(ctx context.Context, version uint) error {
+	if version != 0 && r.index(version) < 0 {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := lock(ctx, conn); err != nil {
+		return err
+	}
+	defer unlock(conn)
+	return setVersion(ctx, conn, State{Version: version})
+}
+
+// index - позиция миграции version в списке (-1, если ее нет)
+func (r *Runner) index(version uint) int {
+	for i, m := range r.migrations {
+		if m.Version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// locked - fn на отдельном соединении под блокировкой; грязная схема не трогается
+func (r *Runner) locked(ctx context.Context, fn func(conn *sql.Conn, state State) error) (err error) {
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := lock(ctx, conn); err != nil {
+		return err
+	}
+	defer func() {
+		if unlockErr := unlock(conn); unlockErr != nil {
+			err = errors.Join(err, unlockErr)
+		}
+	}()
+
+	state, err := readState(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if state.Dirty {
+		return fmt.Errorf("%w: version %d (fix the schema and run migrate force)", ErrDirty, state.Version)
+	}
+	return fn(conn, state)
+}
+
+// apply - выполнить файл миграции и перейти на версию target. На время выполнения
+// версия отмечена dirty: если файл упадет не целиком в транзакции, отметка останется
+func apply(ctx context.Context, conn *sql.Conn, target uint, script string) error {
+	if err := setVersion(ctx, conn, State{Version: target, Dirty: true}); err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, script); err != nil {
+		// Файл с BEGIN оставляет прерванную транзакцию открытой. Без открытой
+		// транзакции ROLLBACK дает лишь предупреждение, так что ошибка здесь
+		// означает сломанное соединение
+		if _, rollbackErr := conn.ExecContext(ctx, "ROLLBACK"); rollbackErr != nil {
+			return errors.Join(err, fmt.Errorf("rollback: %w", rollbackErr))
+		}
+		return err
+	}
+	return setVersion(ctx, conn, State{Version: target})
+}
+
+func lock(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", int64(lockID))
+	return err
+}
+
+// unlock - снять блокировку. Если снять не удалось, соединение закрывается вместо
+// возврата в пул: блокировка уровня сессии освобождается только вместе с ним,
+// иначе она осталась бы висеть и заблокировала следующий migrate up
+func unlock(conn *sql.Conn) error {
+	var released bool
+	err := conn.QueryRowContext(context.Background(), "SELECT pg_advisory_unlock($1)", int64(lockID)).Scan(&released)
+	if err == nil && !released {
+		err = errors.New("lock was not held")
+	}
+	if err == nil {
+		return nil
+	}
+	// driver.ErrBadConn из Raw заставляет database/sql выбросить соединение из пула
+	_ = conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	return fmt.Errorf("release migration lock: %w", err)
+}
+
+func readState(ctx context.Context, conn *sql.Conn) (State, error) {
+	var table sql.NullString
+	if err := conn.QueryRowContext(ctx, "SELECT to_regclass('schema_migrations')::text").Scan(&table); err != nil {
+		return State{}, err
+	}
+	if !table.Valid {
+		return State{}, nil
+	}
+
+	var version int64
+	var dirty bool
+	err := conn.QueryRowContext(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return State{}, nil
+	}
+	if err != nil {
+		return State{}, err
+	}
+	// migrate/migrate хранит -1, когда откачены все миграции, но схема осталась грязной
+	if version < 0 {
+		version = 0
+	}
+	return State{Version: uint(version), Dirty: dirty}, nil
+}
+
+// setVersion - единственная строка schema_migrations (формат migrate/migrate)
+func setVersion(ctx context.Context, conn *sql.Conn, state State) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmts := []string{
+		"CREATE TABLE IF NOT EXISTS schema_migrations (version bigint NOT NULL PRIMARY KEY, dirty boolean NOT NULL)",
+		"TRUNCATE schema_migrations",
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	if state.Version > 0 || state.Dirty {
+		version := int64(state.Version)
+		if state.Version == 0 {
+			version = -1
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, dirty) VALUES ($1, $2)", version, state.Dirty); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,76 @@
+package migrate_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/ImCtyz/duofinance/backend/internal/migrate"
+	migrationfiles "github.com/ImCtyz/duofinance/backend/migrations"
+)
+
+// Встроенные миграции загружаются целиком, по возрастанию версии
+func TestLoadEmbedded(t *testing.T) {
+	migrations, err := migrate.Load(migrationfiles.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 || migrations[0].Version != 1 {
+		t.Fatalf("expected migrations starting at 0001, got %d", len(migrations))
+	}
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version <= migrations[i-1].Version {
+			t.Errorf("migration %04d follows %04d", migrations[i].Version, migrations[i-1].Version)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0003_third.up.sql":   {Data: []byte("up 3")},
+		"0003_third.down.sql": {Data: []byte("down 3")},
+		"0001_first.up.sql":   {Data: []byte("up 1")},
+		"0001_first.down.sql": {Data: []byte("down 1")},
+		"migrations.go":       {Data: []byte("package migrations")},
+	}
+	migrations, err := migrate.Load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []migrate.Migration{
+		{Version: 1, Name: "first", Up: "up 1", Down: "down 1"},
+		{Version: 3, Name: "third", Up: "up 3", Down: "down 3"},
+	}
+	if len(migrations) != len(want) {
+		t.Fatalf("got %d migrations, want %d", len(migrations), len(want))
+	}
+	for i := range want {
+		if migrations[i] != want[i] {
+			t.Errorf("migration %d: got %+v, want %+v", i, migrations[i], want[i])
+		}
+	}
+	runner := migrate.NewRunner(nil, migrations)
+	if runner.Latest() != 3 {
+		t.Errorf("latest: got %d, want 3", runner.Latest())
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	cases := map[string]fstest.MapFS{
+		"missing down": {
+			"0001_first.up.sql": {Data: []byte("up")},
+		},
+		"two names": {
+			"0001_first.up.sql":   {Data: []byte("up")},
+			"0001_other.down.sql": {Data: []byte("down")},
+		},
+		"zero version": {
+			"0000_zero.up.sql":   {Data: []byte("up")},
+			"0000_zero.down.sql": {Data: []byte("down")},
+		},
+	}
+	for name, fsys := range cases {
+		if _, err := migrate.Load(fsys); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
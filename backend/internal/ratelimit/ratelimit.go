@@ -0,0 +1,171 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// sweepInterval - как часто удалять ключи без актуальных событий
+const sweepInterval = time.Minute
+
+// Limiter - ограничение числа событий на ключ в скользящем окне.
+// Безопасен для конкурентного использования.
+type Limiter struct {
+	mu        sync.Mutex
+	limit     int
+	window    time.Duration
+	events    map[string][]time.Time
+	lastSweep time.Time
+}
+
+// NewLimiter - не более limit событий за window на каждый ключ
+func NewLimiter(limit int, window time.Duration) *Limiter {
+	return &Limiter{
+		limit:     limit,
+		window:    window,
+		events:    make(map[string][]time.Time),
+		lastSweep: time.Now(),
+	}
+}
+
+// Limit - максимальное число событий в окне
+func (l *Limiter) Limit() int {
+	return l.limit
+}
+
+// Allow - регистрирует событие для key, если лимит не исчерпан.
+// Возвращает число оставшихся событий либо время до освобождения места в окне.
+func (l *Limiter) Allow(key string) (allowed bool, remaining int, retryAfter time.Duration) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	// Оставляем только события внутри окна
+	events := l.events[key]
+	valid := events[:0]
+	for _, t := range events {
+		if now.Sub(t) < l.window {
+			valid = append(valid, t)
+		}
+	}
+
+	if len(valid) >= l.limit {
+		l.events[key] = valid
+		return false, 0, l.window - now.Sub(valid[0])
+	}
+
+	l.events[key] = append(valid, now)
+	return true, l.limit - len(valid) - 1, 0
+}
+
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, events := range l.events {
+		if len(events) == 0 || now.Sub(events[len(events)-1]) >= l.window {
+			delete(l.events, key)
+		}
+	}
+}
+
+// Backoff - длительность блокировки после failures неудач: base после threshold-й
+// неудачи, дальше удваивается с каждой неудачей, но не больше max. До порога — 0.
+func Backoff(failures, threshold int, base, max time.Duration) time.Duration {
+	if failures < threshold {
+		return 0
+	}
+	delay := base
+	for i := threshold; i < failures; i++ {
+		delay *= 2
+		if delay >= max {
+			return max
+		}
+	}
+	return delay
+}
+
+// FailureTracker - счетчик неудачных попыток на ключ с экспоненциальной блокировкой.
+// Неудачи забываются, если в течение window их не было; успех счетчик не сбрасывает,
+// иначе вход в свой аккаунт с того же адреса обнулял бы перебор чужих.
+// Безопасен для конкурентного использования.
+type FailureTracker struct {
+	mu        sync.Mutex
+	threshold int
+	base      time.Duration
+	max       time.Duration
+	window    time.Duration
+	entries   map[string]*failureEntry
+	lastSweep time.Time
+}
+
+type failureEntry struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// NewFailureTracker - блокировка после threshold неудач на base, удваивающаяся до max
+func NewFailureTracker(threshold int, base, max, window time.Duration) *FailureTracker {
+	return &FailureTracker{
+		threshold: threshold,
+		base:      base,
+		max:       max,
+		window:    window,
+		entries:   make(map[string]*failureEntry),
+		lastSweep: time.Now(),
+	}
+}
+
+// BlockedUntil - время окончания блокировки key, если она действует
+func (t *FailureTracker) BlockedUntil(key string) (time.Time, bool) {
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry, ok := t.entries[key]
+	if !ok || !now.Before(entry.blockedUntil) {
+		return time.Time{}, false
+	}
+	return entry.blockedUntil, true
+}
+
+// Fail - регистрирует неудачу; возвращает время окончания блокировки (нулевое, если порог не достигнут)
+func (t *FailureTracker) Fail(key string) time.Time {
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sweep(now)
+
+	entry, ok := t.entries[key]
+	if !ok || now.Sub(entry.lastFailure) >= t.window {
+		entry = &failureEntry{}
+		t.entries[key] = entry
+	}
+	entry.failures++
+	entry.lastFailure = now
+
+	if delay := Backoff(entry.failures, t.threshold, t.base, t.max); delay > 0 {
+		entry.blockedUntil = now.Add(delay)
+	}
+	return entry.blockedUntil
+}
+
+func (t *FailureTracker) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < sweepInterval {
+		return
+	}
+	t.lastSweep = now
+	for key, entry := range t.entries {
+		if now.Sub(entry.lastFailure) >= t.window && !now.Before(entry.blockedUntil) {
+			delete(t.entries, key)
+		}
+	}
+}
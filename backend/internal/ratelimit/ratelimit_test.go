@@ -0,0 +1,165 @@
+package ratelimit_test
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ImCtyz/duofinance/backend/internal/ratelimit"
+)
+
+func TestBackoff(t *testing.T) {
+	cases := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, time.Minute}, // порог
+		{4, 2 * time.Minute},
+		{5, 4 * time.Minute},
+		{6, 8 * time.Minute},
+		{7, 10 * time.Minute}, // 16 минут ограничены max
+		{50, 10 * time.Minute},
+	}
+	for _, tc := range cases {
+		if got := ratelimit.Backoff(tc.failures, 3, time.Minute, 10*time.Minute); got != tc.want {
+			t.Errorf("Backoff(%d) = %v, want %v", tc.failures, got, tc.want)
+		}
+	}
+	if got := ratelimit.Backoff(1, 1, time.Hour, time.Minute); got != time.Hour {
+		t.Errorf("Backoff at the threshold = %v, want base", got)
+	}
+}
+
+func TestLimiterWindow(t *testing.T) {
+	limiter := ratelimit.NewLimiter(2, 100*time.Millisecond)
+
+	for i, wantRemaining := range []int{1, 0} {
+		allowed, remaining, _ := limiter.Allow("client")
+		if !allowed || remaining != wantRemaining {
+			t.Fatalf("event %d: allowed %v, remaining %d", i+1, allowed, remaining)
+		}
+	}
+	allowed, _, retryAfter := limiter.Allow("client")
+	if allowed || retryAfter <= 0 || retryAfter > 100*time.Millisecond {
+		t.Fatalf("over the limit: allowed %v, retry after %v", allowed, retryAfter)
+	}
+	if allowed, _, _ := limiter.Allow("other"); !allowed {
+		t.Fatal("limit of one key applied to another")
+	}
+
+	time.Sleep(retryAfter + 10*time.Millisecond)
+	if allowed, _, _ := limiter.Allow("client"); !allowed {
+		t.Fatal("event rejected after the window passed")
+	}
+}
+
+// Под нагрузкой из многих горутин лимит не превышается (запускать с -race)
+func TestLimiterConcurrent(t *testing.T) {
+	const limit, workers, perWorker = 50, 16, 20
+	limiter := ratelimit.NewLimiter(limit, time.Minute)
+
+	var allowed [4]int64
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				key := w % len(allowed)
+				if ok, _, _ := limiter.Allow(fmt.Sprint("key-", key)); ok {
+					atomic.AddInt64(&allowed[key], 1)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// На каждый ключ приходится 4 * 20 = 80 событий, из них проходят ровно limit
+	for key, got := range allowed {
+		if got != limit {
+			t.Errorf("key-%d: %d events allowed, want %d", key, got, limit)
+		}
+	}
+}
+
+// within - until отстоит на delay от момента между start и текущим временем
+func within(until, start time.Time, delay time.Duration) bool {
+	return !until.Before(start.Add(delay)) && !until.After(time.Now().Add(delay))
+}
+
+func TestFailureTrackerBlocks(t *testing.T) {
+	tracker := ratelimit.NewFailureTracker(2, 100*time.Millisecond, 150*time.Millisecond, time.Hour)
+
+	if until := tracker.Fail("10.0.0.1"); !until.IsZero() {
+		t.Fatalf("blocked before the threshold until %v", until)
+	}
+	if _, blocked := tracker.BlockedUntil("10.0.0.1"); blocked {
+		t.Fatal("blocked before the threshold")
+	}
+
+	start := time.Now()
+	first := tracker.Fail("10.0.0.1")
+	if !within(first, start, 100*time.Millisecond) {
+		t.Fatalf("first block lasts %v, want base", first.Sub(start))
+	}
+	until, blocked := tracker.BlockedUntil("10.0.0.1")
+	if !blocked || !until.Equal(first) {
+		t.Fatalf("BlockedUntil = %v, %v; want %v", until, blocked, first)
+	}
+	if _, blocked := tracker.BlockedUntil("10.0.0.2"); blocked {
+		t.Fatal("block of one address applied to another")
+	}
+
+	// Следующая неудача удваивает блокировку, но не дольше max
+	start = time.Now()
+	second := tracker.Fail("10.0.0.1")
+	if !within(second, start, 150*time.Millisecond) {
+		t.Fatalf("second block lasts %v, want max", second.Sub(start))
+	}
+
+	time.Sleep(time.Until(second) + 10*time.Millisecond)
+	if _, blocked := tracker.BlockedUntil("10.0.0.1"); blocked {
+		t.Fatal("still blocked after the block expired")
+	}
+}
+
+// Неудачи старше окна забываются
+func TestFailureTrackerWindow(t *testing.T) {
+	tracker := ratelimit.NewFailureTracker(2, time.Minute, time.Hour, 50*time.Millisecond)
+	tracker.Fail("10.0.0.1")
+	time.Sleep(60 * time.Millisecond)
+	if until := tracker.Fail("10.0.0.1"); !until.IsZero() {
+		t.Fatalf("failure outside the window counted: blocked until %v", until)
+	}
+	if until := tracker.Fail("10.0.0.1"); until.IsZero() {
+		t.Fatal("two failures inside the window did not block")
+	}
+}
+
+// Конкурентные неудачи считаются все до одной (запускать с -race)
+func TestFailureTrackerConcurrent(t *testing.T) {
+	const workers = 32
+	tracker := ratelimit.NewFailureTracker(workers, time.Minute, time.Hour, time.Hour)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers-1; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tracker.Fail("10.0.0.1")
+			tracker.BlockedUntil("10.0.0.1")
+		}()
+	}
+	wg.Wait()
+
+	if _, blocked := tracker.BlockedUntil("10.0.0.1"); blocked {
+		t.Fatal("blocked before the threshold")
+	}
+	if until := tracker.Fail("10.0.0.1"); until.IsZero() {
+		t.Fatal("lost failures: threshold not reached")
+	}
+}
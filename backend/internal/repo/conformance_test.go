@@ -0,0 +1,973 @@
+package repo_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ImCtyz/duofinance/backend/internal/domain"
+	"github.com/ImCtyz/duofinance/backend/internal/migrate"
+	"github.com/ImCtyz/duofinance/backend/internal/repo"
+	"github.com/ImCtyz/duofinance/backend/internal/repo/memory"
+	migrationfiles "github.com/ImCtyz/duofinance/backend/migrations"
+	"gorm.io/datatypes"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Общий набор проверок для реализаций repo: в памяти (всегда) и GORM/Postgres
+// (если задан TEST_DATABASE_URL). База должна быть одноразовой: тесты создают
+// в ней схему conformance, применяют миграции и очищают таблицы перед каждым тестом.
+
+type backend struct {
+	users           repo.UserRepo
+	levels          repo.LevelRepo
+	questions       repo.QuestionRepo
+	attempts        repo.AttemptRepo
+	rewards         repo.RewardTxRepo
+	achievements    repo.AchievementRepo
+	sessions        repo.SessionRepo
+	userTokens      repo.UserTokenRepo
+	courses         repo.CourseRepo
+	tx              repo.TxManager
+	seedAchievement func(achievement *domain.Achievement) error
+}
+
+func memoryBackend(t *testing.T) backend {
+	store := memory.NewStore()
+	return backend{
+		users:           memory.NewUserRepo(store),
+		levels:          memory.NewLevelRepo(store),
+		questions:       memory.NewQuestionRepo(store),
+		attempts:        memory.NewAttemptRepo(store),
+		rewards:         memory.NewRewardTxRepo(store),
+		achievements:    memory.NewAchievementRepo(store),
+		sessions:        memory.NewSessionRepo(store),
+		userTokens:      memory.NewUserTokenRepo(store),
+		courses:         memory.NewCourseRepo(store),
+		tx:              memory.NewTxManager(store),
+		seedAchievement: store.SeedAchievement,
+	}
+}
+
+var (
+	postgresOnce sync.Once
+	postgresDB   *gorm.DB
+	postgresErr  error
+)
+
+// openPostgres - подключение к тестовой базе с примененными миграциями в схеме conformance.
+// search_path задается в параметрах подключения, чтобы он действовал на каждом соединении
+// пула: тестам конкурентности нужно несколько соединений одновременно
+func openPostgres(dsn string) (*gorm.DB, error) {
+	config := &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)}
+	admin, err := gorm.Open(postgres.Open(dsn), config)
+	if err != nil {
+		return nil, err
+	}
+	adminDB, err := admin.DB()
+	if err != nil {
+		return nil, err
+	}
+	defer adminDB.Close()
+	for _, stmt := range []string{
+		"DROP SCHEMA IF EXISTS conformance CASCADE",
+		"CREATE SCHEMA conformance",
+	} {
+		if err := admin.Exec(stmt).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	db, err := gorm.Open(postgres.Open(withSearchPath(dsn, "conformance")), config)
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	migrations, err := migrate.Load(migrationfiles.FS)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := migrate.NewRunner(sqlDB, migrations).Up(context.Background()); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// withSearchPath - DSN (URL или key=value) с параметром search_path
+func withSearchPath(dsn, schema string) string {
+	if !strings.Contains(dsn, "://") {
+		return dsn + " search_path=" + schema
+	}
+	if strings.Contains(dsn, "?") {
+		return dsn + "&search_path=" + schema
+	}
+	return dsn + "?search_path=" + schema
+}
+
+func postgresBackend(t *testing.T) backend {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	postgresOnce.Do(func() { postgresDB, postgresErr = openPostgres(dsn) })
+	if postgresErr != nil {
+		t.Fatalf("postgres setup: %v", postgresErr)
+	}
+
+	// Каждый тест начинает с пустых таблиц (миграции заводят стартовый контент)
+	var tables []string
+	err := postgresDB.Raw("SELECT tablename FROM pg_tables WHERE schemaname = 'conformance'").Scan(&tables).Error
+	if err != nil {
+		t.Fatalf("list tables: %v", err)
+	}
+	if err := postgresDB.Exec("TRUNCATE " + strings.Join(tables, ", ") + " RESTART IDENTITY CASCADE").Error; err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+
+	db := postgresDB
+	return backend{
+		users:           repo.NewUserRepo(db),
+		levels:          repo.NewLevelRepo(db),
+		questions:       repo.NewQuestionRepo(db),
+		attempts:        repo.NewAttemptRepo(db),
+		rewards:         repo.NewRewardTxRepo(db),
+		achievements:    repo.NewAchievementRepo(db),
+		sessions:        repo.NewSessionRepo(db),
+		userTokens:      repo.NewUserTokenRepo(db),
+		courses:         repo.NewCourseRepo(db),
+		tx:              repo.NewTxManager(db),
+		seedAchievement: func(achievement *domain.Achievement) error { return db.Create(achievement).Error },
+	}
+}
+
+// forEachBackend - запуск проверки на каждой реализации со свежими данными
+func forEachBackend(t *testing.T, check func(t *testing.T, ctx context.Context, b backend)) {
+	backends := []struct {
+		name string
+		open func(t *testing.T) backend
+	}{
+		{"memory", memoryBackend},
+		{"postgres", postgresBackend},
+	}
+	for _, bk := range backends {
+		t.Run(bk.name, func(t *testing.T) {
+			check(t, context.Background(), bk.open(t))
+		})
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func mustNotFound(t *testing.T, err error) {
+	t.Helper()
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("expected gorm.ErrRecordNotFound, got %v", err)
+	}
+}
+
+func uintPtr(v uint) *uint { return &v }
+
+func createUser(t *testing.T, ctx context.Context, b backend, name string) *domain.User {
+	t.Helper()
+	user := &domain.User{Email: name + "@example.com", Username: name, PasswordHash: "hash"}
+	must(t, b.users.Create(ctx, user))
+	return user
+}
+
+func createLevel(t *testing.T, ctx context.Context, b backend, title string) *domain.Level {
+	t.Helper()
+	level := &domain.Level{Title: title, Topic: "budget", Difficulty: "easy", IsActive: true}
+	must(t, b.levels.Create(ctx, level))
+	return level
+}
+
+func createQuestion(t *testing.T, ctx context.Context, b backend, prompt string) *domain.Question {
+	t.Helper()
+	question := &domain.Question{
+		Prompt: prompt,
+		Kind:   domain.KindChoice,
+		Choices: []domain.Choice{
+			{Text: "second", Order: 2},
+			{Text: "first", Order: 1, IsCorrect: true},
+		},
+	}
+	must(t, b.questions.Create(ctx, question))
+	return question
+}
+
+func TestUserRepoConformance(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ctx context.Context, b backend) {
+		alice := createUser(t, ctx, b, "alice")
+		bob := createUser(t, ctx, b, "bob")
+		if alice.ID == 0 || bob.ID <= alice.ID {
+			t.Fatalf("ids not assigned in order: %d, %d", alice.ID, bob.ID)
+		}
+		if err := b.users.Create(ctx, &domain.User{Email: "alice@example.com", Username: "other", PasswordHash: "x"}); err == nil {
+			t.Fatal("duplicate email accepted")
+		}
+
+		got, err := b.users.GetByEmail(ctx, "alice@example.com")
+		must(t, err)
+		if got.ID != alice.ID || got.Role != domain.RoleLearner {
+			t.Fatalf("GetByEmail = %+v", got)
+		}
+		got, err = b.users.GetByUsername(ctx, "bob")
+		must(t, err)
+		if got.ID != bob.ID {
+			t.Fatalf("GetByUsername returned user %d", got.ID)
+		}
+		_, err = b.users.GetByID(ctx, 999)
+		mustNotFound(t, err)
+
+		_, err = b.users.GetProfile(ctx, alice.ID)
+		mustNotFound(t, err)
+		profile := &domain.Profile{UserID: alice.ID, Streak: 3}
+		must(t, b.users.UpdateProfile(ctx, profile))
+		profile.Streak = 4
+		must(t, b.users.UpdateProfile(ctx, profile))
+		gotProfile, err := b.users.GetProfile(ctx, alice.ID)
+		must(t, err)
+		if gotProfile.ID != profile.ID || gotProfile.Streak != 4 {
+			t.Fatalf("GetProfile = %+v", gotProfile)
+		}
+
+		since := time.Now().Add(-time.Hour)
+		for want := 1; want <= 2; want++ {
+			count, err := b.users.IncrementFailedLogins(ctx, alice.ID, since)
+			must(t, err)
+			if count != want {
+				t.Fatalf("IncrementFailedLogins = %d, want %d", count, want)
+			}
+		}
+		count, err := b.users.IncrementFailedLogins(ctx, alice.ID, time.Now().Add(time.Hour))
+		must(t, err)
+		if count != 1 {
+			t.Fatalf("failures before since must be forgotten, got %d", count)
+		}
+		must(t, b.users.LockUntil(ctx, alice.ID, time.Now().Add(time.Minute)))
+		must(t, b.users.ResetFailedLogins(ctx, alice.ID))
+		got, err = b.users.GetByID(ctx, alice.ID)
+		must(t, err)
+		if got.FailedLoginAttempts != 0 || got.LastFailedLoginAt != nil || got.LockedUntil != nil {
+			t.Fatalf("ResetFailedLogins left %+v", got)
+		}
+
+		must(t, b.users.SetRole(ctx, bob.ID, domain.RoleEditor))
+		mustNotFound(t, b.users.SetRole(ctx, 999, domain.RoleEditor))
+
+		for _, tc := range []struct {
+			step int64
+			want bool
+		}{{5, true}, {5, false}, {4, false}, {6, true}} {
+			accepted, err := b.users.AcceptTOTPStep(ctx, alice.ID, tc.step)
+			must(t, err)
+			if accepted != tc.want {
+				t.Fatalf("AcceptTOTPStep(%d) = %v, want %v", tc.step, accepted, tc.want)
+			}
+		}
+
+		createUser(t, ctx, b, "carol")
+		users, total, err := b.users.List(ctx, 1, 1)
+		must(t, err)
+		if total != 3 || len(users) != 1 || users[0].ID != bob.ID || users[0].Role != domain.RoleEditor {
+			t.Fatalf("List(1, 1) = %d users, total %d", len(users), total)
+		}
+
+		must(t, b.rewards.Create(ctx, &domain.RewardTx{UserID: alice.ID, Amount: 10, Type: "bonus"}))
+		must(t, b.rewards.Create(ctx, &domain.RewardTx{UserID: alice.ID, Amount: -4, Type: "spend"}))
+		balance, err := b.users.GetDiamondsBalance(ctx, alice.ID)
+		must(t, err)
+		if balance != 6 {
+			t.Fatalf("GetDiamondsBalance = %d, want 6", balance)
+		}
+	})
+}
+
+func TestLevelRepoConformance(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ctx context.Context, b backend) {
+		first := createLevel(t, ctx, b, "First")
+		second := createLevel(t, ctx, b, "Second")
+		if first.Slug != fmt.Sprintf("level-%d", first.ID) {
+			t.Fatalf("default slug = %q", first.Slug)
+		}
+		second.IsActive = false
+		must(t, b.levels.Update(ctx, second))
+		third := createLevel(t, ctx, b, "Third")
+
+		active, err := b.levels.GetAll(ctx)
+		must(t, err)
+		if ids := levelIDs(active); fmt.Sprint(ids) != fmt.Sprint([]uint{first.ID, third.ID}) {
+			t.Fatalf("GetAll = %v", ids)
+		}
+
+		must(t, b.levels.Delete(ctx, third.ID))
+		mustNotFound(t, b.levels.Delete(ctx, third.ID))
+		_, err = b.levels.GetByID(ctx, third.ID)
+		mustNotFound(t, err)
+		all, err := b.levels.ListAll(ctx, false)
+		must(t, err)
+		withDeleted, err := b.levels.ListAll(ctx, true)
+		must(t, err)
+		if len(all) != 2 || len(withDeleted) != 3 {
+			t.Fatalf("ListAll = %d, with deleted %d", len(all), len(withDeleted))
+		}
+		if _, err := b.levels.GetForEdit(ctx, third.ID, true); err != nil {
+			t.Fatalf("GetForEdit(includeDeleted) = %v", err)
+		}
+		must(t, b.levels.Restore(ctx, third.ID))
+		mustNotFound(t, b.levels.Restore(ctx, third.ID))
+
+		question := createQuestion(t, ctx, b, "Pick one")
+		var steps []*domain.LevelStep
+		for i := 1; i <= 3; i++ {
+			step := &domain.LevelStep{LevelID: first.ID, Order: i, Type: "question", QuestionID: uintPtr(question.ID)}
+			must(t, b.levels.CreateStep(ctx, step))
+			steps = append(steps, step)
+		}
+		if err := b.levels.CreateStep(ctx, &domain.LevelStep{LevelID: first.ID, Order: 2, Type: "text"}); err == nil {
+			t.Fatal("duplicate step order accepted")
+		}
+		taken, err := b.levels.IsStepOrderTaken(ctx, first.ID, 2, 0)
+		must(t, err)
+		free, err := b.levels.IsStepOrderTaken(ctx, first.ID, 2, steps[1].ID)
+		must(t, err)
+		if !taken || free {
+			t.Fatalf("IsStepOrderTaken = %v, excluding the step itself = %v", taken, free)
+		}
+
+		must(t, b.levels.ReorderSteps(ctx, first.ID, []uint{steps[2].ID, steps[0].ID, steps[1].ID}))
+		mustNotFound(t, b.levels.ReorderSteps(ctx, second.ID, []uint{steps[0].ID}))
+		must(t, b.levels.DeleteStep(ctx, steps[1].ID))
+		mustNotFound(t, b.levels.DeleteStep(ctx, steps[1].ID))
+		_, err = b.levels.GetStep(ctx, steps[1].ID)
+		mustNotFound(t, err)
+
+		current, err := b.levels.GetSteps(ctx, first.ID, false)
+		must(t, err)
+		if ids := stepIDs(current); fmt.Sprint(ids) != fmt.Sprint([]uint{steps[2].ID, steps[0].ID}) {
+			t.Fatalf("GetSteps = %v", ids)
+		}
+		withDeletedSteps, err := b.levels.GetSteps(ctx, first.ID, true)
+		must(t, err)
+		if len(withDeletedSteps) != 3 {
+			t.Fatalf("GetSteps(includeDeleted) = %d steps", len(withDeletedSteps))
+		}
+		maxOrder, err := b.levels.MaxStepOrder(ctx, first.ID)
+		must(t, err)
+		if maxOrder != 2 {
+			t.Fatalf("MaxStepOrder = %d, want 2", maxOrder)
+		}
+
+		level, err := b.levels.GetWithSteps(ctx, first.ID)
+		must(t, err)
+		if len(level.Steps) != 2 || level.Steps[0].ID != steps[2].ID || level.Steps[0].Question == nil {
+			t.Fatalf("GetWithSteps steps = %+v", level.Steps)
+		}
+		if choices := level.Steps[0].Question.Choices; len(choices) != 2 || choices[0].Text != "first" {
+			t.Fatalf("GetWithSteps choices = %+v", choices)
+		}
+
+		for i := 1; i <= 2; i++ {
+			revision, err := domain.NewLevelRevision(level)
+			must(t, err)
+			must(t, b.levels.PublishRevision(ctx, revision))
+			if revision.Number != i {
+				t.Fatalf("revision number = %d, want %d", revision.Number, i)
+			}
+			published, err := b.levels.GetByID(ctx, first.ID)
+			must(t, err)
+			if published.PublishedRevisionID == nil || *published.PublishedRevisionID != revision.ID {
+				t.Fatalf("PublishedRevisionID = %v, want %d", published.PublishedRevisionID, revision.ID)
+			}
+		}
+		revisions, err := b.levels.ListRevisions(ctx, first.ID)
+		must(t, err)
+		if len(revisions) != 2 || revisions[0].Number != 2 || len(revisions[0].Snapshot) != 0 {
+			t.Fatalf("ListRevisions = %+v", revisions)
+		}
+		revision, err := b.levels.GetRevision(ctx, first.ID, 1)
+		must(t, err)
+		snapshot, err := revision.Level()
+		must(t, err)
+		if snapshot.Title != "First" || len(snapshot.Steps) != 2 {
+			t.Fatalf("snapshot = %+v", snapshot)
+		}
+		_, err = b.levels.GetRevision(ctx, first.ID, 3)
+		mustNotFound(t, err)
+		mustNotFound(t, b.levels.PublishRevision(ctx, &domain.LevelRevision{LevelID: 999, Snapshot: datatypes.JSON("{}")}))
+	})
+}
+
+func TestQuestionRepoConformance(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ctx context.Context, b backend) {
+		question := createQuestion(t, ctx, b, "Pick one")
+		other := createQuestion(t, ctx, b, "Pick another")
+		if question.Slug != fmt.Sprintf("question-%d", question.ID) {
+			t.Fatalf("default slug = %q", question.Slug)
+		}
+		for _, choice := range question.Choices {
+			if choice.ID == 0 || choice.QuestionID != question.ID || choice.Slug != fmt.Sprintf("choice-%d", choice.ID) {
+				t.Fatalf("choice not created with the question: %+v", choice)
+			}
+		}
+
+		plain, err := b.questions.GetByID(ctx, question.ID)
+		must(t, err)
+		if len(plain.Choices) != 0 {
+			t.Fatal("GetByID must not load choices")
+		}
+		loaded, err := b.questions.GetWithChoices(ctx, question.ID)
+		must(t, err)
+		if len(loaded.Choices) != 2 || loaded.Choices[0].Text != "first" || loaded.Choices[1].Text != "second" {
+			t.Fatalf("choices out of order: %+v", loaded.Choices)
+		}
+
+		choice := &domain.Choice{QuestionID: question.ID, Text: "third", Order: 3}
+		must(t, b.questions.CreateChoice(ctx, choice))
+		choice.Text = "third, edited"
+		must(t, b.questions.UpdateChoice(ctx, choice))
+		gotChoice, err := b.questions.GetChoice(ctx, choice.ID)
+		must(t, err)
+		if gotChoice.Text != "third, edited" {
+			t.Fatalf("UpdateChoice not stored: %+v", gotChoice)
+		}
+		must(t, b.questions.DeleteChoice(ctx, choice.ID))
+		mustNotFound(t, b.questions.DeleteChoice(ctx, choice.ID))
+
+		byIDs, err := b.questions.GetByIDs(ctx, []uint{other.ID, question.ID, 999})
+		must(t, err)
+		if len(byIDs) != 2 || len(byIDs[0].Choices) != 2 {
+			t.Fatalf("GetByIDs = %d questions", len(byIDs))
+		}
+
+		level := createLevel(t, ctx, b, "Level")
+		step := &domain.LevelStep{LevelID: level.ID, Order: 1, Type: "question", QuestionID: uintPtr(question.ID)}
+		must(t, b.levels.CreateStep(ctx, step))
+		referenced, err := b.questions.IsReferenced(ctx, question.ID)
+		must(t, err)
+		byLevel, err := b.questions.GetByLevelID(ctx, level.ID)
+		must(t, err)
+		if !referenced || len(byLevel) != 1 || byLevel[0].ID != question.ID || len(byLevel[0].Choices) != 2 {
+			t.Fatalf("IsReferenced = %v, GetByLevelID = %d questions", referenced, len(byLevel))
+		}
+		must(t, b.levels.DeleteStep(ctx, step.ID))
+		referenced, err = b.questions.IsReferenced(ctx, question.ID)
+		must(t, err)
+		if referenced {
+			t.Fatal("deleted step still references the question")
+		}
+
+		// Вопрос другого вида больше не хранит варианты
+		other.Kind = domain.KindNumeric
+		other.Spec = datatypes.JSON(`{"answer": 1}`)
+		other.Choices = nil
+		must(t, b.questions.Update(ctx, other))
+		numeric, err := b.questions.GetWithChoices(ctx, other.ID)
+		must(t, err)
+		if numeric.Kind != domain.KindNumeric || len(numeric.Choices) != 0 {
+			t.Fatalf("Update to numeric kept choices: %+v", numeric)
+		}
+
+		must(t, b.questions.Delete(ctx, question.ID))
+		mustNotFound(t, b.questions.Delete(ctx, question.ID))
+		_, err = b.questions.GetWithChoices(ctx, question.ID)
+		mustNotFound(t, err)
+		_, err = b.questions.GetChoice(ctx, question.Choices[0].ID)
+		mustNotFound(t, err)
+	})
+}
+
+func TestAttemptRepoConformance(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ctx context.Context, b backend) {
+		user := createUser(t, ctx, b, "player")
+		level := createLevel(t, ctx, b, "Level")
+		other := createLevel(t, ctx, b, "Other")
+		question := createQuestion(t, ctx, b, "Pick one")
+		step := &domain.LevelStep{LevelID: level.ID, Order: 1, Type: "question", QuestionID: uintPtr(question.ID)}
+		must(t, b.levels.CreateStep(ctx, step))
+
+		start := time.Now().Add(-time.Hour).Truncate(time.Second)
+		older := &domain.Attempt{UserID: user.ID, LevelID: level.ID, Status: domain.AttemptInProgress, StartedAt: start}
+		must(t, b.attempts.Create(ctx, older))
+		newer := &domain.Attempt{UserID: user.ID, LevelID: other.ID, Status: domain.AttemptInProgress, StartedAt: start.Add(time.Minute)}
+		must(t, b.attempts.Create(ctx, newer))
+
+		active, err := b.attempts.GetActiveByUserAndLevel(ctx, user.ID, level.ID)
+		must(t, err)
+		if active.ID != older.ID {
+			t.Fatalf("GetActiveByUserAndLevel = %d", active.ID)
+		}
+		byUser, err := b.attempts.GetByUserID(ctx, user.ID)
+		must(t, err)
+		if len(byUser) != 2 || byUser[0].ID != newer.ID {
+			t.Fatalf("GetByUserID must be ordered by started_at desc")
+		}
+
+		for order := 2; order >= 1; order-- {
+			must(t, b.attempts.AddStep(ctx, &domain.AttemptStep{
+				AttemptID: older.ID, LevelStepID: step.ID, QuestionID: uintPtr(question.ID), StepOrder: order,
+			}))
+		}
+		next, err := b.attempts.GetNextUnansweredStep(ctx, older.ID)
+		must(t, err)
+		if next.StepOrder != 1 {
+			t.Fatalf("GetNextUnansweredStep order = %d", next.StepOrder)
+		}
+		loaded, err := b.attempts.GetByID(ctx, older.ID)
+		must(t, err)
+		if len(loaded.Steps) != 2 || loaded.Steps[0].StepOrder != 1 {
+			t.Fatalf("GetByID steps = %+v", loaded.Steps)
+		}
+		steps, err := b.attempts.GetSteps(ctx, older.ID)
+		must(t, err)
+		if len(steps) != 2 || steps[1].StepOrder != 2 {
+			t.Fatalf("GetSteps = %+v", steps)
+		}
+		_, err = b.attempts.GetNextUnansweredStep(ctx, newer.ID)
+		mustNotFound(t, err)
+
+		servedAt := time.Now()
+		must(t, b.attempts.SetServed(ctx, older.ID, uintPtr(step.ID), &servedAt))
+		loaded, err = b.attempts.GetByID(ctx, older.ID)
+		must(t, err)
+		if loaded.ServedStepID == nil || *loaded.ServedStepID != step.ID || loaded.ServedAt == nil {
+			t.Fatalf("SetServed not stored: %+v", loaded)
+		}
+
+		completedAt := time.Now()
+		older.Status = domain.AttemptCompleted
+		older.ResultScore = 80
+		older.Passed = true
+		older.CompletedAt = &completedAt
+		must(t, b.attempts.Complete(ctx, older))
+		if err := b.attempts.Complete(ctx, older); !errors.Is(err, repo.ErrAttemptNotInProgress) {
+			t.Fatalf("second Complete = %v", err)
+		}
+		loaded, err = b.attempts.GetByID(ctx, older.ID)
+		must(t, err)
+		if loaded.Status != domain.AttemptCompleted || loaded.ResultScore != 80 || loaded.ServedStepID != nil {
+			t.Fatalf("Complete not stored: %+v", loaded)
+		}
+		_, err = b.attempts.GetActiveByUserAndLevel(ctx, user.ID, level.ID)
+		mustNotFound(t, err)
+
+		retry := &domain.Attempt{UserID: user.ID, LevelID: level.ID, Status: domain.AttemptCompleted, ResultScore: 60, StartedAt: start}
+		must(t, b.attempts.Create(ctx, retry))
+		scores, err := b.attempts.GetBestScores(ctx, user.ID)
+		must(t, err)
+		if len(scores) != 1 || scores[level.ID] != 80 {
+			t.Fatalf("GetBestScores = %v", scores)
+		}
+		passed, err := b.attempts.GetPassedLevelIDs(ctx, user.ID)
+		must(t, err)
+		if fmt.Sprint(passed) != fmt.Sprint([]uint{level.ID}) {
+			t.Fatalf("GetPassedLevelIDs = %v", passed)
+		}
+
+		answers, err := b.attempts.GetQuestionAnswers(ctx, []uint{question.ID})
+		must(t, err)
+		if len(answers) != 2 || answers[0].StepOrder != 1 || answers[0].AttemptStatus != domain.AttemptCompleted || answers[0].AttemptScore != 80 {
+			t.Fatalf("GetQuestionAnswers = %+v", answers)
+		}
+		none, err := b.attempts.GetQuestionAnswers(ctx, nil)
+		must(t, err)
+		if len(none) != 0 {
+			t.Fatalf("GetQuestionAnswers(nil) = %d answers", len(none))
+		}
+	})
+}
+
+func TestAttemptCompleteConcurrently(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ctx context.Context, b backend) {
+		user := createUser(t, ctx, b, "player")
+		level := createLevel(t, ctx, b, "Level")
+		attempt := &domain.Attempt{UserID: user.ID, LevelID: level.ID, Status: domain.AttemptInProgress, StartedAt: time.Now()}
+		must(t, b.attempts.Create(ctx, attempt))
+
+		const workers = 8
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		results := make(chan error, workers)
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func(score int) {
+				defer wg.Done()
+				<-start
+				completion := *attempt
+				completion.Status = domain.AttemptCompleted
+				completion.ResultScore = score
+				results <- b.attempts.Complete(ctx, &completion)
+			}(i)
+		}
+		close(start)
+		wg.Wait()
+		close(results)
+
+		succeeded := 0
+		for err := range results {
+			switch {
+			case err == nil:
+				succeeded++
+			case !errors.Is(err, repo.ErrAttemptNotInProgress):
+				t.Fatalf("Complete = %v", err)
+			}
+		}
+		if succeeded != 1 {
+			t.Fatalf("%d concurrent completions succeeded, want 1", succeeded)
+		}
+	})
+}
+
+func TestRewardTxRepoConformance(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ctx context.Context, b backend) {
+		user := createUser(t, ctx, b, "player")
+		level := createLevel(t, ctx, b, "Level")
+		attempt := &domain.Attempt{UserID: user.ID, LevelID: level.ID, Status: domain.AttemptInProgress, StartedAt: time.Now()}
+		must(t, b.attempts.Create(ctx, attempt))
+
+		must(t, b.rewards.Create(ctx, &domain.RewardTx{UserID: user.ID, Amount: 50, Type: "earn", AttemptID: uintPtr(attempt.ID)}))
+		if err := b.rewards.Create(ctx, &domain.RewardTx{UserID: user.ID, Amount: 50, Type: "earn", AttemptID: uintPtr(attempt.ID)}); err == nil {
+			t.Fatal("second earn reward for the attempt accepted")
+		}
+		must(t, b.rewards.Create(ctx, &domain.RewardTx{UserID: user.ID, Amount: 5, Type: "earn"}))
+		must(t, b.rewards.Create(ctx, &domain.RewardTx{UserID: user.ID, Amount: -20, Type: "spend", AttemptID: uintPtr(attempt.ID)}))
+
+		all, err := b.rewards.GetByUserID(ctx, user.ID)
+		must(t, err)
+		if len(all) != 3 {
+			t.Fatalf("GetByUserID = %d transactions", len(all))
+		}
+		balance, err := b.rewards.GetBalance(ctx, user.ID)
+		must(t, err)
+		if balance != 35 {
+			t.Fatalf("GetBalance = %d, want 35", balance)
+		}
+		earned, err := b.rewards.GetByType(ctx, user.ID, "earn")
+		must(t, err)
+		if len(earned) != 2 || earned[0].Amount != 5 {
+			t.Fatalf("GetByType must return newest first: %+v", earned)
+		}
+	})
+}
+
+func TestAchievementRepoConformance(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ctx context.Context, b backend) {
+		user := createUser(t, ctx, b, "player")
+		first := &domain.Achievement{Code: "first_level", Name: "First level"}
+		streak := &domain.Achievement{Code: "streak_7", Name: "Week streak"}
+		must(t, b.seedAchievement(first))
+		must(t, b.seedAchievement(streak))
+
+		all, err := b.achievements.GetAll(ctx)
+		must(t, err)
+		if len(all) != 2 || all[0].ID != first.ID {
+			t.Fatalf("GetAll = %+v", all)
+		}
+		byCode, err := b.achievements.GetByCode(ctx, "streak_7")
+		must(t, err)
+		if byCode.ID != streak.ID {
+			t.Fatalf("GetByCode = %d", byCode.ID)
+		}
+		_, err = b.achievements.GetByCode(ctx, "missing")
+		mustNotFound(t, err)
+
+		must(t, b.achievements.AwardToUser(ctx, user.ID, first.ID))
+		time.Sleep(10 * time.Millisecond)
+		must(t, b.achievements.AwardToUser(ctx, user.ID, streak.ID))
+		if err := b.achievements.AwardToUser(ctx, user.ID, first.ID); err == nil {
+			t.Fatal("achievement awarded twice")
+		}
+		has, err := b.achievements.HasAchievement(ctx, user.ID, first.ID)
+		must(t, err)
+		if !has {
+			t.Fatal("HasAchievement = false after award")
+		}
+		awarded, err := b.achievements.GetByUserID(ctx, user.ID)
+		must(t, err)
+		if len(awarded) != 2 || awarded[0].ID != streak.ID {
+			t.Fatalf("GetByUserID must return latest awards first: %+v", awarded)
+		}
+	})
+}
+
+func newSession(userID uint, jti, familyID string, expiresAt time.Time) *domain.Session {
+	return &domain.Session{UserID: userID, JTI: jti, FamilyID: familyID, IssuedAt: time.Now(), ExpiresAt: expiresAt}
+}
+
+func TestSessionRepoConformance(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ctx context.Context, b backend) {
+		user := createUser(t, ctx, b, "player")
+		other := createUser(t, ctx, b, "other")
+		hour := time.Now().Add(time.Hour)
+
+		must(t, b.sessions.Create(ctx, newSession(user.ID, "jti-1", "family-a", hour)))
+		if err := b.sessions.Create(ctx, newSession(user.ID, "jti-1", "family-a", hour)); err == nil {
+			t.Fatal("duplicate jti accepted")
+		}
+		must(t, b.sessions.Create(ctx, newSession(user.ID, "jti-b", "family-b", hour)))
+		must(t, b.sessions.Create(ctx, newSession(user.ID, "jti-expired", "family-c", time.Now().Add(-time.Hour))))
+		must(t, b.sessions.Create(ctx, newSession(other.ID, "jti-other", "family-o", hour)))
+
+		session, err := b.sessions.GetByJTI(ctx, "jti-1")
+		must(t, err)
+		if session.FamilyID != "family-a" {
+			t.Fatalf("GetByJTI = %+v", session)
+		}
+		_, err = b.sessions.GetByJTI(ctx, "missing")
+		mustNotFound(t, err)
+
+		// Ротация возможна только один раз
+		must(t, b.sessions.Rotate(ctx, "jti-1", newSession(user.ID, "jti-2", "family-a", hour)))
+		if err := b.sessions.Rotate(ctx, "jti-1", newSession(user.ID, "jti-3", "family-a", hour)); !errors.Is(err, repo.ErrSessionRevoked) {
+			t.Fatalf("second Rotate = %v, want ErrSessionRevoked", err)
+		}
+		rotated, err := b.sessions.GetByJTI(ctx, "jti-1")
+		must(t, err)
+		if rotated.RevokedAt == nil || rotated.ReplacedBy != "jti-2" {
+			t.Fatalf("rotated session = %+v", rotated)
+		}
+
+		active, err := b.sessions.GetActiveByUser(ctx, user.ID)
+		must(t, err)
+		if len(active) != 2 {
+			t.Fatalf("GetActiveByUser = %d sessions, want 2", len(active))
+		}
+		if ok, err := b.sessions.IsFamilyActive(ctx, user.ID, "family-c"); err != nil || ok {
+			t.Fatalf("expired family active = %v, %v", ok, err)
+		}
+
+		revoked, err := b.sessions.RevokeFamily(ctx, user.ID, "family-a")
+		must(t, err)
+		if revoked != 1 {
+			t.Fatalf("RevokeFamily = %d, want 1", revoked)
+		}
+		if ok, err := b.sessions.IsFamilyActive(ctx, user.ID, "family-a"); err != nil || ok {
+			t.Fatalf("revoked family active = %v, %v", ok, err)
+		}
+
+		must(t, b.sessions.Create(ctx, newSession(user.ID, "jti-d", "family-d", hour)))
+		must(t, b.sessions.RevokeOthers(ctx, user.ID, "family-d"))
+		if ok, err := b.sessions.IsFamilyActive(ctx, user.ID, "family-b"); err != nil || ok {
+			t.Fatalf("RevokeOthers kept family-b: %v, %v", ok, err)
+		}
+		if ok, err := b.sessions.IsFamilyActive(ctx, user.ID, "family-d"); err != nil || !ok {
+			t.Fatalf("RevokeOthers revoked the kept family: %v, %v", ok, err)
+		}
+
+		must(t, b.sessions.RevokeAllByUser(ctx, user.ID))
+		active, err = b.sessions.GetActiveByUser(ctx, user.ID)
+		must(t, err)
+		if len(active) != 0 {
+			t.Fatalf("GetActiveByUser after RevokeAllByUser = %d sessions", len(active))
+		}
+		if ok, err := b.sessions.IsFamilyActive(ctx, other.ID, "family-o"); err != nil || !ok {
+			t.Fatalf("other user's session revoked: %v, %v", ok, err)
+		}
+	})
+}
+
+func TestUserTokenRepoConformance(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ctx context.Context, b backend) {
+		user := createUser(t, ctx, b, "player")
+		hour := time.Now().Add(time.Hour)
+		create := func(hash string, purpose domain.UserTokenPurpose, expiresAt time.Time) {
+			t.Helper()
+			must(t, b.userTokens.Create(ctx, &domain.UserToken{UserID: user.ID, Purpose: purpose, TokenHash: hash, ExpiresAt: expiresAt}))
+		}
+		create("reset-1", domain.TokenPasswordReset, hour)
+		create("reset-2", domain.TokenPasswordReset, hour)
+		create("expired", domain.TokenPasswordReset, time.Now().Add(-time.Hour))
+		create("verify", domain.TokenEmailVerify, hour)
+		if err := b.userTokens.Create(ctx, &domain.UserToken{UserID: user.ID, Purpose: domain.TokenPasswordReset, TokenHash: "reset-1", ExpiresAt: hour}); err == nil {
+			t.Fatal("duplicate token hash accepted")
+		}
+
+		token, err := b.userTokens.Consume(ctx, domain.TokenPasswordReset, "reset-1")
+		must(t, err)
+		if token.UserID != user.ID || token.UsedAt == nil {
+			t.Fatalf("Consume = %+v", token)
+		}
+		_, err = b.userTokens.Consume(ctx, domain.TokenPasswordReset, "reset-1")
+		mustNotFound(t, err)
+		_, err = b.userTokens.Consume(ctx, domain.TokenPasswordReset, "expired")
+		mustNotFound(t, err)
+		_, err = b.userTokens.Consume(ctx, domain.TokenPasswordReset, "verify")
+		mustNotFound(t, err)
+
+		must(t, b.userTokens.InvalidateByUser(ctx, user.ID, domain.TokenPasswordReset))
+		_, err = b.userTokens.Consume(ctx, domain.TokenPasswordReset, "reset-2")
+		mustNotFound(t, err)
+		_, err = b.userTokens.Consume(ctx, domain.TokenEmailVerify, "verify")
+		must(t, err)
+	})
+}
+
+func TestCourseRepoConformance(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ctx context.Context, b backend) {
+		basics := &domain.Course{Slug: "basics", Title: "Basics", Order: 2, IsActive: true}
+		hidden := &domain.Course{Slug: "hidden", Title: "Hidden", Order: 1}
+		must(t, b.courses.CreateCourse(ctx, basics))
+		must(t, b.courses.CreateCourse(ctx, hidden))
+		if err := b.courses.CreateCourse(ctx, &domain.Course{Slug: "basics", Title: "Copy"}); err == nil {
+			t.Fatal("duplicate course slug accepted")
+		}
+
+		active, err := b.courses.ListCourses(ctx, false)
+		must(t, err)
+		if len(active) != 1 || active[0].ID != basics.ID {
+			t.Fatalf("ListCourses(false) = %+v", active)
+		}
+		all, err := b.courses.ListCourses(ctx, true)
+		must(t, err)
+		if len(all) != 2 || all[0].ID != hidden.ID {
+			t.Fatalf("ListCourses(true) must order by Order: %+v", all)
+		}
+
+		basics.Title = "Money basics"
+		must(t, b.courses.UpdateCourse(ctx, basics))
+		course, err := b.courses.GetCourse(ctx, basics.ID)
+		must(t, err)
+		if course.Title != "Money basics" {
+			t.Fatalf("GetCourse = %+v", course)
+		}
+		_, err = b.courses.GetCourse(ctx, 999)
+		mustNotFound(t, err)
+
+		second := &domain.Unit{CourseID: basics.ID, Title: "Second", Order: 2}
+		first := &domain.Unit{CourseID: basics.ID, Title: "First", Order: 1}
+		must(t, b.courses.CreateUnit(ctx, second))
+		must(t, b.courses.CreateUnit(ctx, first))
+		first.Title = "Intro"
+		must(t, b.courses.UpdateUnit(ctx, first))
+		unit, err := b.courses.GetUnit(ctx, first.ID)
+		must(t, err)
+		if unit.Title != "Intro" {
+			t.Fatalf("GetUnit = %+v", unit)
+		}
+
+		late := createLevel(t, ctx, b, "Late")
+		early := createLevel(t, ctx, b, "Early")
+		must(t, b.courses.PlaceLevel(ctx, late.ID, uintPtr(first.ID), 2))
+		must(t, b.courses.PlaceLevel(ctx, early.ID, uintPtr(first.ID), 1))
+		mustNotFound(t, b.courses.PlaceLevel(ctx, 999, uintPtr(first.ID), 1))
+
+		units, err := b.courses.GetUnits(ctx, basics.ID)
+		must(t, err)
+		if len(units) != 2 || units[0].ID != first.ID {
+			t.Fatalf("GetUnits must order by Order: %+v", units)
+		}
+		if got := levelIDs(levelPtrs(units[0].Levels)); len(got) != 2 || got[0] != early.ID {
+			t.Fatalf("unit levels must order by UnitOrder: %v", got)
+		}
+
+		must(t, b.courses.PlaceLevel(ctx, late.ID, nil, 0))
+		units, err = b.courses.GetUnits(ctx, basics.ID)
+		must(t, err)
+		if len(units[0].Levels) != 1 {
+			t.Fatalf("level not removed from unit: %+v", units[0].Levels)
+		}
+
+		third := createLevel(t, ctx, b, "Third")
+		must(t, b.courses.LockPrerequisites(ctx))
+		must(t, b.courses.ReplacePrerequisites(ctx, third.ID, []*domain.LevelPrerequisite{
+			{RequiredLevelID: late.ID, MinScore: 80},
+			{RequiredLevelID: early.ID, MinScore: 70},
+		}))
+		must(t, b.courses.ReplacePrerequisites(ctx, late.ID, []*domain.LevelPrerequisite{{RequiredLevelID: early.ID, MinScore: 70}}))
+
+		edges, err := b.courses.ListPrerequisites(ctx)
+		must(t, err)
+		if len(edges) != 3 || edges[0].LevelID != late.ID {
+			t.Fatalf("ListPrerequisites = %+v", edges)
+		}
+		edges, err = b.courses.GetPrerequisites(ctx, []uint{third.ID})
+		must(t, err)
+		if len(edges) != 2 || edges[0].RequiredLevelID != late.ID || edges[0].MinScore != 80 {
+			t.Fatalf("GetPrerequisites = %+v", edges)
+		}
+		edges, err = b.courses.GetPrerequisites(ctx, nil)
+		must(t, err)
+		if len(edges) != 0 {
+			t.Fatalf("GetPrerequisites(nil) = %+v", edges)
+		}
+
+		must(t, b.courses.ReplacePrerequisites(ctx, third.ID, nil))
+		edges, err = b.courses.ListPrerequisites(ctx)
+		must(t, err)
+		if len(edges) != 1 {
+			t.Fatalf("ListPrerequisites after clearing = %+v", edges)
+		}
+	})
+}
+
+func TestTxManagerConformance(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ctx context.Context, b backend) {
+		errAbort := errors.New("abort")
+		err := b.tx.WithinTx(ctx, func(ctx context.Context) error {
+			createUser(t, ctx, b, "rolled_back")
+			return errAbort
+		})
+		if !errors.Is(err, errAbort) {
+			t.Fatalf("WithinTx = %v", err)
+		}
+		_, err = b.users.GetByUsername(ctx, "rolled_back")
+		mustNotFound(t, err)
+
+		// Ошибка вложенного вызова откатывает только его часть
+		must(t, b.tx.WithinTx(ctx, func(ctx context.Context) error {
+			createUser(t, ctx, b, "outer")
+			err := b.tx.WithinTx(ctx, func(ctx context.Context) error {
+				createUser(t, ctx, b, "inner")
+				return errAbort
+			})
+			if !errors.Is(err, errAbort) {
+				t.Fatalf("nested WithinTx = %v", err)
+			}
+			_, err = b.users.GetByUsername(ctx, "inner")
+			mustNotFound(t, err)
+			return nil
+		}))
+		_, err = b.users.GetByUsername(ctx, "outer")
+		must(t, err)
+	})
+}
+
+func levelIDs(levels []*domain.Level) []uint {
+	ids := make([]uint, len(levels))
+	for i, level := range levels {
+		ids[i] = level.ID
+	}
+	return ids
+}
+
+func stepIDs(steps []*domain.LevelStep) []uint {
+	ids := make([]uint, len(steps))
+	for i, step := range steps {
+		ids[i] = step.ID
+	}
+	return ids
+}
+
+func levelPtrs(levels []domain.Level) []*domain.Level {
+	ptrs := make([]*domain.Level, len(levels))
+	for i := range levels {
+		ptrs[i] = &levels[i]
+	}
+	return ptrs
+}
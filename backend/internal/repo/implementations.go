@@ -5,9 +5,37 @@ import (
 	"time"
 
 	"github.com/ImCtyz/duofinance/backend/internal/domain"
+	"gorm.io/datatypes"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+type txKey struct{}
+
+type txManager struct {
+	db *gorm.DB
+}
+
+func NewTxManager(db *gorm.DB) TxManager {
+	return &txManager{db: db}
+}
+
+func (m *txManager) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	// Внутри транзакции gorm открывает точку сохранения: ошибка вложенного вызова
+	// откатывает только его часть
+	return dbFor(ctx, m.db).Transaction(func(tx *gorm.DB) error {
+		return fn(context.WithValue(ctx, txKey{}, tx))
+	})
+}
+
+// dbFor - транзакция, открытая TxManager.WithinTx для ctx, или общее подключение
+func dbFor(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx.WithContext(ctx)
+	}
+	return db.WithContext(ctx)
+}
+
 // Заглушки для репозиториев - нужно будет реализовать
 
 type userRepo struct {
@@ -19,12 +47,12 @@ func NewUserRepo(db *gorm.DB) UserRepo {
 }
 
 func (r *userRepo) Create(ctx context.Context, user *domain.User) error {
-	return r.db.WithContext(ctx).Create(user).Error
+	return dbFor(ctx, r.db).Create(user).Error
 }
 
 func (r *userRepo) GetByEmail(ctx context.Context, email string) (*domain.User, error) {
 	var user domain.User
-	err := r.db.WithContext(ctx).Where("email = ?", email).First(&user).Error
+	err := dbFor(ctx, r.db).Where("email = ?", email).First(&user).Error
 	if err != nil {
 		return nil, err
 	}
@@ -33,7 +61,7 @@ func (r *userRepo) GetByEmail(ctx context.Context, email string) (*domain.User,
 
 func (r *userRepo) GetByID(ctx context.Context, id uint) (*domain.User, error) {
 	var user domain.User
-	err := r.db.WithContext(ctx).Where("id = ?", id).First(&user).Error
+	err := dbFor(ctx, r.db).Where("id = ?", id).First(&user).Error
 	if err != nil {
 		return nil, err
 	}
@@ -42,7 +70,7 @@ func (r *userRepo) GetByID(ctx context.Context, id uint) (*domain.User, error) {
 
 func (r *userRepo) GetByUsername(ctx context.Context, username string) (*domain.User, error) {
 	var user domain.User
-	err := r.db.WithContext(ctx).Where("username = ?", username).First(&user).Error
+	err := dbFor(ctx, r.db).Where("username = ?", username).First(&user).Error
 	if err != nil {
 		return nil, err
 	}
@@ -50,12 +78,12 @@ func (r *userRepo) GetByUsername(ctx context.Context, username string) (*domain.
 }
 
 func (r *userRepo) Update(ctx context.Context, user *domain.User) error {
-	return r.db.WithContext(ctx).Save(user).Error
+	return dbFor(ctx, r.db).Save(user).Error
 }
 
 func (r *userRepo) GetProfile(ctx context.Context, userID uint) (*domain.Profile, error) {
 	var profile domain.Profile
-	err := r.db.WithContext(ctx).Where("user_id = ?", userID).First(&profile).Error
+	err := dbFor(ctx, r.db).Where("user_id = ?", userID).First(&profile).Error
 	if err != nil {
 		return nil, err
 	}
@@ -63,18 +91,335 @@ func (r *userRepo) GetProfile(ctx context.Context, userID uint) (*domain.Profile
 }
 
 func (r *userRepo) UpdateProfile(ctx context.Context, profile *domain.Profile) error {
-	return r.db.WithContext(ctx).Save(profile).Error
+	return dbFor(ctx, r.db).Save(profile).Error
 }
 
 func (r *userRepo) GetDiamondsBalance(ctx context.Context, userID uint) (int64, error) {
 	var balance int64
-	err := r.db.WithContext(ctx).Model(&domain.RewardTx{}).
+	err := dbFor(ctx, r.db).Model(&domain.RewardTx{}).
 		Where("user_id = ?", userID).
 		Select("COALESCE(SUM(amount), 0)").
 		Scan(&balance).Error
 	return balance, err
 }
 
+func (r *userRepo) IncrementFailedLogins(ctx context.Context, userID uint, since time.Time) (int, error) {
+	var count int
+	err := dbFor(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		// Счетчик увеличивается атомарно; старые неудачи (до since) не учитываются
+		err := tx.Model(&domain.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"failed_login_attempts": gorm.Expr("CASE WHEN last_failed_login_at IS NULL OR last_failed_login_at < ? THEN 1 ELSE failed_login_attempts + 1 END", since),
+			"last_failed_login_at":  time.Now(),
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&domain.User{}).Select("failed_login_attempts").Where("id = ?", userID).Scan(&count).Error
+	})
+	return count, err
+}
+
+func (r *userRepo) LockUntil(ctx context.Context, userID uint, until time.Time) error {
+	return dbFor(ctx, r.db).Model(&domain.User{}).
+		Where("id = ?", userID).
+		Update("locked_until", until).Error
+}
+
+func (r *userRepo) ResetFailedLogins(ctx context.Context, userID uint) error {
+	return dbFor(ctx, r.db).Model(&domain.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"failed_login_attempts": 0,
+		"last_failed_login_at":  nil,
+		"locked_until":          nil,
+	}).Error
+}
+
+func (r *userRepo) List(ctx context.Context, offset, limit int) ([]*domain.User, int64, error) {
+	var total int64
+	if err := dbFor(ctx, r.db).Model(&domain.User{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var users []*domain.User
+	err := dbFor(ctx, r.db).
+		Order("id ASC").
+		Offset(offset).
+		Limit(limit).
+		Find(&users).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+func (r *userRepo) SetRole(ctx context.Context, userID uint, role domain.UserRole) error {
+	res := dbFor(ctx, r.db).Model(&domain.User{}).
+		Where("id = ?", userID).
+		Update("role", role)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *userRepo) AcceptTOTPStep(ctx context.Context, userID uint, step int64) (bool, error) {
+	res := dbFor(ctx, r.db).Model(&domain.User{}).
+		Where("id = ? AND totp_last_step < ?", userID, step).
+		Update("totp_last_step", step)
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected > 0, nil
+}
+
+type sessionRepo struct {
+	db *gorm.DB
+}
+
+func NewSessionRepo(db *gorm.DB) SessionRepo {
+	return &sessionRepo{db: db}
+}
+
+func (r *sessionRepo) Create(ctx context.Context, session *domain.Session) error {
+	return dbFor(ctx, r.db).Create(session).Error
+}
+
+func (r *sessionRepo) GetByJTI(ctx context.Context, jti string) (*domain.Session, error) {
+	var session domain.Session
+	err := dbFor(ctx, r.db).Where("jti = ?", jti).First(&session).Error
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *sessionRepo) Rotate(ctx context.Context, oldJTI string, next *domain.Session) error {
+	return dbFor(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		// Условное обновление: из двух конкурентных ротаций одного токена пройдет только одна
+		res := tx.Model(&domain.Session{}).
+			Where("jti = ? AND revoked_at IS NULL", oldJTI).
+			Updates(map[string]interface{}{
+				"revoked_at":  time.Now(),
+				"replaced_by": next.JTI,
+			})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrSessionRevoked
+		}
+		return tx.Create(next).Error
+	})
+}
+
+func (r *sessionRepo) GetActiveByUser(ctx context.Context, userID uint) ([]*domain.Session, error) {
+	var sessions []*domain.Session
+	err := dbFor(ctx, r.db).
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_used_at DESC").
+		Find(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (r *sessionRepo) IsFamilyActive(ctx context.Context, userID uint, familyID string) (bool, error) {
+	var count int64
+	err := dbFor(ctx, r.db).Model(&domain.Session{}).
+		Where("user_id = ? AND family_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, familyID, time.Now()).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (r *sessionRepo) RevokeFamily(ctx context.Context, userID uint, familyID string) (int64, error) {
+	res := dbFor(ctx, r.db).Model(&domain.Session{}).
+		Where("user_id = ? AND family_id = ? AND revoked_at IS NULL", userID, familyID).
+		Update("revoked_at", time.Now())
+	return res.RowsAffected, res.Error
+}
+
+func (r *sessionRepo) RevokeOthers(ctx context.Context, userID uint, keepFamilyID string) error {
+	return dbFor(ctx, r.db).Model(&domain.Session{}).
+		Where("user_id = ? AND family_id <> ? AND revoked_at IS NULL", userID, keepFamilyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (r *sessionRepo) RevokeAllByUser(ctx context.Context, userID uint) error {
+	return dbFor(ctx, r.db).Model(&domain.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+type userTokenRepo struct {
+	db *gorm.DB
+}
+
+func NewUserTokenRepo(db *gorm.DB) UserTokenRepo {
+	return &userTokenRepo{db: db}
+}
+
+func (r *userTokenRepo) Create(ctx context.Context, token *domain.UserToken) error {
+	return dbFor(ctx, r.db).Create(token).Error
+}
+
+func (r *userTokenRepo) Consume(ctx context.Context, purpose domain.UserTokenPurpose, tokenHash string) (*domain.UserToken, error) {
+	var token domain.UserToken
+	err := dbFor(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		// Условное обновление гарантирует однократное использование при конкурентных запросах
+		res := tx.Model(&domain.UserToken{}).
+			Where("purpose = ? AND token_hash = ? AND used_at IS NULL AND expires_at > ?", purpose, tokenHash, now).
+			Update("used_at", now)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Where("token_hash = ?", tokenHash).First(&token).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *userTokenRepo) InvalidateByUser(ctx context.Context, userID uint, purpose domain.UserTokenPurpose) error {
+	return dbFor(ctx, r.db).Model(&domain.UserToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Update("used_at", time.Now()).Error
+}
+
+type recoveryCodeRepo struct {
+	db *gorm.DB
+}
+
+func NewRecoveryCodeRepo(db *gorm.DB) RecoveryCodeRepo {
+	return &recoveryCodeRepo{db: db}
+}
+
+func (r *recoveryCodeRepo) Replace(ctx context.Context, userID uint, codeHashes []string) error {
+	return dbFor(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&domain.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		if len(codeHashes) == 0 {
+			return nil
+		}
+		codes := make([]*domain.RecoveryCode, 0, len(codeHashes))
+		for _, hash := range codeHashes {
+			codes = append(codes, &domain.RecoveryCode{UserID: userID, CodeHash: hash})
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+func (r *recoveryCodeRepo) Consume(ctx context.Context, userID uint, codeHash string) error {
+	res := dbFor(ctx, r.db).Model(&domain.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", time.Now())
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *recoveryCodeRepo) DeleteByUser(ctx context.Context, userID uint) error {
+	return dbFor(ctx, r.db).Unscoped().Where("user_id = ?", userID).Delete(&domain.RecoveryCode{}).Error
+}
+
+type identityRepo struct {
+	db *gorm.DB
+}
+
+func NewIdentityRepo(db *gorm.DB) IdentityRepo {
+	return &identityRepo{db: db}
+}
+
+func (r *identityRepo) Create(ctx context.Context, identity *domain.UserIdentity) error {
+	return dbFor(ctx, r.db).Create(identity).Error
+}
+
+func (r *identityRepo) GetByProviderSubject(ctx context.Context, provider, subject string) (*domain.UserIdentity, error) {
+	var identity domain.UserIdentity
+	err := dbFor(ctx, r.db).Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (r *identityRepo) GetByUser(ctx context.Context, userID uint) ([]*domain.UserIdentity, error) {
+	var identities []*domain.UserIdentity
+	err := dbFor(ctx, r.db).
+		Where("user_id = ?", userID).
+		Order("created_at ASC").
+		Find(&identities).Error
+	if err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+func (r *identityRepo) TouchLogin(ctx context.Context, id uint) error {
+	return dbFor(ctx, r.db).Model(&domain.UserIdentity{}).
+		Where("id = ?", id).
+		Update("last_login_at", time.Now()).Error
+}
+
+func (r *identityRepo) Delete(ctx context.Context, userID uint, provider string) error {
+	// Удаляем физически, чтобы освободить уникальные индексы для повторной привязки
+	res := dbFor(ctx, r.db).Unscoped().
+		Where("user_id = ? AND provider = ?", userID, provider).
+		Delete(&domain.UserIdentity{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+type oidcStateRepo struct {
+	db *gorm.DB
+}
+
+func NewOIDCStateRepo(db *gorm.DB) OIDCStateRepo {
+	return &oidcStateRepo{db: db}
+}
+
+func (r *oidcStateRepo) Create(ctx context.Context, state *domain.OIDCState) error {
+	return dbFor(ctx, r.db).Create(state).Error
+}
+
+func (r *oidcStateRepo) Consume(ctx context.Context, state string) (*domain.OIDCState, error) {
+	var record domain.OIDCState
+	err := dbFor(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		res := tx.Model(&domain.OIDCState{}).
+			Where("state = ? AND used_at IS NULL AND expires_at > ?", state, now).
+			Update("used_at", now)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Where("state = ?", state).First(&record).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
 type levelRepo struct {
 	db *gorm.DB
 }
@@ -85,7 +430,7 @@ func NewLevelRepo(db *gorm.DB) LevelRepo {
 
 func (r *levelRepo) GetAll(ctx context.Context) ([]*domain.Level, error) {
 	var levels []*domain.Level
-	err := r.db.WithContext(ctx).
+	err := dbFor(ctx, r.db).
 		Where("is_active = ?", true).
 		Order("id ASC").
 		Find(&levels).Error
@@ -97,7 +442,7 @@ func (r *levelRepo) GetAll(ctx context.Context) ([]*domain.Level, error) {
 
 func (r *levelRepo) GetByID(ctx context.Context, id uint) (*domain.Level, error) {
 	var level domain.Level
-	if err := r.db.WithContext(ctx).First(&level, id).Error; err != nil {
+	if err := dbFor(ctx, r.db).First(&level, id).Error; err != nil {
 		return nil, err
 	}
 	return &level, nil
@@ -105,7 +450,7 @@ func (r *levelRepo) GetByID(ctx context.Context, id uint) (*domain.Level, error)
 
 func (r *levelRepo) GetWithSteps(ctx context.Context, id uint) (*domain.Level, error) {
 	var level domain.Level
-	err := r.db.WithContext(ctx).
+	err := dbFor(ctx, r.db).
 		Preload("Steps", func(db *gorm.DB) *gorm.DB { return db.Order("\"order\" ASC") }).
 		Preload("Steps.Question").
 		Preload("Steps.Question.Choices", func(db *gorm.DB) *gorm.DB { return db.Order("\"order\" ASC") }).
@@ -118,7 +463,7 @@ func (r *levelRepo) GetWithSteps(ctx context.Context, id uint) (*domain.Level, e
 
 func (r *levelRepo) GetByDifficulty(ctx context.Context, difficulty string) ([]*domain.Level, error) {
 	var levels []*domain.Level
-	err := r.db.WithContext(ctx).
+	err := dbFor(ctx, r.db).
 		Where("is_active = ? AND difficulty = ?", true, difficulty).
 		Order("id ASC").
 		Find(&levels).Error
@@ -130,7 +475,7 @@ func (r *levelRepo) GetByDifficulty(ctx context.Context, difficulty string) ([]*
 
 func (r *levelRepo) GetByTopic(ctx context.Context, topic string) ([]*domain.Level, error) {
 	var levels []*domain.Level
-	err := r.db.WithContext(ctx).
+	err := dbFor(ctx, r.db).
 		Where("is_active = ? AND topic = ?", true, topic).
 		Order("id ASC").
 		Find(&levels).Error
@@ -140,6 +485,348 @@ func (r *levelRepo) GetByTopic(ctx context.Context, topic string) ([]*domain.Lev
 	return levels, nil
 }
 
+func (r *levelRepo) ListAll(ctx context.Context, includeDeleted bool) ([]*domain.Level, error) {
+	db := dbFor(ctx, r.db)
+	if includeDeleted {
+		db = db.Unscoped()
+	}
+
+	var levels []*domain.Level
+	if err := db.Order("id ASC").Find(&levels).Error; err != nil {
+		return nil, err
+	}
+	return levels, nil
+}
+
+func (r *levelRepo) GetForEdit(ctx context.Context, id uint, includeDeleted bool) (*domain.Level, error) {
+	db := dbFor(ctx, r.db)
+	if includeDeleted {
+		db = db.Unscoped()
+	}
+
+	var level domain.Level
+	err := db.
+		Preload("Steps", func(db *gorm.DB) *gorm.DB { return db.Where("deleted_at IS NULL").Order("\"order\" ASC") }).
+		First(&level, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &level, nil
+}
+
+func (r *levelRepo) Create(ctx context.Context, level *domain.Level) error {
+	return dbFor(ctx, r.db).Omit("Steps").Create(level).Error
+}
+
+func (r *levelRepo) Update(ctx context.Context, level *domain.Level) error {
+	return dbFor(ctx, r.db).Omit("Steps").Save(level).Error
+}
+
+func (r *levelRepo) Delete(ctx context.Context, id uint) error {
+	res := dbFor(ctx, r.db).Delete(&domain.Level{}, id)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *levelRepo) Restore(ctx context.Context, id uint) error {
+	res := dbFor(ctx, r.db).Unscoped().Model(&domain.Level{}).
+		Where("id = ? AND deleted_at IS NOT NULL", id).
+		Update("deleted_at", nil)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *levelRepo) GetSteps(ctx context.Context, levelID uint, includeDeleted bool) ([]*domain.LevelStep, error) {
+	db := dbFor(ctx, r.db)
+	if includeDeleted {
+		db = db.Unscoped()
+	}
+
+	var steps []*domain.LevelStep
+	err := db.
+		Where("level_id = ?", levelID).
+		Order("\"order\" ASC, id ASC").
+		Find(&steps).Error
+	if err != nil {
+		return nil, err
+	}
+	return steps, nil
+}
+
+func (r *levelRepo) GetStep(ctx context.Context, id uint) (*domain.LevelStep, error) {
+	var step domain.LevelStep
+	if err := dbFor(ctx, r.db).First(&step, id).Error; err != nil {
+		return nil, err
+	}
+	return &step, nil
+}
+
+func (r *levelRepo) MaxStepOrder(ctx context.Context, levelID uint) (int, error) {
+	var max int
+	err := dbFor(ctx, r.db).Model(&domain.LevelStep{}).
+		Where("level_id = ?", levelID).
+		Select("COALESCE(MAX(\"order\"), 0)").
+		Scan(&max).Error
+	return max, err
+}
+
+func (r *levelRepo) IsStepOrderTaken(ctx context.Context, levelID uint, order int, exceptStepID uint) (bool, error) {
+	var count int64
+	err := dbFor(ctx, r.db).Model(&domain.LevelStep{}).
+		Where("level_id = ? AND \"order\" = ? AND id <> ?", levelID, order, exceptStepID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (r *levelRepo) CreateStep(ctx context.Context, step *domain.LevelStep) error {
+	return dbFor(ctx, r.db).Omit("Question").Create(step).Error
+}
+
+func (r *levelRepo) UpdateStep(ctx context.Context, step *domain.LevelStep) error {
+	return dbFor(ctx, r.db).Omit("Question").Save(step).Error
+}
+
+func (r *levelRepo) DeleteStep(ctx context.Context, id uint) error {
+	res := dbFor(ctx, r.db).Delete(&domain.LevelStep{}, id)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *levelRepo) ReorderSteps(ctx context.Context, levelID uint, stepIDs []uint) error {
+	return dbFor(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		// Уникальный индекс (level_id, order) проверяется построчно, поэтому сначала
+		// переносим шаги на отрицательные позиции, затем расставляем итоговые
+		for i, id := range stepIDs {
+			res := tx.Model(&domain.LevelStep{}).
+				Where("id = ? AND level_id = ?", id, levelID).
+				Update("order", -(i + 1))
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				return gorm.ErrRecordNotFound
+			}
+		}
+		for i, id := range stepIDs {
+			if err := tx.Model(&domain.LevelStep{}).Where("id = ?", id).Update("order", i+1).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *levelRepo) PublishRevision(ctx context.Context, revision *domain.LevelRevision) error {
+	return dbFor(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		// Блокируем уровень, чтобы параллельные публикации не получили один номер
+		var level domain.Level
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&level, revision.LevelID).Error; err != nil {
+			return err
+		}
+
+		var last int
+		err := tx.Model(&domain.LevelRevision{}).
+			Where("level_id = ?", revision.LevelID).
+			Select("COALESCE(MAX(number), 0)").
+			Scan(&last).Error
+		if err != nil {
+			return err
+		}
+
+		revision.Number = last + 1
+		if err := tx.Create(revision).Error; err != nil {
+			return err
+		}
+		return tx.Model(&level).UpdateColumn("published_revision_id", revision.ID).Error
+	})
+}
+
+func (r *levelRepo) GetRevisionByID(ctx context.Context, id uint) (*domain.LevelRevision, error) {
+	var revision domain.LevelRevision
+	if err := dbFor(ctx, r.db).First(&revision, id).Error; err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+func (r *levelRepo) GetRevision(ctx context.Context, levelID uint, number int) (*domain.LevelRevision, error) {
+	var revision domain.LevelRevision
+	err := dbFor(ctx, r.db).
+		Where("level_id = ? AND number = ?", levelID, number).
+		First(&revision).Error
+	if err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+func (r *levelRepo) ListRevisions(ctx context.Context, levelID uint) ([]*domain.LevelRevision, error) {
+	var revisions []*domain.LevelRevision
+	err := dbFor(ctx, r.db).
+		Omit("snapshot").
+		Where("level_id = ?", levelID).
+		Order("number DESC").
+		Find(&revisions).Error
+	if err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+type courseRepo struct {
+	db *gorm.DB
+}
+
+func NewCourseRepo(db *gorm.DB) CourseRepo {
+	return &courseRepo{db: db}
+}
+
+func (r *courseRepo) ListCourses(ctx context.Context, includeInactive bool) ([]*domain.Course, error) {
+	db := dbFor(ctx, r.db)
+	if !includeInactive {
+		db = db.Where("is_active = ?", true)
+	}
+
+	var courses []*domain.Course
+	if err := db.Order("\"order\" ASC, id ASC").Find(&courses).Error; err != nil {
+		return nil, err
+	}
+	return courses, nil
+}
+
+func (r *courseRepo) GetCourse(ctx context.Context, id uint) (*domain.Course, error) {
+	var course domain.Course
+	if err := dbFor(ctx, r.db).First(&course, id).Error; err != nil {
+		return nil, err
+	}
+	return &course, nil
+}
+
+func (r *courseRepo) CreateCourse(ctx context.Context, course *domain.Course) error {
+	return dbFor(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Units").Create(course).Error; err != nil {
+			return err
+		}
+		// false совпадает с нулевым значением, и при вставке сработал бы DEFAULT TRUE
+		if !course.IsActive {
+			return tx.Model(course).Update("is_active", false).Error
+		}
+		return nil
+	})
+}
+
+func (r *courseRepo) UpdateCourse(ctx context.Context, course *domain.Course) error {
+	return dbFor(ctx, r.db).Omit("Units").Save(course).Error
+}
+
+func (r *courseRepo) GetUnits(ctx context.Context, courseID uint) ([]*domain.Unit, error) {
+	var units []*domain.Unit
+	err := dbFor(ctx, r.db).
+		Preload("Levels", func(db *gorm.DB) *gorm.DB { return db.Order("unit_order ASC, id ASC") }).
+		Where("course_id = ?", courseID).
+		Order("\"order\" ASC, id ASC").
+		Find(&units).Error
+	if err != nil {
+		return nil, err
+	}
+	return units, nil
+}
+
+func (r *courseRepo) GetUnit(ctx context.Context, id uint) (*domain.Unit, error) {
+	var unit domain.Unit
+	if err := dbFor(ctx, r.db).First(&unit, id).Error; err != nil {
+		return nil, err
+	}
+	return &unit, nil
+}
+
+func (r *courseRepo) CreateUnit(ctx context.Context, unit *domain.Unit) error {
+	return dbFor(ctx, r.db).Omit("Levels").Create(unit).Error
+}
+
+func (r *courseRepo) UpdateUnit(ctx context.Context, unit *domain.Unit) error {
+	return dbFor(ctx, r.db).Omit("Levels").Save(unit).Error
+}
+
+func (r *courseRepo) PlaceLevel(ctx context.Context, levelID uint, unitID *uint, order int) error {
+	res := dbFor(ctx, r.db).Model(&domain.Level{}).
+		Where("id = ?", levelID).
+		Updates(map[string]interface{}{"unit_id": unitID, "unit_order": order})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *courseRepo) ListPrerequisites(ctx context.Context) ([]*domain.LevelPrerequisite, error) {
+	var prerequisites []*domain.LevelPrerequisite
+	err := dbFor(ctx, r.db).
+		Order("level_id ASC, required_level_id ASC").
+		Find(&prerequisites).Error
+	if err != nil {
+		return nil, err
+	}
+	return prerequisites, nil
+}
+
+func (r *courseRepo) LockPrerequisites(ctx context.Context) error {
+	db := dbFor(ctx, r.db)
+	// SQLite (тесты) не знает LOCK TABLE и сам допускает только одного писателя
+	if db.Dialector.Name() != "postgres" {
+		return nil
+	}
+	// Режим конфликтует сам с собой и с любой записью, но не с чтением
+	return db.Exec("LOCK TABLE level_prerequisites IN SHARE ROW EXCLUSIVE MODE").Error
+}
+
+func (r *courseRepo) GetPrerequisites(ctx context.Context, levelIDs []uint) ([]*domain.LevelPrerequisite, error) {
+	var prerequisites []*domain.LevelPrerequisite
+	if len(levelIDs) == 0 {
+		return prerequisites, nil
+	}
+	err := dbFor(ctx, r.db).
+		Where("level_id IN ?", levelIDs).
+		Order("level_id ASC, required_level_id ASC").
+		Find(&prerequisites).Error
+	if err != nil {
+		return nil, err
+	}
+	return prerequisites, nil
+}
+
+func (r *courseRepo) ReplacePrerequisites(ctx context.Context, levelID uint, prerequisites []*domain.LevelPrerequisite) error {
+	return dbFor(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("level_id = ?", levelID).Delete(&domain.LevelPrerequisite{}).Error; err != nil {
+			return err
+		}
+		if len(prerequisites) == 0 {
+			return nil
+		}
+		for _, prerequisite := range prerequisites {
+			prerequisite.LevelID = levelID
+		}
+		return tx.Create(&prerequisites).Error
+	})
+}
+
 type questionRepo struct {
 	db *gorm.DB
 }
@@ -150,7 +837,7 @@ func NewQuestionRepo(db *gorm.DB) QuestionRepo {
 
 func (r *questionRepo) GetByID(ctx context.Context, id uint) (*domain.Question, error) {
 	var question domain.Question
-	err := r.db.WithContext(ctx).First(&question, id).Error
+	err := dbFor(ctx, r.db).First(&question, id).Error
 	if err != nil {
 		return nil, err
 	}
@@ -159,7 +846,7 @@ func (r *questionRepo) GetByID(ctx context.Context, id uint) (*domain.Question,
 
 func (r *questionRepo) GetWithChoices(ctx context.Context, id uint) (*domain.Question, error) {
 	var question domain.Question
-	err := r.db.WithContext(ctx).
+	err := dbFor(ctx, r.db).
 		Preload("Choices", func(db *gorm.DB) *gorm.DB { return db.Order("\"order\" ASC") }).
 		First(&question, id).Error
 	if err != nil {
@@ -170,8 +857,8 @@ func (r *questionRepo) GetWithChoices(ctx context.Context, id uint) (*domain.Que
 
 func (r *questionRepo) GetByLevelID(ctx context.Context, levelID uint) ([]*domain.Question, error) {
 	var questions []*domain.Question
-	err := r.db.WithContext(ctx).
-		Joins("JOIN level_steps ON level_steps.question_id = questions.id").
+	err := dbFor(ctx, r.db).
+		Joins("JOIN level_steps ON level_steps.question_id = questions.id AND level_steps.deleted_at IS NULL").
 		Where("level_steps.level_id = ?", levelID).
 		Preload("Choices", func(db *gorm.DB) *gorm.DB { return db.Order("\"order\" ASC") }).
 		Find(&questions).Error
@@ -183,7 +870,7 @@ func (r *questionRepo) GetByLevelID(ctx context.Context, levelID uint) ([]*domai
 
 func (r *questionRepo) GetByIDs(ctx context.Context, ids []uint) ([]*domain.Question, error) {
 	var questions []*domain.Question
-	err := r.db.WithContext(ctx).
+	err := dbFor(ctx, r.db).
 		Where("id IN ?", ids).
 		Preload("Choices", func(db *gorm.DB) *gorm.DB { return db.Order("\"order\" ASC") }).
 		Find(&questions).Error
@@ -193,6 +880,71 @@ func (r *questionRepo) GetByIDs(ctx context.Context, ids []uint) ([]*domain.Ques
 	return questions, nil
 }
 
+func (r *questionRepo) Create(ctx context.Context, question *domain.Question) error {
+	return dbFor(ctx, r.db).Create(question).Error
+}
+
+func (r *questionRepo) Update(ctx context.Context, question *domain.Question) error {
+	return dbFor(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Choices").Save(question).Error; err != nil {
+			return err
+		}
+		// Оставшиеся варианты удаляются, если вопрос больше их не хранит
+		if !question.StoresChoices() {
+			return tx.Where("question_id = ?", question.ID).Delete(&domain.Choice{}).Error
+		}
+		return nil
+	})
+}
+
+func (r *questionRepo) Delete(ctx context.Context, id uint) error {
+	return dbFor(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		res := tx.Delete(&domain.Question{}, id)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Where("question_id = ?", id).Delete(&domain.Choice{}).Error
+	})
+}
+
+func (r *questionRepo) IsReferenced(ctx context.Context, questionID uint) (bool, error) {
+	var count int64
+	err := dbFor(ctx, r.db).Model(&domain.LevelStep{}).
+		Where("question_id = ?", questionID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (r *questionRepo) GetChoice(ctx context.Context, id uint) (*domain.Choice, error) {
+	var choice domain.Choice
+	if err := dbFor(ctx, r.db).First(&choice, id).Error; err != nil {
+		return nil, err
+	}
+	return &choice, nil
+}
+
+func (r *questionRepo) CreateChoice(ctx context.Context, choice *domain.Choice) error {
+	return dbFor(ctx, r.db).Create(choice).Error
+}
+
+func (r *questionRepo) UpdateChoice(ctx context.Context, choice *domain.Choice) error {
+	return dbFor(ctx, r.db).Save(choice).Error
+}
+
+func (r *questionRepo) DeleteChoice(ctx context.Context, id uint) error {
+	res := dbFor(ctx, r.db).Delete(&domain.Choice{}, id)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
 type attemptRepo struct {
 	db *gorm.DB
 }
@@ -202,12 +954,12 @@ func NewAttemptRepo(db *gorm.DB) AttemptRepo {
 }
 
 func (r *attemptRepo) Create(ctx context.Context, attempt *domain.Attempt) error {
-	return r.db.WithContext(ctx).Create(attempt).Error
+	return dbFor(ctx, r.db).Create(attempt).Error
 }
 
 func (r *attemptRepo) GetByID(ctx context.Context, id uint) (*domain.Attempt, error) {
 	var attempt domain.Attempt
-	err := r.db.WithContext(ctx).
+	err := dbFor(ctx, r.db).
 		Preload("Steps", func(db *gorm.DB) *gorm.DB { return db.Order("step_order ASC") }).
 		First(&attempt, id).Error
 	if err != nil {
@@ -218,7 +970,7 @@ func (r *attemptRepo) GetByID(ctx context.Context, id uint) (*domain.Attempt, er
 
 func (r *attemptRepo) GetActiveByUserAndLevel(ctx context.Context, userID, levelID uint) (*domain.Attempt, error) {
 	var attempt domain.Attempt
-	err := r.db.WithContext(ctx).
+	err := dbFor(ctx, r.db).
 		Where("user_id = ? AND level_id = ? AND status = ?", userID, levelID, "in_progress").
 		First(&attempt).Error
 	if err != nil {
@@ -229,7 +981,7 @@ func (r *attemptRepo) GetActiveByUserAndLevel(ctx context.Context, userID, level
 
 func (r *attemptRepo) GetByUserID(ctx context.Context, userID uint) ([]*domain.Attempt, error) {
 	var attempts []*domain.Attempt
-	err := r.db.WithContext(ctx).
+	err := dbFor(ctx, r.db).
 		Where("user_id = ?", userID).
 		Order("started_at DESC").
 		Find(&attempts).Error
@@ -240,16 +992,37 @@ func (r *attemptRepo) GetByUserID(ctx context.Context, userID uint) ([]*domain.A
 }
 
 func (r *attemptRepo) Update(ctx context.Context, attempt *domain.Attempt) error {
-	return r.db.WithContext(ctx).Save(attempt).Error
+	return dbFor(ctx, r.db).Save(attempt).Error
+}
+
+func (r *attemptRepo) Complete(ctx context.Context, attempt *domain.Attempt) error {
+	// Условное обновление: из двух конкурентных завершений одной попытки пройдет только одно
+	res := dbFor(ctx, r.db).Model(&domain.Attempt{}).
+		Where("id = ? AND status = ?", attempt.ID, domain.AttemptInProgress).
+		Updates(map[string]interface{}{
+			"status":         attempt.Status,
+			"result_score":   attempt.ResultScore,
+			"passed":         attempt.Passed,
+			"completed_at":   attempt.CompletedAt,
+			"served_step_id": nil,
+			"served_at":      nil,
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrAttemptNotInProgress
+	}
+	return nil
 }
 
 func (r *attemptRepo) AddStep(ctx context.Context, step *domain.AttemptStep) error {
-	return r.db.WithContext(ctx).Create(step).Error
+	return dbFor(ctx, r.db).Create(step).Error
 }
 
 func (r *attemptRepo) GetSteps(ctx context.Context, attemptID uint) ([]*domain.AttemptStep, error) {
 	var steps []*domain.AttemptStep
-	err := r.db.WithContext(ctx).
+	err := dbFor(ctx, r.db).
 		Where("attempt_id = ?", attemptID).
 		Order("step_order ASC").
 		Find(&steps).Error
@@ -261,7 +1034,7 @@ func (r *attemptRepo) GetSteps(ctx context.Context, attemptID uint) ([]*domain.A
 
 func (r *attemptRepo) GetNextUnansweredStep(ctx context.Context, attemptID uint) (*domain.AttemptStep, error) {
 	var step domain.AttemptStep
-	err := r.db.WithContext(ctx).
+	err := dbFor(ctx, r.db).
 		Where("attempt_id = ? AND response IS NULL", attemptID).
 		Order("step_order ASC").
 		First(&step).Error
@@ -271,6 +1044,60 @@ func (r *attemptRepo) GetNextUnansweredStep(ctx context.Context, attemptID uint)
 	return &step, nil
 }
 
+func (r *attemptRepo) GetBestScores(ctx context.Context, userID uint) (map[uint]int, error) {
+	var rows []struct {
+		LevelID   uint
+		BestScore int
+	}
+	err := dbFor(ctx, r.db).Model(&domain.Attempt{}).
+		Select("level_id, MAX(result_score) AS best_score").
+		Where("user_id = ? AND status = ?", userID, domain.AttemptCompleted).
+		Group("level_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	scores := make(map[uint]int, len(rows))
+	for _, row := range rows {
+		scores[row.LevelID] = row.BestScore
+	}
+	return scores, nil
+}
+
+func (r *attemptRepo) SetServed(ctx context.Context, attemptID uint, levelStepID *uint, servedAt *time.Time) error {
+	return dbFor(ctx, r.db).Model(&domain.Attempt{}).
+		Where("id = ?", attemptID).
+		Updates(map[string]interface{}{"served_step_id": levelStepID, "served_at": servedAt}).Error
+}
+
+func (r *attemptRepo) GetQuestionAnswers(ctx context.Context, questionIDs []uint) ([]*QuestionAnswer, error) {
+	var answers []*QuestionAnswer
+	if len(questionIDs) == 0 {
+		return answers, nil
+	}
+	err := dbFor(ctx, r.db).Table("attempt_steps AS s").
+		Select("s.attempt_id, s.question_id, s.step_order, s.correct, s.score, s.duration_ms, s.response, "+
+			"a.status AS attempt_status, a.result_score AS attempt_score").
+		Joins("JOIN attempts a ON a.id = s.attempt_id AND a.deleted_at IS NULL").
+		Where("s.question_id IN ? AND s.deleted_at IS NULL", questionIDs).
+		Order("s.attempt_id, s.step_order").
+		Scan(&answers).Error
+	return answers, err
+}
+
+func (r *attemptRepo) GetPassedLevelIDs(ctx context.Context, userID uint) ([]uint, error) {
+	var levelIDs []uint
+	err := dbFor(ctx, r.db).Model(&domain.Attempt{}).
+		Where("user_id = ? AND passed = ?", userID, true).
+		Distinct().
+		Pluck("level_id", &levelIDs).Error
+	if err != nil {
+		return nil, err
+	}
+	return levelIDs, nil
+}
+
 type rewardTxRepo struct {
 	db *gorm.DB
 }
@@ -280,18 +1107,18 @@ func NewRewardTxRepo(db *gorm.DB) RewardTxRepo {
 }
 
 func (r *rewardTxRepo) Create(ctx context.Context, tx *domain.RewardTx) error {
-	return r.db.WithContext(ctx).Create(tx).Error
+	return dbFor(ctx, r.db).Create(tx).Error
 }
 
 func (r *rewardTxRepo) GetByUserID(ctx context.Context, userID uint) ([]*domain.RewardTx, error) {
 	var transactions []*domain.RewardTx
-	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Find(&transactions).Error
+	err := dbFor(ctx, r.db).Where("user_id = ?", userID).Find(&transactions).Error
 	return transactions, err
 }
 
 func (r *rewardTxRepo) GetBalance(ctx context.Context, userID uint) (int64, error) {
 	var balance int64
-	err := r.db.WithContext(ctx).Model(&domain.RewardTx{}).
+	err := dbFor(ctx, r.db).Model(&domain.RewardTx{}).
 		Select("COALESCE(SUM(amount), 0)").
 		Where("user_id = ?", userID).
 		Scan(&balance).Error
@@ -300,13 +1127,66 @@ func (r *rewardTxRepo) GetBalance(ctx context.Context, userID uint) (int64, erro
 
 func (r *rewardTxRepo) GetByType(ctx context.Context, userID uint, txType string) ([]*domain.RewardTx, error) {
 	var transactions []*domain.RewardTx
-	err := r.db.WithContext(ctx).
+	err := dbFor(ctx, r.db).
 		Where("user_id = ? AND type = ?", userID, txType).
 		Order("created_at DESC").
 		Find(&transactions).Error
 	return transactions, err
 }
 
+type idempotencyRepo struct {
+	db *gorm.DB
+}
+
+func NewIdempotencyRepo(db *gorm.DB) IdempotencyRepo {
+	return &idempotencyRepo{db: db}
+}
+
+func (r *idempotencyRepo) Reserve(ctx context.Context, key *domain.IdempotencyKey) (*domain.IdempotencyKey, bool, error) {
+	var existing domain.IdempotencyKey
+	created := false
+	err := dbFor(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		err := tx.Unscoped().
+			Where("user_id = ? AND key = ? AND expires_at <= ?", key.UserID, key.Key, time.Now()).
+			Delete(&domain.IdempotencyKey{}).Error
+		if err != nil {
+			return err
+		}
+
+		// Из двух конкурентных запросов с одним ключом вставка пройдет только у одного
+		res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(key)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected > 0 {
+			created = true
+			return nil
+		}
+		return tx.Where("user_id = ? AND key = ?", key.UserID, key.Key).First(&existing).Error
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if created {
+		return key, true, nil
+	}
+	return &existing, false, nil
+}
+
+func (r *idempotencyRepo) Complete(ctx context.Context, id uint, statusCode int, response []byte) error {
+	return dbFor(ctx, r.db).Model(&domain.IdempotencyKey{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status_code":  statusCode,
+			"response":     datatypes.JSON(response),
+			"completed_at": time.Now(),
+		}).Error
+}
+
+func (r *idempotencyRepo) Delete(ctx context.Context, id uint) error {
+	return dbFor(ctx, r.db).Unscoped().Delete(&domain.IdempotencyKey{}, id).Error
+}
+
 type achievementRepo struct {
 	db *gorm.DB
 }
@@ -317,7 +1197,7 @@ func NewAchievementRepo(db *gorm.DB) AchievementRepo {
 
 func (r *achievementRepo) GetAll(ctx context.Context) ([]*domain.Achievement, error) {
 	var achievements []*domain.Achievement
-	err := r.db.WithContext(ctx).
+	err := dbFor(ctx, r.db).
 		Order("id ASC").
 		Find(&achievements).Error
 	if err != nil {
@@ -328,7 +1208,7 @@ func (r *achievementRepo) GetAll(ctx context.Context) ([]*domain.Achievement, er
 
 func (r *achievementRepo) GetByCode(ctx context.Context, code string) (*domain.Achievement, error) {
 	var achievement domain.Achievement
-	err := r.db.WithContext(ctx).Where("code = ?", code).First(&achievement).Error
+	err := dbFor(ctx, r.db).Where("code = ?", code).First(&achievement).Error
 	if err != nil {
 		return nil, err
 	}
@@ -337,10 +1217,10 @@ func (r *achievementRepo) GetByCode(ctx context.Context, code string) (*domain.A
 
 func (r *achievementRepo) GetByUserID(ctx context.Context, userID uint) ([]*domain.Achievement, error) {
 	var achievements []*domain.Achievement
-	err := r.db.WithContext(ctx).
+	err := dbFor(ctx, r.db).
 		Joins("JOIN user_achievements ON user_achievements.achievement_id = achievements.id").
 		Where("user_achievements.user_id = ?", userID).
-		Order("user_achievements.awarded_at DESC").
+		Order("user_achievements.awarded_at DESC, user_achievements.id DESC").
 		Find(&achievements).Error
 	if err != nil {
 		return nil, err
@@ -354,12 +1234,12 @@ func (r *achievementRepo) AwardToUser(ctx context.Context, userID, achievementID
 		AchievementID: achievementID,
 		AwardedAt:     time.Now(),
 	}
-	return r.db.WithContext(ctx).Create(userAchievement).Error
+	return dbFor(ctx, r.db).Create(userAchievement).Error
 }
 
 func (r *achievementRepo) HasAchievement(ctx context.Context, userID, achievementID uint) (bool, error) {
 	var count int64
-	err := r.db.WithContext(ctx).Model(&domain.UserAchievement{}).
+	err := dbFor(ctx, r.db).Model(&domain.UserAchievement{}).
 		Where("user_id = ? AND achievement_id = ?", userID, achievementID).
 		Count(&count).Error
 	return count > 0, err
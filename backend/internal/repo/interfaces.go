@@ -2,10 +2,27 @@ package repo
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"github.com/ImCtyz/duofinance/backend/internal/domain"
+	"gorm.io/datatypes"
 )
 
+// ErrSessionRevoked - сессия уже отозвана (в том числе ротирована конкурентным запросом)
+var ErrSessionRevoked = errors.New("session revoked")
+
+// ErrAttemptNotInProgress - попытка уже завершена (в том числе конкурентным запросом)
+var ErrAttemptNotInProgress = errors.New("attempt is not in progress")
+
+// TxManager - единица работы над несколькими репозиториями
+type TxManager interface {
+	// Выполнить fn в транзакции: все репозитории, вызванные с ctx из fn, работают в ней.
+	// Ошибка fn откатывает транзакцию; вложенный вызов выполняется в той же транзакции
+	// и при ошибке откатывает только свою часть
+	WithinTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
 // UserRepo - интерфейс для работы с пользователями
 type UserRepo interface {
 	// Создать нового пользователя
@@ -31,6 +48,105 @@ type UserRepo interface {
 
 	// Получить баланс алмазов пользователя
 	GetDiamondsBalance(ctx context.Context, userID uint) (int64, error)
+
+	// Зарегистрировать неудачный вход; неудачи до since забываются. Возвращает текущее число неудач подряд
+	IncrementFailedLogins(ctx context.Context, userID uint, since time.Time) (int, error)
+
+	// Заблокировать вход до указанного времени
+	LockUntil(ctx context.Context, userID uint, until time.Time) error
+
+	// Сбросить счетчик неудачных входов и блокировку
+	ResetFailedLogins(ctx context.Context, userID uint) error
+
+	// Получить страницу пользователей (по id) и их общее число
+	List(ctx context.Context, offset, limit int) ([]*domain.User, int64, error)
+
+	// Изменить роль пользователя
+	SetRole(ctx context.Context, userID uint, role domain.UserRole) error
+
+	// Атомарно запомнить принятый шаг TOTP; false, если код этого или более позднего шага уже использован
+	AcceptTOTPStep(ctx context.Context, userID uint, step int64) (bool, error)
+}
+
+// SessionRepo - интерфейс для работы с сессиями (выданными refresh токенами)
+type SessionRepo interface {
+	// Создать запись о выданном refresh токене
+	Create(ctx context.Context, session *domain.Session) error
+
+	// Получить сессию по jti refresh токена
+	GetByJTI(ctx context.Context, jti string) (*domain.Session, error)
+
+	// Атомарно отозвать токен oldJTI и сохранить выданный вместо него next.
+	// Возвращает ErrSessionRevoked, если oldJTI уже отозван.
+	Rotate(ctx context.Context, oldJTI string, next *domain.Session) error
+
+	// Получить действующие (не отозванные и не истекшие) токены пользователя — по одному на цепочку
+	GetActiveByUser(ctx context.Context, userID uint) ([]*domain.Session, error)
+
+	// Проверить, что в цепочке пользователя есть действующий токен
+	IsFamilyActive(ctx context.Context, userID uint, familyID string) (bool, error)
+
+	// Отозвать все токены цепочки пользователя; возвращает число отозванных записей
+	RevokeFamily(ctx context.Context, userID uint, familyID string) (int64, error)
+
+	// Отозвать все токены пользователя, кроме цепочки keepFamilyID
+	RevokeOthers(ctx context.Context, userID uint, keepFamilyID string) error
+
+	// Отозвать все токены пользователя
+	RevokeAllByUser(ctx context.Context, userID uint) error
+}
+
+// UserTokenRepo - интерфейс для работы с одноразовыми токенами пользователей
+type UserTokenRepo interface {
+	// Создать токен
+	Create(ctx context.Context, token *domain.UserToken) error
+
+	// Атомарно погасить действующий токен по хешу; gorm.ErrRecordNotFound, если он не найден,
+	// уже использован или истек
+	Consume(ctx context.Context, purpose domain.UserTokenPurpose, tokenHash string) (*domain.UserToken, error)
+
+	// Погасить все неиспользованные токены пользователя с указанным назначением
+	InvalidateByUser(ctx context.Context, userID uint, purpose domain.UserTokenPurpose) error
+}
+
+// RecoveryCodeRepo - интерфейс для работы с кодами восстановления 2FA
+type RecoveryCodeRepo interface {
+	// Заменить все коды пользователя новыми (по хешам)
+	Replace(ctx context.Context, userID uint, codeHashes []string) error
+
+	// Атомарно погасить неиспользованный код; gorm.ErrRecordNotFound, если такого нет
+	Consume(ctx context.Context, userID uint, codeHash string) error
+
+	// Удалить все коды пользователя
+	DeleteByUser(ctx context.Context, userID uint) error
+}
+
+// IdentityRepo - интерфейс для работы с внешними аккаунтами пользователей
+type IdentityRepo interface {
+	// Привязать внешний аккаунт
+	Create(ctx context.Context, identity *domain.UserIdentity) error
+
+	// Получить привязку по провайдеру и subject
+	GetByProviderSubject(ctx context.Context, provider, subject string) (*domain.UserIdentity, error)
+
+	// Получить все привязки пользователя
+	GetByUser(ctx context.Context, userID uint) ([]*domain.UserIdentity, error)
+
+	// Отметить вход через внешний аккаунт
+	TouchLogin(ctx context.Context, id uint) error
+
+	// Отвязать провайдера; gorm.ErrRecordNotFound, если привязки нет
+	Delete(ctx context.Context, userID uint, provider string) error
+}
+
+// OIDCStateRepo - интерфейс для работы с незавершенными входами через OIDC
+type OIDCStateRepo interface {
+	// Сохранить state
+	Create(ctx context.Context, state *domain.OIDCState) error
+
+	// Атомарно погасить действующий state; gorm.ErrRecordNotFound, если он не найден,
+	// уже использован или истек
+	Consume(ctx context.Context, state string) (*domain.OIDCState, error)
 }
 
 // LevelRepo - интерфейс для работы с уровнями/уроками
@@ -49,6 +165,104 @@ type LevelRepo interface {
 
 	// Получить уровни по теме
 	GetByTopic(ctx context.Context, topic string) ([]*domain.Level, error)
+
+	// Получить все уровни, включая неактивные (и удаленные при includeDeleted)
+	ListAll(ctx context.Context, includeDeleted bool) ([]*domain.Level, error)
+
+	// Получить уровень для редактирования (удаленный — при includeDeleted)
+	GetForEdit(ctx context.Context, id uint, includeDeleted bool) (*domain.Level, error)
+
+	// Создать уровень
+	Create(ctx context.Context, level *domain.Level) error
+
+	// Обновить поля уровня (без шагов)
+	Update(ctx context.Context, level *domain.Level) error
+
+	// Мягко удалить уровень; gorm.ErrRecordNotFound, если его нет
+	Delete(ctx context.Context, id uint) error
+
+	// Восстановить мягко удаленный уровень; gorm.ErrRecordNotFound, если он не удален
+	Restore(ctx context.Context, id uint) error
+
+	// Получить шаги уровня по порядку (удаленные — при includeDeleted)
+	GetSteps(ctx context.Context, levelID uint, includeDeleted bool) ([]*domain.LevelStep, error)
+
+	// Получить шаг по ID
+	GetStep(ctx context.Context, id uint) (*domain.LevelStep, error)
+
+	// Наибольший порядковый номер шага уровня (0, если шагов нет)
+	MaxStepOrder(ctx context.Context, levelID uint) (int, error)
+
+	// Занят ли порядковый номер другим неудаленным шагом уровня
+	IsStepOrderTaken(ctx context.Context, levelID uint, order int, exceptStepID uint) (bool, error)
+
+	// Создать шаг
+	CreateStep(ctx context.Context, step *domain.LevelStep) error
+
+	// Обновить шаг
+	UpdateStep(ctx context.Context, step *domain.LevelStep) error
+
+	// Мягко удалить шаг; gorm.ErrRecordNotFound, если его нет
+	DeleteStep(ctx context.Context, id uint) error
+
+	// Атомарно присвоить шагам stepIDs порядковые номера 1..n
+	ReorderSteps(ctx context.Context, levelID uint, stepIDs []uint) error
+
+	// Сохранить ревизию со следующим номером и сделать ее опубликованной
+	PublishRevision(ctx context.Context, revision *domain.LevelRevision) error
+
+	// Получить ревизию по ID
+	GetRevisionByID(ctx context.Context, id uint) (*domain.LevelRevision, error)
+
+	// Получить ревизию уровня по номеру
+	GetRevision(ctx context.Context, levelID uint, number int) (*domain.LevelRevision, error)
+
+	// История ревизий уровня, новые первыми (без снимков)
+	ListRevisions(ctx context.Context, levelID uint) ([]*domain.LevelRevision, error)
+}
+
+// CourseRepo - интерфейс для работы с курсами, юнитами и графом зависимостей уровней
+type CourseRepo interface {
+	// Получить курсы по порядку (неактивные — при includeInactive)
+	ListCourses(ctx context.Context, includeInactive bool) ([]*domain.Course, error)
+
+	// Получить курс по ID
+	GetCourse(ctx context.Context, id uint) (*domain.Course, error)
+
+	// Создать курс
+	CreateCourse(ctx context.Context, course *domain.Course) error
+
+	// Обновить поля курса (без юнитов)
+	UpdateCourse(ctx context.Context, course *domain.Course) error
+
+	// Получить юниты курса по порядку вместе с уровнями (по порядку внутри юнита)
+	GetUnits(ctx context.Context, courseID uint) ([]*domain.Unit, error)
+
+	// Получить юнит по ID
+	GetUnit(ctx context.Context, id uint) (*domain.Unit, error)
+
+	// Создать юнит
+	CreateUnit(ctx context.Context, unit *domain.Unit) error
+
+	// Обновить поля юнита (без уровней)
+	UpdateUnit(ctx context.Context, unit *domain.Unit) error
+
+	// Поместить уровень в юнит на позицию order (unitID nil — убрать из курса);
+	// gorm.ErrRecordNotFound, если уровня нет
+	PlaceLevel(ctx context.Context, levelID uint, unitID *uint, order int) error
+
+	// Все ребра графа зависимостей
+	ListPrerequisites(ctx context.Context) ([]*domain.LevelPrerequisite, error)
+
+	// Заблокировать граф зависимостей от изменений до конца транзакции ctx;
+	// чтение графа другими транзакциями не блокируется
+	LockPrerequisites(ctx context.Context) error
+
+	// Зависимости уровней levelIDs
+	GetPrerequisites(ctx context.Context, levelIDs []uint) ([]*domain.LevelPrerequisite, error)
+
+	// Атомарно заменить все зависимости уровня
+	ReplacePrerequisites(ctx context.Context, levelID uint, prerequisites []*domain.LevelPrerequisite) error
 }
 
 // QuestionRepo - интерфейс для работы с вопросами
@@ -64,6 +278,30 @@ type QuestionRepo interface {
 
 	// Получить вопросы по ID списку
 	GetByIDs(ctx context.Context, ids []uint) ([]*domain.Question, error)
+
+	// Создать вопрос вместе с вариантами ответов
+	Create(ctx context.Context, question *domain.Question) error
+
+	// Обновить поля вопроса (без вариантов)
+	Update(ctx context.Context, question *domain.Question) error
+
+	// Мягко удалить вопрос; gorm.ErrRecordNotFound, если его нет
+	Delete(ctx context.Context, id uint) error
+
+	// Используется ли вопрос неудаленными шагами
+	IsReferenced(ctx context.Context, questionID uint) (bool, error)
+
+	// Получить вариант ответа по ID
+	GetChoice(ctx context.Context, id uint) (*domain.Choice, error)
+
+	// Создать вариант ответа
+	CreateChoice(ctx context.Context, choice *domain.Choice) error
+
+	// Обновить вариант ответа
+	UpdateChoice(ctx context.Context, choice *domain.Choice) error
+
+	// Мягко удалить вариант ответа; gorm.ErrRecordNotFound, если его нет
+	DeleteChoice(ctx context.Context, id uint) error
 }
 
 // AttemptRepo - интерфейс для работы с попытками прохождения
@@ -83,6 +321,9 @@ type AttemptRepo interface {
 	// Обновить попытку
 	Update(ctx context.Context, attempt *domain.Attempt) error
 
+	// Сохранить итог попытки, если она еще в статусе in_progress (иначе ErrAttemptNotInProgress)
+	Complete(ctx context.Context, attempt *domain.Attempt) error
+
 	// Добавить шаг к попытке
 	AddStep(ctx context.Context, step *domain.AttemptStep) error
 
@@ -91,6 +332,31 @@ type AttemptRepo interface {
 
 	// Получить следующий неотвеченный шаг
 	GetNextUnansweredStep(ctx context.Context, attemptID uint) (*domain.AttemptStep, error)
+
+	// Лучший результат завершенных попыток пользователя по каждому уровню
+	GetBestScores(ctx context.Context, userID uint) (map[uint]int, error)
+
+	// ID уровней, у которых есть пройденная (Passed) попытка пользователя
+	GetPassedLevelIDs(ctx context.Context, userID uint) ([]uint, error)
+
+	// Запомнить показанный шаг уровня (nil — сбросить)
+	SetServed(ctx context.Context, attemptID uint, levelStepID *uint, servedAt *time.Time) error
+
+	// Все ответы на вопросы questionIDs с итогом попытки, по попыткам и порядку шагов
+	GetQuestionAnswers(ctx context.Context, questionIDs []uint) ([]*QuestionAnswer, error)
+}
+
+// QuestionAnswer - ответ на вопрос вместе со статусом и баллом попытки (для аналитики)
+type QuestionAnswer struct {
+	AttemptID     uint
+	QuestionID    uint
+	StepOrder     int
+	Correct       bool
+	Score         float64
+	DurationMs    int64
+	Response      datatypes.JSON
+	AttemptStatus domain.AttemptStatus
+	AttemptScore  int
 }
 
 // RewardTxRepo - интерфейс для работы с транзакциями наград
@@ -108,6 +374,19 @@ type RewardTxRepo interface {
 	GetByType(ctx context.Context, userID uint, txType string) ([]*domain.RewardTx, error)
 }
 
+// IdempotencyRepo - интерфейс для работы с ключами идемпотентности
+type IdempotencyRepo interface {
+	// Занять ключ пользователя. Если действующий ключ уже есть, возвращает его и false;
+	// истекший ключ заменяется новым
+	Reserve(ctx context.Context, key *domain.IdempotencyKey) (*domain.IdempotencyKey, bool, error)
+
+	// Сохранить ответ на запрос
+	Complete(ctx context.Context, id uint, statusCode int, response []byte) error
+
+	// Освободить ключ, чтобы запрос можно было повторить
+	Delete(ctx context.Context, id uint) error
+}
+
 // AchievementRepo - интерфейс для работы с достижениями
 type AchievementRepo interface {
 	// Получить все достижения
@@ -0,0 +1,1725 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ImCtyz/duofinance/backend/internal/domain"
+	"github.com/ImCtyz/duofinance/backend/internal/repo"
+	"gorm.io/gorm"
+)
+
+var (
+	errNotFound  = gorm.ErrRecordNotFound
+	errDuplicate = gorm.ErrDuplicatedKey
+)
+
+// defaultSlug - slug записи контента, созданной без него (как хуки AfterCreate в domain)
+func defaultSlug(slug *string, kind string, id uint) {
+	if *slug == "" {
+		*slug = fmt.Sprintf("%s-%d", kind, id)
+	}
+}
+
+// UserRepo
+
+type userRepo struct {
+	store *Store
+}
+
+// NewUserRepo - репозиторий пользователей в памяти
+func NewUserRepo(store *Store) repo.UserRepo {
+	return &userRepo{store: store}
+}
+
+// stripUser - пользователь без связей, в таком виде он хранится в таблице
+func stripUser(user domain.User) domain.User {
+	user.Profile = domain.Profile{}
+	user.Attempts = nil
+	user.Achievements = nil
+	user.RewardTxs = nil
+	user.Hints = nil
+	user.Reminders = nil
+	return user
+}
+
+func userConflict(t *tables, user *domain.User) bool {
+	for _, existing := range t.users {
+		if existing.ID == user.ID {
+			continue
+		}
+		if existing.Email == user.Email || existing.Username == user.Username {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *userRepo) Create(ctx context.Context, user *domain.User) error {
+	return r.store.write(ctx, func(t *tables) error {
+		if userConflict(t, user) {
+			return errDuplicate
+		}
+		if user.Role == "" {
+			user.Role = domain.RoleLearner
+		}
+		r.store.insert(&user.Model, "users")
+		t.users[user.ID] = stripUser(*user)
+		return nil
+	})
+}
+
+func (r *userRepo) findUser(ctx context.Context, match func(u *domain.User) bool) (*domain.User, error) {
+	var found *domain.User
+	err := r.store.read(ctx, func(t *tables) error {
+		for _, id := range sortedIDs(t.users) {
+			user := t.users[id]
+			if !user.DeletedAt.Valid && match(&user) {
+				found = &user
+				return nil
+			}
+		}
+		return errNotFound
+	})
+	return found, err
+}
+
+func (r *userRepo) GetByEmail(ctx context.Context, email string) (*domain.User, error) {
+	return r.findUser(ctx, func(u *domain.User) bool { return u.Email == email })
+}
+
+func (r *userRepo) GetByID(ctx context.Context, id uint) (*domain.User, error) {
+	return r.findUser(ctx, func(u *domain.User) bool { return u.ID == id })
+}
+
+func (r *userRepo) GetByUsername(ctx context.Context, username string) (*domain.User, error) {
+	return r.findUser(ctx, func(u *domain.User) bool { return u.Username == username })
+}
+
+func (r *userRepo) Update(ctx context.Context, user *domain.User) error {
+	return r.store.write(ctx, func(t *tables) error {
+		if userConflict(t, user) {
+			return errDuplicate
+		}
+		r.store.save(&user.Model, "users")
+		t.users[user.ID] = stripUser(*user)
+		return nil
+	})
+}
+
+// updateUser - изменение полей существующего пользователя (Updates без ошибки для отсутствующего)
+func (r *userRepo) updateUser(ctx context.Context, userID uint, fn func(u *domain.User)) error {
+	return r.store.write(ctx, func(t *tables) error {
+		user, ok := t.users[userID]
+		if !ok || user.DeletedAt.Valid {
+			return nil
+		}
+		fn(&user)
+		user.UpdatedAt = r.store.now()
+		t.users[userID] = user
+		return nil
+	})
+}
+
+func (r *userRepo) GetProfile(ctx context.Context, userID uint) (*domain.Profile, error) {
+	var found *domain.Profile
+	err := r.store.read(ctx, func(t *tables) error {
+		for _, id := range sortedIDs(t.profiles) {
+			profile := t.profiles[id]
+			if !profile.DeletedAt.Valid && profile.UserID == userID {
+				found = &profile
+				return nil
+			}
+		}
+		return errNotFound
+	})
+	return found, err
+}
+
+func (r *userRepo) UpdateProfile(ctx context.Context, profile *domain.Profile) error {
+	return r.store.write(ctx, func(t *tables) error {
+		for _, existing := range t.profiles {
+			if existing.ID != profile.ID && existing.UserID == profile.UserID {
+				return errDuplicate
+			}
+		}
+		r.store.save(&profile.Model, "profiles")
+		t.profiles[profile.ID] = *profile
+		return nil
+	})
+}
+
+func (r *userRepo) GetDiamondsBalance(ctx context.Context, userID uint) (int64, error) {
+	return rewardBalance(r.store, ctx, userID)
+}
+
+func (r *userRepo) IncrementFailedLogins(ctx context.Context, userID uint, since time.Time) (int, error) {
+	var count int
+	err := r.updateUser(ctx, userID, func(u *domain.User) {
+		// Старые неудачи (до since) не учитываются
+		if u.LastFailedLoginAt == nil || u.LastFailedLoginAt.Before(since) {
+			u.FailedLoginAttempts = 1
+		} else {
+			u.FailedLoginAttempts++
+		}
+		now := r.store.now()
+		u.LastFailedLoginAt = &now
+		count = u.FailedLoginAttempts
+	})
+	return count, err
+}
+
+func (r *userRepo) LockUntil(ctx context.Context, userID uint, until time.Time) error {
+	return r.updateUser(ctx, userID, func(u *domain.User) {
+		u.LockedUntil = &until
+	})
+}
+
+func (r *userRepo) ResetFailedLogins(ctx context.Context, userID uint) error {
+	return r.updateUser(ctx, userID, func(u *domain.User) {
+		u.FailedLoginAttempts = 0
+		u.LastFailedLoginAt = nil
+		u.LockedUntil = nil
+	})
+}
+
+func (r *userRepo) List(ctx context.Context, offset, limit int) ([]*domain.User, int64, error) {
+	var users []*domain.User
+	var total int64
+	err := r.store.read(ctx, func(t *tables) error {
+		var all []*domain.User
+		for _, id := range sortedIDs(t.users) {
+			user := t.users[id]
+			if !user.DeletedAt.Valid {
+				all = append(all, &user)
+			}
+		}
+		total = int64(len(all))
+		users = page(all, offset, limit)
+		return nil
+	})
+	return users, total, err
+}
+
+func (r *userRepo) SetRole(ctx context.Context, userID uint, role domain.UserRole) error {
+	return r.store.write(ctx, func(t *tables) error {
+		user, ok := t.users[userID]
+		if !ok || user.DeletedAt.Valid {
+			return errNotFound
+		}
+		user.Role = role
+		user.UpdatedAt = r.store.now()
+		t.users[userID] = user
+		return nil
+	})
+}
+
+func (r *userRepo) AcceptTOTPStep(ctx context.Context, userID uint, step int64) (bool, error) {
+	accepted := false
+	err := r.updateUser(ctx, userID, func(u *domain.User) {
+		// Код принимается, только если его шаг новее последнего принятого
+		if u.TOTPLastStep < step {
+			u.TOTPLastStep = step
+			accepted = true
+		}
+	})
+	return accepted, err
+}
+
+// SessionRepo
+
+type sessionRepo struct {
+	store *Store
+}
+
+// NewSessionRepo - репозиторий сессий (выданных refresh токенов) в памяти
+func NewSessionRepo(store *Store) repo.SessionRepo {
+	return &sessionRepo{store: store}
+}
+
+func (r *sessionRepo) Create(ctx context.Context, session *domain.Session) error {
+	return r.store.write(ctx, func(t *tables) error {
+		for _, existing := range t.sessions {
+			if existing.JTI == session.JTI {
+				return errDuplicate
+			}
+		}
+		r.store.insert(&session.Model, "sessions")
+		t.sessions[session.ID] = *session
+		return nil
+	})
+}
+
+func (r *sessionRepo) GetByJTI(ctx context.Context, jti string) (*domain.Session, error) {
+	var found *domain.Session
+	err := r.store.read(ctx, func(t *tables) error {
+		for _, id := range sortedIDs(t.sessions) {
+			session := t.sessions[id]
+			if !session.DeletedAt.Valid && session.JTI == jti {
+				found = &session
+				return nil
+			}
+		}
+		return errNotFound
+	})
+	return found, err
+}
+
+func (r *sessionRepo) Rotate(ctx context.Context, oldJTI string, next *domain.Session) error {
+	return r.store.write(ctx, func(t *tables) error {
+		var old *domain.Session
+		for _, session := range t.sessions {
+			if session.JTI == next.JTI {
+				return errDuplicate
+			}
+			if session.JTI == oldJTI && !session.DeletedAt.Valid && session.RevokedAt == nil {
+				session := session
+				old = &session
+			}
+		}
+		if old == nil {
+			return repo.ErrSessionRevoked
+		}
+
+		now := r.store.now()
+		old.RevokedAt = &now
+		old.ReplacedBy = next.JTI
+		old.UpdatedAt = now
+		t.sessions[old.ID] = *old
+		r.store.insert(&next.Model, "sessions")
+		t.sessions[next.ID] = *next
+		return nil
+	})
+}
+
+// activeSession - токен не отозван и не истек
+func activeSession(session *domain.Session, now time.Time) bool {
+	return !session.DeletedAt.Valid && session.RevokedAt == nil && session.ExpiresAt.After(now)
+}
+
+func (r *sessionRepo) GetActiveByUser(ctx context.Context, userID uint) ([]*domain.Session, error) {
+	var sessions []*domain.Session
+	err := r.store.read(ctx, func(t *tables) error {
+		now := r.store.now()
+		for _, id := range sortedIDs(t.sessions) {
+			session := t.sessions[id]
+			if session.UserID == userID && activeSession(&session, now) {
+				sessions = append(sessions, &session)
+			}
+		}
+		return nil
+	})
+	// Как ORDER BY last_used_at DESC в Postgres: NULL первыми
+	sort.SliceStable(sessions, func(i, j int) bool {
+		a, b := sessions[i].LastUsedAt, sessions[j].LastUsedAt
+		if a == nil || b == nil {
+			return a == nil && b != nil
+		}
+		return a.After(*b)
+	})
+	return sessions, err
+}
+
+func (r *sessionRepo) IsFamilyActive(ctx context.Context, userID uint, familyID string) (bool, error) {
+	active := false
+	err := r.store.read(ctx, func(t *tables) error {
+		now := r.store.now()
+		for _, session := range t.sessions {
+			if session.UserID == userID && session.FamilyID == familyID && activeSession(&session, now) {
+				active = true
+				return nil
+			}
+		}
+		return nil
+	})
+	return active, err
+}
+
+// revokeSessions - отозвать действующие токены пользователя, подходящие под match
+func (r *sessionRepo) revokeSessions(ctx context.Context, userID uint, match func(s *domain.Session) bool) (int64, error) {
+	var revoked int64
+	err := r.store.write(ctx, func(t *tables) error {
+		now := r.store.now()
+		for id, session := range t.sessions {
+			if session.UserID != userID || session.DeletedAt.Valid || session.RevokedAt != nil || !match(&session) {
+				continue
+			}
+			session.RevokedAt = &now
+			session.UpdatedAt = now
+			t.sessions[id] = session
+			revoked++
+		}
+		return nil
+	})
+	return revoked, err
+}
+
+func (r *sessionRepo) RevokeFamily(ctx context.Context, userID uint, familyID string) (int64, error) {
+	return r.revokeSessions(ctx, userID, func(s *domain.Session) bool { return s.FamilyID == familyID })
+}
+
+func (r *sessionRepo) RevokeOthers(ctx context.Context, userID uint, keepFamilyID string) error {
+	_, err := r.revokeSessions(ctx, userID, func(s *domain.Session) bool { return s.FamilyID != keepFamilyID })
+	return err
+}
+
+func (r *sessionRepo) RevokeAllByUser(ctx context.Context, userID uint) error {
+	_, err := r.revokeSessions(ctx, userID, func(s *domain.Session) bool { return true })
+	return err
+}
+
+// UserTokenRepo
+
+type userTokenRepo struct {
+	store *Store
+}
+
+// NewUserTokenRepo - репозиторий одноразовых токенов пользователей в памяти
+func NewUserTokenRepo(store *Store) repo.UserTokenRepo {
+	return &userTokenRepo{store: store}
+}
+
+func (r *userTokenRepo) Create(ctx context.Context, token *domain.UserToken) error {
+	return r.store.write(ctx, func(t *tables) error {
+		for _, existing := range t.userTokens {
+			if existing.TokenHash == token.TokenHash {
+				return errDuplicate
+			}
+		}
+		r.store.insert(&token.Model, "user_tokens")
+		t.userTokens[token.ID] = *token
+		return nil
+	})
+}
+
+func (r *userTokenRepo) Consume(ctx context.Context, purpose domain.UserTokenPurpose, tokenHash string) (*domain.UserToken, error) {
+	var found *domain.UserToken
+	err := r.store.write(ctx, func(t *tables) error {
+		now := r.store.now()
+		for id, token := range t.userTokens {
+			if token.DeletedAt.Valid || token.Purpose != purpose || token.TokenHash != tokenHash ||
+				token.UsedAt != nil || !token.ExpiresAt.After(now) {
+				continue
+			}
+			token.UsedAt = &now
+			token.UpdatedAt = now
+			t.userTokens[id] = token
+			found = &token
+			return nil
+		}
+		return errNotFound
+	})
+	return found, err
+}
+
+func (r *userTokenRepo) InvalidateByUser(ctx context.Context, userID uint, purpose domain.UserTokenPurpose) error {
+	return r.store.write(ctx, func(t *tables) error {
+		now := r.store.now()
+		for id, token := range t.userTokens {
+			if token.DeletedAt.Valid || token.UserID != userID || token.Purpose != purpose || token.UsedAt != nil {
+				continue
+			}
+			token.UsedAt = &now
+			token.UpdatedAt = now
+			t.userTokens[id] = token
+		}
+		return nil
+	})
+}
+
+// LevelRepo
+
+type levelRepo struct {
+	store *Store
+}
+
+// NewLevelRepo - репозиторий уровней в памяти
+func NewLevelRepo(store *Store) repo.LevelRepo {
+	return &levelRepo{store: store}
+}
+
+func (r *levelRepo) filterLevels(ctx context.Context, includeDeleted bool, match func(l *domain.Level) bool) ([]*domain.Level, error) {
+	var levels []*domain.Level
+	err := r.store.read(ctx, func(t *tables) error {
+		for _, id := range sortedIDs(t.levels) {
+			level := t.levels[id]
+			if (includeDeleted || !level.DeletedAt.Valid) && match(&level) {
+				levels = append(levels, &level)
+			}
+		}
+		return nil
+	})
+	return levels, err
+}
+
+func (r *levelRepo) GetAll(ctx context.Context) ([]*domain.Level, error) {
+	return r.filterLevels(ctx, false, func(l *domain.Level) bool { return l.IsActive })
+}
+
+func (r *levelRepo) GetByID(ctx context.Context, id uint) (*domain.Level, error) {
+	var found *domain.Level
+	err := r.store.read(ctx, func(t *tables) error {
+		level, ok := t.levels[id]
+		if !ok || level.DeletedAt.Valid {
+			return errNotFound
+		}
+		found = &level
+		return nil
+	})
+	return found, err
+}
+
+// levelSteps - шаги уровня по порядку
+func levelSteps(t *tables, levelID uint, includeDeleted bool) []*domain.LevelStep {
+	var steps []*domain.LevelStep
+	for _, step := range t.steps {
+		if step.LevelID == levelID && (includeDeleted || !step.DeletedAt.Valid) {
+			step := step
+			steps = append(steps, &step)
+		}
+	}
+	sort.Slice(steps, func(i, j int) bool {
+		if steps[i].Order != steps[j].Order {
+			return steps[i].Order < steps[j].Order
+		}
+		return steps[i].ID < steps[j].ID
+	})
+	return steps
+}
+
+func (r *levelRepo) GetWithSteps(ctx context.Context, id uint) (*domain.Level, error) {
+	var found *domain.Level
+	err := r.store.read(ctx, func(t *tables) error {
+		level, ok := t.levels[id]
+		if !ok || level.DeletedAt.Valid {
+			return errNotFound
+		}
+		for _, step := range levelSteps(t, id, false) {
+			if step.QuestionID != nil {
+				if question, ok := t.questions[*step.QuestionID]; ok && !question.DeletedAt.Valid {
+					question.Choices = questionChoices(t, question.ID)
+					step.Question = &question
+				}
+			}
+			level.Steps = append(level.Steps, *step)
+		}
+		found = &level
+		return nil
+	})
+	return found, err
+}
+
+func (r *levelRepo) GetByDifficulty(ctx context.Context, difficulty string) ([]*domain.Level, error) {
+	return r.filterLevels(ctx, false, func(l *domain.Level) bool { return l.IsActive && l.Difficulty == difficulty })
+}
+
+func (r *levelRepo) GetByTopic(ctx context.Context, topic string) ([]*domain.Level, error) {
+	return r.filterLevels(ctx, false, func(l *domain.Level) bool { return l.IsActive && l.Topic == topic })
+}
+
+func (r *levelRepo) ListAll(ctx context.Context, includeDeleted bool) ([]*domain.Level, error) {
+	return r.filterLevels(ctx, includeDeleted, func(l *domain.Level) bool { return true })
+}
+
+func (r *levelRepo) GetForEdit(ctx context.Context, id uint, includeDeleted bool) (*domain.Level, error) {
+	var found *domain.Level
+	err := r.store.read(ctx, func(t *tables) error {
+		level, ok := t.levels[id]
+		if !ok || (!includeDeleted && level.DeletedAt.Valid) {
+			return errNotFound
+		}
+		for _, step := range levelSteps(t, id, false) {
+			level.Steps = append(level.Steps, *step)
+		}
+		found = &level
+		return nil
+	})
+	return found, err
+}
+
+func levelSlugTaken(t *tables, level *domain.Level) bool {
+	if level.Slug == "" {
+		return false
+	}
+	for _, existing := range t.levels {
+		if existing.ID != level.ID && !existing.DeletedAt.Valid && existing.Slug == level.Slug {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *levelRepo) Create(ctx context.Context, level *domain.Level) error {
+	return r.store.write(ctx, func(t *tables) error {
+		if levelSlugTaken(t, level) {
+			return errDuplicate
+		}
+		r.store.insert(&level.Model, "levels")
+		defaultSlug(&level.Slug, "level", level.ID)
+		stored := *level
+		stored.Steps = nil
+		t.levels[level.ID] = stored
+		return nil
+	})
+}
+
+func (r *levelRepo) Update(ctx context.Context, level *domain.Level) error {
+	return r.store.write(ctx, func(t *tables) error {
+		if levelSlugTaken(t, level) {
+			return errDuplicate
+		}
+		r.store.save(&level.Model, "levels")
+		stored := *level
+		stored.Steps = nil
+		t.levels[level.ID] = stored
+		return nil
+	})
+}
+
+func (r *levelRepo) Delete(ctx context.Context, id uint) error {
+	return r.store.write(ctx, func(t *tables) error {
+		level, ok := t.levels[id]
+		if !ok || level.DeletedAt.Valid {
+			return errNotFound
+		}
+		r.store.deleteModel(&level.Model)
+		t.levels[id] = level
+		return nil
+	})
+}
+
+func (r *levelRepo) Restore(ctx context.Context, id uint) error {
+	return r.store.write(ctx, func(t *tables) error {
+		level, ok := t.levels[id]
+		if !ok || !level.DeletedAt.Valid {
+			return errNotFound
+		}
+		if levelSlugTaken(t, &level) {
+			return errDuplicate
+		}
+		level.DeletedAt = gorm.DeletedAt{}
+		t.levels[id] = level
+		return nil
+	})
+}
+
+func (r *levelRepo) GetSteps(ctx context.Context, levelID uint, includeDeleted bool) ([]*domain.LevelStep, error) {
+	var steps []*domain.LevelStep
+	err := r.store.read(ctx, func(t *tables) error {
+		steps = levelSteps(t, levelID, includeDeleted)
+		return nil
+	})
+	return steps, err
+}
+
+func (r *levelRepo) GetStep(ctx context.Context, id uint) (*domain.LevelStep, error) {
+	var found *domain.LevelStep
+	err := r.store.read(ctx, func(t *tables) error {
+		step, ok := t.steps[id]
+		if !ok || step.DeletedAt.Valid {
+			return errNotFound
+		}
+		found = &step
+		return nil
+	})
+	return found, err
+}
+
+func (r *levelRepo) MaxStepOrder(ctx context.Context, levelID uint) (int, error) {
+	var max int
+	err := r.store.read(ctx, func(t *tables) error {
+		for _, step := range levelSteps(t, levelID, false) {
+			if step.Order > max {
+				max = step.Order
+			}
+		}
+		return nil
+	})
+	return max, err
+}
+
+func stepOrderTaken(t *tables, levelID uint, order int, exceptStepID uint) bool {
+	for _, step := range t.steps {
+		if step.ID != exceptStepID && step.LevelID == levelID && step.Order == order && !step.DeletedAt.Valid {
+			return true
+		}
+	}
+	return false
+}
+
+func stepConflict(t *tables, step *domain.LevelStep) bool {
+	if stepOrderTaken(t, step.LevelID, step.Order, step.ID) {
+		return true
+	}
+	if step.Slug == "" {
+		return false
+	}
+	for _, existing := range t.steps {
+		if existing.ID != step.ID && existing.LevelID == step.LevelID && existing.Slug == step.Slug && !existing.DeletedAt.Valid {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *levelRepo) IsStepOrderTaken(ctx context.Context, levelID uint, order int, exceptStepID uint) (bool, error) {
+	var taken bool
+	err := r.store.read(ctx, func(t *tables) error {
+		taken = stepOrderTaken(t, levelID, order, exceptStepID)
+		return nil
+	})
+	return taken, err
+}
+
+func (r *levelRepo) CreateStep(ctx context.Context, step *domain.LevelStep) error {
+	return r.store.write(ctx, func(t *tables) error {
+		if stepConflict(t, step) {
+			return errDuplicate
+		}
+		r.store.insert(&step.Model, "level_steps")
+		defaultSlug(&step.Slug, "step", step.ID)
+		stored := *step
+		stored.Question = nil
+		t.steps[step.ID] = stored
+		return nil
+	})
+}
+
+func (r *levelRepo) UpdateStep(ctx context.Context, step *domain.LevelStep) error {
+	return r.store.write(ctx, func(t *tables) error {
+		if stepConflict(t, step) {
+			return errDuplicate
+		}
+		r.store.save(&step.Model, "level_steps")
+		stored := *step
+		stored.Question = nil
+		t.steps[step.ID] = stored
+		return nil
+	})
+}
+
+func (r *levelRepo) DeleteStep(ctx context.Context, id uint) error {
+	return r.store.write(ctx, func(t *tables) error {
+		step, ok := t.steps[id]
+		if !ok || step.DeletedAt.Valid {
+			return errNotFound
+		}
+		r.store.deleteModel(&step.Model)
+		t.steps[id] = step
+		return nil
+	})
+}
+
+func (r *levelRepo) ReorderSteps(ctx context.Context, levelID uint, stepIDs []uint) error {
+	return r.store.write(ctx, func(t *tables) error {
+		for _, id := range stepIDs {
+			step, ok := t.steps[id]
+			if !ok || step.DeletedAt.Valid || step.LevelID != levelID {
+				return errNotFound
+			}
+		}
+		// Позиции 1..n не должны быть заняты шагами, которых нет в списке
+		listed := make(map[uint]bool, len(stepIDs))
+		for _, id := range stepIDs {
+			listed[id] = true
+		}
+		for _, step := range levelSteps(t, levelID, false) {
+			if !listed[step.ID] && step.Order >= 1 && step.Order <= len(stepIDs) {
+				return errDuplicate
+			}
+		}
+		now := r.store.now()
+		for i, id := range stepIDs {
+			step := t.steps[id]
+			step.Order = i + 1
+			step.UpdatedAt = now
+			t.steps[id] = step
+		}
+		return nil
+	})
+}
+
+func (r *levelRepo) PublishRevision(ctx context.Context, revision *domain.LevelRevision) error {
+	return r.store.write(ctx, func(t *tables) error {
+		level, ok := t.levels[revision.LevelID]
+		if !ok || level.DeletedAt.Valid {
+			return errNotFound
+		}
+
+		last := 0
+		for _, existing := range t.revisions {
+			if existing.LevelID == revision.LevelID && existing.Number > last {
+				last = existing.Number
+			}
+		}
+
+		r.store.seq["level_revisions"]++
+		revision.ID = r.store.seq["level_revisions"]
+		revision.Number = last + 1
+		if revision.CreatedAt.IsZero() {
+			revision.CreatedAt = r.store.now()
+		}
+		t.revisions[revision.ID] = *revision
+
+		revisionID := revision.ID
+		level.PublishedRevisionID = &revisionID
+		t.levels[level.ID] = level
+		return nil
+	})
+}
+
+func (r *levelRepo) findRevision(ctx context.Context, match func(rev *domain.LevelRevision) bool) (*domain.LevelRevision, error) {
+	var found *domain.LevelRevision
+	err := r.store.read(ctx, func(t *tables) error {
+		for _, id := range sortedIDs(t.revisions) {
+			revision := t.revisions[id]
+			if match(&revision) {
+				found = &revision
+				return nil
+			}
+		}
+		return errNotFound
+	})
+	return found, err
+}
+
+func (r *levelRepo) GetRevisionByID(ctx context.Context, id uint) (*domain.LevelRevision, error) {
+	return r.findRevision(ctx, func(rev *domain.LevelRevision) bool { return rev.ID == id })
+}
+
+func (r *levelRepo) GetRevision(ctx context.Context, levelID uint, number int) (*domain.LevelRevision, error) {
+	return r.findRevision(ctx, func(rev *domain.LevelRevision) bool {
+		return rev.LevelID == levelID && rev.Number == number
+	})
+}
+
+func (r *levelRepo) ListRevisions(ctx context.Context, levelID uint) ([]*domain.LevelRevision, error) {
+	var revisions []*domain.LevelRevision
+	err := r.store.read(ctx, func(t *tables) error {
+		for _, revision := range t.revisions {
+			if revision.LevelID == levelID {
+				revision := revision
+				revision.Snapshot = nil
+				revisions = append(revisions, &revision)
+			}
+		}
+		sort.Slice(revisions, func(i, j int) bool { return revisions[i].Number > revisions[j].Number })
+		return nil
+	})
+	return revisions, err
+}
+
+// CourseRepo
+
+type courseRepo struct {
+	store *Store
+}
+
+// NewCourseRepo - репозиторий курсов, юнитов и графа зависимостей уровней в памяти
+func NewCourseRepo(store *Store) repo.CourseRepo {
+	return &courseRepo{store: store}
+}
+
+func courseSlugTaken(t *tables, course *domain.Course) bool {
+	for _, existing := range t.courses {
+		if existing.ID != course.ID && !existing.DeletedAt.Valid && existing.Slug == course.Slug {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *courseRepo) ListCourses(ctx context.Context, includeInactive bool) ([]*domain.Course, error) {
+	var courses []*domain.Course
+	err := r.store.read(ctx, func(t *tables) error {
+		for _, id := range sortedIDs(t.courses) {
+			course := t.courses[id]
+			if !course.DeletedAt.Valid && (includeInactive || course.IsActive) {
+				courses = append(courses, &course)
+			}
+		}
+		return nil
+	})
+	sort.SliceStable(courses, func(i, j int) bool { return courses[i].Order < courses[j].Order })
+	return courses, err
+}
+
+func (r *courseRepo) GetCourse(ctx context.Context, id uint) (*domain.Course, error) {
+	var found *domain.Course
+	err := r.store.read(ctx, func(t *tables) error {
+		course, ok := t.courses[id]
+		if !ok || course.DeletedAt.Valid {
+			return errNotFound
+		}
+		found = &course
+		return nil
+	})
+	return found, err
+}
+
+func (r *courseRepo) CreateCourse(ctx context.Context, course *domain.Course) error {
+	return r.store.write(ctx, func(t *tables) error {
+		if courseSlugTaken(t, course) {
+			return errDuplicate
+		}
+		r.store.insert(&course.Model, "courses")
+		stored := *course
+		stored.Units = nil
+		t.courses[course.ID] = stored
+		return nil
+	})
+}
+
+func (r *courseRepo) UpdateCourse(ctx context.Context, course *domain.Course) error {
+	return r.store.write(ctx, func(t *tables) error {
+		if courseSlugTaken(t, course) {
+			return errDuplicate
+		}
+		r.store.save(&course.Model, "courses")
+		stored := *course
+		stored.Units = nil
+		t.courses[course.ID] = stored
+		return nil
+	})
+}
+
+func (r *courseRepo) GetUnits(ctx context.Context, courseID uint) ([]*domain.Unit, error) {
+	var units []*domain.Unit
+	err := r.store.read(ctx, func(t *tables) error {
+		for _, id := range sortedIDs(t.units) {
+			unit := t.units[id]
+			if unit.DeletedAt.Valid || unit.CourseID != courseID {
+				continue
+			}
+			for _, levelID := range sortedIDs(t.levels) {
+				level := t.levels[levelID]
+				if !level.DeletedAt.Valid && level.UnitID != nil && *level.UnitID == unit.ID {
+					unit.Levels = append(unit.Levels, level)
+				}
+			}
+			sort.SliceStable(unit.Levels, func(i, j int) bool { return unit.Levels[i].UnitOrder < unit.Levels[j].UnitOrder })
+			units = append(units, &unit)
+		}
+		return nil
+	})
+	sort.SliceStable(units, func(i, j int) bool { return units[i].Order < units[j].Order })
+	return units, err
+}
+
+func (r *courseRepo) GetUnit(ctx context.Context, id uint) (*domain.Unit, error) {
+	var found *domain.Unit
+	err := r.store.read(ctx, func(t *tables) error {
+		unit, ok := t.units[id]
+		if !ok || unit.DeletedAt.Valid {
+			return errNotFound
+		}
+		found = &unit
+		return nil
+	})
+	return found, err
+}
+
+func (r *courseRepo) CreateUnit(ctx context.Context, unit *domain.Unit) error {
+	return r.store.write(ctx, func(t *tables) error {
+		r.store.insert(&unit.Model, "units")
+		stored := *unit
+		stored.Levels = nil
+		t.units[unit.ID] = stored
+		return nil
+	})
+}
+
+func (r *courseRepo) UpdateUnit(ctx context.Context, unit *domain.Unit) error {
+	return r.store.write(ctx, func(t *tables) error {
+		r.store.save(&unit.Model, "units")
+		stored := *unit
+		stored.Levels = nil
+		t.units[unit.ID] = stored
+		return nil
+	})
+}
+
+func (r *courseRepo) PlaceLevel(ctx context.Context, levelID uint, unitID *uint, order int) error {
+	return r.store.write(ctx, func(t *tables) error {
+		level, ok := t.levels[levelID]
+		if !ok || level.DeletedAt.Valid {
+			return errNotFound
+		}
+		level.UnitID = unitID
+		level.UnitOrder = order
+		level.UpdatedAt = r.store.now()
+		t.levels[levelID] = level
+		return nil
+	})
+}
+
+// sortPrerequisites - ребра по (level_id, required_level_id)
+func sortPrerequisites(prerequisites []*domain.LevelPrerequisite) {
+	sort.Slice(prerequisites, func(i, j int) bool {
+		if prerequisites[i].LevelID != prerequisites[j].LevelID {
+			return prerequisites[i].LevelID < prerequisites[j].LevelID
+		}
+		return prerequisites[i].RequiredLevelID < prerequisites[j].RequiredLevelID
+	})
+}
+
+func (r *courseRepo) ListPrerequisites(ctx context.Context) ([]*domain.LevelPrerequisite, error) {
+	return r.filterPrerequisites(ctx, func(p *domain.LevelPrerequisite) bool { return true })
+}
+
+// filterPrerequisites - ребра графа, подходящие под match, по порядку
+func (r *courseRepo) filterPrerequisites(ctx context.Context, match func(p *domain.LevelPrerequisite) bool) ([]*domain.LevelPrerequisite, error) {
+	prerequisites := []*domain.LevelPrerequisite{}
+	err := r.store.read(ctx, func(t *tables) error {
+		for _, prerequisite := range t.prerequisites {
+			if match(&prerequisite) {
+				prerequisite := prerequisite
+				prerequisites = append(prerequisites, &prerequisite)
+			}
+		}
+		return nil
+	})
+	sortPrerequisites(prerequisites)
+	return prerequisites, err
+}
+
+// LockPrerequisites - транзакции хранилища и так сериализованы его блокировкой
+func (r *courseRepo) LockPrerequisites(ctx context.Context) error {
+	return nil
+}
+
+func (r *courseRepo) GetPrerequisites(ctx context.Context, levelIDs []uint) ([]*domain.LevelPrerequisite, error) {
+	wanted := make(map[uint]bool, len(levelIDs))
+	for _, id := range levelIDs {
+		wanted[id] = true
+	}
+	return r.filterPrerequisites(ctx, func(p *domain.LevelPrerequisite) bool { return wanted[p.LevelID] })
+}
+
+func (r *courseRepo) ReplacePrerequisites(ctx context.Context, levelID uint, prerequisites []*domain.LevelPrerequisite) error {
+	return r.store.write(ctx, func(t *tables) error {
+		seen := make(map[uint]bool, len(prerequisites))
+		for _, prerequisite := range prerequisites {
+			if seen[prerequisite.RequiredLevelID] {
+				return errDuplicate
+			}
+			seen[prerequisite.RequiredLevelID] = true
+		}
+
+		kept := t.prerequisites[:0:0]
+		for _, existing := range t.prerequisites {
+			if existing.LevelID != levelID {
+				kept = append(kept, existing)
+			}
+		}
+		now := r.store.now()
+		for _, prerequisite := range prerequisites {
+			prerequisite.LevelID = levelID
+			if prerequisite.CreatedAt.IsZero() {
+				prerequisite.CreatedAt = now
+			}
+			kept = append(kept, *prerequisite)
+		}
+		t.prerequisites = kept
+		return nil
+	})
+}
+
+// QuestionRepo
+
+type questionRepo struct {
+	store *Store
+}
+
+// NewQuestionRepo - репозиторий вопросов в памяти
+func NewQuestionRepo(store *Store) repo.QuestionRepo {
+	return &questionRepo{store: store}
+}
+
+// questionChoices - действующие варианты ответа вопроса по порядку
+func questionChoices(t *tables, questionID uint) []domain.Choice {
+	var choices []domain.Choice
+	for _, choice := range t.choices {
+		if choice.QuestionID == questionID && !choice.DeletedAt.Valid {
+			choices = append(choices, choice)
+		}
+	}
+	sort.Slice(choices, func(i, j int) bool {
+		if choices[i].Order != choices[j].Order {
+			return choices[i].Order < choices[j].Order
+		}
+		return choices[i].ID < choices[j].ID
+	})
+	return choices
+}
+
+func (r *questionRepo) getQuestion(ctx context.Context, id uint, withChoices bool) (*domain.Question, error) {
+	var found *domain.Question
+	err := r.store.read(ctx, func(t *tables) error {
+		question, ok := t.questions[id]
+		if !ok || question.DeletedAt.Valid {
+			return errNotFound
+		}
+		if withChoices {
+			question.Choices = questionChoices(t, id)
+		}
+		found = &question
+		return nil
+	})
+	return found, err
+}
+
+func (r *questionRepo) GetByID(ctx context.Context, id uint) (*domain.Question, error) {
+	return r.getQuestion(ctx, id, false)
+}
+
+func (r *questionRepo) GetWithChoices(ctx context.Context, id uint) (*domain.Question, error) {
+	return r.getQuestion(ctx, id, true)
+}
+
+func (r *questionRepo) GetByLevelID(ctx context.Context, levelID uint) ([]*domain.Question, error) {
+	var questions []*domain.Question
+	err := r.store.read(ctx, func(t *tables) error {
+		seen := make(map[uint]bool)
+		for _, step := range levelSteps(t, levelID, false) {
+			if step.QuestionID == nil || seen[*step.QuestionID] {
+				continue
+			}
+			question, ok := t.questions[*step.QuestionID]
+			if !ok || question.DeletedAt.Valid {
+				continue
+			}
+			seen[question.ID] = true
+			question.Choices = questionChoices(t, question.ID)
+			questions = append(questions, &question)
+		}
+		return nil
+	})
+	return questions, err
+}
+
+func (r *questionRepo) GetByIDs(ctx context.Context, ids []uint) ([]*domain.Question, error) {
+	var questions []*domain.Question
+	err := r.store.read(ctx, func(t *tables) error {
+		wanted := make(map[uint]bool, len(ids))
+		for _, id := range ids {
+			wanted[id] = true
+		}
+		for _, id := range sortedIDs(t.questions) {
+			question := t.questions[id]
+			if wanted[id] && !question.DeletedAt.Valid {
+				question.Choices = questionChoices(t, id)
+				questions = append(questions, &question)
+			}
+		}
+		return nil
+	})
+	return questions, err
+}
+
+func questionSlugTaken(t *tables, question *domain.Question) bool {
+	if question.Slug == "" {
+		return false
+	}
+	for _, existing := range t.questions {
+		if existing.ID != question.ID && !existing.DeletedAt.Valid && existing.Slug == question.Slug {
+			return true
+		}
+	}
+	return false
+}
+
+func choiceSlugTaken(t *tables, choice *domain.Choice) bool {
+	if choice.Slug == "" {
+		return false
+	}
+	for _, existing := range t.choices {
+		if existing.ID != choice.ID && existing.QuestionID == choice.QuestionID &&
+			!existing.DeletedAt.Valid && existing.Slug == choice.Slug {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *questionRepo) Create(ctx context.Context, question *domain.Question) error {
+	return r.store.write(ctx, func(t *tables) error {
+		if questionSlugTaken(t, question) {
+			return errDuplicate
+		}
+		slugs := make(map[string]bool)
+		for _, choice := range question.Choices {
+			if choice.Slug != "" && slugs[choice.Slug] {
+				return errDuplicate
+			}
+			slugs[choice.Slug] = true
+		}
+
+		if question.Kind == "" {
+			question.Kind = domain.KindChoice
+		}
+		r.store.insert(&question.Model, "questions")
+		defaultSlug(&question.Slug, "question", question.ID)
+		// Варианты ответа создаются вместе с вопросом, как ассоциации в GORM
+		for i := range question.Choices {
+			choice := &question.Choices[i]
+			choice.QuestionID = question.ID
+			r.store.insert(&choice.Model, "choices")
+			defaultSlug(&choice.Slug, "choice", choice.ID)
+			t.choices[choice.ID] = *choice
+		}
+		stored := *question
+		stored.Choices = nil
+		t.questions[question.ID] = stored
+		return nil
+	})
+}
+
+func (r *questionRepo) Update(ctx context.Context, question *domain.Question) error {
+	return r.store.write(ctx, func(t *tables) error {
+		if questionSlugTaken(t, question) {
+			return errDuplicate
+		}
+		r.store.save(&question.Model, "questions")
+		stored := *question
+		stored.Choices = nil
+		t.questions[question.ID] = stored
+
+		// Оставшиеся варианты удаляются, если вопрос больше их не хранит
+		if !question.StoresChoices() {
+			deleteChoices(r.store, t, question.ID)
+		}
+		return nil
+	})
+}
+
+func deleteChoices(store *Store, t *tables, questionID uint) {
+	for id, choice := range t.choices {
+		if choice.QuestionID == questionID && !choice.DeletedAt.Valid {
+			store.deleteModel(&choice.Model)
+			t.choices[id] = choice
+		}
+	}
+}
+
+func (r *questionRepo) Delete(ctx context.Context, id uint) error {
+	return r.store.write(ctx, func(t *tables) error {
+		question, ok := t.questions[id]
+		if !ok || question.DeletedAt.Valid {
+			return errNotFound
+		}
+		r.store.deleteModel(&question.Model)
+		t.questions[id] = question
+		deleteChoices(r.store, t, id)
+		return nil
+	})
+}
+
+func (r *questionRepo) IsReferenced(ctx context.Context, questionID uint) (bool, error) {
+	var referenced bool
+	err := r.store.read(ctx, func(t *tables) error {
+		for _, step := range t.steps {
+			if step.QuestionID != nil && *step.QuestionID == questionID && !step.DeletedAt.Valid {
+				referenced = true
+				break
+			}
+		}
+		return nil
+	})
+	return referenced, err
+}
+
+func (r *questionRepo) GetChoice(ctx context.Context, id uint) (*domain.Choice, error) {
+	var found *domain.Choice
+	err := r.store.read(ctx, func(t *tables) error {
+		choice, ok := t.choices[id]
+		if !ok || choice.DeletedAt.Valid {
+			return errNotFound
+		}
+		found = &choice
+		return nil
+	})
+	return found, err
+}
+
+func (r *questionRepo) CreateChoice(ctx context.Context, choice *domain.Choice) error {
+	return r.store.write(ctx, func(t *tables) error {
+		if choiceSlugTaken(t, choice) {
+			return errDuplicate
+		}
+		r.store.insert(&choice.Model, "choices")
+		defaultSlug(&choice.Slug, "choice", choice.ID)
+		t.choices[choice.ID] = *choice
+		return nil
+	})
+}
+
+func (r *questionRepo) UpdateChoice(ctx context.Context, choice *domain.Choice) error {
+	return r.store.write(ctx, func(t *tables) error {
+		if choiceSlugTaken(t, choice) {
+			return errDuplicate
+		}
+		r.store.save(&choice.Model, "choices")
+		t.choices[choice.ID] = *choice
+		return nil
+	})
+}
+
+func (r *questionRepo) DeleteChoice(ctx context.Context, id uint) error {
+	return r.store.write(ctx, func(t *tables) error {
+		choice, ok := t.choices[id]
+		if !ok || choice.DeletedAt.Valid {
+			return errNotFound
+		}
+		r.store.deleteModel(&choice.Model)
+		t.choices[id] = choice
+		return nil
+	})
+}
+
+// AttemptRepo
+
+type attemptRepo struct {
+	store *Store
+}
+
+// NewAttemptRepo - репозиторий попыток в памяти
+func NewAttemptRepo(store *Store) repo.AttemptRepo {
+	return &attemptRepo{store: store}
+}
+
+// attemptSteps - действующие записи шагов попытки по порядку
+func attemptSteps(t *tables, attemptID uint) []*domain.AttemptStep {
+	var steps []*domain.AttemptStep
+	for _, step := range t.attemptSteps {
+		if step.AttemptID == attemptID && !step.DeletedAt.Valid {
+			step := step
+			steps = append(steps, &step)
+		}
+	}
+	sort.Slice(steps, func(i, j int) bool {
+		if steps[i].StepOrder != steps[j].StepOrder {
+			return steps[i].StepOrder < steps[j].StepOrder
+		}
+		return steps[i].ID < steps[j].ID
+	})
+	return steps
+}
+
+func (r *attemptRepo) Create(ctx context.Context, attempt *domain.Attempt) error {
+	return r.store.write(ctx, func(t *tables) error {
+		if attempt.Status == "" {
+			attempt.Status = domain.AttemptInProgress
+		}
+		r.store.insert(&attempt.Model, "attempts")
+		for i := range attempt.Steps {
+			step := &attempt.Steps[i]
+			step.AttemptID = attempt.ID
+			r.store.insert(&step.Model, "attempt_steps")
+			t.attemptSteps[step.ID] = *step
+		}
+		stored := *attempt
+		stored.Steps = nil
+		t.attempts[attempt.ID] = stored
+		return nil
+	})
+}
+
+func (r *attemptRepo) GetByID(ctx context.Context, id uint) (*domain.Attempt, error) {
+	var found *domain.Attempt
+	err := r.store.read(ctx, func(t *tables) error {
+		attempt, ok := t.attempts[id]
+		if !ok || attempt.DeletedAt.Valid {
+			return errNotFound
+		}
+		for _, step := range attemptSteps(t, id) {
+			attempt.Steps = append(attempt.Steps, *step)
+		}
+		found = &attempt
+		return nil
+	})
+	return found, err
+}
+
+func (r *attemptRepo) GetActiveByUserAndLevel(ctx context.Context, userID, levelID uint) (*domain.Attempt, error) {
+	var found *domain.Attempt
+	err := r.store.read(ctx, func(t *tables) error {
+		for _, id := range sortedIDs(t.attempts) {
+			attempt := t.attempts[id]
+			if !attempt.DeletedAt.Valid && attempt.UserID == userID && attempt.LevelID == levelID &&
+				attempt.Status == domain.AttemptInProgress {
+				found = &attempt
+				return nil
+			}
+		}
+		return errNotFound
+	})
+	return found, err
+}
+
+func (r *attemptRepo) GetByUserID(ctx context.Context, userID uint) ([]*domain.Attempt, error) {
+	var attempts []*domain.Attempt
+	err := r.store.read(ctx, func(t *tables) error {
+		for _, attempt := range t.attempts {
+			if attempt.UserID == userID && !attempt.DeletedAt.Valid {
+				attempt := attempt
+				attempts = append(attempts, &attempt)
+			}
+		}
+		sort.Slice(attempts, func(i, j int) bool {
+			if !attempts[i].StartedAt.Equal(attempts[j].StartedAt) {
+				return attempts[i].StartedAt.After(attempts[j].StartedAt)
+			}
+			return attempts[i].ID > attempts[j].ID
+		})
+		return nil
+	})
+	return attempts, err
+}
+
+func (r *attemptRepo) Update(ctx context.Context, attempt *domain.Attempt) error {
+	return r.store.write(ctx, func(t *tables) error {
+		r.store.save(&attempt.Model, "attempts")
+		stored := *attempt
+		stored.Steps = nil
+		t.attempts[attempt.ID] = stored
+		return nil
+	})
+}
+
+func (r *attemptRepo) Complete(ctx context.Context, attempt *domain.Attempt) error {
+	return r.store.write(ctx, func(t *tables) error {
+		// Условное обновление: из двух конкурентных завершений одной попытки пройдет только одно
+		stored, ok := t.attempts[attempt.ID]
+		if !ok || stored.DeletedAt.Valid || stored.Status != domain.AttemptInProgress {
+			return repo.ErrAttemptNotInProgress
+		}
+		stored.Status = attempt.Status
+		stored.ResultScore = attempt.ResultScore
+		stored.Passed = attempt.Passed
+		stored.CompletedAt = attempt.CompletedAt
+		stored.ServedStepID = nil
+		stored.ServedAt = nil
+		stored.UpdatedAt = r.store.now()
+		t.attempts[attempt.ID] = stored
+		return nil
+	})
+}
+
+func (r *attemptRepo) AddStep(ctx context.Context, step *domain.AttemptStep) error {
+	return r.store.write(ctx, func(t *tables) error {
+		r.store.insert(&step.Model, "attempt_steps")
+		t.attemptSteps[step.ID] = *step
+		return nil
+	})
+}
+
+func (r *attemptRepo) GetSteps(ctx context.Context, attemptID uint) ([]*domain.AttemptStep, error) {
+	var steps []*domain.AttemptStep
+	err := r.store.read(ctx, func(t *tables) error {
+		steps = attemptSteps(t, attemptID)
+		return nil
+	})
+	return steps, err
+}
+
+func (r *attemptRepo) GetNextUnansweredStep(ctx context.Context, attemptID uint) (*domain.AttemptStep, error) {
+	var found *domain.AttemptStep
+	err := r.store.read(ctx, func(t *tables) error {
+		for _, step := range attemptSteps(t, attemptID) {
+			if step.Response == nil {
+				found = step
+				return nil
+			}
+		}
+		return errNotFound
+	})
+	return found, err
+}
+
+func (r *attemptRepo) GetBestScores(ctx context.Context, userID uint) (map[uint]int, error) {
+	scores := make(map[uint]int)
+	err := r.store.read(ctx, func(t *tables) error {
+		for _, attempt := range t.attempts {
+			if attempt.UserID != userID || attempt.Status != domain.AttemptCompleted || attempt.DeletedAt.Valid {
+				continue
+			}
+			if best, ok := scores[attempt.LevelID]; !ok || attempt.ResultScore > best {
+				scores[attempt.LevelID] = attempt.ResultScore
+			}
+		}
+		return nil
+	})
+	return scores, err
+}
+
+func (r *attemptRepo) GetPassedLevelIDs(ctx context.Context, userID uint) ([]uint, error) {
+	var levelIDs []uint
+	err := r.store.read(ctx, func(t *tables) error {
+		seen := make(map[uint]bool)
+		for _, attempt := range t.attempts {
+			if attempt.UserID == userID && attempt.Passed && !attempt.DeletedAt.Valid && !seen[attempt.LevelID] {
+				seen[attempt.LevelID] = true
+				levelIDs = append(levelIDs, attempt.LevelID)
+			}
+		}
+		sort.Slice(levelIDs, func(i, j int) bool { return levelIDs[i] < levelIDs[j] })
+		return nil
+	})
+	return levelIDs, err
+}
+
+func (r *attemptRepo) SetServed(ctx context.Context, attemptID uint, levelStepID *uint, servedAt *time.Time) error {
+	return r.store.write(ctx, func(t *tables) error {
+		attempt, ok := t.attempts[attemptID]
+		if !ok || attempt.DeletedAt.Valid {
+			return nil
+		}
+		attempt.ServedStepID = levelStepID
+		attempt.ServedAt = servedAt
+		attempt.UpdatedAt = r.store.now()
+		t.attempts[attemptID] = attempt
+		return nil
+	})
+}
+
+func (r *attemptRepo) GetQuestionAnswers(ctx context.Context, questionIDs []uint) ([]*repo.QuestionAnswer, error) {
+	var answers []*repo.QuestionAnswer
+	if len(questionIDs) == 0 {
+		return answers, nil
+	}
+	err := r.store.read(ctx, func(t *tables) error {
+		wanted := make(map[uint]bool, len(questionIDs))
+		for _, id := range questionIDs {
+			wanted[id] = true
+		}
+		for _, attemptID := range sortedIDs(t.attempts) {
+			attempt := t.attempts[attemptID]
+			if attempt.DeletedAt.Valid {
+				continue
+			}
+			for _, step := range attemptSteps(t, attemptID) {
+				if step.QuestionID == nil || !wanted[*step.QuestionID] {
+					continue
+				}
+				answers = append(answers, &repo.QuestionAnswer{
+					AttemptID:     attemptID,
+					QuestionID:    *step.QuestionID,
+					StepOrder:     step.StepOrder,
+					Correct:       step.Correct,
+					Score:         step.Score,
+					DurationMs:    step.DurationMs,
+					Response:      step.Response,
+					AttemptStatus: attempt.Status,
+					AttemptScore:  attempt.ResultScore,
+				})
+			}
+		}
+		return nil
+	})
+	return answers, err
+}
+
+// RewardTxRepo
+
+type rewardTxRepo struct {
+	store *Store
+}
+
+// NewRewardTxRepo - репозиторий транзакций наград в памяти
+func NewRewardTxRepo(store *Store) repo.RewardTxRepo {
+	return &rewardTxRepo{store: store}
+}
+
+func (r *rewardTxRepo) Create(ctx context.Context, tx *domain.RewardTx) error {
+	return r.store.write(ctx, func(t *tables) error {
+		// Награда за прохождение начисляется по попытке не более одного раза
+		if tx.AttemptID != nil && tx.Type == "earn" {
+			for _, existing := range t.rewardTxs {
+				if existing.AttemptID != nil && *existing.AttemptID == *tx.AttemptID &&
+					existing.Type == "earn" && !existing.DeletedAt.Valid {
+					return errDuplicate
+				}
+			}
+		}
+		r.store.insert(&tx.Model, "reward_txs")
+		t.rewardTxs[tx.ID] = *tx
+		return nil
+	})
+}
+
+func (r *rewardTxRepo) filter(ctx context.Context, match func(tx *domain.RewardTx) bool) ([]*domain.RewardTx, error) {
+	var transactions []*domain.RewardTx
+	err := r.store.read(ctx, func(t *tables) error {
+		for _, id := range sortedIDs(t.rewardTxs) {
+			tx := t.rewardTxs[id]
+			if !tx.DeletedAt.Valid && match(&tx) {
+				transactions = append(transactions, &tx)
+			}
+		}
+		return nil
+	})
+	return transactions, err
+}
+
+func (r *rewardTxRepo) GetByUserID(ctx context.Context, userID uint) ([]*domain.RewardTx, error) {
+	return r.filter(ctx, func(tx *domain.RewardTx) bool { return tx.UserID == userID })
+}
+
+func (r *rewardTxRepo) GetBalance(ctx context.Context, userID uint) (int64, error) {
+	return rewardBalance(r.store, ctx, userID)
+}
+
+func (r *rewardTxRepo) GetByType(ctx context.Context, userID uint, txType string) ([]*domain.RewardTx, error) {
+	transactions, err := r.filter(ctx, func(tx *domain.RewardTx) bool {
+		return tx.UserID == userID && tx.Type == txType
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(transactions, func(i, j int) bool {
+		if !transactions[i].CreatedAt.Equal(transactions[j].CreatedAt) {
+			return transactions[i].CreatedAt.After(transactions[j].CreatedAt)
+		}
+		return transactions[i].ID > transactions[j].ID
+	})
+	return transactions, nil
+}
+
+func rewardBalance(store *Store, ctx context.Context, userID uint) (int64, error) {
+	var balance int64
+	err := store.read(ctx, func(t *tables) error {
+		for _, tx := range t.rewardTxs {
+			if tx.UserID == userID && !tx.DeletedAt.Valid {
+				balance += tx.Amount
+			}
+		}
+		return nil
+	})
+	return balance, err
+}
+
+// AchievementRepo
+
+type achievementRepo struct {
+	store *Store
+}
+
+// NewAchievementRepo - репозиторий достижений в памяти; сами достижения заводятся через Store.SeedAchievement
+func NewAchievementRepo(store *Store) repo.AchievementRepo {
+	return &achievementRepo{store: store}
+}
+
+func (r *achievementRepo) GetAll(ctx context.Context) ([]*domain.Achievement, error) {
+	var achievements []*domain.Achievement
+	err := r.store.read(ctx, func(t *tables) error {
+		for _, id := range sortedIDs(t.achievements) {
+			achievement := t.achievements[id]
+			if !achievement.DeletedAt.Valid {
+				achievements = append(achievements, &achievement)
+			}
+		}
+		return nil
+	})
+	return achievements, err
+}
+
+func (r *achievementRepo) GetByCode(ctx context.Context, code string) (*domain.Achievement, error) {
+	var found *domain.Achievement
+	err := r.store.read(ctx, func(t *tables) error {
+		for _, id := range sortedIDs(t.achievements) {
+			achievement := t.achievements[id]
+			if !achievement.DeletedAt.Valid && achievement.Code == code {
+				found = &achievement
+				return nil
+			}
+		}
+		return errNotFound
+	})
+	return found, err
+}
+
+func (r *achievementRepo) GetByUserID(ctx context.Context, userID uint) ([]*domain.Achievement, error) {
+	var achievements []*domain.Achievement
+	err := r.store.read(ctx, func(t *tables) error {
+		var awards []domain.UserAchievement
+		for _, award := range t.userAchievements {
+			if award.UserID == userID {
+				awards = append(awards, award)
+			}
+		}
+		sort.Slice(awards, func(i, j int) bool {
+			if !awards[i].AwardedAt.Equal(awards[j].AwardedAt) {
+				return awards[i].AwardedAt.After(awards[j].AwardedAt)
+			}
+			return awards[i].ID > awards[j].ID
+		})
+		for _, award := range awards {
+			achievement, ok := t.achievements[award.AchievementID]
+			if ok && !achievement.DeletedAt.Valid {
+				achievements = append(achievements, &achievement)
+			}
+		}
+		return nil
+	})
+	return achievements, err
+}
+
+func (r *achievementRepo) AwardToUser(ctx context.Context, userID, achievementID uint) error {
+	return r.store.write(ctx, func(t *tables) error {
+		for _, award := range t.userAchievements {
+			if award.UserID == userID && award.AchievementID == achievementID {
+				return errDuplicate
+			}
+		}
+		award := domain.UserAchievement{
+			UserID:        userID,
+			AchievementID: achievementID,
+			AwardedAt:     r.store.now(),
+		}
+		r.store.insert(&award.Model, "user_achievements")
+		t.userAchievements[award.ID] = award
+		return nil
+	})
+}
+
+func (r *achievementRepo) HasAchievement(ctx context.Context, userID, achievementID uint) (bool, error) {
+	var has bool
+	err := r.store.read(ctx, func(t *tables) error {
+		for _, award := range t.userAchievements {
+			if award.UserID == userID && award.AchievementID == achievementID && !award.DeletedAt.Valid {
+				has = true
+				break
+			}
+		}
+		return nil
+	})
+	return has, err
+}
+
+// sortedIDs - ключи таблицы по возрастанию (порядок по первичному ключу)
+func sortedIDs[T any](m map[uint]T) []uint {
+	ids := make([]uint, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// page - срез выборки по offset/limit; отрицательный limit - без ограничения
+func page[T any](items []T, offset, limit int) []T {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= len(items) {
+		return nil
+	}
+	items = items[offset:]
+	if limit >= 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
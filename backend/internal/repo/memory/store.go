@@ -0,0 +1,213 @@
+// Package memory - реализации интерфейсов repo в памяти процесса для быстрых тестов
+// сервисов без Postgres. Семантика повторяет GORM-реализации: мягкое удаление,
+// порядок выборок, gorm.ErrRecordNotFound для отсутствующих записей и
+// gorm.ErrDuplicatedKey при нарушении уникальных индексов.
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ImCtyz/duofinance/backend/internal/domain"
+	"github.com/ImCtyz/duofinance/backend/internal/repo"
+)
+
+// Store - общее хранилище репозиториев. Все операции сериализуются одной блокировкой;
+// транзакция (TxManager.WithinTx) держит ее до конца, поэтому внутри fn репозитории
+// нужно вызывать с переданным ctx.
+type Store struct {
+	mu   sync.RWMutex
+	seq  map[string]uint
+	data *tables
+	now  func() time.Time
+}
+
+type tables struct {
+	users            map[uint]domain.User
+	profiles         map[uint]domain.Profile
+	levels           map[uint]domain.Level
+	steps            map[uint]domain.LevelStep
+	revisions        map[uint]domain.LevelRevision
+	questions        map[uint]domain.Question
+	choices          map[uint]domain.Choice
+	attempts         map[uint]domain.Attempt
+	attemptSteps     map[uint]domain.AttemptStep
+	rewardTxs        map[uint]domain.RewardTx
+	achievements     map[uint]domain.Achievement
+	userAchievements map[uint]domain.UserAchievement
+	sessions         map[uint]domain.Session
+	userTokens       map[uint]domain.UserToken
+	courses          map[uint]domain.Course
+	units            map[uint]domain.Unit
+	prerequisites    []domain.LevelPrerequisite
+}
+
+// NewStore - пустое хранилище
+func NewStore() *Store {
+	return &Store{
+		seq:  make(map[string]uint),
+		data: newTables(),
+		now:  time.Now,
+	}
+}
+
+func newTables() *tables {
+	return &tables{
+		users:            make(map[uint]domain.User),
+		profiles:         make(map[uint]domain.Profile),
+		levels:           make(map[uint]domain.Level),
+		steps:            make(map[uint]domain.LevelStep),
+		revisions:        make(map[uint]domain.LevelRevision),
+		questions:        make(map[uint]domain.Question),
+		choices:          make(map[uint]domain.Choice),
+		attempts:         make(map[uint]domain.Attempt),
+		attemptSteps:     make(map[uint]domain.AttemptStep),
+		rewardTxs:        make(map[uint]domain.RewardTx),
+		achievements:     make(map[uint]domain.Achievement),
+		userAchievements: make(map[uint]domain.UserAchievement),
+		sessions:         make(map[uint]domain.Session),
+		userTokens:       make(map[uint]domain.UserToken),
+		courses:          make(map[uint]domain.Course),
+		units:            make(map[uint]domain.Unit),
+	}
+}
+
+// clone - копия таблиц для отката транзакции
+func (t *tables) clone() *tables {
+	return &tables{
+		users:            cloneMap(t.users),
+		profiles:         cloneMap(t.profiles),
+		levels:           cloneMap(t.levels),
+		steps:            cloneMap(t.steps),
+		revisions:        cloneMap(t.revisions),
+		questions:        cloneMap(t.questions),
+		choices:          cloneMap(t.choices),
+		attempts:         cloneMap(t.attempts),
+		attemptSteps:     cloneMap(t.attemptSteps),
+		rewardTxs:        cloneMap(t.rewardTxs),
+		achievements:     cloneMap(t.achievements),
+		userAchievements: cloneMap(t.userAchievements),
+		sessions:         cloneMap(t.sessions),
+		userTokens:       cloneMap(t.userTokens),
+		courses:          cloneMap(t.courses),
+		units:            cloneMap(t.units),
+		prerequisites:    append([]domain.LevelPrerequisite(nil), t.prerequisites...),
+	}
+}
+
+func cloneMap[T any](m map[uint]T) map[uint]T {
+	out := make(map[uint]T, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+// SeedAchievement - добавить достижение (в репозитории достижений нет создания,
+// в Postgres они заводятся миграциями)
+func (s *Store) SeedAchievement(achievement *domain.Achievement) error {
+	return s.write(context.Background(), func(t *tables) error {
+		for _, existing := range t.achievements {
+			if existing.Code == achievement.Code {
+				return errDuplicate
+			}
+		}
+		s.insert(&achievement.Model, "achievements")
+		t.achievements[achievement.ID] = *achievement
+		return nil
+	})
+}
+
+// txKey - ключ контекста, которым помечается транзакция конкретного хранилища
+type txKey struct{ store *Store }
+
+func (s *Store) inTx(ctx context.Context) bool {
+	return ctx.Value(txKey{s}) != nil
+}
+
+// read - чтение под разделяемой блокировкой (внутри транзакции блокировка уже взята)
+func (s *Store) read(ctx context.Context, fn func(t *tables) error) error {
+	if !s.inTx(ctx) {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+	}
+	return fn(s.data)
+}
+
+// write - изменение под исключительной блокировкой. fn сначала проверяет все условия
+// и только потом меняет таблицы, чтобы ошибка не оставляла частичных изменений
+func (s *Store) write(ctx context.Context, fn func(t *tables) error) error {
+	if !s.inTx(ctx) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	}
+	return fn(s.data)
+}
+
+// insert - новый ID и отметки времени, как у вставки через GORM.
+// Последовательности, как и в Postgres, не откатываются вместе с транзакцией
+func (s *Store) insert(model *domain.Model, table string) {
+	s.seq[table]++
+	model.ID = s.seq[table]
+	now := s.now()
+	if model.CreatedAt.IsZero() {
+		model.CreatedAt = now
+	}
+	if model.UpdatedAt.IsZero() {
+		model.UpdatedAt = now
+	}
+}
+
+// save - ID для новой записи или обновленный UpdatedAt (как Save в GORM)
+func (s *Store) save(model *domain.Model, table string) {
+	if model.ID == 0 {
+		s.insert(model, table)
+		return
+	}
+	if s.seq[table] < model.ID {
+		s.seq[table] = model.ID
+	}
+	if model.CreatedAt.IsZero() {
+		model.CreatedAt = s.now()
+	}
+	model.UpdatedAt = s.now()
+}
+
+// deleteModel - мягкое удаление записи
+func (s *Store) deleteModel(model *domain.Model) {
+	model.DeletedAt.Time = s.now()
+	model.DeletedAt.Valid = true
+}
+
+type txManager struct {
+	store *Store
+}
+
+// NewTxManager - единица работы над репозиториями хранилища store
+func NewTxManager(store *Store) repo.TxManager {
+	return &txManager{store: store}
+}
+
+func (m *txManager) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	s := m.store
+	if !s.inTx(ctx) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		ctx = context.WithValue(ctx, txKey{s}, true)
+	}
+
+	// Снимок таблиц - аналог точки сохранения: ошибка или паника откатывает только этот вызов
+	snapshot := s.data.clone()
+	committed := false
+	defer func() {
+		if !committed {
+			s.data = snapshot
+		}
+	}()
+	if err := fn(ctx); err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
@@ -0,0 +1,235 @@
+// Package simulation - пошаговые финансовые симуляции для шагов уровня типа simulation.
+// Шаг описывается Spec в LevelStep.Payload: имя симулятора, его конфигурация и цели,
+// по которым итоговое состояние оценивается в балл попытки
+package simulation
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+var (
+	ErrUnknownSimulator = errors.New("unknown simulator")
+	ErrFinished         = errors.New("simulation is already finished")
+)
+
+// DecisionError - решение игрока не подходит для текущего хода
+type DecisionError struct {
+	Message string
+}
+
+func (e *DecisionError) Error() string {
+	return "invalid decision: " + e.Message
+}
+
+func invalidDecision(format string, args ...interface{}) error {
+	return &DecisionError{Message: fmt.Sprintf(format, args...)}
+}
+
+// Simulator - пошаговая модель. Конфигурация и решения передаются как JSON,
+// чтобы каждый симулятор сам определял их формат
+type Simulator interface {
+	// Metrics - показатели состояния при конфигурации config, на которые могут ссылаться цели
+	Metrics(config json.RawMessage) []string
+	// Validate - проверка конфигурации шага
+	Validate(config json.RawMessage) error
+	// Start - начальное состояние
+	Start(config json.RawMessage) (*State, error)
+	// Advance - один ход с решением игрока; state не изменяется
+	Advance(config json.RawMessage, state *State, decision json.RawMessage) (*State, error)
+}
+
+// State - состояние симуляции после очередного хода
+type State struct {
+	Turn    int                `json:"turn"`
+	Turns   int                `json:"turns"`
+	Done    bool               `json:"done"`
+	Metrics map[string]float64 `json:"metrics"`
+	Events  []string           `json:"events,omitempty"` // события последнего хода
+}
+
+// Spec - описание шага симуляции (LevelStep.Payload)
+type Spec struct {
+	Simulator  string          `json:"simulator"`
+	Config     json.RawMessage `json:"config"`
+	Objectives []Objective     `json:"objectives"`
+}
+
+// Objective - цель: показатель Metric в конце симуляции сравнивается с Value
+type Objective struct {
+	Metric string  `json:"metric"`
+	Op     string  `json:"op"` // gte, lte, gt, lt
+	Value  float64 `json:"value"`
+	Weight float64 `json:"weight,omitempty"` // 0 — вес 1
+	Label  string  `json:"label,omitempty"`
+}
+
+// Outcome - оценка итогового состояния по целям
+type Outcome struct {
+	Score      float64 // доля выполненных целей с учетом весов, от 0 до 1
+	Passed     bool    // выполнены все цели
+	Objectives []ObjectiveResult
+}
+
+// ObjectiveResult - выполнение одной цели
+type ObjectiveResult struct {
+	Objective
+	Actual float64
+	Met    bool
+}
+
+// Engine - реестр симуляторов
+type Engine struct {
+	simulators map[string]Simulator
+}
+
+// NewEngine - реестр со встроенными симуляторами
+func NewEngine() *Engine {
+	e := &Engine{simulators: make(map[string]Simulator)}
+	e.Register("compound_interest", compoundInterest{})
+	e.Register("loan_amortization", loanAmortization{})
+	e.Register("budget_allocation", budgetAllocation{})
+	e.Register("emergency_fund", emergencyFund{})
+	return e
+}
+
+// Register - добавление или замена симулятора
+func (e *Engine) Register(name string, simulator Simulator) {
+	e.simulators[name] = simulator
+}
+
+// Names - имена зарегистрированных симуляторов по алфавиту
+func (e *Engine) Names() []string {
+	names := make([]string, 0, len(e.simulators))
+	for name := range e.simulators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseSpec - разбор и проверка Payload шага симуляции
+func (e *Engine) ParseSpec(payload []byte) (*Spec, error) {
+	var spec Spec
+	if err := decodeStrict(payload, &spec); err != nil {
+		return nil, fmt.Errorf("invalid simulation payload: %w", err)
+	}
+	simulator, ok := e.simulators[spec.Simulator]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownSimulator, spec.Simulator)
+	}
+	if err := simulator.Validate(spec.Config); err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	if len(spec.Objectives) == 0 {
+		return nil, errors.New("at least one objective is required")
+	}
+	metrics := make(map[string]bool)
+	for _, metric := range simulator.Metrics(spec.Config) {
+		metrics[metric] = true
+	}
+	for i, objective := range spec.Objectives {
+		if !metrics[objective.Metric] {
+			return nil, fmt.Errorf("objectives[%d]: unknown metric %q", i, objective.Metric)
+		}
+		switch objective.Op {
+		case "gte", "lte", "gt", "lt":
+		default:
+			return nil, fmt.Errorf("objectives[%d]: op must be one of gte, lte, gt, lt", i)
+		}
+		if objective.Weight < 0 {
+			return nil, fmt.Errorf("objectives[%d]: weight must not be negative", i)
+		}
+	}
+	return &spec, nil
+}
+
+// Start - начальное состояние шага
+func (e *Engine) Start(spec *Spec) (*State, error) {
+	simulator, ok := e.simulators[spec.Simulator]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownSimulator, spec.Simulator)
+	}
+	return simulator.Start(spec.Config)
+}
+
+// Advance - ход с решением игрока
+func (e *Engine) Advance(spec *Spec, state *State, decision json.RawMessage) (*State, error) {
+	simulator, ok := e.simulators[spec.Simulator]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownSimulator, spec.Simulator)
+	}
+	if state.Done {
+		return nil, ErrFinished
+	}
+	if len(decision) == 0 {
+		decision = json.RawMessage("{}")
+	}
+	return simulator.Advance(spec.Config, state, decision)
+}
+
+// Evaluate - оценка состояния по целям шага
+func (spec *Spec) Evaluate(state *State) Outcome {
+	outcome := Outcome{Passed: true}
+	total, met := 0.0, 0.0
+	for _, objective := range spec.Objectives {
+		weight := objective.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		actual := state.Metrics[objective.Metric]
+		ok := false
+		switch objective.Op {
+		case "gte":
+			ok = actual >= objective.Value
+		case "lte":
+			ok = actual <= objective.Value
+		case "gt":
+			ok = actual > objective.Value
+		case "lt":
+			ok = actual < objective.Value
+		}
+		total += weight
+		if ok {
+			met += weight
+		} else {
+			outcome.Passed = false
+		}
+		outcome.Objectives = append(outcome.Objectives, ObjectiveResult{Objective: objective, Actual: actual, Met: ok})
+	}
+	if total > 0 {
+		outcome.Score = met / total
+	}
+	return outcome
+}
+
+// decodeStrict - разбор JSON с запретом неизвестных полей
+func decodeStrict(data []byte, target interface{}) error {
+	if len(data) == 0 {
+		data = []byte("{}")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(target)
+}
+
+// nextState - состояние следующего хода с копией показателей
+func nextState(state *State) *State {
+	next := &State{
+		Turn:    state.Turn + 1,
+		Turns:   state.Turns,
+		Metrics: make(map[string]float64, len(state.Metrics)),
+	}
+	for name, value := range state.Metrics {
+		next.Metrics[name] = value
+	}
+	return next
+}
+
+func roundMoney(value float64) float64 {
+	return math.Round(value*100) / 100
+}
@@ -0,0 +1,289 @@
+package simulation_test
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/ImCtyz/duofinance/backend/internal/simulation"
+)
+
+// turn - ход симуляции: решение игрока и ожидаемое состояние после него
+type turn struct {
+	decision string
+	metrics  map[string]float64
+	done     bool
+	events   []string
+}
+
+type simulatorCase struct {
+	name   string
+	config string
+	turns  []turn
+}
+
+// spec - шаг симулятора name с конфигурацией config и целью по первому показателю
+func spec(t *testing.T, engine *simulation.Engine, name, config, metric string) *simulation.Spec {
+	t.Helper()
+	payload := `{"simulator":"` + name + `","config":` + config + `,"objectives":[{"metric":"` + metric + `","op":"gte","value":0}]}`
+	s, err := engine.ParseSpec([]byte(payload))
+	if err != nil {
+		t.Fatalf("%s: ParseSpec = %v", name, err)
+	}
+	return s
+}
+
+func checkSimulator(t *testing.T, simulator, metric string, cases []simulatorCase) {
+	t.Helper()
+	engine := simulation.NewEngine()
+	for _, tc := range cases {
+		s := spec(t, engine, simulator, tc.config, metric)
+		state, err := engine.Start(s)
+		if err != nil {
+			t.Fatalf("%s: Start = %v", tc.name, err)
+		}
+		if state.Turns != len(tc.turns) {
+			t.Errorf("%s: Turns = %d, want %d", tc.name, state.Turns, len(tc.turns))
+		}
+		for i, want := range tc.turns {
+			before := copyMetrics(state.Metrics)
+			next, err := engine.Advance(s, state, json.RawMessage(want.decision))
+			if err != nil {
+				t.Fatalf("%s, turn %d: Advance = %v", tc.name, i+1, err)
+			}
+			// Advance не меняет переданное состояние
+			if !reflect.DeepEqual(state.Metrics, before) {
+				t.Errorf("%s, turn %d: previous state modified", tc.name, i+1)
+			}
+			if next.Turn != i+1 || next.Done != want.done || !reflect.DeepEqual(next.Events, want.events) {
+				t.Errorf("%s, turn %d: turn %d, done %v, events %q; want done %v, events %q",
+					tc.name, i+1, next.Turn, next.Done, next.Events, want.done, want.events)
+			}
+			for name, value := range want.metrics {
+				if math.Abs(next.Metrics[name]-value) > 1e-9 {
+					t.Errorf("%s, turn %d: %s = %v, want %v", tc.name, i+1, name, next.Metrics[name], value)
+				}
+			}
+			state = next
+		}
+		if _, err := engine.Advance(s, state, nil); !errors.Is(err, simulation.ErrFinished) {
+			t.Errorf("%s: Advance after the last turn = %v", tc.name, err)
+		}
+	}
+}
+
+func copyMetrics(metrics map[string]float64) map[string]float64 {
+	out := make(map[string]float64, len(metrics))
+	for name, value := range metrics {
+		out[name] = value
+	}
+	return out
+}
+
+// checkDecisions - решения, которые симулятор должен отклонить на первом ходу
+func checkDecisions(t *testing.T, simulator, config, metric string, decisions []string) {
+	t.Helper()
+	engine := simulation.NewEngine()
+	s := spec(t, engine, simulator, config, metric)
+	state, err := engine.Start(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, decision := range decisions {
+		var decisionErr *simulation.DecisionError
+		if _, err := engine.Advance(s, state, json.RawMessage(decision)); !errors.As(err, &decisionErr) {
+			t.Errorf("%s: decision %s: err = %v, want DecisionError", simulator, decision, err)
+		}
+	}
+}
+
+func TestCompoundInterest(t *testing.T) {
+	checkSimulator(t, "compound_interest", "balance", []simulatorCase{
+		{
+			name:   "yearly compounding with a contribution",
+			config: `{"principal":1000,"annual_rate":12,"years":2,"compounding":1}`,
+			turns: []turn{
+				{decision: `{"contribution":100}`, metrics: map[string]float64{"balance": 1232, "contributed": 100, "interest": 132, "year": 1}},
+				{decision: `{}`, metrics: map[string]float64{"balance": 1379.84, "contributed": 100, "interest": 279.84, "year": 2}, done: true},
+			},
+		},
+		{
+			name:   "zero rate keeps the balance",
+			config: `{"principal":500,"annual_rate":0,"years":1}`,
+			turns: []turn{
+				{decision: `{"contribution":50}`, metrics: map[string]float64{"balance": 550, "contributed": 50, "interest": 0}, done: true},
+			},
+		},
+	})
+	checkDecisions(t, "compound_interest", `{"principal":1000,"annual_rate":5,"years":3,"max_contribution":50}`, "balance", []string{
+		`{"contribution":-1}`,
+		`{"contribution":51}`,
+		`{"amount":10}`,
+	})
+}
+
+func TestLoanAmortization(t *testing.T) {
+	checkSimulator(t, "loan_amortization", "balance", []simulatorCase{
+		{
+			name:   "extra payment repays the loan early",
+			config: `{"principal":1200,"annual_rate":0,"term_months":12,"months_per_turn":6}`,
+			turns: []turn{
+				{decision: `{"extra_payment":0}`, metrics: map[string]float64{"balance": 600, "total_paid": 600, "interest_paid": 0, "month": 6, "monthly_payment": 100}},
+				{decision: `{"extra_payment":100}`, metrics: map[string]float64{"balance": 0, "total_paid": 1200, "month": 9}, done: true,
+					events: []string{"loan repaid in month 9"}},
+			},
+		},
+		{
+			name:   "interest of a one-month loan",
+			config: `{"principal":1000,"annual_rate":12,"term_months":1}`,
+			turns: []turn{
+				{decision: `{}`, metrics: map[string]float64{"balance": 0, "interest_paid": 10, "total_paid": 1010, "month": 1}, done: true,
+					events: []string{"loan repaid in month 1"}},
+			},
+		},
+	})
+	checkDecisions(t, "loan_amortization", `{"principal":1000,"annual_rate":10,"term_months":24,"max_extra_payment":100}`, "balance", []string{
+		`{"extra_payment":-5}`,
+		`{"extra_payment":101}`,
+		`{"extra":1}`,
+	})
+}
+
+func TestBudgetAllocation(t *testing.T) {
+	config := `{"income":1000,"months":2,"categories":[{"name":"needs","min":500},{"name":"wants"},{"name":"savings"}]}`
+	checkSimulator(t, "budget_allocation", "savings_rate", []simulatorCase{
+		{
+			name:   "shortfall below a category minimum",
+			config: config,
+			turns: []turn{
+				{decision: `{"allocations":{"needs":500,"wants":300,"savings":200}}`,
+					metrics: map[string]float64{"needs_total": 500, "savings_total": 200, "shortfalls": 0, "savings_rate": 20, "month": 1}},
+				{decision: `{"allocations":{"needs":400,"wants":300,"savings":300}}`,
+					metrics: map[string]float64{"needs_total": 900, "wants_total": 600, "savings_total": 500, "shortfalls": 1, "savings_rate": 25, "month": 2},
+					done:    true, events: []string{"needs is below the minimum of 500"}},
+			},
+		},
+	})
+	checkDecisions(t, "budget_allocation", config, "savings_rate", []string{
+		`{"allocations":{"needs":500,"wants":300}}`,
+		`{"allocations":{"needs":500,"wants":300,"savings":100,"travel":100}}`,
+		`{"allocations":{"needs":1100,"wants":-100,"savings":0}}`,
+		`{"allocations":{"needs":500,"wants":300,"savings":300}}`,
+	})
+}
+
+func TestEmergencyFund(t *testing.T) {
+	config := `{"monthly_income":3000,"monthly_expenses":2000,"starting_savings":500,"months":4,"debt_rate":12,
+		"shocks":[{"month":2,"amount":1500,"label":"car repair"},{"month":3,"amount":2000}]}`
+	checkSimulator(t, "emergency_fund", "savings", []simulatorCase{
+		{
+			name:   "shocks covered from savings and then borrowed",
+			config: config,
+			turns: []turn{
+				{decision: `{"save":1000}`, metrics: map[string]float64{"savings": 1500, "debt": 0, "runway_months": 0.75, "discretionary": 0, "month": 1}},
+				{decision: `{"save":500}`, metrics: map[string]float64{"savings": 500, "shocks_covered": 1, "runway_months": 0.25, "discretionary": 500},
+					events: []string{"car repair of 1500 covered from savings"}},
+				{decision: `{"save":0}`, metrics: map[string]float64{"savings": 0, "debt": 1500, "shocks_covered": 1, "runway_months": 0, "discretionary": 1500},
+					events: []string{"unexpected expense of 2000: 1500 borrowed"}},
+				// Долг растет на месячную ставку
+				{decision: `{"save":0}`, metrics: map[string]float64{"debt": 1515, "discretionary": 2500, "month": 4}, done: true},
+			},
+		},
+	})
+	checkDecisions(t, "emergency_fund", config, "savings", []string{
+		`{"save":-1}`,
+		`{"save":1001}`,
+		`{"invest":10}`,
+	})
+}
+
+func TestParseSpec(t *testing.T) {
+	engine := simulation.NewEngine()
+	if got := engine.Names(); !reflect.DeepEqual(got, []string{"budget_allocation", "compound_interest", "emergency_fund", "loan_amortization"}) {
+		t.Errorf("Names = %v", got)
+	}
+
+	config := `"config":{"principal":1000,"annual_rate":5,"years":3}`
+	cases := []struct {
+		name    string
+		payload string
+	}{
+		{"unknown field", `{"simulator":"compound_interest",` + config + `,"objectives":[{"metric":"balance","op":"gte","value":1}],"extra":1}`},
+		{"invalid config", `{"simulator":"compound_interest","config":{"principal":1000,"years":0},"objectives":[{"metric":"balance","op":"gte","value":1}]}`},
+		{"no objectives", `{"simulator":"compound_interest",` + config + `,"objectives":[]}`},
+		{"unknown metric", `{"simulator":"compound_interest",` + config + `,"objectives":[{"metric":"debt","op":"gte","value":1}]}`},
+		{"unknown op", `{"simulator":"compound_interest",` + config + `,"objectives":[{"metric":"balance","op":"eq","value":1}]}`},
+		{"negative weight", `{"simulator":"compound_interest",` + config + `,"objectives":[{"metric":"balance","op":"gte","value":1,"weight":-1}]}`},
+	}
+	for _, tc := range cases {
+		if _, err := engine.ParseSpec([]byte(tc.payload)); err == nil {
+			t.Errorf("%s: payload accepted", tc.name)
+		}
+	}
+
+	_, err := engine.ParseSpec([]byte(`{"simulator":"lottery",` + config + `,"objectives":[{"metric":"balance","op":"gte","value":1}]}`))
+	if !errors.Is(err, simulation.ErrUnknownSimulator) {
+		t.Errorf("unknown simulator: err = %v", err)
+	}
+
+	// Показатели категорий бюджета зависят от конфигурации
+	_, err = engine.ParseSpec([]byte(`{"simulator":"budget_allocation","config":{"income":100,"months":1,"categories":[{"name":"rent"},{"name":"fun"}]},
+		"objectives":[{"metric":"rent_total","op":"lte","value":60}]}`))
+	if err != nil {
+		t.Errorf("category metric: ParseSpec = %v", err)
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	state := &simulation.State{Metrics: map[string]float64{"balance": 100, "debt": 0}}
+	cases := []struct {
+		name       string
+		objectives []simulation.Objective
+		score      float64
+		passed     bool
+		met        []bool
+	}{
+		{
+			name:       "gte boundary is met",
+			objectives: []simulation.Objective{{Metric: "balance", Op: "gte", Value: 100}},
+			score:      1, passed: true, met: []bool{true},
+		},
+		{
+			name:       "gt boundary is not met",
+			objectives: []simulation.Objective{{Metric: "balance", Op: "gt", Value: 100}},
+			met:        []bool{false},
+		},
+		{
+			name:       "lte and lt",
+			objectives: []simulation.Objective{{Metric: "debt", Op: "lte", Value: 0}, {Metric: "debt", Op: "lt", Value: 0}},
+			score:      0.5, met: []bool{true, false},
+		},
+		{
+			name: "weights",
+			objectives: []simulation.Objective{
+				{Metric: "balance", Op: "gte", Value: 50, Weight: 3},
+				{Metric: "debt", Op: "gt", Value: 0},
+			},
+			score: 0.75, met: []bool{true, false},
+		},
+		{
+			name:       "missing metric counts as zero",
+			objectives: []simulation.Objective{{Metric: "savings", Op: "lte", Value: 0}},
+			score:      1, passed: true, met: []bool{true},
+		},
+	}
+	for _, tc := range cases {
+		s := &simulation.Spec{Objectives: tc.objectives}
+		outcome := s.Evaluate(state)
+		if math.Abs(outcome.Score-tc.score) > 1e-9 || outcome.Passed != tc.passed {
+			t.Errorf("%s: score %v, passed %v; want %v, %v", tc.name, outcome.Score, outcome.Passed, tc.score, tc.passed)
+		}
+		for i, result := range outcome.Objectives {
+			if result.Met != tc.met[i] {
+				t.Errorf("%s: objective %d met = %v, want %v", tc.name, i, result.Met, tc.met[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,451 @@
+package simulation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// compoundInterest - сложный процент: ход — год, игрок решает, сколько довнести
+type compoundInterest struct{}
+
+type compoundInterestConfig struct {
+	Principal       float64 `json:"principal"`
+	AnnualRate      float64 `json:"annual_rate"` // процентов годовых
+	Years           int     `json:"years"`
+	Compounding     int     `json:"compounding,omitempty"`      // начислений в год; 0 — ежемесячно
+	MaxContribution float64 `json:"max_contribution,omitempty"` // 0 — без ограничения
+}
+
+type compoundInterestDecision struct {
+	Contribution float64 `json:"contribution"`
+}
+
+func (compoundInterest) Metrics(json.RawMessage) []string {
+	return []string{"balance", "contributed", "interest", "year"}
+}
+
+func (compoundInterest) config(raw json.RawMessage) (*compoundInterestConfig, error) {
+	var cfg compoundInterestConfig
+	if err := decodeStrict(raw, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Principal < 0 || cfg.AnnualRate < 0 || cfg.MaxContribution < 0 {
+		return nil, errors.New("principal, annual_rate and max_contribution must not be negative")
+	}
+	if cfg.Years < 1 || cfg.Years > 100 {
+		return nil, errors.New("years must be between 1 and 100")
+	}
+	if cfg.Compounding < 0 || cfg.Compounding > 365 {
+		return nil, errors.New("compounding must be between 1 and 365")
+	}
+	if cfg.Compounding == 0 {
+		cfg.Compounding = 12
+	}
+	return &cfg, nil
+}
+
+func (c compoundInterest) Validate(raw json.RawMessage) error {
+	_, err := c.config(raw)
+	return err
+}
+
+func (c compoundInterest) Start(raw json.RawMessage) (*State, error) {
+	cfg, err := c.config(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &State{
+		Turns: cfg.Years,
+		Metrics: map[string]float64{
+			"balance":     cfg.Principal,
+			"contributed": 0,
+			"interest":    0,
+			"year":        0,
+		},
+	}, nil
+}
+
+func (c compoundInterest) Advance(raw json.RawMessage, state *State, rawDecision json.RawMessage) (*State, error) {
+	cfg, err := c.config(raw)
+	if err != nil {
+		return nil, err
+	}
+	var decision compoundInterestDecision
+	if err := decodeStrict(rawDecision, &decision); err != nil {
+		return nil, invalidDecision("%v", err)
+	}
+	if decision.Contribution < 0 {
+		return nil, invalidDecision("contribution must not be negative")
+	}
+	if cfg.MaxContribution > 0 && decision.Contribution > cfg.MaxContribution {
+		return nil, invalidDecision("contribution must not exceed %v", cfg.MaxContribution)
+	}
+
+	next := nextState(state)
+	n := float64(cfg.Compounding)
+	balance := (state.Metrics["balance"] + decision.Contribution) * math.Pow(1+cfg.AnnualRate/100/n, n)
+	next.Metrics["balance"] = roundMoney(balance)
+	next.Metrics["contributed"] = roundMoney(state.Metrics["contributed"] + decision.Contribution)
+	next.Metrics["interest"] = roundMoney(balance - cfg.Principal - next.Metrics["contributed"])
+	next.Metrics["year"] = float64(next.Turn)
+	next.Done = next.Turn >= cfg.Years
+	return next, nil
+}
+
+// loanAmortization - аннуитетный кредит: ход — несколько месяцев, игрок решает,
+// сколько платить сверх обязательного платежа каждый месяц
+type loanAmortization struct{}
+
+type loanConfig struct {
+	Principal       float64 `json:"principal"`
+	AnnualRate      float64 `json:"annual_rate"` // процентов годовых
+	TermMonths      int     `json:"term_months"`
+	MonthsPerTurn   int     `json:"months_per_turn,omitempty"`   // 0 — 12
+	MaxExtraPayment float64 `json:"max_extra_payment,omitempty"` // 0 — без ограничения
+}
+
+type loanDecision struct {
+	ExtraPayment float64 `json:"extra_payment"`
+}
+
+func (loanAmortization) Metrics(json.RawMessage) []string {
+	return []string{"balance", "interest_paid", "total_paid", "month", "monthly_payment"}
+}
+
+func (loanAmortization) config(raw json.RawMessage) (*loanConfig, error) {
+	var cfg loanConfig
+	if err := decodeStrict(raw, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Principal <= 0 {
+		return nil, errors.New("principal must be positive")
+	}
+	if cfg.AnnualRate < 0 || cfg.MaxExtraPayment < 0 {
+		return nil, errors.New("annual_rate and max_extra_payment must not be negative")
+	}
+	if cfg.TermMonths < 1 || cfg.TermMonths > 600 {
+		return nil, errors.New("term_months must be between 1 and 600")
+	}
+	if cfg.MonthsPerTurn < 0 {
+		return nil, errors.New("months_per_turn must not be negative")
+	}
+	if cfg.MonthsPerTurn == 0 {
+		cfg.MonthsPerTurn = 12
+	}
+	return &cfg, nil
+}
+
+func (cfg *loanConfig) payment() float64 {
+	r := cfg.AnnualRate / 100 / 12
+	n := float64(cfg.TermMonths)
+	if r == 0 {
+		return cfg.Principal / n
+	}
+	return cfg.Principal * r / (1 - math.Pow(1+r, -n))
+}
+
+func (l loanAmortization) Validate(raw json.RawMessage) error {
+	_, err := l.config(raw)
+	return err
+}
+
+func (l loanAmortization) Start(raw json.RawMessage) (*State, error) {
+	cfg, err := l.config(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &State{
+		Turns: (cfg.TermMonths + cfg.MonthsPerTurn - 1) / cfg.MonthsPerTurn,
+		Metrics: map[string]float64{
+			"balance":         cfg.Principal,
+			"interest_paid":   0,
+			"total_paid":      0,
+			"month":           0,
+			"monthly_payment": roundMoney(cfg.payment()),
+		},
+	}, nil
+}
+
+func (l loanAmortization) Advance(raw json.RawMessage, state *State, rawDecision json.RawMessage) (*State, error) {
+	cfg, err := l.config(raw)
+	if err != nil {
+		return nil, err
+	}
+	var decision loanDecision
+	if err := decodeStrict(rawDecision, &decision); err != nil {
+		return nil, invalidDecision("%v", err)
+	}
+	if decision.ExtraPayment < 0 {
+		return nil, invalidDecision("extra_payment must not be negative")
+	}
+	if cfg.MaxExtraPayment > 0 && decision.ExtraPayment > cfg.MaxExtraPayment {
+		return nil, invalidDecision("extra_payment must not exceed %v", cfg.MaxExtraPayment)
+	}
+
+	next := nextState(state)
+	r := cfg.AnnualRate / 100 / 12
+	payment := cfg.payment()
+	balance := state.Metrics["balance"]
+	month := int(state.Metrics["month"])
+	for i := 0; i < cfg.MonthsPerTurn && month < cfg.TermMonths && balance > 0.005; i++ {
+		interest := balance * r
+		pay := math.Min(payment+decision.ExtraPayment, balance+interest)
+		balance = balance + interest - pay
+		month++
+		next.Metrics["interest_paid"] += interest
+		next.Metrics["total_paid"] += pay
+	}
+	if balance <= 0.005 {
+		balance = 0
+		next.Events = append(next.Events, fmt.Sprintf("loan repaid in month %d", month))
+	}
+	next.Metrics["balance"] = roundMoney(balance)
+	next.Metrics["interest_paid"] = roundMoney(next.Metrics["interest_paid"])
+	next.Metrics["total_paid"] = roundMoney(next.Metrics["total_paid"])
+	next.Metrics["month"] = float64(month)
+	next.Done = balance == 0 || month >= cfg.TermMonths
+	return next, nil
+}
+
+// budgetAllocation - распределение дохода по категориям: ход — месяц, игрок делит
+// весь доход между категориями; месяц с суммой ниже минимума категории — недостача
+type budgetAllocation struct{}
+
+type budgetConfig struct {
+	Income          float64          `json:"income"`
+	Months          int              `json:"months"`
+	Categories      []budgetCategory `json:"categories"`
+	SavingsCategory string           `json:"savings_category,omitempty"` // пусто — savings
+}
+
+type budgetCategory struct {
+	Name string  `json:"name"`
+	Min  float64 `json:"min,omitempty"`
+}
+
+type budgetDecision struct {
+	Allocations map[string]float64 `json:"allocations"`
+}
+
+// Metrics - кроме общих показателей, по каждой категории есть <категория>_total
+func (b budgetAllocation) Metrics(raw json.RawMessage) []string {
+	metrics := []string{"month", "shortfalls", "savings_rate"}
+	if cfg, err := b.config(raw); err == nil {
+		for _, category := range cfg.Categories {
+			metrics = append(metrics, category.Name+"_total")
+		}
+	}
+	return metrics
+}
+
+func (budgetAllocation) config(raw json.RawMessage) (*budgetConfig, error) {
+	var cfg budgetConfig
+	if err := decodeStrict(raw, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Income <= 0 {
+		return nil, errors.New("income must be positive")
+	}
+	if cfg.Months < 1 || cfg.Months > 120 {
+		return nil, errors.New("months must be between 1 and 120")
+	}
+	if len(cfg.Categories) < 2 {
+		return nil, errors.New("at least two categories are required")
+	}
+	seen := make(map[string]bool)
+	minimum := 0.0
+	for _, category := range cfg.Categories {
+		if category.Name == "" || seen[category.Name] {
+			return nil, fmt.Errorf("category names must be unique and non-empty")
+		}
+		if category.Min < 0 {
+			return nil, fmt.Errorf("category %q: min must not be negative", category.Name)
+		}
+		seen[category.Name] = true
+		minimum += category.Min
+	}
+	if minimum > cfg.Income {
+		return nil, errors.New("category minimums exceed income")
+	}
+	if cfg.SavingsCategory == "" {
+		cfg.SavingsCategory = "savings"
+	}
+	return &cfg, nil
+}
+
+func (b budgetAllocation) Validate(raw json.RawMessage) error {
+	_, err := b.config(raw)
+	return err
+}
+
+func (b budgetAllocation) Start(raw json.RawMessage) (*State, error) {
+	cfg, err := b.config(raw)
+	if err != nil {
+		return nil, err
+	}
+	metrics := map[string]float64{"month": 0, "shortfalls": 0, "savings_rate": 0}
+	for _, category := range cfg.Categories {
+		metrics[category.Name+"_total"] = 0
+	}
+	return &State{Turns: cfg.Months, Metrics: metrics}, nil
+}
+
+func (b budgetAllocation) Advance(raw json.RawMessage, state *State, rawDecision json.RawMessage) (*State, error) {
+	cfg, err := b.config(raw)
+	if err != nil {
+		return nil, err
+	}
+	var decision budgetDecision
+	if err := decodeStrict(rawDecision, &decision); err != nil {
+		return nil, invalidDecision("%v", err)
+	}
+	known := make(map[string]bool, len(cfg.Categories))
+	for _, category := range cfg.Categories {
+		known[category.Name] = true
+	}
+	sum := 0.0
+	for name, amount := range decision.Allocations {
+		if !known[name] {
+			return nil, invalidDecision("unknown category %q", name)
+		}
+		if amount < 0 {
+			return nil, invalidDecision("allocation for %q must not be negative", name)
+		}
+		sum += amount
+	}
+	if math.Abs(sum-cfg.Income) > 0.005 {
+		return nil, invalidDecision("allocations must add up to income %v, got %v", cfg.Income, sum)
+	}
+
+	next := nextState(state)
+	for _, category := range cfg.Categories {
+		amount := decision.Allocations[category.Name]
+		next.Metrics[category.Name+"_total"] = roundMoney(next.Metrics[category.Name+"_total"] + amount)
+		if amount < category.Min {
+			next.Metrics["shortfalls"]++
+			next.Events = append(next.Events, fmt.Sprintf("%s is below the minimum of %v", category.Name, category.Min))
+		}
+	}
+	next.Metrics["month"] = float64(next.Turn)
+	next.Metrics["savings_rate"] = roundMoney(next.Metrics[cfg.SavingsCategory+"_total"] / (cfg.Income * float64(next.Turn)) * 100)
+	next.Done = next.Turn >= cfg.Months
+	return next, nil
+}
+
+// emergencyFund - финансовая подушка: ход — месяц, игрок решает, сколько отложить
+// из свободных денег; непредвиденные расходы покрываются подушкой, остальное — в долг
+type emergencyFund struct{}
+
+type emergencyFundConfig struct {
+	MonthlyIncome   float64 `json:"monthly_income"`
+	MonthlyExpenses float64 `json:"monthly_expenses"`
+	StartingSavings float64 `json:"starting_savings,omitempty"`
+	Months          int     `json:"months"`
+	DebtRate        float64 `json:"debt_rate,omitempty"` // процентов годовых на долг
+	Shocks          []shock `json:"shocks,omitempty"`
+}
+
+type shock struct {
+	Month  int     `json:"month"`
+	Amount float64 `json:"amount"`
+	Label  string  `json:"label,omitempty"`
+}
+
+type emergencyFundDecision struct {
+	Save float64 `json:"save"`
+}
+
+func (emergencyFund) Metrics(json.RawMessage) []string {
+	return []string{"savings", "debt", "runway_months", "discretionary", "shocks_covered", "month"}
+}
+
+func (emergencyFund) config(raw json.RawMessage) (*emergencyFundConfig, error) {
+	var cfg emergencyFundConfig
+	if err := decodeStrict(raw, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.MonthlyExpenses <= 0 || cfg.MonthlyIncome < cfg.MonthlyExpenses {
+		return nil, errors.New("monthly_expenses must be positive and not exceed monthly_income")
+	}
+	if cfg.StartingSavings < 0 || cfg.DebtRate < 0 {
+		return nil, errors.New("starting_savings and debt_rate must not be negative")
+	}
+	if cfg.Months < 1 || cfg.Months > 120 {
+		return nil, errors.New("months must be between 1 and 120")
+	}
+	for _, s := range cfg.Shocks {
+		if s.Month < 1 || s.Month > cfg.Months || s.Amount <= 0 {
+			return nil, errors.New("shocks need a month within the simulation and a positive amount")
+		}
+	}
+	return &cfg, nil
+}
+
+func (f emergencyFund) Validate(raw json.RawMessage) error {
+	_, err := f.config(raw)
+	return err
+}
+
+func (f emergencyFund) Start(raw json.RawMessage) (*State, error) {
+	cfg, err := f.config(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &State{
+		Turns: cfg.Months,
+		Metrics: map[string]float64{
+			"savings":        cfg.StartingSavings,
+			"debt":           0,
+			"runway_months":  roundMoney(cfg.StartingSavings / cfg.MonthlyExpenses),
+			"discretionary":  0,
+			"shocks_covered": 0,
+			"month":          0,
+		},
+	}, nil
+}
+
+func (f emergencyFund) Advance(raw json.RawMessage, state *State, rawDecision json.RawMessage) (*State, error) {
+	cfg, err := f.config(raw)
+	if err != nil {
+		return nil, err
+	}
+	var decision emergencyFundDecision
+	if err := decodeStrict(rawDecision, &decision); err != nil {
+		return nil, invalidDecision("%v", err)
+	}
+	free := cfg.MonthlyIncome - cfg.MonthlyExpenses
+	if decision.Save < 0 || decision.Save > free {
+		return nil, invalidDecision("save must be between 0 and %v", free)
+	}
+
+	next := nextState(state)
+	savings := state.Metrics["savings"] + decision.Save
+	debt := state.Metrics["debt"] * (1 + cfg.DebtRate/100/12)
+	next.Metrics["discretionary"] = roundMoney(state.Metrics["discretionary"] + free - decision.Save)
+	for _, s := range cfg.Shocks {
+		if s.Month != next.Turn {
+			continue
+		}
+		label := s.Label
+		if label == "" {
+			label = "unexpected expense"
+		}
+		if savings >= s.Amount {
+			savings -= s.Amount
+			next.Metrics["shocks_covered"]++
+			next.Events = append(next.Events, fmt.Sprintf("%s of %v covered from savings", label, s.Amount))
+		} else {
+			debt += s.Amount - savings
+			next.Events = append(next.Events, fmt.Sprintf("%s of %v: %v borrowed", label, s.Amount, roundMoney(s.Amount-savings)))
+			savings = 0
+		}
+	}
+	next.Metrics["savings"] = roundMoney(savings)
+	next.Metrics["debt"] = roundMoney(debt)
+	next.Metrics["runway_months"] = roundMoney(savings / cfg.MonthlyExpenses)
+	next.Metrics["month"] = float64(next.Turn)
+	next.Done = next.Turn >= cfg.Months
+	return next, nil
+}
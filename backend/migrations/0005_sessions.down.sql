@@ -0,0 +1,6 @@
+-- Drop refresh token sessions
+BEGIN;
+
+DROP TABLE IF EXISTS sessions;
+
+COMMIT;
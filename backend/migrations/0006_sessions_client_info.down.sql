@@ -0,0 +1,9 @@
+-- Revert client details on sessions
+BEGIN;
+
+ALTER TABLE sessions
+  DROP COLUMN IF EXISTS last_used_at,
+  DROP COLUMN IF EXISTS ip,
+  DROP COLUMN IF EXISTS user_agent;
+
+COMMIT;
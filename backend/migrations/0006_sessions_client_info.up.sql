@@ -0,0 +1,11 @@
+-- Record client details for each issued refresh token
+BEGIN;
+
+ALTER TABLE sessions
+  ADD COLUMN IF NOT EXISTS user_agent VARCHAR(512),
+  ADD COLUMN IF NOT EXISTS ip VARCHAR(64),
+  ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMPTZ;
+
+UPDATE sessions SET last_used_at = issued_at WHERE last_used_at IS NULL;
+
+COMMIT;
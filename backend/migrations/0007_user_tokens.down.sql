@@ -0,0 +1,9 @@
+-- Drop user tokens and email verification flag
+BEGIN;
+
+DROP TABLE IF EXISTS user_tokens;
+
+ALTER TABLE users
+  DROP COLUMN IF EXISTS email_verified_at;
+
+COMMIT;
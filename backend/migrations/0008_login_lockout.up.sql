@@ -0,0 +1,9 @@
+-- Failed login counters and temporary account lockout
+BEGIN;
+
+ALTER TABLE users
+  ADD COLUMN IF NOT EXISTS failed_login_attempts INTEGER NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS last_failed_login_at TIMESTAMPTZ,
+  ADD COLUMN IF NOT EXISTS locked_until TIMESTAMPTZ;
+
+COMMIT;
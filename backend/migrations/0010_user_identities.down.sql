@@ -0,0 +1,7 @@
+-- Drop OIDC identities and login states
+BEGIN;
+
+DROP TABLE IF EXISTS oidc_states;
+DROP TABLE IF EXISTS user_identities;
+
+COMMIT;
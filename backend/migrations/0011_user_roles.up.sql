@@ -0,0 +1,12 @@
+-- User roles for access control (learner|editor|admin)
+BEGIN;
+
+ALTER TABLE users
+  ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'learner';
+
+ALTER TABLE users
+  ADD CONSTRAINT chk_users_role CHECK (role IN ('learner', 'editor', 'admin'));
+
+CREATE INDEX IF NOT EXISTS idx_users_role ON users(role);
+
+COMMIT;
@@ -0,0 +1,10 @@
+-- Restore the unconditional unique step order (fails if soft-deleted steps collide)
+BEGIN;
+
+DROP INDEX IF EXISTS idx_level_steps_question;
+DROP INDEX IF EXISTS uq_level_step_order;
+
+ALTER TABLE level_steps
+  ADD CONSTRAINT uq_level_step_order UNIQUE (level_id, "order");
+
+COMMIT;
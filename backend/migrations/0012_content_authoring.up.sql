@@ -0,0 +1,14 @@
+-- Step order must be unique only among live (not soft-deleted) steps, so a deleted
+-- step does not block reusing its position
+BEGIN;
+
+ALTER TABLE level_steps
+  DROP CONSTRAINT IF EXISTS uq_level_step_order;
+
+CREATE UNIQUE INDEX IF NOT EXISTS uq_level_step_order
+  ON level_steps(level_id, "order")
+  WHERE deleted_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_level_steps_question ON level_steps(question_id);
+
+COMMIT;
@@ -0,0 +1,14 @@
+-- Drop content slugs
+BEGIN;
+
+DROP INDEX IF EXISTS uq_choices_slug;
+DROP INDEX IF EXISTS uq_questions_slug;
+DROP INDEX IF EXISTS uq_level_steps_slug;
+DROP INDEX IF EXISTS uq_levels_slug;
+
+ALTER TABLE choices DROP COLUMN IF EXISTS slug;
+ALTER TABLE questions DROP COLUMN IF EXISTS slug;
+ALTER TABLE level_steps DROP COLUMN IF EXISTS slug;
+ALTER TABLE levels DROP COLUMN IF EXISTS slug;
+
+COMMIT;
@@ -0,0 +1,31 @@
+-- Stable slugs for declarative content import/export (cmd/contentctl).
+-- Existing rows get generated slugs; new rows without a slug get <kind>-<id>.
+BEGIN;
+
+ALTER TABLE levels ADD COLUMN IF NOT EXISTS slug VARCHAR(255);
+ALTER TABLE level_steps ADD COLUMN IF NOT EXISTS slug VARCHAR(255);
+ALTER TABLE questions ADD COLUMN IF NOT EXISTS slug VARCHAR(255);
+ALTER TABLE choices ADD COLUMN IF NOT EXISTS slug VARCHAR(255);
+
+UPDATE levels SET slug = 'level-' || id WHERE slug IS NULL;
+UPDATE level_steps SET slug = 'step-' || id WHERE slug IS NULL;
+UPDATE questions SET slug = 'question-' || id WHERE slug IS NULL;
+UPDATE choices SET slug = 'choice-' || id WHERE slug IS NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS uq_levels_slug
+  ON levels(slug)
+  WHERE deleted_at IS NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS uq_level_steps_slug
+  ON level_steps(level_id, slug)
+  WHERE deleted_at IS NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS uq_questions_slug
+  ON questions(slug)
+  WHERE deleted_at IS NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS uq_choices_slug
+  ON choices(question_id, slug)
+  WHERE deleted_at IS NULL;
+
+COMMIT;
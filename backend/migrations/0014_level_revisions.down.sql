@@ -0,0 +1,11 @@
+-- Drop level revisions
+BEGIN;
+
+DROP INDEX IF EXISTS idx_attempts_level_revision_id;
+
+ALTER TABLE attempts DROP COLUMN IF EXISTS level_revision_id;
+ALTER TABLE levels DROP COLUMN IF EXISTS published_revision_id;
+
+DROP TABLE IF EXISTS level_revisions;
+
+COMMIT;
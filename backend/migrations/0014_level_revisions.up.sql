@@ -0,0 +1,27 @@
+-- Published level revisions: immutable snapshots that learners and attempts read,
+-- while editors keep changing the working (draft) tables
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS level_revisions (
+  id BIGSERIAL PRIMARY KEY,
+  level_id BIGINT NOT NULL REFERENCES levels(id) ON UPDATE CASCADE ON DELETE CASCADE,
+  number INTEGER NOT NULL,
+  snapshot JSONB NOT NULL,
+  published_by BIGINT REFERENCES users(id) ON UPDATE CASCADE ON DELETE SET NULL,
+  rolled_back_from INTEGER,
+  note VARCHAR(500) NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  CONSTRAINT uq_level_revision_number UNIQUE (level_id, number)
+);
+
+ALTER TABLE levels
+  ADD COLUMN IF NOT EXISTS published_revision_id BIGINT
+    REFERENCES level_revisions(id) ON UPDATE CASCADE ON DELETE SET NULL;
+
+ALTER TABLE attempts
+  ADD COLUMN IF NOT EXISTS level_revision_id BIGINT
+    REFERENCES level_revisions(id) ON UPDATE CASCADE ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_attempts_level_revision_id ON attempts(level_revision_id);
+
+COMMIT;
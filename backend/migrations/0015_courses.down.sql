@@ -0,0 +1,14 @@
+-- Drop courses, units and level prerequisites
+BEGIN;
+
+DROP TABLE IF EXISTS level_prerequisites;
+
+DROP INDEX IF EXISTS idx_levels_unit_id;
+ALTER TABLE levels
+  DROP COLUMN IF EXISTS unit_order,
+  DROP COLUMN IF EXISTS unit_id;
+
+DROP TABLE IF EXISTS units;
+DROP TABLE IF EXISTS courses;
+
+COMMIT;
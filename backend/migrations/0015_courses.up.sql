@@ -0,0 +1,91 @@
+-- Courses and units group levels; level_prerequisites is an explicit unlock graph
+-- (acyclic, enforced by the application). Existing levels are placed into a default
+-- course and chained in id order, which preserves the previous unlocking behaviour.
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS courses (
+  id BIGSERIAL PRIMARY KEY,
+  slug VARCHAR(255) NOT NULL,
+  title VARCHAR(255) NOT NULL,
+  description TEXT,
+  "order" INTEGER NOT NULL DEFAULT 0,
+  is_active BOOLEAN NOT NULL DEFAULT TRUE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  deleted_at TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS uq_courses_slug ON courses(slug) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_courses_deleted_at ON courses(deleted_at);
+
+CREATE TABLE IF NOT EXISTS units (
+  id BIGSERIAL PRIMARY KEY,
+  course_id BIGINT NOT NULL REFERENCES courses(id) ON UPDATE CASCADE ON DELETE CASCADE,
+  title VARCHAR(255) NOT NULL,
+  "order" INTEGER NOT NULL DEFAULT 0,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  deleted_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_units_course_id ON units(course_id);
+CREATE INDEX IF NOT EXISTS idx_units_deleted_at ON units(deleted_at);
+
+ALTER TABLE levels
+  ADD COLUMN IF NOT EXISTS unit_id BIGINT REFERENCES units(id) ON UPDATE CASCADE ON DELETE SET NULL,
+  ADD COLUMN IF NOT EXISTS unit_order INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_levels_unit_id ON levels(unit_id);
+
+CREATE TABLE IF NOT EXISTS level_prerequisites (
+  level_id BIGINT NOT NULL REFERENCES levels(id) ON UPDATE CASCADE ON DELETE CASCADE,
+  required_level_id BIGINT NOT NULL REFERENCES levels(id) ON UPDATE CASCADE ON DELETE CASCADE,
+  min_score INTEGER NOT NULL DEFAULT 70,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (level_id, required_level_id),
+  CONSTRAINT chk_level_prerequisites_self CHECK (level_id <> required_level_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_level_prerequisites_required ON level_prerequisites(required_level_id);
+
+-- Default course with a single unit holding all existing levels
+INSERT INTO courses (slug, title, description, "order")
+SELECT 'main', 'Финансовая грамотность', '', 1
+WHERE NOT EXISTS (SELECT 1 FROM courses WHERE slug = 'main');
+
+INSERT INTO units (course_id, title, "order")
+SELECT c.id, 'Основы', 1
+FROM courses c
+WHERE c.slug = 'main'
+  AND NOT EXISTS (SELECT 1 FROM units u WHERE u.course_id = c.id);
+
+WITH main_unit AS (
+  SELECT u.id
+  FROM units u
+  JOIN courses c ON c.id = u.course_id
+  WHERE c.slug = 'main'
+  ORDER BY u."order", u.id
+  LIMIT 1
+),
+ordered AS (
+  SELECT id, ROW_NUMBER() OVER (ORDER BY id) AS position
+  FROM levels
+  WHERE unit_id IS NULL AND deleted_at IS NULL
+)
+UPDATE levels l
+SET unit_id = (SELECT id FROM main_unit), unit_order = o.position
+FROM ordered o
+WHERE l.id = o.id;
+
+-- Previous rule: each active level requires the previous active level (by id) at 70
+INSERT INTO level_prerequisites (level_id, required_level_id, min_score)
+SELECT id, prev_id, 70
+FROM (
+  SELECT id, LAG(id) OVER (ORDER BY id) AS prev_id
+  FROM levels
+  WHERE is_active = TRUE AND deleted_at IS NULL
+) chain
+WHERE prev_id IS NOT NULL
+ON CONFLICT DO NOTHING;
+
+COMMIT;
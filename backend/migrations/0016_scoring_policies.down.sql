@@ -0,0 +1,9 @@
+-- Drop scoring policies and attempt pass verdicts
+BEGIN;
+
+DROP INDEX IF EXISTS idx_attempts_user_passed;
+
+ALTER TABLE attempts DROP COLUMN IF EXISTS passed;
+ALTER TABLE levels DROP COLUMN IF EXISTS scoring_policy;
+
+COMMIT;
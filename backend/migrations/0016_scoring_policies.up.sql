@@ -0,0 +1,13 @@
+-- Per-level scoring policy (NULL = default: pass at 70, penalty curve 1/0.7/0.4/0.1/0)
+-- and the pass verdict of each attempt, decided by the policy it was scored with
+BEGIN;
+
+ALTER TABLE levels ADD COLUMN IF NOT EXISTS scoring_policy JSONB;
+
+ALTER TABLE attempts ADD COLUMN IF NOT EXISTS passed BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE attempts SET passed = TRUE WHERE status = 'completed' AND result_score >= 70;
+
+CREATE INDEX IF NOT EXISTS idx_attempts_user_passed ON attempts(user_id, level_id) WHERE passed;
+
+COMMIT;
@@ -0,0 +1,11 @@
+-- Drop grading modes and fractional step scores
+BEGIN;
+
+ALTER TABLE attempt_steps DROP COLUMN IF EXISTS score;
+
+ALTER TABLE questions DROP CONSTRAINT IF EXISTS chk_questions_grading_mode;
+ALTER TABLE questions
+  DROP COLUMN IF EXISTS min_correct,
+  DROP COLUMN IF EXISTS grading_mode;
+
+COMMIT;
@@ -0,0 +1,18 @@
+-- Per-question grading mode (exact, partial, at_least; empty = decided by the level's
+-- scoring policy) and the fractional score of every answered attempt step
+BEGIN;
+
+ALTER TABLE questions
+  ADD COLUMN IF NOT EXISTS grading_mode VARCHAR(20) NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS min_correct INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE questions
+  ADD CONSTRAINT chk_questions_grading_mode
+  CHECK (grading_mode IN ('', 'exact', 'partial', 'at_least'));
+
+ALTER TABLE attempt_steps ADD COLUMN IF NOT EXISTS score DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+-- Answers graded before this migration were all-or-nothing
+UPDATE attempt_steps SET score = 1 WHERE correct;
+
+COMMIT;
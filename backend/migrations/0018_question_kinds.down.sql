@@ -0,0 +1,9 @@
+-- Drop question kinds; non-choice questions become unanswerable choice questions
+BEGIN;
+
+ALTER TABLE questions DROP CONSTRAINT IF EXISTS chk_questions_kind;
+ALTER TABLE questions
+  DROP COLUMN IF EXISTS spec,
+  DROP COLUMN IF EXISTS kind;
+
+COMMIT;
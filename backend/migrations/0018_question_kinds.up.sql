@@ -0,0 +1,13 @@
+-- Question kinds beyond multiple choice (numeric, ordering, matching, cloze); the
+-- correct answer of a non-choice question is stored in spec
+BEGIN;
+
+ALTER TABLE questions
+  ADD COLUMN IF NOT EXISTS kind VARCHAR(20) NOT NULL DEFAULT 'choice',
+  ADD COLUMN IF NOT EXISTS spec JSONB;
+
+ALTER TABLE questions
+  ADD CONSTRAINT chk_questions_kind
+  CHECK (kind IN ('choice', 'numeric', 'ordering', 'matching', 'cloze'));
+
+COMMIT;
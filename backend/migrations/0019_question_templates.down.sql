@@ -0,0 +1,8 @@
+-- Drop question templates and attempt seeds
+BEGIN;
+
+ALTER TABLE attempts DROP COLUMN IF EXISTS seed;
+
+ALTER TABLE questions DROP COLUMN IF EXISTS template;
+
+COMMIT;
@@ -0,0 +1,11 @@
+-- Parameterized question templates and the per-attempt seed used to render them
+BEGIN;
+
+ALTER TABLE questions ADD COLUMN IF NOT EXISTS template JSONB;
+
+ALTER TABLE attempts ADD COLUMN IF NOT EXISTS seed BIGINT NOT NULL DEFAULT 0;
+
+-- Attempts started before templates existed still get distinct values per attempt
+UPDATE attempts SET seed = id WHERE seed = 0;
+
+COMMIT;
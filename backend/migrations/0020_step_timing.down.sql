@@ -0,0 +1,10 @@
+-- Drop server-side step timing
+BEGIN;
+
+ALTER TABLE attempt_steps DROP COLUMN IF EXISTS answered_at;
+ALTER TABLE attempt_steps DROP COLUMN IF EXISTS served_at;
+
+ALTER TABLE attempts DROP COLUMN IF EXISTS served_at;
+ALTER TABLE attempts DROP COLUMN IF EXISTS served_step_id;
+
+COMMIT;
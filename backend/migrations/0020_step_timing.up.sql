@@ -0,0 +1,16 @@
+-- Server-side step timing: when a step was served and answered
+BEGIN;
+
+ALTER TABLE attempts ADD COLUMN IF NOT EXISTS served_step_id BIGINT;
+ALTER TABLE attempts ADD COLUMN IF NOT EXISTS served_at TIMESTAMPTZ;
+
+ALTER TABLE attempt_steps ADD COLUMN IF NOT EXISTS served_at TIMESTAMPTZ;
+ALTER TABLE attempt_steps ADD COLUMN IF NOT EXISTS answered_at TIMESTAMPTZ;
+
+-- Older steps were recorded at answer time; their served time is derived from the stored duration
+UPDATE attempt_steps
+SET answered_at = created_at,
+    served_at = created_at - (duration_ms * INTERVAL '1 millisecond')
+WHERE answered_at IS NULL;
+
+COMMIT;
@@ -0,0 +1,8 @@
+-- Drop idempotency keys and the one-reward-per-attempt constraint
+BEGIN;
+
+DROP TABLE IF EXISTS idempotency_keys;
+
+DROP INDEX IF EXISTS uq_reward_txs_attempt;
+
+COMMIT;
@@ -0,0 +1,41 @@
+-- At most one completion reward per attempt and stored responses for Idempotency-Key requests
+BEGIN;
+
+-- Concurrent completions could pay the same attempt twice; keep only the first payout
+UPDATE reward_txs r
+SET deleted_at = NOW()
+WHERE r.type = 'earn'
+  AND r.attempt_id IS NOT NULL
+  AND r.deleted_at IS NULL
+  AND EXISTS (
+      SELECT 1 FROM reward_txs p
+      WHERE p.attempt_id = r.attempt_id
+        AND p.type = 'earn'
+        AND p.deleted_at IS NULL
+        AND p.id < r.id
+  );
+
+CREATE UNIQUE INDEX IF NOT EXISTS uq_reward_txs_attempt ON reward_txs(attempt_id)
+    WHERE type = 'earn' AND deleted_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    key VARCHAR(255) NOT NULL,
+    fingerprint VARCHAR(64) NOT NULL,
+    status_code INTEGER NOT NULL DEFAULT 0,
+    response JSONB,
+    completed_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    deleted_at TIMESTAMPTZ,
+    CONSTRAINT fk_idempotency_keys_user
+        FOREIGN KEY (user_id) REFERENCES users(id)
+        ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS uq_idempotency_keys_user_key ON idempotency_keys(user_id, key);
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys(expires_at);
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_deleted_at ON idempotency_keys(deleted_at);
+
+COMMIT;
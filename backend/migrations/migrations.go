@@ -0,0 +1,9 @@
+// Package migrations - SQL миграции схемы, встроенные в бинарник сервера.
+// Файлы называются NNNN_name.up.sql / NNNN_name.down.sql; номера могут идти
+// с пропусками (0002 нет), порядок определяется номером.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS